@@ -0,0 +1,159 @@
+// Package blobstore сохраняет большие payload заданий во внешнем S3-совместимом хранилище
+// (AWS S3, MinIO, Ceph RGW). В строке задания остается только ссылка вида s3://bucket/key,
+// worker скачивает payload по ней перед выполнением.
+// Запросы подписываются AWS Signature Version 4 без внешнего SDK; используется path-style адресация
+// (endpoint/bucket/key), которую поддерживают все S3-совместимые хранилища.
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// keyPrefix - префикс ключей объектов с payload в бакете
+const keyPrefix = "payloads/"
+
+// Options содержит параметры подключения к S3-совместимому хранилищу
+type Options struct {
+	Endpoint  string // Базовый URL хранилища, например https://s3.eu-central-1.amazonaws.com или http://minio:9000
+	Bucket    string // Бакет для payload
+	Region    string // Регион для подписи запросов; для MinIO обычно us-east-1
+	AccessKey string
+	SecretKey string
+}
+
+// S3Store сохраняет payload в бакете S3-совместимого хранилища
+type S3Store struct {
+	endpoint   *url.URL
+	bucket     string
+	region     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// NewS3Store создает хранилище payload. Endpoint должен быть абсолютным http(s) URL.
+// Параметры:
+//   - opts: адрес хранилища, бакет, регион и ключи доступа
+func NewS3Store(opts Options) (*S3Store, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: must be an absolute http or https URL", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+
+	return &S3Store{
+		endpoint:   endpoint,
+		bucket:     opts.Bucket,
+		region:     opts.Region,
+		accessKey:  opts.AccessKey,
+		secretKey:  opts.SecretKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Put сохраняет payload новым объектом со случайным ключом и возвращает ссылку s3://bucket/key.
+// Объект не перезаписывается и не удаляется API: срок хранения задается правилами жизненного цикла бакета.
+func (s *S3Store) Put(ctx context.Context, payload []byte) (string, error) {
+	key, err := newKey()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, payload, time.Now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload payload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to upload payload: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return "s3://" + s.bucket + "/" + key, nil
+}
+
+// objectURL возвращает path-style URL объекта: endpoint/bucket/key
+func (s *S3Store) objectURL(key string) string {
+	u := *s.endpoint
+	u.Path += "/" + s.bucket + "/" + key
+	return u.String()
+}
+
+// newKey генерирует случайный ключ объекта: ID задания до вставки еще неизвестен
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate payload key: %w", err)
+	}
+	return keyPrefix + hex.EncodeToString(b) + ".json", nil
+}
+
+// sign подписывает запрос AWS Signature Version 4 (заголовок Authorization).
+// Подписываются заголовки host, x-amz-content-sha256 и x-amz-date; тело передается целиком, поэтому
+// его хеш считается заранее, а не UNSIGNED-PAYLOAD.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
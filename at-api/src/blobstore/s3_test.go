@@ -0,0 +1,70 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestPut проверяет загрузку payload: path-style URL, подпись и возвращаемую ссылку
+func TestPut(t *testing.T) {
+	var gotPath, gotAuth, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("Method: got=%s, want=PUT", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotAuth, gotBody = r.URL.Path, r.Header.Get("Authorization"), string(body)
+	}))
+	defer server.Close()
+
+	store, err := NewS3Store(Options{Endpoint: server.URL + "/", Bucket: "payloads", Region: "us-east-1", AccessKey: "AKID", SecretKey: "secret"})
+	if err != nil {
+		t.Fatalf("NewS3Store failed: %v", err)
+	}
+
+	ref, err := store.Put(context.Background(), []byte(`{"html":"<p>hi</p>"}`))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	key := strings.TrimPrefix(gotPath, "/payloads/")
+	if !strings.HasPrefix(key, keyPrefix) || ref != "s3://payloads/"+key {
+		t.Errorf("Unexpected object path %q or ref %q", gotPath, ref)
+	}
+	if gotBody != `{"html":"<p>hi</p>"}` {
+		t.Errorf("Body: got=%s", gotBody)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(gotAuth, "/us-east-1/s3/aws4_request") {
+		t.Errorf("Unexpected Authorization header: %s", gotAuth)
+	}
+}
+
+// TestPutError проверяет, что неуспешный ответ хранилища возвращается ошибкой
+func TestPutError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	store, err := NewS3Store(Options{Endpoint: server.URL, Bucket: "payloads"})
+	if err != nil {
+		t.Fatalf("NewS3Store failed: %v", err)
+	}
+	if _, err := store.Put(context.Background(), []byte(`{}`)); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected upload error with status 403, got %v", err)
+	}
+}
+
+// TestNewS3StoreValidation проверяет обязательные параметры хранилища
+func TestNewS3StoreValidation(t *testing.T) {
+	if _, err := NewS3Store(Options{Endpoint: "minio:9000", Bucket: "payloads"}); err == nil {
+		t.Error("Expected error for endpoint without scheme")
+	}
+	if _, err := NewS3Store(Options{Endpoint: "http://minio:9000"}); err == nil {
+		t.Error("Expected error for empty bucket")
+	}
+}
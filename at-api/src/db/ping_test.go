@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyPinger возвращает ошибку на первых failures вызовах
+type flakyPinger struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyPinger) PingContext(ctx context.Context) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+// TestPingWithRetry проверяет, что кратковременные ошибки ping не делают сервис not-ready
+func TestPingWithRetry(t *testing.T) {
+	testCases := []struct {
+		name      string
+		failures  int
+		retries   int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"first ping succeeds", 0, 2, false, 1},
+		{"recovers within retries", 2, 2, false, 3},
+		{"fails after retries", 3, 2, true, 3},
+		{"no retries", 1, 0, true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &flakyPinger{failures: tc.failures}
+			err := PingWithRetry(context.Background(), p, tc.retries, time.Millisecond)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Error: got=%v, wantErr=%v", err, tc.wantErr)
+			}
+			if p.calls != tc.wantCalls {
+				t.Errorf("Calls: got=%d, want=%d", p.calls, tc.wantCalls)
+			}
+		})
+	}
+}
@@ -6,19 +6,27 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq" // Драйвер PostgreSQL
 )
 
+// ApplicationName - application_name соединений API (если не задан в строке подключения);
+// под этим именем смены статуса, сделанные API, попадают в историю задания (state_transitions)
+const ApplicationName = "at-api"
+
 // NewPostgresDB создает новое подключение к PostgreSQL и возвращает пул соединений.
 // Параметры:
 //   - dsn: строка подключения в формате "host=... port=... user=... password=... dbname=... sslmode=..."
+//   - statementTimeout: серверный таймаут одного запроса (statement_timeout), 0 - без ограничения
 //
 // Возвращает указатель на sql.DB или ошибку при невозможности подключения.
 // Также настраивает параметры пула соединений для оптимальной работы.
-func NewPostgresDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dsn)
+func NewPostgresDB(dsn string, statementTimeout time.Duration) (*sql.DB, error) {
+	db, err := sql.Open("postgres", withApplicationName(withStatementTimeout(dsn, statementTimeout), ApplicationName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -35,3 +43,48 @@ func NewPostgresDB(dsn string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// withStatementTimeout добавляет в строку подключения параметр statement_timeout (в миллисекундах).
+// Драйвер pq передает неизвестные ему параметры серверу при установке соединения, поэтому таймаут
+// действует для каждого соединения пула: PostgreSQL сам прерывает запрос, выполняющийся дольше,
+// даже если клиент уже перестал ждать ответа. Поддерживаются оба формата: "key=value" и URL.
+// timeout <= 0 - строка не меняется (таймаут выключен).
+func withStatementTimeout(dsn string, timeout time.Duration) string {
+	if timeout <= 0 {
+		return dsn
+	}
+	return withParam(dsn, "statement_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+}
+
+// withApplicationName добавляет в строку подключения fallback_application_name: имя приложения
+// попадает в application_name соединения, если оно не задано в самой строке. По нему триггер истории
+// статусов (state_transitions) записывает, кто сменил статус задания. Пустое name - строка не меняется
+func withApplicationName(dsn, name string) string {
+	if name == "" {
+		return dsn
+	}
+	return withParam(dsn, "fallback_application_name", name)
+}
+
+// dsnValueQuoter экранирует значение параметра строки "key=value" для заключения в одинарные кавычки
+var dsnValueQuoter = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// withParam добавляет параметр в строку подключения в формате "key=value" или URL
+func withParam(dsn, name, value string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// Некорректный URL отклонит sql.Open с понятной ошибкой
+			return dsn
+		}
+		q := u.Query()
+		q.Set(name, value)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	if value == "" || strings.ContainsAny(value, ` '\`) {
+		value = "'" + dsnValueQuoter.Replace(value) + "'"
+	}
+	return dsn + " " + name + "=" + value
+}
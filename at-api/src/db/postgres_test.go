@@ -0,0 +1,52 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWithStatementTimeout проверяет добавление statement_timeout в оба формата строки подключения
+func TestWithStatementTimeout(t *testing.T) {
+	testCases := []struct {
+		name    string
+		dsn     string
+		timeout time.Duration
+		want    string
+	}{
+		{"disabled", "host=db dbname=at", 0, "host=db dbname=at"},
+		{"key value", "host=db dbname=at", 30 * time.Second, "host=db dbname=at statement_timeout=30000"},
+		{"url", "postgres://reader@replica/at?sslmode=require", 1500 * time.Millisecond, "postgres://reader@replica/at?sslmode=require&statement_timeout=1500"},
+		{"url overrides", "postgresql://replica/at?statement_timeout=0", time.Second, "postgresql://replica/at?statement_timeout=1000"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := withStatementTimeout(tc.dsn, tc.timeout); got != tc.want {
+				t.Errorf("withStatementTimeout(%q, %v) = %q, want %q", tc.dsn, tc.timeout, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestWithApplicationName проверяет добавление fallback_application_name и экранирование значения
+func TestWithApplicationName(t *testing.T) {
+	testCases := []struct {
+		name    string
+		dsn     string
+		appName string
+		want    string
+	}{
+		{"disabled", "host=db dbname=at", "", "host=db dbname=at"},
+		{"key value", "host=db dbname=at", "at-api", "host=db dbname=at fallback_application_name=at-api"},
+		{"quoted", "host=db", `at-worker:node 1's`, `host=db fallback_application_name='at-worker:node 1\'s'`},
+		{"url", "postgres://replica/at?sslmode=require", "at-api", "postgres://replica/at?fallback_application_name=at-api&sslmode=require"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := withApplicationName(tc.dsn, tc.appName); got != tc.want {
+				t.Errorf("withApplicationName(%q, %q) = %q, want %q", tc.dsn, tc.appName, got, tc.want)
+			}
+		})
+	}
+}
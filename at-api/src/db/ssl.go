@@ -0,0 +1,27 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrConnectionNotEncrypted - SSL запрошен (sslmode не disable), но соединение с БД не зашифровано
+var ErrConnectionNotEncrypted = errors.New("SSL was requested, but the database connection is not encrypted")
+
+// VerifySSL проверяет, зашифровано ли соединение с БД на самом деле (pg_stat_ssl текущего backend'а).
+// Вызывается при старте: ошибка конфигурации SSL видна сразу, а не остается незамеченной работой открытым текстом.
+// Возвращает признак шифрования и ErrConnectionNotEncrypted, если sslMode требует SSL, а соединение без него.
+func VerifySSL(ctx context.Context, db *sql.DB, sslMode string) (bool, error) {
+	var encrypted bool
+	err := db.QueryRowContext(ctx, `SELECT ssl FROM pg_stat_ssl WHERE pid = pg_backend_pid()`).Scan(&encrypted)
+	if err != nil {
+		return false, fmt.Errorf("failed to check connection encryption: %w", err)
+	}
+
+	if sslMode != "disable" && !encrypted {
+		return false, fmt.Errorf("%w (sslmode=%s)", ErrConnectionNotEncrypted, sslMode)
+	}
+	return encrypted, nil
+}
@@ -1,7 +1,9 @@
 module at-api
 
-go 1.21
+go 1.22
 
 require github.com/lib/pq v1.10.9
 
 require github.com/joho/godotenv v1.5.1
+
+require github.com/robfig/cron/v3 v3.0.1
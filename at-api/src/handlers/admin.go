@@ -0,0 +1,27 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// Файл admin.go содержит проверку доступа к служебным эндпоинтам.
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin проверяет заголовок Authorization: Bearer <adminToken> и сам отвечает клиенту, если доступа нет:
+// 404, если токен не настроен (служебные эндпоинты выключены), 401 при отсутствующем или неверном токене.
+// Возвращает true, если запрос можно обрабатывать.
+func requireAdmin(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		respondWithError(w, r, http.StatusNotFound, "Not found")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		respondWithError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+	return true
+}
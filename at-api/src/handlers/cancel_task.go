@@ -3,47 +3,64 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"at-api/models"
 	"at-api/services"
 )
 
 // CancelTaskHandler обрабатывает DELETE /api/v1/tasks/:id - отмена задания.
-// Устанавливает статус задания в 'cancelled'.
-// Возвращает 404 если задание не найдено, 200 с обновленными данными при успехе.
+// Устанавливает статус задания в 'cancelled'. Необязательное тело {"reason": "..."} сохраняет
+// причину отмены в cancel_reason.
+// Возвращает 404 если задание не найдено, 400 при некорректной причине, 200 с обновленными данными при успехе.
 // Можно отменить только задания в статусе 'pending' или 'processing'.
 func CancelTaskHandler(taskService *services.TaskService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Извлекаем ID из URL пути (предполагается формат /api/v1/tasks/{id})
-		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-		if len(pathParts) < 4 {
-			respondWithError(w, http.StatusBadRequest, "Invalid URL format")
+		// Парсим ID задания из пути /api/v1/tasks/{id}
+		id, err := pathTaskID(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
 			return
 		}
 
-		// Парсим ID задания
-		idStr := pathParts[3]
-		id, err := strconv.ParseInt(idStr, 10, 64)
+		reason, err := decodeCancelReason(r)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid task ID")
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		// Отменяем задание через сервис
-		task, err := taskService.CancelTask(id)
+		task, err := taskService.CancelTask(r.Context(), id, reason)
 		if err != nil {
 			if err == services.ErrTaskNotFound {
-				respondWithError(w, http.StatusNotFound, "Task not found or cannot be cancelled")
+				respondWithError(w, r, http.StatusNotFound, "Task not found or cannot be cancelled")
 				return
 			}
-			respondWithError(w, http.StatusInternalServerError, "Failed to cancel task")
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to cancel task")
 			return
 		}
 
 		// Возвращаем обновленное задание
-		respondWithJSON(w, http.StatusOK, models.TaskResponse{Task: task})
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
+
+// decodeCancelReason читает необязательное тело запроса на отмену и возвращает причину.
+// Пустое тело или причина из одних пробелов означает отмену без причины
+func decodeCancelReason(r *http.Request) (string, error) {
+	var req models.CancelTaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return "", errors.New(decodeErrorMessage(err))
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(reason) > models.MaxCancelReasonLength {
+		return "", fmt.Errorf("reason must be at most %d characters", models.MaxCancelReasonLength)
 	}
+	return reason, nil
 }
@@ -0,0 +1,46 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// CancelTaskByKeyHandler обрабатывает DELETE запросы на отмену задания по dedup_key.
+package handlers
+
+import (
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// CancelTaskByKeyHandler обрабатывает DELETE /api/v1/tasks/by-key/:key - отмена задания по dedup_key.
+// Позволяет клиенту, сохранившему только ключ, отменить задание без предварительного поиска ID.
+// Отменяется только активное задание ('pending', 'processing' или 'hold') с этим ключом.
+// Необязательное тело {"reason": "..."} - как у CancelTaskHandler.
+// Возвращает 404 если активного задания с ключом нет, 200 с обновленными данными при успехе.
+func CancelTaskByKeyHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Ключ - весь остаток пути (может содержать "/"), см. шаблон {key...} в RegisterRoutes
+		key := r.PathValue("key")
+		if key == "" {
+			respondWithError(w, r, http.StatusBadRequest, "dedup key is required")
+			return
+		}
+
+		reason, err := decodeCancelReason(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Отменяем задание через сервис
+		task, err := taskService.CancelTaskByKey(r.Context(), key, reason)
+		if err != nil {
+			if err == services.ErrTaskNotFound {
+				respondWithError(w, r, http.StatusNotFound, "No active task with this key")
+				return
+			}
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to cancel task")
+			return
+		}
+
+		// Возвращаем обновленное задание
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
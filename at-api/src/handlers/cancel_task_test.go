@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// createCancelTestTask создает задание с dedup_key "orders/42", которое можно отменить
+func createCancelTestTask(t *testing.T, taskService *services.TaskService) *models.ScheduledTask {
+	t.Helper()
+	task, err := taskService.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  "test_task",
+		Payload:   json.RawMessage(`{}`),
+		DedupKey:  "orders/42",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	return task
+}
+
+// TestCancelTaskHandlerReason проверяет сохранение причины отмены: пустая или из одних пробелов
+// причина не записывается в cancel_reason
+func TestCancelTaskHandlerReason(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		wantReason string // Пусто - cancel_reason не задан
+	}{
+		{"no body", "", ""},
+		{"empty object", `{}`, ""},
+		{"blank reason", `{"reason": "   "}`, ""},
+		{"reason", `{"reason": " duplicate order "}`, "duplicate order"},
+		{"max length", `{"reason": "` + strings.Repeat("я", models.MaxCancelReasonLength) + `"}`, strings.Repeat("я", models.MaxCancelReasonLength)},
+	}
+
+	for _, tc := range testCases {
+		taskService := newTestTaskService()
+		task := createCancelTestTask(t, taskService)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/v1/tasks/%d", task.ID), strings.NewReader(tc.body))
+		newTestRouter(taskService).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status got=%d, want=%d, body=%s", tc.name, rec.Code, http.StatusOK, rec.Body.String())
+		}
+
+		stored, err := taskService.GetTask(context.Background(), task.ID)
+		if err != nil {
+			t.Fatalf("%s: failed to get task: %v", tc.name, err)
+		}
+		if stored.Status != "cancelled" {
+			t.Errorf("%s: status got=%s, want=cancelled", tc.name, stored.Status)
+		}
+		switch {
+		case tc.wantReason == "" && stored.CancelReason != nil:
+			t.Errorf("%s: cancel_reason got=%q, want not set", tc.name, *stored.CancelReason)
+		case tc.wantReason != "" && (stored.CancelReason == nil || *stored.CancelReason != tc.wantReason):
+			t.Errorf("%s: cancel_reason got=%v, want=%q", tc.name, stored.CancelReason, tc.wantReason)
+		}
+	}
+}
+
+// TestCancelTaskHandlerReasonValidation проверяет ответ 400 на слишком длинную или некорректную причину:
+// задание при этом не отменяется
+func TestCancelTaskHandlerReasonValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		byKey   bool
+		body    string
+		wantErr string
+	}{
+		{"too long", false, `{"reason": "` + strings.Repeat("я", models.MaxCancelReasonLength+1) + `"}`,
+			fmt.Sprintf("reason must be at most %d characters", models.MaxCancelReasonLength)},
+		{"too long by key", true, `{"reason": "` + strings.Repeat("a", models.MaxCancelReasonLength+1) + `"}`,
+			fmt.Sprintf("reason must be at most %d characters", models.MaxCancelReasonLength)},
+		{"not a string", false, `{"reason": 42}`, "reason"},
+	}
+
+	for _, tc := range testCases {
+		taskService := newTestTaskService()
+		task := createCancelTestTask(t, taskService)
+
+		path := fmt.Sprintf("/api/v1/tasks/%d", task.ID)
+		if tc.byKey {
+			path = "/api/v1/tasks/by-key/orders/42"
+		}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, path, strings.NewReader(tc.body))
+		newTestRouter(taskService).ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status got=%d, want=%d, body=%s", tc.name, rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+		if !strings.Contains(rec.Body.String(), tc.wantErr) {
+			t.Errorf("%s: error got=%s, want it to mention %q", tc.name, rec.Body.String(), tc.wantErr)
+		}
+
+		stored, err := taskService.GetTask(context.Background(), task.ID)
+		if err != nil {
+			t.Fatalf("%s: failed to get task: %v", tc.name, err)
+		}
+		if stored.Status != "pending" {
+			t.Errorf("%s: status got=%s, want pending (not cancelled)", tc.name, stored.Status)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// ClaimTasksHandler обрабатывает захват заданий внешними (pull) клиентами.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// ClaimTasksHandler обрабатывает POST /api/v1/tasks/claim - захват готовых заданий внешним клиентом.
+// Принимает JSON с полями queue, task_types, limit и lease_seconds (все опциональны).
+// Возвращает 200 со списком захваченных заданий (возможно пустым); у каждого задания есть
+// lease_token и locked_until. 400 если lease_seconds вне допустимого диапазона.
+func ClaimTasksHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Тело запроса необязательно: пустое тело означает "любые задания с параметрами по умолчанию"
+		var req models.ClaimTasksRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+
+		tasks, err := taskService.ClaimTasks(r.Context(), &req)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidLease) {
+				respondWithError(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to claim tasks")
+			return
+		}
+
+		response := models.ClaimTasksResponse{Tasks: make([]models.ClaimedTask, 0, len(tasks))}
+		for _, task := range tasks {
+			claimed := models.ClaimedTask{ScheduledTask: task}
+			if task.LeaseToken != nil {
+				claimed.LeaseToken = *task.LeaseToken
+			}
+			response.Tasks = append(response.Tasks, claimed)
+		}
+
+		respondWithJSON(w, r, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// TestClaimTasksHandlerLeaseToken проверяет, что в ответе claim у задания есть lease_token
+// (ClaimedTask не должен наследовать MarshalJSON встроенного ScheduledTask)
+func TestClaimTasksHandlerLeaseToken(t *testing.T) {
+	store := services.NewMemoryTaskStore()
+	taskService := services.NewTaskService(store, services.Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute})
+
+	// Хранилище не проверяет execute_at, поэтому задание сразу готово к выполнению
+	task, err := store.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt:   time.Now().Add(-time.Minute),
+		TaskType:    "email",
+		Payload:     json.RawMessage(`{}`),
+		MaxAttempts: 3,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks/claim", strings.NewReader(`{"task_types": ["email"]}`))
+	rec := httptest.NewRecorder()
+	ClaimTasksHandler(taskService)(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var resp struct {
+		Tasks []map[string]interface{} `json:"tasks"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Tasks) != 1 {
+		t.Fatalf("Claimed tasks: got=%d, want=1", len(resp.Tasks))
+	}
+	claimed := resp.Tasks[0]
+	if token, _ := claimed["lease_token"].(string); token == "" {
+		t.Errorf("lease_token: got=%v, want non-empty token", claimed["lease_token"])
+	}
+	if id, _ := claimed["id"].(float64); int64(id) != task.ID || claimed["status"] != "processing" {
+		t.Errorf("Claimed task: got id=%v status=%v, want id=%d status=processing", claimed["id"], claimed["status"], task.ID)
+	}
+	if lockedUntil, _ := claimed["locked_until"].(string); !strings.HasSuffix(lockedUntil, "Z") {
+		t.Errorf("locked_until: got=%q, want UTC time", lockedUntil)
+	}
+}
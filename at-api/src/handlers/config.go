@@ -0,0 +1,28 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// ConfigHandler обрабатывает запросы действующей конфигурации.
+package handlers
+
+import (
+	"net/http"
+
+	"at-api/models"
+)
+
+// ConfigHandler обрабатывает GET /config - действующая конфигурация API.
+// settings - результат config.Effective(): имена переменных окружения и загруженные значения,
+// пароли и токены замаскированы. Помогает понять, какое значение на самом деле действует
+// (переменная окружения, .env или значение по умолчанию).
+// Доступен только с токеном API_ADMIN_TOKEN (см. requireAdmin).
+func ConfigHandler(settings map[string]string, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !requireAdmin(w, r, adminToken) {
+			return
+		}
+
+		respondWithJSON(w, r, http.StatusOK, models.ConfigResponse{Config: settings})
+	}
+}
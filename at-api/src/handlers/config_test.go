@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"at-api/models"
+)
+
+// TestConfigHandler проверяет доступ к GET /config по токену администратора
+func TestConfigHandler(t *testing.T) {
+	settings := map[string]string{"API_PORT": "8080", "DB_PASSWORD": "****"}
+
+	testCases := []struct {
+		name          string
+		adminToken    string
+		authorization string
+		wantStatus    int
+	}{
+		{"token not configured", "", "Bearer secret", http.StatusNotFound},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/config", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := httptest.NewRecorder()
+			ConfigHandler(settings, tc.adminToken)(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, tc.wantStatus, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var resp models.ConfigResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Config["API_PORT"] != "8080" {
+				t.Errorf("API_PORT: got=%q, want=8080", resp.Config["API_PORT"])
+			}
+		})
+	}
+}
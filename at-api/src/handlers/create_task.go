@@ -4,58 +4,381 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"at-api/models"
 	"at-api/services"
 )
 
 // CreateTaskHandler обрабатывает POST /api/v1/tasks - создание нового задания.
-// Принимает JSON с полями: execute_at, task_type, payload, max_attempts (опционально).
-// Возвращает созданное задание со статусом 201 Created или ошибку.
+// Принимает JSON с полями: execute_at, task_type, payload, queue, max_attempts, dedup_key, on_duplicate,
+// dedup_window_seconds, timeout_seconds, skip_if_late_seconds, notify, interval, schedule или cron_expression,
+// on_success и on_failure (опционально).
+// Возвращает созданное задание со статусом 201 Created и заголовком Location или ошибку.
+// Если активное задание с тем же dedup_key уже есть (или с dedup_window_seconds - любое задание
+// с ключом, созданное в окне) - 409 Conflict,
+// либо 200 OK с существующим заданием при on_duplicate=return_existing.
 func CreateTaskHandler(taskService *services.TaskService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Декодируем JSON из тела запроса
 		var req models.CreateTaskRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
 			return
 		}
 
-		// Валидация обязательных полей
-		if req.ExecuteAt.IsZero() {
-			respondWithError(w, http.StatusBadRequest, "execute_at is required")
+		// Значения payload по умолчанию для типа подмешиваются до валидации: обязательные поля могут прийти из них
+		if err := taskService.ApplyPayloadDefaults(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		if req.TaskType == "" {
-			respondWithError(w, http.StatusBadRequest, "task_type is required")
+		if err := applySchedule(&req, time.Now()); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
-		if len(req.Payload) == 0 {
-			respondWithError(w, http.StatusBadRequest, "payload is required")
+		if err := validateCreateTaskRequest(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		// Создаем задание через сервис
-		task, err := taskService.CreateTask(&req)
+		task, err := taskService.CreateTask(r.Context(), &req)
+		var duplicate *services.DuplicateTaskError
+		if errors.As(err, &duplicate) {
+			if req.OnDuplicate == models.OnDuplicateReturnExisting {
+				w.Header().Set("Location", fmt.Sprintf("/api/v1/tasks/%d", duplicate.Existing.ID))
+				respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: duplicate.Existing, Duplicate: true})
+				return
+			}
+			respondWithError(w, r, http.StatusConflict, err.Error())
+			return
+		}
 		if err != nil {
-			if err == services.ErrInvalidExecuteTime {
-				respondWithError(w, http.StatusBadRequest, err.Error())
+			if err == services.ErrInvalidExecuteTime || errors.Is(err, services.ErrInvalidMaxAttempts) || errors.Is(err, services.ErrBlockedURL) {
+				respondWithError(w, r, http.StatusBadRequest, err.Error())
 				return
 			}
-			respondWithError(w, http.StatusInternalServerError, "Failed to create task")
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to create task")
 			return
 		}
 
-		// Возвращаем созданное задание
-		respondWithJSON(w, http.StatusCreated, models.TaskResponse{Task: task})
+		// Возвращаем созданное задание; Location указывает на созданный ресурс
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/tasks/%d", task.ID))
+		respondWithJSON(w, r, http.StatusCreated, models.TaskResponse{Task: task})
+	}
+}
+
+// validateCreateTaskRequest проверяет поля запроса на создание задания (обязательные поля,
+// ограничения длины и диапазоны). Используется и для одного задания, и для каждого задания батча.
+func validateCreateTaskRequest(req *models.CreateTaskRequest) error {
+	if req.ExecuteAt.IsZero() {
+		return errors.New("execute_at is required")
+	}
+	if req.TaskType == "" {
+		return errors.New("task_type is required")
+	}
+	if len(req.Payload) == 0 {
+		return errors.New("payload is required")
+	}
+	if err := validatePayload(req.TaskType, req.Payload); err != nil {
+		return err
+	}
+	if len(req.Queue) > 50 {
+		return errors.New("queue must be at most 50 characters")
+	}
+	if len(req.DedupKey) > 255 {
+		return errors.New("dedup_key must be at most 255 characters")
+	}
+	if req.Timeout < 0 || req.Timeout > models.MaxTimeoutSeconds {
+		return fmt.Errorf("timeout_seconds must be between 1 and %d", models.MaxTimeoutSeconds)
+	}
+	if req.ResultTTL < 0 || req.ResultTTL > models.MaxResultTTLSeconds {
+		return fmt.Errorf("result_ttl_seconds must be between 1 and %d", models.MaxResultTTLSeconds)
+	}
+	if req.SkipIfLate < 0 || req.SkipIfLate > models.MaxSkipIfLateSeconds {
+		return fmt.Errorf("skip_if_late_seconds must be between 1 and %d", models.MaxSkipIfLateSeconds)
+	}
+	if req.Priority < models.MinPriority || req.Priority > models.MaxPriority {
+		return fmt.Errorf("priority must be between %d and %d", models.MinPriority, models.MaxPriority)
+	}
+	if req.DedupWindow < 0 || req.DedupWindow > models.MaxDedupWindowSeconds {
+		return fmt.Errorf("dedup_window_seconds must be between 1 and %d", models.MaxDedupWindowSeconds)
+	}
+	if req.DedupWindow > 0 && req.DedupKey == "" {
+		return errors.New("dedup_window_seconds requires dedup_key")
+	}
+	if err := validateRecurrence(req); err != nil {
+		return err
+	}
+	if err := validateNotify(req.Notify); err != nil {
+		return err
+	}
+	if err := validateFollowUps("", req.OnSuccess, req.OnFailure, 1); err != nil {
+		return err
+	}
+	switch req.OnDuplicate {
+	case "", models.OnDuplicateReject, models.OnDuplicateReturnExisting:
+	default:
+		return errors.New("on_duplicate must be one of: reject, return_existing")
+	}
+	return nil
+}
+
+// applySchedule переводит сокращение schedule в поля повторяющегося задания: interval - период расписания,
+// execute_at - первый момент расписания не раньше переданного execute_at и не раньше now (execute_at
+// со schedule необязателен). Выполняется до validateCreateTaskRequest, которая проверяет результат как обычно.
+// cron_expression обрабатывается так же (см. applyCron)
+func applySchedule(req *models.CreateTaskRequest, now time.Time) error {
+	if req.CronExpression != "" {
+		return applyCron(req, now)
+	}
+	if req.Schedule == "" {
+		return nil
+	}
+	if req.Interval != "" || req.Align != "" {
+		return errors.New("schedule cannot be combined with interval or align")
+	}
+	schedule, err := models.ParseSchedule(req.Schedule)
+	if err != nil {
+		return err
+	}
+	from := now
+	if req.ExecuteAt.After(now) {
+		from = req.ExecuteAt
+	}
+	req.ExecuteAt = schedule.Next(from)
+	req.Interval = schedule.Interval.String()
+	return nil
+}
+
+// applyCron проверяет cron_expression и переносит execute_at на первый момент расписания не раньше
+// переданного execute_at и не раньше now (execute_at с cron_expression необязателен).
+// interval для cron-задания не задается: следующее выполнение считается по расписанию после каждого успешного
+func applyCron(req *models.CreateTaskRequest, now time.Time) error {
+	if req.Schedule != "" || req.Interval != "" || req.Align != "" {
+		return errors.New("cron_expression cannot be combined with schedule, interval or align")
+	}
+	if len(req.CronExpression) > models.MaxCronExpressionLength {
+		return fmt.Errorf("cron_expression must be at most %d characters", models.MaxCronExpressionLength)
+	}
+	schedule, err := models.ParseCron(req.CronExpression)
+	if err != nil {
+		return fmt.Errorf("invalid cron_expression: %v", err)
+	}
+	from := now
+	if req.ExecuteAt.After(now) {
+		from = req.ExecuteAt
+	}
+	next := models.CronNext(schedule, from)
+	if next.IsZero() {
+		return errors.New("cron_expression has no upcoming runs")
+	}
+	req.ExecuteAt = next.UTC()
+	return nil
+}
+
+// validateRecurrence проверяет поля повторяющегося задания: interval - Go duration не меньше
+// models.MinInterval, max_executions и end_at имеют смысл только вместе с interval или cron_expression,
+// align - только вместе с interval.
+// С align interval должен быть кратен границе, иначе привязка к ближайшей границе меняла бы период
+func validateRecurrence(req *models.CreateTaskRequest) error {
+	var interval time.Duration
+	if req.Interval != "" {
+		var err error
+		interval, err = time.ParseDuration(req.Interval)
+		if err != nil || interval < models.MinInterval {
+			return fmt.Errorf("interval must be a duration of at least %v (e.g. 15m, 1h30m)", models.MinInterval)
+		}
+	}
+	if req.MaxExecutions < 0 {
+		return errors.New("max_executions must be positive")
+	}
+	if (req.MaxExecutions > 0 || req.EndAt != nil) && req.Interval == "" && req.CronExpression == "" {
+		return errors.New("max_executions and end_at require interval or cron_expression")
+	}
+	if req.Align != "" && req.Interval == "" {
+		return errors.New("align requires interval")
+	}
+	if req.Align != "" {
+		unit := models.AlignUnit(req.Align)
+		if unit == 0 {
+			return fmt.Errorf("align must be one of: %s, %s, %s", models.AlignMinute, models.AlignHour, models.AlignDay)
+		}
+		if interval%unit != 0 {
+			return fmt.Errorf("interval must be a multiple of 1 %s with align=%s", req.Align, req.Align)
+		}
+	}
+	if req.EndAt != nil && req.EndAt.Before(req.ExecuteAt) {
+		return errors.New("end_at must not be before execute_at")
+	}
+	return nil
+}
+
+// validatePayload проверяет обязательные поля payload для типов заданий, у которых они известны
+// (rabbitmq - queue и message, email - to и subject). Worker проверяет то же самое перед выполнением,
+// но отклонить задание при создании лучше, чем узнать об ошибке в момент execute_at.
+// Payload остальных типов не проверяется.
+func validatePayload(taskType string, raw json.RawMessage) error {
+	switch taskType {
+	case "rabbitmq":
+		var payload struct {
+			Queue   string          `json:"queue"`
+			Message json.RawMessage `json:"message"`
+		}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return errors.New("payload must be a JSON object")
+		}
+		if strings.TrimSpace(payload.Queue) == "" {
+			return errors.New("payload.queue is required for rabbitmq tasks")
+		}
+		if len(payload.Message) == 0 {
+			return errors.New("payload.message is required for rabbitmq tasks")
+		}
+	case "email":
+		var payload struct {
+			To      string `json:"to"`
+			Subject string `json:"subject"`
+		}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return errors.New("payload must be a JSON object")
+		}
+		if strings.TrimSpace(payload.To) == "" {
+			return errors.New("payload.to is required for email tasks")
+		}
+		if _, err := mail.ParseAddress(payload.To); err != nil {
+			return errors.New("payload.to must be a valid email address")
+		}
+		if payload.Subject == "" {
+			return errors.New("payload.subject is required for email tasks")
+		}
+	}
+	return nil
+}
+
+// validateFollowUps проверяет задания on_success и on_failure (и вложенные в них) так же, как поля
+// создаваемого задания. prefix - путь родителя для сообщения об ошибке ("on_success." для вложенных),
+// depth - уровень вложенности, не больше models.MaxFollowUpDepth
+func validateFollowUps(prefix string, onSuccess, onFailure *models.FollowUpTask, depth int) error {
+	for _, followUp := range []struct {
+		name string
+		task *models.FollowUpTask
+	}{{"on_success", onSuccess}, {"on_failure", onFailure}} {
+		if followUp.task == nil {
+			continue
+		}
+		if depth > models.MaxFollowUpDepth {
+			return fmt.Errorf("on_success and on_failure must be nested at most %d levels deep", models.MaxFollowUpDepth)
+		}
+		if err := validateFollowUp(prefix+followUp.name+".", followUp.task, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateFollowUp проверяет одно задание цепочки; path - префикс полей в сообщении об ошибке
+func validateFollowUp(path string, task *models.FollowUpTask, depth int) error {
+	if task.TaskType == "" {
+		return fmt.Errorf("%stask_type is required", path)
+	}
+	if len(task.TaskType) > 50 {
+		return fmt.Errorf("%stask_type must be at most 50 characters", path)
+	}
+	if len(task.Payload) == 0 {
+		return fmt.Errorf("%spayload is required", path)
+	}
+	if err := validatePayload(task.TaskType, task.Payload); err != nil {
+		return fmt.Errorf("%s%w", path, err)
+	}
+	if len(task.Queue) > 50 {
+		return fmt.Errorf("%squeue must be at most 50 characters", path)
+	}
+	if task.MaxAttempts < 0 {
+		return fmt.Errorf("%smax_attempts must be positive", path)
+	}
+	if task.Timeout < 0 || task.Timeout > models.MaxTimeoutSeconds {
+		return fmt.Errorf("%stimeout_seconds must be between 1 and %d", path, models.MaxTimeoutSeconds)
+	}
+	if task.Delay < 0 || task.Delay > models.MaxFollowUpDelaySeconds {
+		return fmt.Errorf("%sdelay_seconds must be between 1 and %d", path, models.MaxFollowUpDelaySeconds)
+	}
+	if err := validateNotify(task.Notify); err != nil {
+		return fmt.Errorf("%s%w", path, err)
+	}
+	return validateFollowUps(path, task.OnSuccess, task.OnFailure, depth+1)
+}
+
+// validateNotify проверяет каналы уведомлений: не больше MaxNotifyChannels,
+// известный тип и абсолютный http(s) URL получателя
+func validateNotify(channels []models.NotifyChannel) error {
+	if len(channels) > models.MaxNotifyChannels {
+		return fmt.Errorf("notify must have at most %d channels", models.MaxNotifyChannels)
+	}
+	for i, channel := range channels {
+		switch channel.Type {
+		case models.NotifyWebhook, models.NotifySlack:
+		default:
+			return fmt.Errorf("notify[%d].type must be one of: %s, %s", i, models.NotifyWebhook, models.NotifySlack)
+		}
+		target, err := url.Parse(channel.Target)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return fmt.Errorf("notify[%d].target must be an absolute http or https URL", i)
+		}
+	}
+	return nil
+}
+
+// decodeErrorMessage формирует понятное клиенту сообщение об ошибке разбора JSON тела запроса.
+// Сообщает, что именно не так (синтаксис, тип поля, формат времени), но не пропускает
+// наружу текст остальных ошибок - для них возвращается общее "Invalid request body".
+func decodeErrorMessage(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return "Invalid request body: body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "Invalid request body: unexpected end of JSON input"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("Invalid request body: malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Sprintf("Invalid request body: expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+		return fmt.Sprintf("Invalid request body: %s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case errors.As(err, &timeErr):
+		// Имя поля добавляет CreateTaskRequest.UnmarshalJSON; Unix timestamp допустим только в execute_at
+		var fieldErr *models.TimeFieldError
+		if errors.As(err, &fieldErr) && fieldErr.Field != "execute_at" {
+			return fmt.Sprintf("Invalid request body: %s: cannot parse %q as RFC3339 (e.g. 2025-11-10T15:00:00Z)", fieldErr.Field, timeErr.Value)
+		}
+		return fmt.Sprintf("Invalid request body: execute_at: cannot parse %q as RFC3339 (e.g. 2025-11-10T15:00:00Z) or Unix timestamp", timeErr.Value)
+	default:
+		return "Invalid request body"
 	}
 }
 
 // respondWithJSON отправляет JSON ответ с указанным статус кодом.
 // Используется для возврата успешных ответов с данными.
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, err := json.Marshal(payload)
+// При ?pretty=true ответ форматируется с отступами (удобно при отладке через curl).
+func respondWithJSON(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
+	var response []byte
+	var err error
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		response, err = json.MarshalIndent(payload, "", "  ")
+	} else {
+		response, err = json.Marshal(payload)
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("Failed to marshal response"))
@@ -69,6 +392,6 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 
 // respondWithError отправляет JSON ответ с ошибкой.
 // Используется для возврата ошибок клиенту в унифицированном формате.
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, models.ErrorResponse{Error: message})
+func respondWithError(w http.ResponseWriter, r *http.Request, code int, message string) {
+	respondWithJSON(w, r, code, models.ErrorResponse{Error: message})
 }
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// newTestTaskService создает TaskService поверх хранилища в памяти
+func newTestTaskService() *services.TaskService {
+	return services.NewTaskService(services.NewMemoryTaskStore(), services.Options{MaxAttemptsLimit: 100})
+}
+
+// newTestRouter создает mux со всеми маршрутами заданий: параметры пути ({id}, {key...}) заполняет ServeMux
+func newTestRouter(taskService *services.TaskService) http.Handler {
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, taskService)
+	return mux
+}
+
+// TestCreateTaskHandler проверяет успешное создание задания
+func TestCreateTaskHandler(t *testing.T) {
+	handler := CreateTaskHandler(newTestTaskService())
+
+	body := `{"execute_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `",
+		"task_type": "test_task", "payload": {"key": "value"}, "max_attempts": 5}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+
+	var resp models.TaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Task.TaskType != "test_task" || resp.Task.MaxAttempts != 5 || resp.Task.Status != "pending" {
+		t.Errorf("Unexpected task: %+v", resp.Task)
+	}
+
+	if location, want := rec.Header().Get("Location"), fmt.Sprintf("/api/v1/tasks/%d", resp.Task.ID); location != want {
+		t.Errorf("Location: got=%q, want=%q", location, want)
+	}
+}
+
+// TestCreateTaskHandlerDedupKey проверяет отказ и возврат существующего задания при дубликате dedup_key
+func TestCreateTaskHandlerDedupKey(t *testing.T) {
+	handler := CreateTaskHandler(newTestTaskService())
+	body := func(onDuplicate string) string {
+		return `{"execute_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `",
+			"task_type": "test_task", "payload": {}, "dedup_key": "send-welcome-user-42", "on_duplicate": "` + onDuplicate + `"}`
+	}
+	create := func(onDuplicate string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body(onDuplicate)))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := create("")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("First create status: got=%d, want=%d, body=%s", first.Code, http.StatusCreated, first.Body.String())
+	}
+	var created models.TaskResponse
+	if err := json.NewDecoder(first.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if rec := create(models.OnDuplicateReject); rec.Code != http.StatusConflict {
+		t.Errorf("Duplicate reject status: got=%d, want=%d", rec.Code, http.StatusConflict)
+	}
+
+	rec := create(models.OnDuplicateReturnExisting)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Duplicate return_existing status: got=%d, want=%d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var existing models.TaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&existing); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !existing.Duplicate || existing.Task.ID != created.Task.ID {
+		t.Errorf("Duplicate response: got duplicate=%v id=%d, want duplicate=true id=%d", existing.Duplicate, existing.Task.ID, created.Task.ID)
+	}
+}
+
+// TestGetTaskByKeyHandler проверяет поиск задания по dedup_key и 404 для неизвестного ключа
+func TestGetTaskByKeyHandler(t *testing.T) {
+	taskService := newTestTaskService()
+	created, err := taskService.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  "test_task",
+		Payload:   json.RawMessage(`{}`),
+		DedupKey:  "orders/42",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	router := newTestRouter(taskService)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/by-key/orders/42", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp models.TaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Task.ID != created.ID {
+		t.Errorf("Task ID: got=%d, want=%d", resp.Task.ID, created.ID)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/by-key/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown key status: got=%d, want=%d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// TestCreateTaskHandlerValidation проверяет ответы 400 на невалидные запросы
+func TestCreateTaskHandlerValidation(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{"invalid json", `{`},
+		{"missing execute_at", `{"task_type": "test", "payload": {}}`},
+		{"missing task_type", `{"execute_at": "` + future + `", "payload": {}}`},
+		{"missing payload", `{"execute_at": "` + future + `", "task_type": "test"}`},
+		{"execute_at in past", `{"execute_at": "` + past + `", "task_type": "test", "payload": {}}`},
+		{"timeout too large", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "timeout_seconds": 86401}`},
+		{"negative result ttl", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "result_ttl_seconds": -1}`},
+		{"dedup window without key", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "dedup_window_seconds": 3600}`},
+		{"negative dedup window", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "dedup_key": "k", "dedup_window_seconds": -1}`},
+		{"invalid interval", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "interval": "15 minutes"}`},
+		{"interval too short", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "interval": "500ms"}`},
+		{"max_executions without interval", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "max_executions": 3}`},
+		{"end_at before execute_at", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "interval": "1h", "end_at": "2000-01-01T00:00:00Z"}`},
+		{"align without interval", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "align": "hour"}`},
+		{"unknown align", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "interval": "1h", "align": "week"}`},
+		{"interval not multiple of align", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "interval": "90m", "align": "hour"}`},
+		{"unknown schedule", `{"task_type": "test", "payload": {}, "schedule": "monthly@01,09:00"}`},
+		{"schedule with interval", `{"task_type": "test", "payload": {}, "schedule": "daily@14:30", "interval": "1h"}`},
+		{"invalid cron", `{"task_type": "test", "payload": {}, "cron_expression": "*/5 * * *"}`},
+		{"cron with interval", `{"task_type": "test", "payload": {}, "cron_expression": "*/5 * * * *", "interval": "5m"}`},
+		{"cron with schedule", `{"task_type": "test", "payload": {}, "cron_expression": "*/5 * * * *", "schedule": "daily@14:30"}`},
+		{"cron unknown time zone", `{"task_type": "test", "payload": {}, "cron_expression": "CRON_TZ=Mars/Base 0 9 * * *"}`},
+		{"negative skip_if_late", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "skip_if_late_seconds": -5}`},
+		{"priority out of range", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "priority": 101}`},
+		{"unknown notify type", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "notify": [{"type": "sms", "target": "https://example.com"}]}`},
+		{"relative notify target", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "notify": [{"type": "webhook", "target": "/hooks/done"}]}`},
+		{"rabbitmq without queue", `{"execute_at": "` + future + `", "task_type": "rabbitmq", "payload": {"message": {}}}`},
+		{"email without to", `{"execute_at": "` + future + `", "task_type": "email", "payload": {"subject": "Hi"}}`},
+		{"email invalid to", `{"execute_at": "` + future + `", "task_type": "email", "payload": {"to": "nobody", "subject": "Hi"}}`},
+		{"invalid on_duplicate", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_duplicate": "replace"}`},
+		{"max_attempts over limit", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "max_attempts": 101}`},
+		{"on_success without task_type", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_success": {"payload": {}}}`},
+		{"on_failure without payload", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_failure": {"task_type": "test"}}`},
+		{"on_success invalid payload", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_success": {"task_type": "email", "payload": {"subject": "Hi"}}}`},
+		{"on_success negative delay", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_success": {"task_type": "test", "payload": {}, "delay_seconds": -1}}`},
+		{"nested on_failure max_attempts over limit", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_success": {"task_type": "test", "payload": {}, "on_failure": {"task_type": "test", "payload": {}, "max_attempts": 101}}}`},
+		{"on_success too deep", `{"execute_at": "` + future + `", "task_type": "test", "payload": {}, "on_success": ` + strings.Repeat(`{"task_type": "test", "payload": {}, "on_success": `, models.MaxFollowUpDepth) + `{"task_type": "test", "payload": {}}` + strings.Repeat(`}`, models.MaxFollowUpDepth) + `}`},
+	}
+
+	handler := CreateTaskHandler(newTestTaskService())
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestApplySchedule проверяет перевод сокращений schedule в interval и ближайший execute_at (UTC)
+func TestApplySchedule(t *testing.T) {
+	now := time.Date(2025, 11, 12, 10, 20, 0, 0, time.UTC) // Среда
+	startAt := time.Date(2025, 12, 1, 0, 0, 0, 0, time.FixedZone("", 5*3600))
+
+	testCases := []struct {
+		schedule  string
+		executeAt time.Time
+		want      time.Time
+		interval  string
+	}{
+		{"daily@14:30", time.Time{}, time.Date(2025, 11, 12, 14, 30, 0, 0, time.UTC), "24h0m0s"},
+		{"daily@09:00", time.Time{}, time.Date(2025, 11, 13, 9, 0, 0, 0, time.UTC), "24h0m0s"},
+		{"Daily@00:00", time.Time{}, time.Date(2025, 11, 13, 0, 0, 0, 0, time.UTC), "24h0m0s"},
+		{"hourly@:30", time.Time{}, time.Date(2025, 11, 12, 10, 30, 0, 0, time.UTC), "1h0m0s"},
+		{"hourly@:15", time.Time{}, time.Date(2025, 11, 12, 11, 15, 0, 0, time.UTC), "1h0m0s"},
+		{"weekly@mon,09:00", time.Time{}, time.Date(2025, 11, 17, 9, 0, 0, 0, time.UTC), "168h0m0s"},
+		{"weekly@wed,11:00", time.Time{}, time.Date(2025, 11, 12, 11, 0, 0, 0, time.UTC), "168h0m0s"},
+		{"weekly@wed,10:00", time.Time{}, time.Date(2025, 11, 19, 10, 0, 0, 0, time.UTC), "168h0m0s"},
+		{"daily@14:30", startAt, time.Date(2025, 12, 1, 14, 30, 0, 0, time.UTC), "24h0m0s"},
+		{"daily@14:30", now.Add(-48 * time.Hour), time.Date(2025, 11, 12, 14, 30, 0, 0, time.UTC), "24h0m0s"},
+	}
+	for _, tc := range testCases {
+		req := &models.CreateTaskRequest{Schedule: tc.schedule, ExecuteAt: tc.executeAt}
+		if err := applySchedule(req, now); err != nil {
+			t.Fatalf("%s: %v", tc.schedule, err)
+		}
+		if !req.ExecuteAt.Equal(tc.want) || req.ExecuteAt.Location() != time.UTC || req.Interval != tc.interval {
+			t.Errorf("%s from %v: got=%v every %s, want=%v every %s",
+				tc.schedule, tc.executeAt, req.ExecuteAt, req.Interval, tc.want, tc.interval)
+		}
+	}
+
+	for _, schedule := range []string{"daily@24:00", "daily@9:00", "daily", "hourly@15", "hourly@:60", "weekly@xyz,09:00", "weekly@mon", "cron@* * * * *"} {
+		if err := applySchedule(&models.CreateTaskRequest{Schedule: schedule}, now); err == nil {
+			t.Errorf("%s: expected error", schedule)
+		}
+	}
+}
+
+// TestApplyCron проверяет перенос execute_at на ближайший момент cron_expression
+func TestApplyCron(t *testing.T) {
+	now := time.Date(2025, 11, 12, 10, 22, 30, 0, time.UTC)
+
+	testCases := []struct {
+		expr      string
+		executeAt time.Time
+		want      time.Time
+	}{
+		{"*/5 * * * *", time.Time{}, time.Date(2025, 11, 12, 10, 25, 0, 0, time.UTC)},
+		{"*/5 * * * *", time.Date(2025, 11, 12, 11, 0, 0, 0, time.UTC), time.Date(2025, 11, 12, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Time{}, time.Date(2025, 11, 13, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Europe/Moscow 0 9 * * *", time.Time{}, time.Date(2025, 11, 13, 6, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range testCases {
+		req := &models.CreateTaskRequest{CronExpression: tc.expr, ExecuteAt: tc.executeAt}
+		if err := applySchedule(req, now); err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if !req.ExecuteAt.Equal(tc.want) || req.Interval != "" {
+			t.Errorf("%s from %v: got=%v (interval %q), want=%v", tc.expr, tc.executeAt, req.ExecuteAt, req.Interval, tc.want)
+		}
+	}
+
+	// max_executions и end_at допустимы и с cron_expression
+	req := &models.CreateTaskRequest{ExecuteAt: now, TaskType: "test", Payload: json.RawMessage(`{}`), CronExpression: "@hourly", MaxExecutions: 3}
+	if err := validateRecurrence(req); err != nil {
+		t.Errorf("max_executions with cron_expression: %v", err)
+	}
+}
+
+// TestCreateTaskHandlerFollowUp проверяет, что задания цепочки сохраняются с подставленными значениями по умолчанию
+func TestCreateTaskHandlerFollowUp(t *testing.T) {
+	handler := CreateTaskHandler(newTestTaskService())
+
+	body := `{"execute_at": "` + time.Now().Add(time.Hour).Format(time.RFC3339) + `", "task_type": "test", "payload": {},
+		"on_success": {"task_type": "next", "payload": {"step": 2}, "delay_seconds": 60,
+			"on_failure": {"task_type": "undo", "payload": {}, "queue": "high", "max_attempts": 10}}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var resp models.TaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Task.OnSuccess == nil || resp.Task.OnFailure != nil {
+		t.Fatalf("Expected only on_success, got on_success=%v on_failure=%v", resp.Task.OnSuccess, resp.Task.OnFailure)
+	}
+
+	var onSuccess models.FollowUpTask
+	if err := json.Unmarshal(*resp.Task.OnSuccess, &onSuccess); err != nil {
+		t.Fatalf("Failed to decode on_success: %v", err)
+	}
+	if onSuccess.TaskType != "next" || onSuccess.Queue != models.DefaultQueue || onSuccess.MaxAttempts != 3 || onSuccess.Delay != 60 {
+		t.Errorf("Unexpected on_success: %+v", onSuccess)
+	}
+	if onSuccess.OnFailure == nil || onSuccess.OnFailure.Queue != "high" || onSuccess.OnFailure.MaxAttempts != 10 {
+		t.Errorf("Unexpected nested on_failure: %+v", onSuccess.OnFailure)
+	}
+}
+
+// TestCreateTaskHandlerEpochExecuteAt проверяет execute_at в виде Unix timestamp в секундах и миллисекундах
+func TestCreateTaskHandlerEpochExecuteAt(t *testing.T) {
+	handler := CreateTaskHandler(newTestTaskService())
+	executeAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	for name, epoch := range map[string]int64{"seconds": executeAt.Unix(), "millis": executeAt.UnixMilli()} {
+		t.Run(name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"execute_at": %d, "task_type": "test_task", "payload": {}}`, epoch)
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusCreated, rec.Body.String())
+			}
+			var resp models.TaskResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if !resp.Task.ExecuteAt.Equal(executeAt) {
+				t.Errorf("ExecuteAt: got=%v, want=%v", resp.Task.ExecuteAt, executeAt)
+			}
+		})
+	}
+}
+
+// TestCreateTaskHandlerExecuteAtUTC проверяет, что execute_at со смещением клиента (+05:00) возвращается
+// при создании и чтении задания в UTC с "Z"
+func TestCreateTaskHandlerExecuteAtUTC(t *testing.T) {
+	router := newTestRouter(newTestTaskService())
+	executeAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	local := executeAt.In(time.FixedZone("", 5*3600)).Format(time.RFC3339)
+	want := `"execute_at":"` + executeAt.UTC().Format(time.RFC3339) + `"`
+	if !strings.HasSuffix(local, "+05:00") || !strings.HasSuffix(want, `Z"`) {
+		t.Fatalf("Unexpected test times: %s, %s", local, want)
+	}
+
+	body := `{"execute_at": "` + local + `", "task_type": "test_task", "payload": {}, "end_at": "` + local + `", "interval": "1h"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), want) || !strings.Contains(rec.Body.String(), `"end_at":"`+executeAt.UTC().Format(time.RFC3339)+`"`) {
+		t.Errorf("Create response must contain %s in UTC, got %s", want, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, rec.Header().Get("Location"), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("Get response must contain %s, got %d %s", want, rec.Code, rec.Body.String())
+	}
+}
+
+// TestCreateTaskHandlerDecodeErrors проверяет, что ошибка разбора тела указывает на поле и формат
+func TestCreateTaskHandlerDecodeErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty body", ``, "body is empty"},
+		{"truncated json", `{"task_type": "test"`, "unexpected end of JSON input"},
+		{"malformed json", `{"task_type": test}`, "malformed JSON at offset"},
+		{"type mismatch", `{"max_attempts": "five"}`, "max_attempts: expected int, got string"},
+		{"bad time format", `{"execute_at": "2025-11-10 15:00"}`, `execute_at: cannot parse "2025-11-10 15:00" as RFC3339`},
+		{"fractional epoch", `{"execute_at": 1762786800.5}`, "execute_at: expected time.Time, got number 1762786800.5"},
+		{"bool execute_at", `{"execute_at": true}`, "execute_at: expected time.Time, got bool"},
+		{"bad end_at format", `{"execute_at": "2025-11-10T15:00:00Z", "end_at": "tomorrow"}`, `Invalid request body: end_at: cannot parse "tomorrow" as RFC3339`},
+		{"bool end_at", `{"end_at": true}`, "end_at: expected time.Time, got bool"},
+	}
+
+	handler := CreateTaskHandler(newTestTaskService())
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			var resp models.ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if rec.Code != http.StatusBadRequest || !strings.Contains(resp.Error, tc.want) {
+				t.Errorf("Got status=%d error=%q, want 400 containing %q", rec.Code, resp.Error, tc.want)
+			}
+		})
+	}
+}
+
+// TestGetTaskHandlerNotFound проверяет 404 для несуществующего задания
+func TestGetTaskHandlerNotFound(t *testing.T) {
+	router := newTestRouter(newTestTaskService())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks/999", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Status: got=%d, want=%d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// TestRespondWithJSONPretty проверяет форматирование ответа при ?pretty=true
+func TestRespondWithJSONPretty(t *testing.T) {
+	payload := models.ErrorResponse{Error: "boom"}
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"/api/v1/tasks", `{"error":"boom"}`},
+		{"/api/v1/tasks?pretty=false", `{"error":"boom"}`},
+		{"/api/v1/tasks?pretty=true", "{\n  \"error\": \"boom\"\n}"},
+	}
+
+	for _, tc := range testCases {
+		rec := httptest.NewRecorder()
+		respondWithJSON(rec, httptest.NewRequest(http.MethodGet, tc.url, nil), http.StatusOK, payload)
+
+		if rec.Body.String() != tc.want {
+			t.Errorf("%s: got=%q, want=%q", tc.url, rec.Body.String(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// CreateTasksBatchHandler обрабатывает POST запросы на создание батча заданий.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// CreateTasksBatchHandler обрабатывает POST /api/v1/tasks/batch - создание батча заданий (импорт).
+// Принимает JSON {"tasks": [...]}, где каждое задание - тело POST /api/v1/tasks.
+// Батч создается одной транзакцией: при любой ошибке не создается ни одно задание.
+// Возвращает 201 Created с числом созданных заданий и самими заданиями в порядке запроса,
+// 400 Bad Request с индексом задания при ошибке проверки или 409 Conflict при занятом dedup_key.
+// on_duplicate=return_existing и dedup_window_seconds в батче не поддерживаются.
+func CreateTasksBatchHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.CreateTasksRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+
+		if len(req.Tasks) == 0 {
+			respondWithError(w, r, http.StatusBadRequest, "tasks is required")
+			return
+		}
+		if len(req.Tasks) > models.MaxBatchTasks {
+			respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("tasks must have at most %d items", models.MaxBatchTasks))
+			return
+		}
+		for i := range req.Tasks {
+			if err := taskService.ApplyPayloadDefaults(&req.Tasks[i]); err != nil {
+				respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("tasks[%d]: %v", i, err))
+				return
+			}
+			if err := applySchedule(&req.Tasks[i], time.Now()); err != nil {
+				respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("tasks[%d]: %v", i, err))
+				return
+			}
+			if err := validateBatchTask(&req.Tasks[i]); err != nil {
+				respondWithError(w, r, http.StatusBadRequest, fmt.Sprintf("tasks[%d]: %v", i, err))
+				return
+			}
+		}
+
+		tasks, err := taskService.CreateTasks(r.Context(), req.Tasks)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrInvalidExecuteTime), errors.Is(err, services.ErrInvalidMaxAttempts),
+				errors.Is(err, services.ErrBlockedURL):
+				respondWithError(w, r, http.StatusBadRequest, err.Error())
+			case errors.Is(err, services.ErrDuplicateTask):
+				respondWithError(w, r, http.StatusConflict, err.Error())
+			default:
+				respondWithError(w, r, http.StatusInternalServerError, "Failed to create tasks")
+			}
+			return
+		}
+
+		respondWithJSON(w, r, http.StatusCreated, models.CreateTasksResponse{Created: len(tasks), Tasks: tasks})
+	}
+}
+
+// validateBatchTask проверяет задание батча как одиночное и отклоняет поля,
+// которые требуют отдельной проверки каждого задания перед вставкой
+func validateBatchTask(req *models.CreateTaskRequest) error {
+	if err := validateCreateTaskRequest(req); err != nil {
+		return err
+	}
+	if req.OnDuplicate == models.OnDuplicateReturnExisting {
+		return errors.New("on_duplicate=return_existing is not supported in batch")
+	}
+	if req.DedupWindow > 0 {
+		return errors.New("dedup_window_seconds is not supported in batch")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"at-api/models"
+)
+
+// TestCreateTasksBatchHandler проверяет создание батча и отказ с индексом задания
+func TestCreateTasksBatchHandler(t *testing.T) {
+	handler := CreateTasksBatchHandler(newTestTaskService())
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	item := func(taskType string) string {
+		return fmt.Sprintf(`{"execute_at": %q, "task_type": %q, "payload": {}}`, future, taskType)
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks/batch", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	rec := post(`{"tasks": [` + item("a") + `, ` + item("b") + `]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var resp models.CreateTasksResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Created != 2 || resp.Tasks[0].TaskType != "a" || resp.Tasks[1].TaskType != "b" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	testCases := []struct {
+		name      string
+		body      string
+		wantError string
+	}{
+		{"empty", `{"tasks": []}`, "tasks is required"},
+		{"invalid item", `{"tasks": [` + item("a") + `, {"execute_at": "` + future + `", "payload": {}}]}`, "tasks[1]: task_type is required"},
+		{"dedup window", `{"tasks": [{"execute_at": "` + future + `", "task_type": "a", "payload": {}, "dedup_key": "k", "dedup_window_seconds": 60}]}`, "tasks[0]: dedup_window_seconds is not supported in batch"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := post(tc.body)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tc.wantError) {
+				t.Errorf("got %d %s, want 400 with %q", rec.Code, rec.Body.String(), tc.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// ExportTasksHandler обрабатывает GET запросы на выгрузку всех заданий в формате NDJSON.
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+const (
+	// exportFlushRows - через сколько заданий выгрузка отправляется клиенту, не дожидаясь буфера сервера
+	exportFlushRows = 100
+	// exportFlushInterval - не реже какого интервала отправляются накопленные задания при медленном чтении из БД
+	exportFlushInterval = time.Second
+)
+
+// ExportTasksHandler обрабатывает GET /api/v1/tasks/export - выгрузка всех заданий без пагинации.
+// Поддерживает те же фильтры и sort, что и GET /api/v1/tasks (limit и offset не используются).
+//
+// Ответ - NDJSON (application/x-ndjson): по заданию в строке, затем трейлер models.ExportTrailer
+// {"_complete": true, "count": n}. Ответ отправляется по частям (каждые exportFlushRows заданий
+// или exportFlushInterval), поэтому ошибка БД посреди выгрузки уже не может стать статусом ответа:
+// вместо нее последней строкой пишется {"_complete": false, ...}. Ответ без трейлера (оборван
+// вместе с соединением) клиент тоже должен считать неполным.
+func ExportTasksHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params, errMessage := parseListFilters(r.URL.Query())
+		if errMessage != "" {
+			respondWithError(w, r, http.StatusBadRequest, errMessage)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		// ResponseController находит Flush и под оберткой ResponseWriter из middleware
+		controller := http.NewResponseController(w)
+		encoder := json.NewEncoder(w)
+		count, unflushed := 0, 0
+		lastFlush := time.Now()
+
+		err := taskService.ExportTasks(r.Context(), params, func(task *models.ScheduledTask) error {
+			if err := encoder.Encode(task); err != nil {
+				return err
+			}
+			count++
+			unflushed++
+			if unflushed >= exportFlushRows || time.Since(lastFlush) >= exportFlushInterval {
+				unflushed, lastFlush = 0, time.Now()
+				return controller.Flush()
+			}
+			return nil
+		})
+
+		trailer := models.ExportTrailer{Complete: err == nil, Count: count}
+		if err != nil {
+			// Клиент отключился - писать трейлер некому
+			if r.Context().Err() != nil {
+				return
+			}
+			log.Printf("Task export interrupted after %d tasks: %v", count, err)
+			trailer.Error = "Failed to export tasks"
+		}
+		encoder.Encode(trailer)
+		controller.Flush()
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// exportLines читает ответ выгрузки: задания и последнюю строку как трейлер
+func exportLines(t *testing.T, rec *httptest.ResponseRecorder) ([]models.ScheduledTask, models.ExportTrailer) {
+	t.Helper()
+	var lines []json.RawMessage
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		lines = append(lines, append(json.RawMessage(nil), scanner.Bytes()...))
+	}
+	if len(lines) == 0 {
+		t.Fatal("Export response is empty")
+	}
+
+	tasks := make([]models.ScheduledTask, len(lines)-1)
+	for i := range tasks {
+		if err := json.Unmarshal(lines[i], &tasks[i]); err != nil {
+			t.Fatalf("Line %d is not a task: %v", i+1, err)
+		}
+	}
+	var trailer models.ExportTrailer
+	if err := json.Unmarshal(lines[len(lines)-1], &trailer); err != nil {
+		t.Fatalf("Last line is not a trailer: %v", err)
+	}
+	return tasks, trailer
+}
+
+// TestExportTasksHandler проверяет выгрузку по фильтру и трейлер полной выгрузки
+func TestExportTasksHandler(t *testing.T) {
+	taskService := newTestTaskService()
+	for _, taskType := range []string{"report", "email", "report"} {
+		_, err := taskService.CreateTask(context.Background(), &models.CreateTaskRequest{
+			ExecuteAt: time.Now().Add(time.Hour),
+			TaskType:  taskType,
+			Payload:   json.RawMessage(`{}`),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks/export?task_type=report&sort=created_at", nil)
+	rec := httptest.NewRecorder()
+	newTestRouter(taskService).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Content-Type: got=%q, want=%q", contentType, "application/x-ndjson")
+	}
+
+	tasks, trailer := exportLines(t, rec)
+	if len(tasks) != 2 || tasks[0].ID != 1 || tasks[1].ID != 3 {
+		t.Errorf("Exported tasks: got=%+v, want IDs 1, 3", tasks)
+	}
+	if !trailer.Complete || trailer.Count != 2 || trailer.Error != "" {
+		t.Errorf("Trailer: got=%+v, want complete with count 2", trailer)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/tasks/export?sort=priority", nil)
+	rec = httptest.NewRecorder()
+	newTestRouter(taskService).ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid sort status: got=%d, want=%d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// brokenExportStore - хранилище, выгрузка из которого обрывается после двух заданий, как при обрыве соединения с БД
+type brokenExportStore struct {
+	*services.MemoryTaskStore
+}
+
+func (s brokenExportStore) ExportTasks(ctx context.Context, params models.ListTasksParams, fn func(*models.ScheduledTask) error) error {
+	for id := int64(1); id <= 2; id++ {
+		if err := fn(&models.ScheduledTask{ID: id, Status: "pending"}); err != nil {
+			return err
+		}
+	}
+	return errors.New("driver: bad connection")
+}
+
+// TestExportTasksHandlerInterrupted проверяет, что оборванная выгрузка заканчивается трейлером _complete: false
+func TestExportTasksHandlerInterrupted(t *testing.T) {
+	taskService := services.NewTaskService(brokenExportStore{services.NewMemoryTaskStore()}, services.Options{})
+
+	rec := httptest.NewRecorder()
+	ExportTasksHandler(taskService)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/export", nil))
+
+	tasks, trailer := exportLines(t, rec)
+	if len(tasks) != 2 {
+		t.Errorf("Exported tasks: got=%d, want=2", len(tasks))
+	}
+	if trailer.Complete || trailer.Count != 2 || trailer.Error == "" {
+		t.Errorf("Trailer: got=%+v, want incomplete with count 2 and error", trailer)
+	}
+}
@@ -0,0 +1,56 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// ExtendLeaseHandler продлевает аренду задания, которое внешний клиент еще выполняет.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// ExtendLeaseHandler обрабатывает POST /api/v1/tasks/:id/extend - продление аренды захваченного задания.
+// Принимает JSON с полями lease_token (обязательно) и lease_seconds (опционально, по умолчанию
+// API_CLAIM_DEFAULT_LEASE_SECONDS): locked_until становится сейчас + lease_seconds.
+// Возвращает 400 если lease_seconds вне диапазона, 404 если задание не найдено,
+// 409 если токен не совпадает или аренда уже возвращена в очередь.
+func ExtendLeaseHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Парсим ID задания из пути /api/v1/tasks/{id}/extend
+		id, err := pathTaskID(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
+			return
+		}
+
+		var req models.ExtendLeaseRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if req.LeaseToken == "" {
+			respondWithError(w, r, http.StatusBadRequest, "lease_token is required")
+			return
+		}
+
+		task, err := taskService.ExtendLease(r.Context(), id, &req)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrInvalidLease):
+				respondWithError(w, r, http.StatusBadRequest, err.Error())
+			case err == services.ErrTaskNotFound:
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
+			case err == services.ErrLeaseMismatch:
+				respondWithError(w, r, http.StatusConflict, err.Error())
+			default:
+				respondWithError(w, r, http.StatusInternalServerError, "Failed to extend lease")
+			}
+			return
+		}
+
+		// Возвращаем задание с новым locked_until
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
@@ -0,0 +1,71 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// CompleteTaskHandler и FailTaskHandler принимают отчеты внешних клиентов о выполнении захваченных заданий.
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// CompleteTaskHandler обрабатывает POST /api/v1/tasks/:id/complete - успешное выполнение захваченного задания.
+// Принимает JSON с полями lease_token (обязательно), result и warning (опционально).
+// warning - предупреждение при частичном успехе: задание все равно считается выполненным.
+// Возвращает 404 если задание не найдено, 409 если токен не совпадает или аренда уже возвращена в очередь.
+func CompleteTaskHandler(taskService *services.TaskService) http.HandlerFunc {
+	return finishTaskHandler("complete", "Failed to complete task", taskService.CompleteTask)
+}
+
+// FailTaskHandler обрабатывает POST /api/v1/tasks/:id/fail - неудачная попытка выполнения захваченного задания.
+// Принимает JSON с полями lease_token (обязательно), error_message, failure_reason и result (опционально).
+// failure_reason - класс ошибки (timeout, connection_refused, http_4xx, http_5xx, validation, unknown);
+// по умолчанию unknown. Задание возвращается в очередь или переводится в 'failed', если попытки исчерпаны.
+// Возвращает 404 если задание не найдено, 409 если токен не совпадает или аренда уже возвращена в очередь.
+func FailTaskHandler(taskService *services.TaskService) http.HandlerFunc {
+	return finishTaskHandler("fail", "Failed to fail task", taskService.FailTask)
+}
+
+// finishTaskHandler - общий обработчик POST /api/v1/tasks/:id/{complete,fail}
+func finishTaskHandler(action, failureMessage string, finish func(ctx context.Context, id int64, req *models.FinishTaskRequest) (*models.ScheduledTask, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Парсим ID задания из пути /api/v1/tasks/{id}/{action}
+		id, err := pathTaskID(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
+			return
+		}
+
+		var req models.FinishTaskRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if req.LeaseToken == "" {
+			respondWithError(w, r, http.StatusBadRequest, "lease_token is required")
+			return
+		}
+		if req.FailureReason != "" && !models.IsFailureReason(req.FailureReason) {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid failure_reason")
+			return
+		}
+
+		task, err := finish(r.Context(), id, &req)
+		if err != nil {
+			switch err {
+			case services.ErrTaskNotFound:
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
+			case services.ErrLeaseMismatch:
+				respondWithError(w, r, http.StatusConflict, err.Error())
+			default:
+				respondWithError(w, r, http.StatusInternalServerError, failureMessage)
+			}
+			return
+		}
+
+		// Возвращаем обновленное задание
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
@@ -4,8 +4,6 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
-	"strings"
 
 	"at-api/models"
 	"at-api/services"
@@ -16,33 +14,25 @@ import (
 // Возвращает 404 если задание не найдено, 200 с данными задания при успехе.
 func GetTaskHandler(taskService *services.TaskService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Извлекаем ID из URL пути (предполагается формат /api/v1/tasks/{id})
-		pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-		if len(pathParts) < 4 {
-			respondWithError(w, http.StatusBadRequest, "Invalid URL format")
-			return
-		}
-
-		// Парсим ID задания
-		idStr := pathParts[3]
-		id, err := strconv.ParseInt(idStr, 10, 64)
+		// Парсим ID задания из пути /api/v1/tasks/{id}
+		id, err := pathTaskID(r)
 		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "Invalid task ID")
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
 			return
 		}
 
 		// Получаем задание из сервиса
-		task, err := taskService.GetTask(id)
+		task, err := taskService.GetTask(r.Context(), id)
 		if err != nil {
 			if err == services.ErrTaskNotFound {
-				respondWithError(w, http.StatusNotFound, "Task not found")
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
 				return
 			}
-			respondWithError(w, http.StatusInternalServerError, "Failed to get task")
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to get task")
 			return
 		}
 
 		// Возвращаем задание
-		respondWithJSON(w, http.StatusOK, models.TaskResponse{Task: task})
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
 	}
 }
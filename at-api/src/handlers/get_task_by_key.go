@@ -0,0 +1,39 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// GetTaskByKeyHandler обрабатывает GET запросы на получение задания по dedup_key.
+package handlers
+
+import (
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// GetTaskByKeyHandler обрабатывает GET /api/v1/tasks/by-key/:key - получение задания по dedup_key.
+// Позволяет клиенту, сохранившему только ключ, найти задание без серверного ID.
+// Если с ключом связано несколько заданий, возвращается активное, а при его отсутствии - последнее созданное.
+// Возвращает 404 если заданий с ключом нет, 200 с данными задания при успехе.
+func GetTaskByKeyHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Ключ - весь остаток пути (может содержать "/"), см. шаблон {key...} в RegisterRoutes
+		key := r.PathValue("key")
+		if key == "" {
+			respondWithError(w, r, http.StatusBadRequest, "dedup key is required")
+			return
+		}
+
+		// Получаем задание из сервиса
+		task, err := taskService.GetTaskByKey(r.Context(), key)
+		if err != nil {
+			if err == services.ErrTaskNotFound {
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
+				return
+			}
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to get task")
+			return
+		}
+
+		// Возвращаем задание
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
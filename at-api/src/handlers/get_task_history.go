@@ -0,0 +1,34 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// GetTaskHistoryHandler обрабатывает GET запросы на получение истории смен статуса задания.
+package handlers
+
+import (
+	"net/http"
+
+	"at-api/services"
+)
+
+// GetTaskHistoryHandler обрабатывает GET /api/v1/tasks/:id/history - история смен статуса задания
+// (pending → processing → completed и т.д.) со временем и тем, кто сменил статус.
+// Возвращает 404 если задание не найдено, 200 с текущим статусом и переходами по порядку при успехе.
+func GetTaskHistoryHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := pathTaskID(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
+			return
+		}
+
+		history, err := taskService.GetTaskHistory(r.Context(), id)
+		if err != nil {
+			if err == services.ErrTaskNotFound {
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
+				return
+			}
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to get task history")
+			return
+		}
+
+		respondWithJSON(w, r, http.StatusOK, history)
+	}
+}
@@ -0,0 +1,54 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// HoldTaskHandler и UnholdTaskHandler обрабатывают приостановку задания и возврат его в очередь.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// HoldTaskHandler обрабатывает POST /api/v1/tasks/:id/hold - приостановка задания.
+// Переводит задание из 'pending' в 'hold': worker его не забирает, но задание не отменено.
+// Возвращает 404 если задание не найдено, 409 если задание не в статусе 'pending'.
+func HoldTaskHandler(taskService *services.TaskService) http.HandlerFunc {
+	return taskStatusActionHandler("hold", "Failed to hold task", taskService.HoldTask)
+}
+
+// UnholdTaskHandler обрабатывает POST /api/v1/tasks/:id/unhold - возврат приостановленного задания.
+// Переводит задание из 'hold' обратно в 'pending'.
+// Возвращает 404 если задание не найдено, 409 если задание не в статусе 'hold'.
+func UnholdTaskHandler(taskService *services.TaskService) http.HandlerFunc {
+	return taskStatusActionHandler("unhold", "Failed to unhold task", taskService.UnholdTask)
+}
+
+// taskStatusActionHandler - общий обработчик POST /api/v1/tasks/:id/{action} для смены статуса
+func taskStatusActionHandler(action, failureMessage string, apply func(ctx context.Context, id int64) (*models.ScheduledTask, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Парсим ID задания из пути /api/v1/tasks/{id}/{action}
+		id, err := pathTaskID(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
+			return
+		}
+
+		task, err := apply(r.Context(), id)
+		if err != nil {
+			switch {
+			case err == services.ErrTaskNotFound:
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
+			case errors.Is(err, services.ErrInvalidTaskStatus):
+				respondWithError(w, r, http.StatusConflict, err.Error())
+			default:
+				respondWithError(w, r, http.StatusInternalServerError, failureMessage)
+			}
+			return
+		}
+
+		// Возвращаем обновленное задание
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
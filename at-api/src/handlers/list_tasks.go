@@ -4,6 +4,7 @@ package handlers
 
 import (
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"at-api/models"
@@ -12,8 +13,14 @@ import (
 
 // ListTasksHandler обрабатывает GET /api/v1/tasks - получение списка заданий.
 // Поддерживает query параметры:
-//   - status: фильтр по статусу (pending, processing, completed, failed, cancelled)
+//   - status: фильтр по статусу (pending, processing, hold, completed, failed, cancelled, skipped)
 //   - task_type: фильтр по типу задания
+//   - queue: фильтр по очереди
+//   - has_error: true - только задания с error_message, false - только без него
+//   - error_contains: подстрока error_message (без учета регистра)
+//   - failure_reason: класс ошибки (timeout, connection_refused, http_4xx, http_5xx, validation, unknown)
+//   - min_duration_ms, max_duration_ms: границы длительности последнего выполнения (duration_ms) включительно
+//   - sort: порядок - -created_at (по умолчанию), created_at, -duration_ms, duration_ms
 //   - limit: количество записей на странице (по умолчанию 50, максимум 100)
 //   - offset: смещение для пагинации (по умолчанию 0)
 //
@@ -23,17 +30,17 @@ func ListTasksHandler(taskService *services.TaskService) http.HandlerFunc {
 		// Парсим query параметры
 		query := r.URL.Query()
 
-		// Параметры фильтрации
-		params := models.ListTasksParams{
-			Status:   query.Get("status"),
-			TaskType: query.Get("task_type"),
+		params, errMessage := parseListFilters(query)
+		if errMessage != "" {
+			respondWithError(w, r, http.StatusBadRequest, errMessage)
+			return
 		}
 
 		// Парсим limit
 		if limitStr := query.Get("limit"); limitStr != "" {
 			limit, err := strconv.Atoi(limitStr)
 			if err != nil || limit < 0 {
-				respondWithError(w, http.StatusBadRequest, "Invalid limit parameter")
+				respondWithError(w, r, http.StatusBadRequest, "Invalid limit parameter")
 				return
 			}
 			params.Limit = limit
@@ -43,23 +50,70 @@ func ListTasksHandler(taskService *services.TaskService) http.HandlerFunc {
 		if offsetStr := query.Get("offset"); offsetStr != "" {
 			offset, err := strconv.Atoi(offsetStr)
 			if err != nil || offset < 0 {
-				respondWithError(w, http.StatusBadRequest, "Invalid offset parameter")
+				respondWithError(w, r, http.StatusBadRequest, "Invalid offset parameter")
 				return
 			}
 			params.Offset = offset
 		}
 
 		// Получаем список заданий
-		tasks, total, err := taskService.ListTasks(params)
+		tasks, total, err := taskService.ListTasks(r.Context(), params)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to list tasks")
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to list tasks")
 			return
 		}
 
 		// Возвращаем результат
-		respondWithJSON(w, http.StatusOK, models.TaskListResponse{
+		respondWithJSON(w, r, http.StatusOK, models.TaskListResponse{
 			Tasks: tasks,
 			Total: total,
 		})
 	}
 }
+
+// parseListFilters разбирает query параметры фильтров и порядка списка заданий (все, кроме limit и offset).
+// Используется списком и выгрузкой; при ошибке возвращает текст ответа 400
+func parseListFilters(query url.Values) (models.ListTasksParams, string) {
+	// Параметры фильтрации
+	params := models.ListTasksParams{
+		Status:        query.Get("status"),
+		TaskType:      query.Get("task_type"),
+		Queue:         query.Get("queue"),
+		ErrorContains: query.Get("error_contains"),
+		FailureReason: query.Get("failure_reason"),
+		Sort:          query.Get("sort"),
+	}
+	if params.FailureReason != "" && !models.IsFailureReason(params.FailureReason) {
+		return params, "Invalid failure_reason parameter"
+	}
+	if params.Sort != "" && !models.IsListSort(params.Sort) {
+		return params, "Invalid sort parameter"
+	}
+
+	// Парсим границы длительности выполнения
+	for _, bound := range []struct {
+		name  string
+		value **int64
+	}{{"min_duration_ms", &params.MinDurationMs}, {"max_duration_ms", &params.MaxDurationMs}} {
+		str := query.Get(bound.name)
+		if str == "" {
+			continue
+		}
+		ms, err := strconv.ParseInt(str, 10, 64)
+		if err != nil || ms < 0 {
+			return params, "Invalid " + bound.name + " parameter"
+		}
+		*bound.value = &ms
+	}
+
+	// Парсим has_error
+	if hasErrorStr := query.Get("has_error"); hasErrorStr != "" {
+		hasError, err := strconv.ParseBool(hasErrorStr)
+		if err != nil {
+			return params, "Invalid has_error parameter"
+		}
+		params.HasError = &hasError
+	}
+
+	return params, ""
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"at-api/services"
+)
+
+// blockingDriver - драйвер database/sql, запросы которого "висят" до отмены контекста.
+// Имитирует медленный запрос к PostgreSQL без реальной БД.
+type blockingDriver struct {
+	started   chan struct{} // Сигнал о том, что запрос дошел до драйвера
+	cancelled chan struct{} // Сигнал о том, что драйвер увидел отмену контекста
+}
+
+func (d *blockingDriver) Open(name string) (driver.Conn, error) {
+	return &blockingConn{driver: d}, nil
+}
+
+type blockingConn struct {
+	driver *blockingDriver
+}
+
+func (c *blockingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (c *blockingConn) Close() error { return nil }
+
+func (c *blockingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+// QueryContext блокируется до отмены контекста, как долгий запрос на сервере
+func (c *blockingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	close(c.driver.started)
+	<-ctx.Done()
+	close(c.driver.cancelled)
+	return nil, ctx.Err()
+}
+
+// TestListTasksClientDisconnect проверяет, что отключение клиента прерывает запрос к БД
+func TestListTasksClientDisconnect(t *testing.T) {
+	drv := &blockingDriver{
+		started:   make(chan struct{}),
+		cancelled: make(chan struct{}),
+	}
+	sql.Register("blocking-list-tasks", drv)
+
+	database, err := sql.Open("blocking-list-tasks", "")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	handler := ListTasksHandler(services.NewTaskService(services.NewPostgresTaskStore(database, services.PostgresStoreOptions{}), services.Options{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		handler(rec, req)
+		close(done)
+	}()
+
+	// Дожидаемся, пока запрос дойдет до БД, и "отключаем" клиента
+	select {
+	case <-drv.started:
+	case <-time.After(time.Second):
+		t.Fatal("Query was not started")
+	}
+	cancel()
+
+	select {
+	case <-drv.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Query was not cancelled after client disconnect")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Handler did not return after client disconnect")
+	}
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Status: got=%d, want=%d", rec.Code, http.StatusInternalServerError)
+	}
+
+	if stats := database.Stats(); stats.InUse != 0 {
+		t.Errorf("Connections in use after cancel: got=%d, want=0", stats.InUse)
+	}
+}
@@ -0,0 +1,34 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// RegisterPprof регистрирует обработчики профилирования net/http/pprof.
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// RegisterPprof регистрирует обработчики net/http/pprof на /debug/pprof/ (API_ENABLE_PPROF).
+// API слушает публичный порт, поэтому, как и GET /config, они доступны только с токеном
+// API_ADMIN_TOKEN (см. requireAdmin): без настроенного токена - 404.
+func RegisterPprof(mux *http.ServeMux, adminToken string) {
+	handlers := map[string]http.HandlerFunc{
+		"/debug/pprof/":        pprof.Index,
+		"/debug/pprof/cmdline": pprof.Cmdline,
+		"/debug/pprof/profile": pprof.Profile,
+		"/debug/pprof/symbol":  pprof.Symbol,
+		"/debug/pprof/trace":   pprof.Trace,
+	}
+	for pattern, handler := range handlers {
+		mux.HandleFunc(pattern, adminOnly(handler, adminToken))
+	}
+}
+
+// adminOnly пропускает запрос к next только с токеном администратора
+func adminOnly(next http.HandlerFunc, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(w, r, adminToken) {
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,25 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// ReadyHandler обрабатывает readiness-проверку.
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// ReadyHandler обрабатывает GET /ready - готовность принимать запросы.
+// В отличие от /health проверяет доступность БД через check (ping с повторами).
+// Возвращает 200 "OK" или 503, если БД недоступна.
+func ReadyHandler(check func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(r.Context()); err != nil {
+			log.Printf("Readiness check failed: %v", err)
+			respondWithError(w, r, http.StatusServiceUnavailable, "Database is unavailable")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
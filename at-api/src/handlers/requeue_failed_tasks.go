@@ -0,0 +1,62 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// RequeueFailedTasksHandler обрабатывает POST запросы на массовый перезапуск заданий, завершившихся ошибкой.
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// RequeueFailedTasksHandler обрабатывает POST /api/v1/tasks/requeue - массовый перезапуск заданий 'failed'.
+// Принимает JSON с фильтрами task_type, queue, failure_reason, error_contains (как у списка заданий),
+// limit (по умолчанию DefaultBulkRequeueLimit) и spread_seconds - окно, по которому равномерно
+// распределяется execute_at перезапущенных заданий. Каждое задание получает еще одну попытку.
+// Возвращает число перезапущенных заданий; 400 при некорректных параметрах,
+// 409 если активное задание с тем же dedup_key появилось во время перезапуска.
+func RequeueFailedTasksHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Тело запроса необязательно: пустое тело означает "все упавшие задания, до лимита, сразу"
+		var req models.BulkRequeueRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if err := validateBulkRequeue(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		requeued, err := taskService.RequeueFailedTasks(r.Context(), &req)
+		if err != nil {
+			if err == services.ErrDuplicateTask {
+				respondWithError(w, r, http.StatusConflict, err.Error())
+				return
+			}
+			respondWithError(w, r, http.StatusInternalServerError, "Failed to requeue tasks")
+			return
+		}
+
+		respondWithJSON(w, r, http.StatusOK, models.BulkRequeueResponse{Requeued: requeued})
+	}
+}
+
+// validateBulkRequeue проверяет параметры массового перезапуска и подставляет limit по умолчанию
+func validateBulkRequeue(req *models.BulkRequeueRequest) error {
+	switch {
+	case req.Limit < 0 || req.Limit > models.MaxBulkRequeueLimit:
+		return fmt.Errorf("limit must be between 1 and %d", models.MaxBulkRequeueLimit)
+	case req.SpreadSeconds < 0 || req.SpreadSeconds > models.MaxRequeueSpreadSeconds:
+		return fmt.Errorf("spread_seconds must be between 0 and %d", models.MaxRequeueSpreadSeconds)
+	case req.FailureReason != "" && !models.IsFailureReason(req.FailureReason):
+		return fmt.Errorf("invalid failure_reason %q", req.FailureReason)
+	}
+	if req.Limit == 0 {
+		req.Limit = models.DefaultBulkRequeueLimit
+	}
+	return nil
+}
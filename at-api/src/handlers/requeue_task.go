@@ -0,0 +1,60 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// RequeueTaskHandler обрабатывает POST запросы на повторный запуск завершившегося ошибкой задания.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// RequeueTaskHandler обрабатывает POST /api/v1/tasks/:id/requeue - повторный запуск задания.
+// Принимает JSON с полем max_attempts (опционально, по умолчанию attempts + 1).
+// Увеличивает max_attempts у задания в статусе 'failed' и возвращает его в 'pending'.
+// Возвращает 404 если задание не найдено, 409 если задание не в статусе 'failed'
+// или уже есть активное задание с тем же dedup_key,
+// 400 если max_attempts не больше уже сделанных попыток или превышает лимит.
+func RequeueTaskHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Парсим ID задания из пути /api/v1/tasks/{id}/requeue
+		id, err := pathTaskID(r)
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Invalid task ID")
+			return
+		}
+
+		// Тело запроса необязательно: пустое тело означает "еще одна попытка"
+		var req models.RequeueTaskRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if req.MaxAttempts < 0 {
+			respondWithError(w, r, http.StatusBadRequest, "max_attempts must be positive")
+			return
+		}
+
+		// Перезапускаем задание через сервис
+		task, err := taskService.RequeueTask(r.Context(), id, req.MaxAttempts)
+		if err != nil {
+			switch {
+			case err == services.ErrTaskNotFound:
+				respondWithError(w, r, http.StatusNotFound, "Task not found")
+			case err == services.ErrTaskNotFailed || err == services.ErrDuplicateTask:
+				respondWithError(w, r, http.StatusConflict, err.Error())
+			case errors.Is(err, services.ErrInvalidMaxAttempts):
+				respondWithError(w, r, http.StatusBadRequest, err.Error())
+			default:
+				respondWithError(w, r, http.StatusInternalServerError, "Failed to requeue task")
+			}
+			return
+		}
+
+		// Возвращаем обновленное задание
+		respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+	}
+}
@@ -0,0 +1,89 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// RegisterRoutes регистрирует endpoints заданий по шаблонам маршрутов http.ServeMux (Go 1.22+).
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"at-api/services"
+)
+
+// RegisterRoutes регистрирует все endpoints /api/v1/tasks в mux.
+// Метод и параметры пути ({id}, {key...}) разбирает сам ServeMux: неизвестный путь - 404,
+// известный путь с другим методом - 405 с заголовком Allow.
+func RegisterRoutes(mux *http.ServeMux, taskService *services.TaskService) {
+	tasks := http.NewServeMux()
+
+	// Создание и список регистрируются с "/" и без "/" для совместимости
+	tasks.HandleFunc("POST /api/v1/tasks", CreateTaskHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{$}", CreateTaskHandler(taskService))
+	tasks.HandleFunc("GET /api/v1/tasks", ListTasksHandler(taskService))
+	tasks.HandleFunc("GET /api/v1/tasks/{$}", ListTasksHandler(taskService))
+
+	tasks.HandleFunc("POST /api/v1/tasks/batch", CreateTasksBatchHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/claim", ClaimTasksHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/requeue", RequeueFailedTasksHandler(taskService))
+	tasks.HandleFunc("GET /api/v1/tasks/export", ExportTasksHandler(taskService))
+
+	tasks.HandleFunc("GET /api/v1/tasks/{id}", GetTaskHandler(taskService))
+	tasks.HandleFunc("GET /api/v1/tasks/{id}/history", GetTaskHistoryHandler(taskService))
+	tasks.HandleFunc("DELETE /api/v1/tasks/{id}", CancelTaskHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{id}/requeue", RequeueTaskHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{id}/hold", HoldTaskHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{id}/unhold", UnholdTaskHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{id}/extend", ExtendLeaseHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{id}/complete", CompleteTaskHandler(taskService))
+	tasks.HandleFunc("POST /api/v1/tasks/{id}/fail", FailTaskHandler(taskService))
+
+	// Ключ - весь остаток пути (может содержать "/")
+	tasks.HandleFunc("PUT /api/v1/tasks/by-key/{key...}", UpsertTaskByKeyHandler(taskService))
+	tasks.HandleFunc("DELETE /api/v1/tasks/by-key/{key...}", CancelTaskByKeyHandler(taskService))
+
+	// Шаблоны GET by-key/{key...} и GET {id}/history пересекаются (путь by-key/history), и ServeMux
+	// не регистрирует оба, поэтому GET по ключу разбирается до маршрутов заданий
+	mux.Handle("/api/v1/tasks", tasks)
+	mux.Handle("/api/v1/tasks/", byKeyRoute(GetTaskByKeyHandler(taskService), tasks))
+}
+
+// byKeyPrefix - путь endpoints заданий по dedup_key; ключ - весь остаток пути
+const byKeyPrefix = "/api/v1/tasks/by-key/"
+
+// byKeyRoute передает запросы GET (и HEAD) /api/v1/tasks/by-key/:key в get с ключом в параметре пути key,
+// а остальные - в next. Другие методы, кроме PUT и DELETE (они зарегистрированы в next), получают 405, как от ServeMux
+func byKeyRoute(get http.HandlerFunc, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.URL.Path, byKeyPrefix)
+		if !ok || r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		r.SetPathValue("key", key)
+		get(w, r)
+	})
+}
+
+// errInvalidTaskID - ID задания в пути не является положительным целым числом
+var errInvalidTaskID = errors.New("invalid task ID")
+
+// pathTaskID возвращает ID задания из параметра пути {id}.
+// ID - только положительное десятичное число без знака: "abc", "1.5", "+1", "-1" и "0" - ошибка (400),
+// чтобы некорректный ID не превращался в 404 "Task not found"
+func pathTaskID(r *http.Request) (int64, error) {
+	value := r.PathValue("id")
+	if value == "" || value[0] < '0' || value[0] > '9' {
+		return 0, errInvalidTaskID
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errInvalidTaskID
+	}
+	return id, nil
+}
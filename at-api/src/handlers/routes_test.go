@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"at-api/models"
+)
+
+// TestRoutesMalformedPaths проверяет, что лишние сегменты пути дают 404, а нечисловой ID - 400
+// одинаково во всех endpoints с {id}
+func TestRoutesMalformedPaths(t *testing.T) {
+	taskService := newTestTaskService()
+	created, err := taskService.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  "test_task",
+		Payload:   json.RawMessage(`{}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	router := newTestRouter(taskService)
+
+	testCases := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		// Неизвестные подпути
+		{http.MethodGet, "/api/v1/tasks/abc/def", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/tasks/1/def", http.StatusNotFound},
+		{http.MethodDelete, "/api/v1/tasks/1/def", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/tasks/1/retry", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/tasks/1/hold/extra", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/task/1", http.StatusNotFound},
+
+		// Нечисловые и неположительные ID
+		{http.MethodGet, "/api/v1/tasks/abc", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/tasks/1.5", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/tasks/-1", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/tasks/+1", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/tasks/0", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/tasks/99999999999999999999", http.StatusBadRequest},
+		{http.MethodDelete, "/api/v1/tasks/abc", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tasks/abc/requeue", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tasks/abc/hold", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tasks/abc/unhold", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tasks/abc/extend", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tasks/abc/complete", http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/tasks/abc/fail", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/tasks/abc/history", http.StatusBadRequest},
+
+		// Известный путь с другим методом
+		{http.MethodPut, "/api/v1/tasks/1", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/v1/tasks/1/hold", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/v1/tasks/1/history", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/tasks/by-key/x", http.StatusMethodNotAllowed},
+		{http.MethodPatch, "/api/v1/tasks/by-key/x", http.StatusMethodNotAllowed},
+
+		// Корректные пути
+		{http.MethodGet, fmt.Sprintf("/api/v1/tasks/%d", created.ID), http.StatusOK},
+		{http.MethodGet, "/api/v1/tasks/999", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/tasks/", http.StatusOK},
+		{http.MethodGet, fmt.Sprintf("/api/v1/tasks/%d/history", created.ID), http.StatusOK},
+		{http.MethodGet, "/api/v1/tasks/999/history", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/tasks/requeue", http.StatusOK},
+		{http.MethodGet, "/api/v1/tasks/requeue", http.StatusBadRequest},
+		{http.MethodPut, "/api/v1/tasks/by-key/x", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{}`)))
+			if rec.Code != tc.want {
+				t.Errorf("Status: got=%d, want=%d, body=%s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestRoutesByKeyHistory проверяет, что by-key/history - ключ "history", а не история задания
+func TestRoutesByKeyHistory(t *testing.T) {
+	taskService := newTestTaskService()
+	created, err := taskService.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  "test_task",
+		Payload:   json.RawMessage(`{}`),
+		DedupKey:  "history",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	newTestRouter(taskService).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/by-key/history", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status: got=%d, want=%d, body=%s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var resp models.TaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Task.ID != created.ID {
+		t.Errorf("Task ID: got=%d, want=%d", resp.Task.ID, created.ID)
+	}
+}
@@ -0,0 +1,74 @@
+// Package handlers содержит HTTP обработчики для API endpoints.
+// UpsertTaskByKeyHandler обрабатывает PUT запросы на создание или замену задания по dedup_key.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"at-api/models"
+	"at-api/services"
+)
+
+// UpsertTaskByKeyHandler обрабатывает PUT /api/v1/tasks/by-key/:key - идемпотентное планирование по dedup_key.
+// Принимает JSON с полями, как при создании задания (dedup_key можно не указывать, он берется из пути).
+// Если задания с ключом в 'pending' или 'hold' нет, создает его: 201 Created с заголовком Location;
+// иначе заменяет execute_at, payload и остальные поля этого задания: 200 OK.
+// Возвращает 400 при некорректном запросе и 409 если задание с ключом сейчас выполняется.
+func UpsertTaskByKeyHandler(taskService *services.TaskService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Ключ - весь остаток пути (может содержать "/"), см. шаблон {key...} в RegisterRoutes
+		key := r.PathValue("key")
+		if key == "" {
+			respondWithError(w, r, http.StatusBadRequest, "dedup key is required")
+			return
+		}
+
+		var req models.CreateTaskRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if req.DedupKey != "" && req.DedupKey != key {
+			respondWithError(w, r, http.StatusBadRequest, "dedup_key must match the key in the path")
+			return
+		}
+		req.DedupKey = key
+
+		if err := taskService.ApplyPayloadDefaults(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := applySchedule(&req, time.Now()); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateCreateTaskRequest(&req); err != nil {
+			respondWithError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		task, created, err := taskService.UpsertTaskByKey(r.Context(), key, &req)
+		if err != nil {
+			switch {
+			case err == services.ErrTaskInProgress:
+				respondWithError(w, r, http.StatusConflict, err.Error())
+			case err == services.ErrInvalidExecuteTime || errors.Is(err, services.ErrInvalidMaxAttempts) || errors.Is(err, services.ErrBlockedURL):
+				respondWithError(w, r, http.StatusBadRequest, err.Error())
+			default:
+				respondWithError(w, r, http.StatusInternalServerError, "Failed to upsert task")
+			}
+			return
+		}
+
+		if !created {
+			respondWithJSON(w, r, http.StatusOK, models.TaskResponse{Task: task})
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/tasks/%d", task.ID))
+		respondWithJSON(w, r, http.StatusCreated, models.TaskResponse{Task: task})
+	}
+}
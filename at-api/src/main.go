@@ -4,41 +4,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"time"
 
+	"at-api/blobstore"
 	"at-api/config"
 	"at-api/db"
 	"at-api/handlers"
+	"at-api/middleware"
 	"at-api/services"
 
 	"github.com/joho/godotenv"
 )
 
-// responseWriter оборачивает http.ResponseWriter для захвата статус-кода
-type responseWriter struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
-	rw.ResponseWriter.WriteHeader(code)
-}
-
-// loggingMiddleware логирует все HTTP-запросы
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		next.ServeHTTP(rw, r)
-		duration := time.Since(start)
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, rw.statusCode, duration)
-	})
-}
-
 func main() {
 	// Пытаемся загрузить .env файл, если он существует
 	// Если файла нет, используем переменные окружения системы
@@ -55,43 +36,86 @@ func main() {
 	}
 
 	// Подключаемся к базе данных
-	database, err := db.NewPostgresDB(cfg.Database.DSN())
+	log.Printf("Connecting to database: %s", cfg.Database.RedactedDSN())
+	database, err := db.NewPostgresDB(cfg.Database.DSN(), cfg.Database.StatementTimeout)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to connect to database (%s): %v", cfg.Database.RedactedDSN(), err)
 	}
 	defer database.Close()
 
 	log.Println("Successfully connected to database")
 
-	// Создаем сервис для работы с заданиями
-	taskService := services.NewTaskService(database)
+	// Проверяем, что соединение действительно зашифровано, если DB_SSLMODE этого требует
+	encrypted, err := db.VerifySSL(context.Background(), database, cfg.Database.SSLMode)
+	if errors.Is(err, db.ErrConnectionNotEncrypted) {
+		log.Fatalf("Database SSL check failed: %v", err)
+	}
+	if err != nil {
+		log.Printf("Could not verify database connection encryption: %v", err)
+	} else {
+		log.Printf("Database connection encrypted: %v (sslmode=%s)", encrypted, cfg.Database.SSLMode)
+	}
 
-	// Настраиваем роутинг
-	mux := http.NewServeMux()
+	// Запросы к БД дольше DB_SLOW_QUERY_MS пишутся в лог с текстом и длительностью
+	storeOpts := services.PostgresStoreOptions{SlowQueryThreshold: cfg.Database.SlowQuery}
 
-	// Обработчик для всех запросов к /api/v1/tasks
-	taskHandler := func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handlers.CreateTaskHandler(taskService)(w, r)
-		case http.MethodGet:
-			// Проверяем, есть ли ID в пути
-			if r.URL.Path != "/api/v1/tasks/" && r.URL.Path != "/api/v1/tasks" {
-				handlers.GetTaskHandler(taskService)(w, r)
-			} else {
-				handlers.ListTasksHandler(taskService)(w, r)
-			}
-		case http.MethodDelete:
-			handlers.CancelTaskHandler(taskService)(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Read-only запросы (GET задания, список) можно направить на реплику, чтобы разгрузить основную БД
+	var readStore services.TaskStore
+	if cfg.Database.ReplicaURL != "" {
+		replica, err := db.NewPostgresDB(cfg.Database.ReplicaURL, cfg.Database.StatementTimeout)
+		if err != nil {
+			log.Fatalf("Failed to connect to read replica: %v", err)
 		}
+		defer replica.Close()
+		readStore = services.NewPostgresTaskStore(replica, storeOpts)
+		log.Println("Read replica enabled for task reads")
 	}
 
-	// API endpoints
-	// Регистрируем оба паттерна: с "/" и без "/" для совместимости
-	mux.HandleFunc("/api/v1/tasks", taskHandler)  // Без слеша - для POST, GET списка
-	mux.HandleFunc("/api/v1/tasks/", taskHandler) // Со слешом - для GET/:id, DELETE/:id
+	// Большие payload выносятся во внешнее S3-совместимое хранилище, в строке задания остается ссылка
+	var payloadStore services.PayloadStore
+	if cfg.PayloadStore.Bucket != "" {
+		store, err := blobstore.NewS3Store(blobstore.Options{
+			Endpoint:  cfg.PayloadStore.Endpoint,
+			Bucket:    cfg.PayloadStore.Bucket,
+			Region:    cfg.PayloadStore.Region,
+			AccessKey: cfg.PayloadStore.AccessKey,
+			SecretKey: cfg.PayloadStore.SecretKey,
+		})
+		if err != nil {
+			log.Fatalf("Invalid payload store configuration: %v", err)
+		}
+		payloadStore = store
+		log.Printf("Payloads larger than %d bytes are stored in s3://%s (%s)",
+			cfg.PayloadStore.RefThreshold, cfg.PayloadStore.Bucket, cfg.PayloadStore.Endpoint)
+	}
+
+	// Создаем сервис для работы с заданиями
+	taskService := services.NewTaskService(services.NewPostgresTaskStore(database, storeOpts), services.Options{
+		MaxAttemptsLimit: cfg.Tasks.MaxAttemptsLimit,
+		DefaultLease:     cfg.Tasks.DefaultLease,
+		MaxLease:         cfg.Tasks.MaxLease,
+		ReadStore:        readStore,
+		BatchChunkSize:   cfg.Tasks.BatchChunkSize,
+		PayloadDefaults:  cfg.Tasks.PayloadDefaults,
+
+		PayloadStore:        payloadStore,
+		PayloadRefThreshold: cfg.PayloadStore.RefThreshold,
+
+		CallbackAllowlist:    cfg.Tasks.CallbackAllowlist,
+		CallbackDenylist:     cfg.Tasks.CallbackDenylist,
+		CallbackBlockPrivate: !cfg.Tasks.CallbackPrivate,
+	})
+
+	// Настраиваем роутинг
+	mux := http.NewServeMux()
+
+	// API endpoints: шаблоны маршрутов с методом и параметрами пути (Go 1.22+).
+	// Ответы API - только JSON, поэтому клиенту, который не принимает JSON, отвечаем 406
+	apiMux := http.NewServeMux()
+	handlers.RegisterRoutes(apiMux, taskService)
+	mux.Handle("/api/v1/", middleware.Chain(apiMux,
+		middleware.AcceptJSON,
+	))
 
 	// Health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -99,8 +123,28 @@ func main() {
 		w.Write([]byte("OK"))
 	})
 
-	// Оборачиваем mux в middleware для логирования
-	wrappedMux := loggingMiddleware(mux)
+	// Readiness endpoint: проверяет БД, кратковременные сбои ping сглаживаются повторами
+	mux.HandleFunc("/ready", handlers.ReadyHandler(func(ctx context.Context) error {
+		return db.PingWithRetry(ctx, database, cfg.Ready.PingRetries, cfg.Ready.PingInterval)
+	}))
+
+	// Действующая конфигурация (секреты замаскированы); доступна только с API_ADMIN_TOKEN
+	mux.HandleFunc("/config", handlers.ConfigHandler(cfg.Effective(), cfg.Server.AdminToken))
+
+	// Профилирование работающего экземпляра; как и /config, только с API_ADMIN_TOKEN
+	if cfg.Server.EnablePprof {
+		handlers.RegisterPprof(mux, cfg.Server.AdminToken)
+		if cfg.Server.AdminToken == "" {
+			log.Println("API_ENABLE_PPROF is set, but API_ADMIN_TOKEN is empty: /debug/pprof/ will respond 404")
+		} else {
+			log.Println("pprof profiling endpoints enabled on /debug/pprof/ (admin token required)")
+		}
+	}
+
+	// Сквозные middleware: первый в списке - внешний, то есть первым получает запрос
+	wrappedMux := middleware.Chain(mux,
+		middleware.Logging,
+	)
 
 	// Запускаем сервер
 	addr := fmt.Sprintf(":%s", cfg.Server.Port)
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"at-api/models"
+)
+
+// AcceptJSON проверяет заголовок Accept: API отвечает только JSON, поэтому запрос, в котором клиент
+// явно не принимает application/json (например, только application/xml или text/plain),
+// получает 406 Not Acceptable вместо молча отправленного JSON.
+// Без заголовка Accept, с application/json, application/* или */* запрос проходит как обычно.
+// application/x-ndjson (строки JSON) тоже допустим: в нем отвечает выгрузка заданий.
+func AcceptJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept := r.Header.Values("Accept")
+		if len(accept) > 0 && !acceptsJSON(strings.Join(accept, ",")) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotAcceptable)
+			json.NewEncoder(w).Encode(models.ErrorResponse{Error: "Not Acceptable: only application/json responses are supported"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsJSON сообщает, допускает ли значение заголовка Accept ответ application/json.
+// Диапазоны с q=0 означают явный отказ и не учитываются.
+func acceptsJSON(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	for _, item := range strings.Split(accept, ",") {
+		params := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType != "application/json" && mediaType != "application/x-ndjson" &&
+			mediaType != "application/*" && mediaType != "*/*" {
+			continue
+		}
+		refused := false
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q <= 0 {
+					refused = true
+				}
+			}
+		}
+		if !refused {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+// Package middleware содержит HTTP middleware AT API и хелпер Chain для их композиции.
+// Сквозная логика (логирование, авторизация, ограничения и т.п.) подключается в main.go
+// одним вызовом Chain с явным порядком, а не вложенными вызовами.
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Middleware оборачивает http.Handler дополнительной логикой
+type Middleware func(http.Handler) http.Handler
+
+// Chain оборачивает handler в middlewares. Порядок детерминирован: первый middleware - внешний,
+// то есть Chain(h, a, b) эквивалентно a(b(h)) и запрос проходит a, затем b, затем h.
+// Nil-элементы пропускаются, что позволяет подключать middleware по условию из конфигурации.
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			handler = middlewares[i](handler)
+		}
+	}
+	return handler
+}
+
+// responseWriter оборачивает http.ResponseWriter для захвата статус-кода
+type responseWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap возвращает исходный ResponseWriter, чтобы http.ResponseController мог вызвать Flush
+// (потоковая выгрузка заданий)
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Logging логирует все HTTP-запросы: метод, путь, статус и длительность
+func Logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		duration := time.Since(start)
+		log.Printf("%s %s %d %v", r.Method, r.URL.Path, rw.statusCode, duration)
+	})
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestChainOrder проверяет, что первый middleware в Chain - внешний, а nil пропускается
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}), mark("a"), nil, mark("b"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if got := strings.Join(calls, ","); got != "a,b,handler" {
+		t.Errorf("Expected call order a,b,handler, got %s", got)
+	}
+}
+
+// TestAcceptJSON проверяет 406 для Accept без application/json
+func TestAcceptJSON(t *testing.T) {
+	handler := AcceptJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	testCases := []struct {
+		accept string
+		want   int
+	}{
+		{"", http.StatusOK},
+		{"application/json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"*/*", http.StatusOK},
+		{"application/*", http.StatusOK},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", http.StatusOK},
+		{"Application/JSON", http.StatusOK},
+		{"application/x-ndjson", http.StatusOK},
+		{"application/xml", http.StatusNotAcceptable},
+		{"text/plain", http.StatusNotAcceptable},
+		{"text/plain, application/json;q=0", http.StatusNotAcceptable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("Accept %q: got=%d, want=%d", tc.accept, rec.Code, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"strings"
+	"time"
+	// База часовых поясов для CRON_TZ: в образе alpine ее нет
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+// MaxCronExpressionLength - максимальная длина cron_expression
+const MaxCronExpressionLength = 255
+
+// ParseCron разбирает cron-выражение поля cron_expression: стандартные 5 полей ("*/5 * * * *"),
+// дескрипторы (@hourly, @daily, @every 90s) и префикс CRON_TZ=Europe/Moscow для часового пояса.
+// Без префикса выражение считается в UTC, а не в локальном часовом поясе сервера.
+// Тот же разбор в worker'е (at-worker, cronNextRun)
+func ParseCron(expr string) (cron.Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "CRON_TZ=") && !strings.HasPrefix(expr, "TZ=") {
+		expr = "CRON_TZ=UTC " + expr
+	}
+	return cron.ParseStandard(expr)
+}
+
+// CronNext возвращает первый момент cron-расписания не раньше from
+func CronNext(schedule cron.Schedule, from time.Time) time.Time {
+	// Next возвращает момент строго позже переданного, с точностью до секунды
+	return schedule.Next(from.Add(-time.Nanosecond))
+}
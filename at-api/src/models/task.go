@@ -4,48 +4,694 @@
 package models
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
 // ScheduledTask представляет запланированное задание в системе.
 // Структура соответствует таблице scheduled_tasks в PostgreSQL.
 type ScheduledTask struct {
-	ID          int64           `json:"id"`
-	ExecuteAt   time.Time       `json:"execute_at"`
-	TaskType    string          `json:"task_type"`
-	Payload     json.RawMessage `json:"payload"`
-	Status      string          `json:"status"`
-	Attempts    int             `json:"attempts"`
-	MaxAttempts int             `json:"max_attempts"`
-	ErrorMessage sql.NullString `json:"error_message,omitempty"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
-	CompletedAt sql.NullTime    `json:"completed_at,omitempty"`
+	ID            int64            `json:"id"`
+	ExecuteAt     time.Time        `json:"execute_at"`
+	TaskType      string           `json:"task_type"`
+	Queue         string           `json:"queue"`
+	Payload       json.RawMessage  `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	MaxAttempts   int              `json:"max_attempts"`
+	ErrorMessage  sql.NullString   `json:"error_message,omitempty"`
+	Result        *json.RawMessage `json:"result,omitempty"` // Структурированный результат выполнения от worker'а
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	CompletedAt   sql.NullTime     `json:"completed_at,omitempty"`
+	ClaimedAt     sql.NullTime     `json:"claimed_at,omitempty"`
+	DedupKey      *string          `json:"dedup_key,omitempty"`
+	Timeout       *int             `json:"timeout_seconds,omitempty"`      // Таймаут выполнения; nil - по умолчанию для типа
+	LeaseToken    *string          `json:"-"`                              // Токен аренды внешнего клиента; отдается только в ответе claim
+	LockedUntil   *time.Time       `json:"locked_until,omitempty"`         // Срок аренды задания внешним клиентом
+	Warning       *string          `json:"warning,omitempty"`              // Предупреждение выполненного с оговорками задания
+	ResultTTL     *int             `json:"result_ttl_seconds,omitempty"`   // Срок хранения payload и результата после выполнения
+	ScrubbedAt    *time.Time       `json:"scrubbed_at,omitempty"`          // Когда payload и результат удалены по result_ttl_seconds
+	Notify        *json.RawMessage `json:"notify,omitempty"`               // Каналы уведомлений о завершении ([]NotifyChannel)
+	SkipIfLate    *int             `json:"skip_if_late_seconds,omitempty"` // Допустимое опоздание; опоздавшее сильнее задание получает статус skipped
+	Progress      *json.RawMessage `json:"progress,omitempty"`             // Прогресс выполнения, который пишет worker (последнее значение)
+	Interval      *Interval        `json:"interval,omitempty"`             // Период повторяющегося задания; nil - выполняется один раз
+	MaxExecutions *int             `json:"max_executions,omitempty"`       // Сколько раз выполнить повторяющееся задание
+	EndAt         *time.Time       `json:"end_at,omitempty"`               // Позже этого момента повторяющееся задание не планируется
+	Executions    int              `json:"executions"`                     // Сколько раз задание выполнено успешно
+	FailureReason *string          `json:"failure_reason,omitempty"`       // Класс причины последней ошибки (FailureTimeout, FailureHTTP5xx, ...)
+	PayloadRef    *string          `json:"payload_ref,omitempty"`          // Ссылка на payload во внешнем хранилище (s3://bucket/key); payload при этом {}
+	Align         *string          `json:"align,omitempty"`                // Граница, к которой привязываются выполнения повторяющегося задания (AlignMinute, ...)
+	ParentID      *int64           `json:"parent_id,omitempty"`            // Задание, по итогу которого worker создал это (on_success / on_failure)
+	OnSuccess     *json.RawMessage `json:"on_success,omitempty"`           // Задание, которое будет создано после успешного завершения (FollowUpTask)
+	OnFailure     *json.RawMessage `json:"on_failure,omitempty"`           // Задание, которое будет создано после окончательной ошибки (FollowUpTask)
+	DurationMs    *int64           `json:"duration_ms,omitempty"`          // Длительность последнего выполнения в миллисекундах; nil - задание не выполнялось
+	Priority      int              `json:"priority"`                       // Приоритет захвата (см. EffectivePriority); по умолчанию 0
+
+	// Последний ответ http_callback: бинарное тело не пишется в error_message и result как текст
+	// и сохраняется в base64 только при WORKER_BINARY_RESPONSE_MODE=base64 (см. at-worker)
+	ResponseType *string `json:"response_content_type,omitempty"` // Content-Type ответа
+	ResponseBody *string `json:"response_body_base64,omitempty"`  // Бинарное тело ответа в base64
+
+	CancelReason *string `json:"cancel_reason,omitempty"` // Причина отмены, указанная оператором (CancelTaskRequest)
+
+	// Cron-расписание повторяющегося задания (см. ParseCron) вместо interval; nil - без cron
+	CronExpression *string `json:"cron_expression,omitempty"`
+}
+
+// MarshalJSON возвращает задание с временными метками в UTC (RFC3339 с "Z"): PostgreSQL отдает их в часовом
+// поясе сессии, а execute_at из запроса хранится со смещением клиента, поэтому без перевода одно и то же
+// задание выглядело бы по-разному в зависимости от настроек БД и того, как его создали
+func (t ScheduledTask) MarshalJSON() ([]byte, error) {
+	type plain ScheduledTask
+	utc := plain(t)
+	utc.ExecuteAt = utc.ExecuteAt.UTC()
+	utc.CreatedAt = utc.CreatedAt.UTC()
+	utc.UpdatedAt = utc.UpdatedAt.UTC()
+	utc.CompletedAt.Time = utc.CompletedAt.Time.UTC()
+	utc.ClaimedAt.Time = utc.ClaimedAt.Time.UTC()
+	utc.LockedUntil = utcPtr(utc.LockedUntil)
+	utc.ScrubbedAt = utcPtr(utc.ScrubbedAt)
+	utc.EndAt = utcPtr(utc.EndAt)
+	return json.Marshal(utc)
+}
+
+// utcPtr возвращает копию необязательной временной метки в UTC
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// Interval - период повторяющегося задания. В JSON - строка в формате Go duration ("15m"),
+// в БД - колонка interval_ms
+type Interval time.Duration
+
+// MarshalJSON возвращает период строкой Go duration, в том же формате, в котором он задается при создании
+func (i Interval) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(i).String())
+}
+
+// Scan читает период из колонки interval_ms (миллисекунды)
+func (i *Interval) Scan(src interface{}) error {
+	ms, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Interval", src)
+	}
+	*i = Interval(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// NextRun возвращает время следующего выполнения повторяющегося задания после успешного выполнения
+// в момент now; false - задание не повторяющееся или серия закончилась.
+// Следующее выполнение - execute_at + interval; если этот момент уже прошел (worker'ы стояли),
+// пропущенные выполнения не догоняются: берется первый момент сетки после now.
+// С align следующее выполнение привязывается к ближайшей границе минуты, часа или суток UTC (см. alignTime).
+// Задание с cron_expression выполняется в первый момент расписания после now (пропущенные тоже не догоняются).
+// Серия заканчивается после max_executions выполнений или когда следующее выполнение позже end_at.
+// Та же логика для worker'а записана в SQL (at-worker, nextRunQuery).
+func (t *ScheduledTask) NextRun(now time.Time) (time.Time, bool) {
+	if t.CronExpression == nil && (t.Interval == nil || *t.Interval <= 0) {
+		return time.Time{}, false
+	}
+	if t.MaxExecutions != nil && t.Executions+1 >= *t.MaxExecutions {
+		return time.Time{}, false
+	}
+	if t.CronExpression != nil {
+		schedule, err := ParseCron(*t.CronExpression)
+		if err != nil {
+			return time.Time{}, false
+		}
+		next := schedule.Next(now)
+		if next.IsZero() || t.EndAt != nil && next.After(*t.EndAt) {
+			return time.Time{}, false
+		}
+		return next, true
+	}
+
+	interval := time.Duration(*t.Interval)
+	steps := int64(1)
+	if elapsed := now.Sub(t.ExecuteAt); elapsed >= 0 {
+		steps = int64(elapsed/interval) + 1
+	}
+	next := t.ExecuteAt.Add(time.Duration(steps) * interval)
+	if t.Align != nil {
+		next = alignTime(next, now, AlignUnit(*t.Align))
+	}
+	if t.EndAt != nil && next.After(*t.EndAt) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// Границы приоритета задания (поле priority)
+const (
+	MinPriority = -100
+	MaxPriority = 100
+)
+
+// PriorityAgingSeconds - за сколько секунд ожидания после execute_at эффективный приоритет задания растет на 1
+const PriorityAgingSeconds = 60
+
+// EffectivePriority возвращает приоритет, с которым задание захватывается в момент now: priority плюс
+// старение - по 1 за каждые PriorityAgingSeconds после execute_at. Задания с большим priority захватываются
+// раньше, но задание с priority 0, ожидающее 10 минут, обгоняет только что наступившее задание с priority 10,
+// поэтому поток высокоприоритетных заданий не откладывает остальные бесконечно.
+// Та же логика для worker'а записана в SQL (at-worker, priorityOrder).
+func (t *ScheduledTask) EffectivePriority(now time.Time) float64 {
+	return float64(t.Priority) + now.Sub(t.ExecuteAt).Seconds()/PriorityAgingSeconds
+}
+
+// Границы привязки повторяющегося задания (поле align)
+const (
+	AlignMinute = "minute"
+	AlignHour   = "hour"
+	AlignDay    = "day"
+)
+
+// AlignUnit возвращает длину периода границы align; 0 - неизвестное значение
+func AlignUnit(align string) time.Duration {
+	switch align {
+	case AlignMinute:
+		return time.Minute
+	case AlignHour:
+		return time.Hour
+	case AlignDay:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// alignTime привязывает момент next к ближайшей границе unit. Границы считаются от Unix epoch, то есть в UTC:
+// сутки начинаются в 00:00 UTC независимо от часового пояса получателя. Если ближайшая граница не позже now
+// (выполнение опоздало), берется следующая, чтобы задание не выполнилось повторно сразу же.
+// Та же логика для worker'а записана в SQL (at-worker, nextRunQuery).
+func alignTime(next, now time.Time, unit time.Duration) time.Time {
+	if unit <= 0 {
+		return next
+	}
+	aligned := time.Unix(0, 0).Add(next.Sub(time.Unix(0, 0)).Round(unit)).In(next.Location())
+	if !aligned.After(now) {
+		aligned = aligned.Add(unit)
+	}
+	return aligned
+}
+
+// Schedule - расписание, заданное сокращением поля schedule: "hourly@:15", "daily@14:30", "weekly@mon,09:00".
+// Сокращение переводится в interval и execute_at повторяющегося задания и отдельно не хранится.
+// Время расписания - UTC, как и границы align
+type Schedule struct {
+	Interval time.Duration // Период: час, сутки или неделя
+	weekday  time.Weekday  // День недели для weekly
+	hour     int
+	minute   int
+}
+
+// errInvalidSchedule - неизвестное сокращение расписания или неверное время в нем
+var errInvalidSchedule = errors.New("schedule must be one of: hourly@:MM, daily@HH:MM, weekly@DAY,HH:MM (e.g. daily@14:30, weekly@mon,09:00)")
+
+// scheduleWeekdays - дни недели сокращения weekly
+var scheduleWeekdays = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+}
+
+// ParseSchedule разбирает сокращение расписания; неизвестное сокращение или неверное время - ошибка
+func ParseSchedule(value string) (Schedule, error) {
+	kind, at, ok := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "@")
+	if !ok {
+		return Schedule{}, errInvalidSchedule
+	}
+	var schedule Schedule
+	switch kind {
+	case "hourly":
+		schedule.Interval = time.Hour
+		minute, ok := strings.CutPrefix(at, ":")
+		if !ok || !parseClockPart(minute, 59, &schedule.minute) {
+			return Schedule{}, errInvalidSchedule
+		}
+		return schedule, nil
+	case "daily":
+		schedule.Interval = 24 * time.Hour
+	case "weekly":
+		schedule.Interval = 7 * 24 * time.Hour
+		day, clock, _ := strings.Cut(at, ",")
+		weekday, ok := scheduleWeekdays[day]
+		if !ok {
+			return Schedule{}, errInvalidSchedule
+		}
+		schedule.weekday, at = weekday, clock
+	default:
+		return Schedule{}, errInvalidSchedule
+	}
+
+	hour, minute, ok := strings.Cut(at, ":")
+	if !ok || !parseClockPart(hour, 23, &schedule.hour) || !parseClockPart(minute, 59, &schedule.minute) {
+		return Schedule{}, errInvalidSchedule
+	}
+	return schedule, nil
+}
+
+// parseClockPart разбирает часы или минуты из двух цифр в диапазоне 0..max
+func parseClockPart(value string, max int, dst *int) bool {
+	if len(value) != 2 {
+		return false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > max {
+		return false
+	}
+	*dst = n
+	return true
+}
+
+// Next возвращает первый момент расписания не раньше from (в UTC)
+func (s Schedule) Next(from time.Time) time.Time {
+	from = from.UTC()
+	var next time.Time
+	switch s.Interval {
+	case time.Hour:
+		next = from.Truncate(time.Hour).Add(time.Duration(s.minute) * time.Minute)
+	default:
+		next = time.Date(from.Year(), from.Month(), from.Day(), s.hour, s.minute, 0, 0, time.UTC)
+		if s.Interval == 7*24*time.Hour {
+			next = next.AddDate(0, 0, (int(s.weekday)-int(from.Weekday())+7)%7)
+		}
+	}
+	if next.Before(from) {
+		next = next.Add(s.Interval)
+	}
+	return next
 }
 
 // CreateTaskRequest представляет запрос на создание нового задания.
 // Используется в POST /api/v1/tasks
 type CreateTaskRequest struct {
-	ExecuteAt   time.Time       `json:"execute_at"`
+	ExecuteAt     time.Time       `json:"execute_at"` // RFC3339 или Unix timestamp (см. UnmarshalJSON)
+	TaskType      string          `json:"task_type"`
+	Queue         string          `json:"queue,omitempty"` // Именованная очередь; по умолчанию DefaultQueue
+	Payload       json.RawMessage `json:"payload"`
+	MaxAttempts   int             `json:"max_attempts,omitempty"`
+	DedupKey      string          `json:"dedup_key,omitempty"`            // Не более одного активного задания с этим ключом
+	OnDuplicate   string          `json:"on_duplicate,omitempty"`         // Поведение при дубликате: OnDuplicateReject или OnDuplicateReturnExisting
+	DedupWindow   int             `json:"dedup_window_seconds,omitempty"` // Дубликат - любое задание с dedup_key, созданное за последние N секунд
+	Timeout       int             `json:"timeout_seconds,omitempty"`      // Таймаут выполнения в секундах; 0 - по умолчанию для типа
+	ResultTTL     int             `json:"result_ttl_seconds,omitempty"`   // Через сколько секунд после выполнения удалить payload и результат; 0 - хранить
+	Notify        []NotifyChannel `json:"notify,omitempty"`               // Куда сообщить о завершении задания (completed или failed)
+	SkipIfLate    int             `json:"skip_if_late_seconds,omitempty"` // Не выполнять, если worker опоздал больше чем на N секунд (статус skipped); 0 - выполнять всегда
+	Interval      string          `json:"interval,omitempty"`             // Период повторения (Go duration, например "15m"); пусто - выполнить один раз
+	MaxExecutions int             `json:"max_executions,omitempty"`       // Сколько раз выполнить повторяющееся задание; 0 - без ограничения
+	EndAt         *time.Time      `json:"end_at,omitempty"`               // Не планировать повторяющееся задание позже этого момента
+	PayloadRef    string          `json:"-"`                              // Ссылка на вынесенный во внешнее хранилище payload; заполняет TaskService
+	Align         string          `json:"align,omitempty"`                // Привязать выполнения к границе минуты, часа или суток UTC (AlignMinute, ...)
+	Schedule      string          `json:"schedule,omitempty"`             // Сокращение расписания ("daily@14:30", см. Schedule) вместо interval
+	OnSuccess     *FollowUpTask   `json:"on_success,omitempty"`           // Создать задание после успешного завершения этого
+	OnFailure     *FollowUpTask   `json:"on_failure,omitempty"`           // Создать задание после окончательной ошибки этого (компенсирующее действие)
+	Priority      int             `json:"priority,omitempty"`             // Приоритет захвата от MinPriority до MaxPriority; больше - раньше
+
+	// Cron-расписание ("*/5 * * * *", см. ParseCron) вместо interval и schedule: после каждого успешного
+	// выполнения задание возвращается в pending к следующему моменту расписания
+	CronExpression string `json:"cron_expression,omitempty"`
+}
+
+// FollowUpTask - задание, которое worker создает по итогу родительского: on_success - после статуса completed
+// (у повторяющегося - после завершения серии), on_failure - после failed без оставшихся попыток.
+// Созданное задание получает parent_id родителя и execute_at = момент завершения родителя + delay_seconds.
+// Вложенные on_success / on_failure переходят в созданное задание, так строится цепочка шагов.
+type FollowUpTask struct {
 	TaskType    string          `json:"task_type"`
+	Queue       string          `json:"queue,omitempty"` // По умолчанию DefaultQueue
 	Payload     json.RawMessage `json:"payload"`
-	MaxAttempts int             `json:"max_attempts,omitempty"`
+	MaxAttempts int             `json:"max_attempts,omitempty"`    // По умолчанию 3
+	Timeout     int             `json:"timeout_seconds,omitempty"` // 0 - таймаут по умолчанию для типа
+	Delay       int             `json:"delay_seconds,omitempty"`   // Пауза после завершения родителя
+	Notify      []NotifyChannel `json:"notify,omitempty"`
+	OnSuccess   *FollowUpTask   `json:"on_success,omitempty"`
+	OnFailure   *FollowUpTask   `json:"on_failure,omitempty"`
+}
+
+// MaxFollowUpDepth - максимальная вложенность on_success / on_failure (число шагов цепочки после задания)
+const MaxFollowUpDepth = 10
+
+// MaxFollowUpDelaySeconds - максимальная пауза delay_seconds перед заданием цепочки (30 дней)
+const MaxFollowUpDelaySeconds = 30 * 24 * 60 * 60
+
+// IntervalMillis возвращает период повторения в миллисекундах (для колонки interval_ms); 0 - задание
+// не повторяющееся. Interval должен быть уже проверен при валидации запроса
+func (r *CreateTaskRequest) IntervalMillis() int64 {
+	interval, err := time.ParseDuration(r.Interval)
+	if err != nil {
+		return 0
+	}
+	return interval.Milliseconds()
+}
+
+// NotifyChannel - канал уведомления о завершении задания.
+// Worker доставляет уведомление в каждый канал независимо: ошибка одного не мешает остальным
+type NotifyChannel struct {
+	Type   string `json:"type"`   // NotifyWebhook или NotifySlack
+	Target string `json:"target"` // URL получателя (http или https)
+}
+
+// Типы каналов уведомлений
+const (
+	NotifyWebhook = "webhook" // POST JSON с итогом задания
+	NotifySlack   = "slack"   // Slack incoming webhook: POST {"text": "..."}
+)
+
+// MaxNotifyChannels - максимальное число каналов уведомлений у одного задания
+const MaxNotifyChannels = 10
+
+// epochMillisThreshold - числа execute_at от этого значения считаются миллисекундами, меньшие - секундами.
+// 1e12 секунд - это 33658 год, а 1e12 миллисекунд - 2001 год, поэтому граница однозначна для реальных дат.
+const epochMillisThreshold = 1_000_000_000_000
+
+// UnmarshalJSON разбирает CreateTaskRequest, принимая execute_at в двух форматах:
+//   - строка RFC3339: "2025-11-10T15:00:00Z"
+//   - целое число: Unix timestamp в секундах (1762786800) или миллисекундах (1762786800000)
+//
+// end_at принимается только строкой RFC3339.
+//
+// Ошибки формата возвращаются как *TimeFieldError (оборачивает *time.ParseError)
+// или *json.UnmarshalTypeError, чтобы handler мог сообщить клиенту, какое поле и что именно не так.
+func (r *CreateTaskRequest) UnmarshalJSON(data []byte) error {
+	type plain CreateTaskRequest
+	aux := struct {
+		*plain
+		ExecuteAt json.RawMessage `json:"execute_at"`
+		EndAt     json.RawMessage `json:"end_at"`
+	}{plain: (*plain)(r)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	executeAt, err := parseExecuteAt(aux.ExecuteAt)
+	if err != nil {
+		return wrapTimeField("execute_at", err)
+	}
+	r.ExecuteAt = executeAt
+
+	endAt, err := parseEndAt(aux.EndAt)
+	if err != nil {
+		return wrapTimeField("end_at", err)
+	}
+	r.EndAt = endAt
+	return nil
+}
+
+// TimeFieldError - ошибка разбора строки времени с именем поля запроса, в котором она возникла
+type TimeFieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *TimeFieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *TimeFieldError) Unwrap() error {
+	return e.Err
+}
+
+// wrapTimeField добавляет имя поля к ошибке формата времени (*time.ParseError).
+// Остальные ошибки возвращаются как есть: *json.UnmarshalTypeError уже содержит поле.
+func wrapTimeField(field string, err error) error {
+	var parseErr *time.ParseError
+	if errors.As(err, &parseErr) {
+		return &TimeFieldError{Field: field, Err: err}
+	}
+	return err
 }
 
+// parseEndAt разбирает значение end_at: строку RFC3339.
+// Отсутствующее поле и null дают nil (без ограничения по времени).
+func parseEndAt(raw json.RawMessage) (*time.Time, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '"' {
+		return nil, &json.UnmarshalTypeError{Value: jsonKind(raw[0]), Type: reflect.TypeOf(time.Time{}), Field: "end_at"}
+	}
+
+	var t time.Time
+	if err := t.UnmarshalJSON(raw); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// parseExecuteAt разбирает значение execute_at: строку RFC3339 или Unix timestamp.
+// Отсутствующее поле и null дают нулевое время (обязательность проверяет handler).
+func parseExecuteAt(raw json.RawMessage) (time.Time, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, nil
+	}
+
+	switch raw[0] {
+	case '"':
+		var t time.Time
+		err := t.UnmarshalJSON(raw)
+		return t, err
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		epoch, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return time.Time{}, &json.UnmarshalTypeError{Value: "number " + string(raw), Type: reflect.TypeOf(time.Time{}), Field: "execute_at"}
+		}
+		if epoch >= epochMillisThreshold || epoch <= -epochMillisThreshold {
+			return time.UnixMilli(epoch).UTC(), nil
+		}
+		return time.Unix(epoch, 0).UTC(), nil
+	default:
+		return time.Time{}, &json.UnmarshalTypeError{Value: jsonKind(raw[0]), Type: reflect.TypeOf(time.Time{}), Field: "execute_at"}
+	}
+}
+
+// jsonKind возвращает название типа JSON значения по первому символу
+func jsonKind(first byte) string {
+	switch first {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	default:
+		return "value"
+	}
+}
+
+// RequeueTaskRequest представляет запрос на повторный запуск завершившегося ошибкой задания.
+// Используется в POST /api/v1/tasks/:id/requeue
+type RequeueTaskRequest struct {
+	MaxAttempts int `json:"max_attempts,omitempty"` // Новый лимит попыток; по умолчанию attempts + 1
+}
+
+// BulkRequeueRequest представляет запрос на массовый перезапуск заданий в статусе 'failed'.
+// Используется в POST /api/v1/tasks/requeue; фильтры те же, что у списка заданий
+type BulkRequeueRequest struct {
+	TaskType      string `json:"task_type,omitempty"`      // Фильтр по типу задания
+	Queue         string `json:"queue,omitempty"`          // Фильтр по очереди
+	FailureReason string `json:"failure_reason,omitempty"` // Фильтр по классу ошибки (FailureTimeout, ...)
+	ErrorContains string `json:"error_contains,omitempty"` // Подстрока error_message без учета регистра
+	Limit         int    `json:"limit,omitempty"`          // Сколько заданий перезапустить; по умолчанию DefaultBulkRequeueLimit
+	SpreadSeconds int    `json:"spread_seconds,omitempty"` // Окно, по которому равномерно распределяется execute_at; 0 - все сразу
+}
+
+// BulkRequeueResponse представляет ответ на массовый перезапуск заданий
+type BulkRequeueResponse struct {
+	Requeued int `json:"requeued"` // Сколько заданий возвращено в 'pending'
+}
+
+// Ограничения массового перезапуска (POST /api/v1/tasks/requeue)
+const (
+	DefaultBulkRequeueLimit = 1000
+	MaxBulkRequeueLimit     = 50000
+	MaxRequeueSpreadSeconds = 24 * 60 * 60
+)
+
+// CreateTasksRequest представляет запрос на создание нескольких заданий одной транзакцией.
+// Используется в POST /api/v1/tasks/batch
+type CreateTasksRequest struct {
+	Tasks []CreateTaskRequest `json:"tasks"`
+}
+
+// MaxBatchTasks - максимальное число заданий в одном запросе POST /api/v1/tasks/batch
+const MaxBatchTasks = 50000
+
+// CreateTasksResponse представляет ответ на создание батча заданий
+type CreateTasksResponse struct {
+	Created int              `json:"created"` // Сколько заданий создано
+	Tasks   []*ScheduledTask `json:"tasks"`   // Созданные задания в порядке запроса
+}
+
+// ClaimTasksRequest представляет запрос внешнего клиента на захват заданий.
+// Используется в POST /api/v1/tasks/claim
+type ClaimTasksRequest struct {
+	Queue        string   `json:"queue,omitempty"`         // Очередь; пусто - все очереди
+	TaskTypes    []string `json:"task_types,omitempty"`    // Типы заданий, которые умеет выполнять клиент; пусто - любые
+	Limit        int      `json:"limit,omitempty"`         // Сколько заданий захватить; по умолчанию DefaultClaimLimit
+	LeaseSeconds int      `json:"lease_seconds,omitempty"` // Срок аренды; по умолчанию API_CLAIM_DEFAULT_LEASE_SECONDS
+}
+
+// DefaultClaimLimit и MaxClaimLimit - количество заданий, захватываемых за один claim
+const (
+	DefaultClaimLimit = 10
+	MaxClaimLimit     = 100
+)
+
+// ClaimedTask - задание, захваченное внешним клиентом, вместе с токеном аренды
+type ClaimedTask struct {
+	*ScheduledTask
+	LeaseToken string `json:"lease_token"`
+}
+
+// MarshalJSON возвращает поля задания (в UTC, см. ScheduledTask.MarshalJSON) вместе с lease_token.
+// Без своего метода ClaimedTask унаследовал бы MarshalJSON встроенного задания, и токен аренды пропал бы из ответа
+func (c ClaimedTask) MarshalJSON() ([]byte, error) {
+	token, err := json.Marshal(c.LeaseToken)
+	if err != nil {
+		return nil, err
+	}
+	if c.ScheduledTask == nil {
+		return []byte(`{"lease_token":` + string(token) + `}`), nil
+	}
+
+	task, err := json.Marshal(c.ScheduledTask)
+	if err != nil {
+		return nil, err
+	}
+	// Объект задания всегда непустой (id, execute_at, ...), поэтому поле добавляется через запятую перед "}"
+	merged := append(task[:len(task)-1:len(task)-1], `,"lease_token":`...)
+	merged = append(merged, token...)
+	return append(merged, '}'), nil
+}
+
+// ClaimTasksResponse представляет ответ со списком захваченных заданий
+type ClaimTasksResponse struct {
+	Tasks []ClaimedTask `json:"tasks"`
+}
+
+// ExtendLeaseRequest представляет запрос внешнего клиента на продление аренды задания, которое он еще выполняет.
+// Используется в POST /api/v1/tasks/:id/extend
+type ExtendLeaseRequest struct {
+	LeaseToken   string `json:"lease_token"`
+	LeaseSeconds int    `json:"lease_seconds,omitempty"` // Новый срок аренды от текущего момента; по умолчанию API_CLAIM_DEFAULT_LEASE_SECONDS
+}
+
+// MaxCancelReasonLength - максимальная длина причины отмены задания в символах
+const MaxCancelReasonLength = 1000
+
+// CancelTaskRequest представляет необязательное тело запроса на отмену задания.
+// Используется в DELETE /api/v1/tasks/:id и DELETE /api/v1/tasks/by-key/:key
+type CancelTaskRequest struct {
+	Reason string `json:"reason,omitempty"` // Почему задание отменено; сохраняется в cancel_reason для аудита
+}
+
+// FinishTaskRequest представляет отчет внешнего клиента о выполнении захваченного задания.
+// Используется в POST /api/v1/tasks/:id/complete и POST /api/v1/tasks/:id/fail
+type FinishTaskRequest struct {
+	LeaseToken    string          `json:"lease_token"`
+	ErrorMessage  string          `json:"error_message,omitempty"`  // Описание ошибки (для fail)
+	FailureReason string          `json:"failure_reason,omitempty"` // Класс ошибки (для fail); пусто - FailureUnknown
+	Warning       string          `json:"warning,omitempty"`        // Предупреждение при частичном успехе (для complete)
+	Result        json.RawMessage `json:"result,omitempty"`         // Структурированный результат выполнения
+}
+
+// Классы причин ошибок выполнения (failure_reason). Свободный текст error_message удобен для
+// разбора отдельного случая, класс - для агрегации и фильтрации
+const (
+	FailureTimeout           = "timeout"            // Истек таймаут задания или аренды, сетевой таймаут
+	FailureConnectionRefused = "connection_refused" // Получатель отказал в соединении
+	FailureHTTP4xx           = "http_4xx"           // Получатель ответил 4xx
+	FailureHTTP5xx           = "http_5xx"           // Получатель ответил 5xx
+	FailureValidation        = "validation"         // Некорректный payload или неизвестный тип задания
+	FailureUnknown           = "unknown"            // Остальные ошибки
+)
+
+// IsFailureReason сообщает, является ли значение известным классом ошибки
+func IsFailureReason(reason string) bool {
+	switch reason {
+	case FailureTimeout, FailureConnectionRefused, FailureHTTP4xx, FailureHTTP5xx, FailureValidation, FailureUnknown:
+		return true
+	}
+	return false
+}
+
+// MaxTimeoutSeconds - максимальный таймаут выполнения задания (сутки)
+const MaxTimeoutSeconds = 24 * 60 * 60
+
+// MinInterval - минимальный период повторяющегося задания: чаще worker не опрашивает очередь
+const MinInterval = time.Second
+
+// MaxSkipIfLateSeconds - максимальное допустимое опоздание для skip_if_late_seconds (неделя)
+const MaxSkipIfLateSeconds = 7 * 24 * 60 * 60
+
+// MaxResultTTLSeconds - максимальный срок хранения payload и результата выполненного задания (колонка INT)
+const MaxResultTTLSeconds = math.MaxInt32
+
+// MaxDedupWindowSeconds - максимальное окно дедупликации при создании задания (30 дней)
+const MaxDedupWindowSeconds = 30 * 24 * 60 * 60
+
+// DefaultQueue - очередь, в которую попадают задания без явно указанного queue
+const DefaultQueue = "default"
+
+// Поведение при создании задания с dedup_key, для которого уже есть активное задание
+const (
+	OnDuplicateReject         = "reject"          // Отказ с 409 Conflict (по умолчанию)
+	OnDuplicateReturnExisting = "return_existing" // Возврат существующего задания с 200 OK
+)
+
 // ListTasksParams содержит параметры для фильтрации списка заданий.
 // Используется в GET /api/v1/tasks
 type ListTasksParams struct {
-	Status   string // Фильтр по статусу: pending, processing, completed, failed, cancelled
-	TaskType string // Фильтр по типу задания
-	Limit    int    // Количество записей на странице
-	Offset   int    // Смещение для пагинации
+	Status        string // Фильтр по статусу: pending, processing, hold, completed, failed, cancelled
+	TaskType      string // Фильтр по типу задания
+	Queue         string // Фильтр по очереди
+	HasError      *bool  // Фильтр по наличию error_message; nil - без фильтра
+	ErrorContains string // Подстрока error_message без учета регистра
+	FailureReason string // Фильтр по классу ошибки (failure_reason)
+	MinDurationMs *int64 // Только задания с duration_ms не меньше указанного; nil - без фильтра
+	MaxDurationMs *int64 // Только задания с duration_ms не больше указанного; nil - без фильтра
+	Sort          string // Порядок списка (ListSortCreatedDesc, ...); пусто - ListSortCreatedDesc
+	Limit         int    // Количество записей на странице
+	Offset        int    // Смещение для пагинации
+}
+
+// Порядок списка заданий (параметр sort): поле и направление, "-" - по убыванию.
+// По duration_ms задания без длительности (еще не выполнявшиеся) идут в конце при любом направлении
+const (
+	ListSortCreatedDesc  = "-created_at" // Новые первыми (по умолчанию)
+	ListSortCreatedAsc   = "created_at"
+	ListSortDurationDesc = "-duration_ms" // Самые долгие первыми
+	ListSortDurationAsc  = "duration_ms"
+)
+
+// IsListSort проверяет, что sort - известный порядок списка заданий
+func IsListSort(sort string) bool {
+	switch sort {
+	case ListSortCreatedDesc, ListSortCreatedAsc, ListSortDurationDesc, ListSortDurationAsc:
+		return true
+	}
+	return false
 }
 
 // TaskResponse представляет успешный ответ с данными задания
 type TaskResponse struct {
-	Task *ScheduledTask `json:"task"`
+	Task      *ScheduledTask `json:"task"`
+	Duplicate bool           `json:"duplicate,omitempty"` // Вместо создания возвращено существующее задание с тем же dedup_key
 }
 
 // TaskListResponse представляет ответ со списком заданий
@@ -54,6 +700,42 @@ type TaskListResponse struct {
 	Total int             `json:"total"`
 }
 
+// StateTransition - одна смена статуса задания из его истории (state_transitions)
+type StateTransition struct {
+	From string    `json:"from,omitempty"` // Прежний статус; нет у первой записи (создание задания)
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+	By   string    `json:"by,omitempty"` // Кто сменил статус: at-api, at-worker:<WORKER_ID> (application_name соединения)
+}
+
+// MarshalJSON возвращает смену статуса со временем в UTC, как и временные метки задания
+func (t StateTransition) MarshalJSON() ([]byte, error) {
+	type plain StateTransition
+	utc := plain(t)
+	utc.At = utc.At.UTC()
+	return json.Marshal(utc)
+}
+
+// TaskHistory представляет ответ GET /api/v1/tasks/:id/history: текущий статус и смены статуса по порядку
+type TaskHistory struct {
+	TaskID      int64             `json:"task_id"`
+	Status      string            `json:"status"`
+	Transitions []StateTransition `json:"transitions"`
+}
+
+// ExportTrailer - последняя строка выгрузки GET /api/v1/tasks/export. Выгрузка полная, только если
+// последняя строка - трейлер с _complete: true; без трейлера ответ оборван (например, при обрыве соединения с БД)
+type ExportTrailer struct {
+	Complete bool   `json:"_complete"`
+	Count    int    `json:"count"`           // Сколько заданий выгружено до трейлера
+	Error    string `json:"error,omitempty"` // Причина, по которой выгрузка прервана (при _complete: false)
+}
+
+// ConfigResponse представляет ответ GET /config: действующие настройки по именам переменных окружения
+type ConfigResponse struct {
+	Config map[string]string `json:"config"`
+}
+
 // ErrorResponse представляет ответ с ошибкой
 type ErrorResponse struct {
 	Error string `json:"error"`
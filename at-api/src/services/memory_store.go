@@ -0,0 +1,806 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"at-api/models"
+)
+
+// MemoryTaskStore хранит задания в памяти процесса.
+// Предназначен для unit-тестов handlers и TaskService без PostgreSQL:
+// повторяет поведение PostgresTaskStore (статусы, сортировку, пагинацию), но не персистентен.
+type MemoryTaskStore struct {
+	mu      sync.Mutex
+	nextID  int64
+	tasks   map[int64]*models.ScheduledTask
+	history map[int64][]models.StateTransition // Смены статуса по ID задания, как state_transitions в БД
+}
+
+const (
+	// memoryActor - кто меняет статус в хранилище в памяти (поле by истории); в БД это application_name API
+	memoryActor = "at-api"
+	// maxStateTransitions - сколько последних смен статуса хранится, как в триггере record_state_transition
+	maxStateTransitions = 100
+)
+
+// NewMemoryTaskStore создает пустое хранилище заданий в памяти
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		nextID:  1,
+		tasks:   make(map[int64]*models.ScheduledTask),
+		history: make(map[int64][]models.StateTransition),
+	}
+}
+
+// CreateTask сохраняет новое задание в статусе 'pending'
+func (s *MemoryTaskStore) CreateTask(ctx context.Context, req *models.CreateTaskRequest) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.DedupKey != "" && s.activeByDedupKey(req.DedupKey) != nil {
+		return nil, ErrDuplicateTask
+	}
+	return s.create(req)
+}
+
+// CreateTasks сохраняет все задания или ни одного, если хотя бы одно нарушает уникальность dedup_key
+// (в том числе повтором ключа внутри батча). chunkSize в памяти не нужен и игнорируется
+func (s *MemoryTaskStore) CreateTasks(ctx context.Context, reqs []models.CreateTaskRequest, chunkSize int) ([]*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make(map[string]bool)
+	for i := range reqs {
+		key := reqs[i].DedupKey
+		if key == "" {
+			continue
+		}
+		if keys[key] || s.activeByDedupKey(key) != nil {
+			return nil, ErrDuplicateTask
+		}
+		keys[key] = true
+	}
+
+	tasks := make([]*models.ScheduledTask, 0, len(reqs))
+	for i := range reqs {
+		task, err := s.create(&reqs[i])
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// create добавляет задание в хранилище; вызывается под s.mu
+func (s *MemoryTaskStore) create(req *models.CreateTaskRequest) (*models.ScheduledTask, error) {
+	now := time.Now()
+	task, err := s.newTask(req, now)
+	if err != nil {
+		return nil, err
+	}
+	s.nextID++
+	s.tasks[task.ID] = task
+	s.history[task.ID] = []models.StateTransition{{To: task.Status, At: now, By: memoryActor}}
+
+	copied := *task
+	return &copied, nil
+}
+
+// newTask создает pending задание по запросу со следующим ID, не добавляя его в хранилище; вызывается под s.mu
+func (s *MemoryTaskStore) newTask(req *models.CreateTaskRequest, now time.Time) (*models.ScheduledTask, error) {
+	task := &models.ScheduledTask{
+		ID:          s.nextID,
+		ExecuteAt:   req.ExecuteAt,
+		TaskType:    req.TaskType,
+		Queue:       req.Queue,
+		Payload:     append([]byte(nil), req.Payload...),
+		Status:      "pending",
+		MaxAttempts: req.MaxAttempts,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Priority:    req.Priority,
+	}
+	if req.DedupKey != "" {
+		key := req.DedupKey
+		task.DedupKey = &key
+	}
+	if req.Timeout != 0 {
+		timeout := req.Timeout
+		task.Timeout = &timeout
+	}
+	if req.ResultTTL != 0 {
+		ttl := req.ResultTTL
+		task.ResultTTL = &ttl
+	}
+	if req.SkipIfLate != 0 {
+		grace := req.SkipIfLate
+		task.SkipIfLate = &grace
+	}
+	if ms := req.IntervalMillis(); ms != 0 {
+		interval := models.Interval(time.Duration(ms) * time.Millisecond)
+		task.Interval = &interval
+	}
+	if req.MaxExecutions != 0 {
+		maxExecutions := req.MaxExecutions
+		task.MaxExecutions = &maxExecutions
+	}
+	if req.EndAt != nil {
+		endAt := *req.EndAt
+		task.EndAt = &endAt
+	}
+	if req.PayloadRef != "" {
+		ref := req.PayloadRef
+		task.PayloadRef = &ref
+	}
+	if req.Align != "" {
+		align := req.Align
+		task.Align = &align
+	}
+	if req.CronExpression != "" {
+		expr := req.CronExpression
+		task.CronExpression = &expr
+	}
+	if len(req.Notify) > 0 {
+		data, err := json.Marshal(req.Notify)
+		if err != nil {
+			return nil, err
+		}
+		notify := json.RawMessage(data)
+		task.Notify = &notify
+	}
+	if req.OnSuccess != nil {
+		data, err := json.Marshal(req.OnSuccess)
+		if err != nil {
+			return nil, err
+		}
+		onSuccess := json.RawMessage(data)
+		task.OnSuccess = &onSuccess
+	}
+	if req.OnFailure != nil {
+		data, err := json.Marshal(req.OnFailure)
+		if err != nil {
+			return nil, err
+		}
+		onFailure := json.RawMessage(data)
+		task.OnFailure = &onFailure
+	}
+	return task, nil
+}
+
+// UpsertTaskByKey создает задание с ключом req.DedupKey или заменяет описание активного задания с этим ключом,
+// как UpsertTaskByKey в PostgresTaskStore
+func (s *MemoryTaskStore) UpsertTaskByKey(ctx context.Context, req *models.CreateTaskRequest) (*models.ScheduledTask, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.activeByDedupKey(req.DedupKey)
+	if existing == nil {
+		task, err := s.create(req)
+		return task, true, err
+	}
+	if existing.Status == "processing" {
+		return nil, false, ErrTaskInProgress
+	}
+
+	now := time.Now()
+	replacement, err := s.newTask(req, now)
+	if err != nil {
+		return nil, false, err
+	}
+	// Заменяются поля, которые задаются при создании (insertTaskColumns), и сбрасываются счетчики
+	// и результаты прошлых выполнений; статус остается
+	existing.ExecuteAt = replacement.ExecuteAt
+	existing.TaskType = replacement.TaskType
+	existing.Queue = replacement.Queue
+	existing.Payload = replacement.Payload
+	existing.MaxAttempts = replacement.MaxAttempts
+	existing.Timeout = replacement.Timeout
+	existing.ResultTTL = replacement.ResultTTL
+	existing.Notify = replacement.Notify
+	existing.SkipIfLate = replacement.SkipIfLate
+	existing.Interval = replacement.Interval
+	existing.MaxExecutions = replacement.MaxExecutions
+	existing.EndAt = replacement.EndAt
+	existing.PayloadRef = replacement.PayloadRef
+	existing.Align = replacement.Align
+	existing.OnSuccess = replacement.OnSuccess
+	existing.OnFailure = replacement.OnFailure
+	existing.Priority = replacement.Priority
+	existing.CronExpression = replacement.CronExpression
+	existing.Attempts = 0
+	existing.Executions = 0
+	existing.ErrorMessage = sql.NullString{}
+	existing.Result = nil
+	existing.Warning = nil
+	existing.FailureReason = nil
+	existing.DurationMs = nil
+	existing.Progress = nil
+	existing.CompletedAt = sql.NullTime{}
+	existing.ScrubbedAt = nil
+	existing.ResponseType = nil
+	existing.ResponseBody = nil
+	existing.UpdatedAt = now
+
+	copied := *existing
+	return &copied, false, nil
+}
+
+// GetTask возвращает копию задания по ID
+func (s *MemoryTaskStore) GetTask(ctx context.Context, id int64) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	copied := *task
+	return &copied, nil
+}
+
+// GetTaskHistory возвращает статус задания и его смены статуса
+func (s *MemoryTaskStore) GetTaskHistory(ctx context.Context, id int64) (*models.TaskHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+
+	return &models.TaskHistory{
+		TaskID:      id,
+		Status:      task.Status,
+		Transitions: append([]models.StateTransition(nil), s.history[id]...),
+	}, nil
+}
+
+// setStatus меняет статус задания и дописывает смену в историю, как триггер record_state_transition;
+// вызывается под s.mu
+func (s *MemoryTaskStore) setStatus(task *models.ScheduledTask, status string, now time.Time) {
+	if task.Status == status {
+		return
+	}
+	history := append(s.history[task.ID], models.StateTransition{From: task.Status, To: status, At: now, By: memoryActor})
+	if len(history) > maxStateTransitions {
+		history = history[len(history)-maxStateTransitions:]
+	}
+	s.history[task.ID] = history
+	task.Status = status
+}
+
+// GetTaskByDedupKey возвращает копию задания с ключом: активного, а если его нет - последнего созданного
+func (s *MemoryTaskStore) GetTaskByDedupKey(ctx context.Context, key string) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := s.activeByDedupKey(key)
+	if found == nil {
+		for _, task := range s.tasks {
+			if task.DedupKey != nil && *task.DedupKey == key && (found == nil || task.ID > found.ID) {
+				found = task
+			}
+		}
+	}
+	if found == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	copied := *found
+	return &copied, nil
+}
+
+// GetRecentTaskByDedupKey возвращает копию последнего задания с ключом, созданного за последние window
+func (s *MemoryTaskStore) GetRecentTaskByDedupKey(ctx context.Context, key string, window time.Duration) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := time.Now().Add(-window)
+	var found *models.ScheduledTask
+	for _, task := range s.tasks {
+		if task.DedupKey != nil && *task.DedupKey == key && task.CreatedAt.After(since) && (found == nil || task.ID > found.ID) {
+			found = task
+		}
+	}
+	if found == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	copied := *found
+	return &copied, nil
+}
+
+// GetActiveTaskByDedupKey возвращает копию активного задания с указанным dedup_key
+func (s *MemoryTaskStore) GetActiveTaskByDedupKey(ctx context.Context, key string) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task := s.activeByDedupKey(key)
+	if task == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	copied := *task
+	return &copied, nil
+}
+
+// activeByDedupKey ищет активное задание по ключу; вызывается под s.mu
+func (s *MemoryTaskStore) activeByDedupKey(key string) *models.ScheduledTask {
+	for _, task := range s.tasks {
+		if task.DedupKey != nil && *task.DedupKey == key && (task.Status == "pending" || task.Status == "processing" || task.Status == "hold") {
+			return task
+		}
+	}
+	return nil
+}
+
+// CancelTask переводит задание в 'cancelled', если оно в 'pending' или 'processing'
+func (s *MemoryTaskStore) CancelTask(ctx context.Context, id int64, reason string) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok || (task.Status != "pending" && task.Status != "processing" && task.Status != "hold") {
+		return nil, ErrTaskNotFound
+	}
+
+	now := time.Now()
+	s.setStatus(task, "cancelled", now)
+	task.UpdatedAt = now
+	task.CompletedAt = sql.NullTime{Time: now, Valid: true}
+	if reason != "" {
+		task.CancelReason = &reason
+	}
+
+	copied := *task
+	return &copied, nil
+}
+
+// TransitionStatus меняет статус задания с from на to, если задание все еще в статусе from
+func (s *MemoryTaskStore) TransitionStatus(ctx context.Context, id int64, from, to string) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok || task.Status != from {
+		return nil, ErrTaskNotFound
+	}
+
+	now := time.Now()
+	s.setStatus(task, to, now)
+	task.UpdatedAt = now
+
+	copied := *task
+	return &copied, nil
+}
+
+// RequeueTask возвращает задание из 'failed' в 'pending' с новым max_attempts
+func (s *MemoryTaskStore) RequeueTask(ctx context.Context, id int64, maxAttempts int) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok || task.Status != "failed" || task.Attempts >= maxAttempts {
+		return nil, ErrTaskNotFound
+	}
+	if task.DedupKey != nil && s.activeByDedupKey(*task.DedupKey) != nil {
+		return nil, ErrDuplicateTask
+	}
+
+	now := time.Now()
+	s.setStatus(task, "pending", now)
+	task.MaxAttempts = maxAttempts
+	task.CompletedAt = sql.NullTime{}
+	task.UpdatedAt = now
+
+	copied := *task
+	return &copied, nil
+}
+
+// RequeueFailedTasks возвращает в 'pending' до limit упавших заданий, как RequeueFailedTasks в PostgresTaskStore
+func (s *MemoryTaskStore) RequeueFailedTasks(ctx context.Context, params models.ListTasksParams, limit int, spread time.Duration, maxAttemptsLimit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Из упавших заданий с одним dedup_key перезапускается последнее, и только если активного с этим ключом нет
+	latest := make(map[string]*models.ScheduledTask)
+	var candidates []*models.ScheduledTask
+	for _, task := range s.tasks {
+		if !listMatch(task, params) || (maxAttemptsLimit > 0 && task.Attempts >= maxAttemptsLimit) {
+			continue
+		}
+		if task.DedupKey == nil {
+			candidates = append(candidates, task)
+			continue
+		}
+		if s.activeByDedupKey(*task.DedupKey) != nil {
+			continue
+		}
+		if prev := latest[*task.DedupKey]; prev == nil || task.ID > prev.ID {
+			latest[*task.DedupKey] = task
+		}
+	}
+	for _, task := range latest {
+		candidates = append(candidates, task)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.CompletedAt.Time.Equal(b.CompletedAt.Time) {
+			return a.CompletedAt.Time.Before(b.CompletedAt.Time)
+		}
+		return a.ID < b.ID
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	now := time.Now()
+	for n, task := range candidates {
+		s.setStatus(task, "pending", now)
+		task.MaxAttempts = task.Attempts + 1
+		task.CompletedAt = sql.NullTime{}
+		task.ExecuteAt = now.Add(time.Duration(int64(n)*spread.Milliseconds()/int64(len(candidates))) * time.Millisecond)
+		task.UpdatedAt = now
+	}
+	return len(candidates), nil
+}
+
+// ClaimTasks захватывает готовые к выполнению pending задания в порядке claimLess
+func (s *MemoryTaskStore) ClaimTasks(ctx context.Context, req *models.ClaimTasksRequest, leaseToken string, lease time.Duration) ([]*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	types := make(map[string]bool, len(req.TaskTypes))
+	for _, taskType := range req.TaskTypes {
+		types[taskType] = true
+	}
+
+	ready := []*models.ScheduledTask{}
+	for _, task := range s.tasks {
+		if task.Status != "pending" || task.ExecuteAt.After(now) {
+			continue
+		}
+		if req.Queue != "" && task.Queue != req.Queue {
+			continue
+		}
+		if len(types) > 0 && !types[task.TaskType] {
+			continue
+		}
+		ready = append(ready, task)
+	}
+
+	// Как в PostgresTaskStore (claimTasksQuery): приоритет учитывается только среди ближайших по execute_at заданий
+	sort.Slice(ready, func(i, j int) bool {
+		if !ready[i].ExecuteAt.Equal(ready[j].ExecuteAt) {
+			return ready[i].ExecuteAt.Before(ready[j].ExecuteAt)
+		}
+		return ready[i].ID < ready[j].ID
+	})
+	if window := req.Limit * claimScanFactor; len(ready) > window {
+		ready = ready[:window]
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		return claimLess(ready[i], ready[j], now)
+	})
+	if len(ready) > req.Limit {
+		ready = ready[:req.Limit]
+	}
+
+	claimed := make([]*models.ScheduledTask, 0, len(ready))
+	lockedUntil := now.Add(lease)
+	for _, task := range ready {
+		token := fmt.Sprintf("%s-%d", leaseToken, task.ID)
+		lockedUntil := lockedUntil
+		s.setStatus(task, "processing", now)
+		task.Attempts++
+		task.ClaimedAt = sql.NullTime{Time: now, Valid: true}
+		task.LeaseToken = &token
+		task.LockedUntil = &lockedUntil
+		task.UpdatedAt = now
+
+		copied := *task
+		claimed = append(claimed, &copied)
+	}
+
+	return claimed, nil
+}
+
+// claimLess сравнивает задания для порядка захвата, как claimOrder в PostgresTaskStore: раньше захватывается
+// задание с большим эффективным приоритетом на момент now, при равном - с меньшим execute_at и id
+func claimLess(a, b *models.ScheduledTask, now time.Time) bool {
+	if pa, pb := a.EffectivePriority(now), b.EffectivePriority(now); pa != pb {
+		return pa > pb
+	}
+	if !a.ExecuteAt.Equal(b.ExecuteAt) {
+		return a.ExecuteAt.Before(b.ExecuteAt)
+	}
+	return a.ID < b.ID
+}
+
+// ExtendLease продлевает аренду задания, если токен совпадает
+func (s *MemoryTaskStore) ExtendLease(ctx context.Context, id int64, leaseToken string, lease time.Duration) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task := s.leasedTask(id, leaseToken)
+	if task == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	now := time.Now()
+	lockedUntil := now.Add(lease)
+	task.LockedUntil = &lockedUntil
+	task.UpdatedAt = now
+
+	copied := *task
+	return &copied, nil
+}
+
+// CompleteLeasedTask переводит арендованное задание в 'completed', если токен совпадает.
+// Повторяющееся задание с незакончившейся серией возвращается в 'pending' со следующим execute_at
+func (s *MemoryTaskStore) CompleteLeasedTask(ctx context.Context, id int64, leaseToken, warning string, result json.RawMessage) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task := s.leasedTask(id, leaseToken)
+	if task == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	now := time.Now()
+	if next, ok := task.NextRun(now); ok {
+		s.setStatus(task, "pending", now)
+		task.ExecuteAt = next
+		task.Attempts = 0
+	} else {
+		s.setStatus(task, "completed", now)
+		task.CompletedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	task.Executions++
+	task.FailureReason = nil
+	task.DurationMs = leaseDuration(task, now)
+	task.Result = rawResult(result)
+	if warning != "" {
+		task.Warning = &warning
+	}
+	task.LeaseToken = nil
+	task.LockedUntil = nil
+	task.UpdatedAt = now
+	if err := s.chain(task, now); err != nil {
+		return nil, err
+	}
+
+	copied := *task
+	return &copied, nil
+}
+
+// FailLeasedTask возвращает арендованное задание в 'pending' или переводит в 'failed', если попытки исчерпаны
+func (s *MemoryTaskStore) FailLeasedTask(ctx context.Context, id int64, leaseToken, errorMessage, failureReason string, result json.RawMessage) (*models.ScheduledTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task := s.leasedTask(id, leaseToken)
+	if task == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	now := time.Now()
+	status := "pending"
+	if task.Attempts >= task.MaxAttempts {
+		status = "failed"
+		task.CompletedAt = sql.NullTime{Time: now, Valid: true}
+	}
+	s.setStatus(task, status, now)
+	task.ErrorMessage = sql.NullString{String: errorMessage, Valid: true}
+	task.FailureReason = &failureReason
+	task.DurationMs = leaseDuration(task, now)
+	task.Result = rawResult(result)
+	task.LeaseToken = nil
+	task.LockedUntil = nil
+	task.UpdatedAt = now
+	if err := s.chain(task, now); err != nil {
+		return nil, err
+	}
+
+	copied := *task
+	return &copied, nil
+}
+
+// chain создает задание цепочки из on_success завершенного или on_failure упавшего задания parent,
+// как CTE chained в PostgresTaskStore (chainedTaskQuery); вызывается под s.mu
+func (s *MemoryTaskStore) chain(parent *models.ScheduledTask, now time.Time) error {
+	var spec *json.RawMessage
+	switch parent.Status {
+	case "completed":
+		spec = parent.OnSuccess
+	case "failed":
+		spec = parent.OnFailure
+	}
+	if spec == nil {
+		return nil
+	}
+
+	var followUp models.FollowUpTask
+	if err := json.Unmarshal(*spec, &followUp); err != nil {
+		return fmt.Errorf("failed to decode follow-up task: %w", err)
+	}
+	req := &models.CreateTaskRequest{
+		ExecuteAt:   now.Add(time.Duration(followUp.Delay) * time.Second),
+		TaskType:    followUp.TaskType,
+		Queue:       followUp.Queue,
+		Payload:     followUp.Payload,
+		MaxAttempts: followUp.MaxAttempts,
+		Timeout:     followUp.Timeout,
+		Notify:      followUp.Notify,
+		OnSuccess:   followUp.OnSuccess,
+		OnFailure:   followUp.OnFailure,
+	}
+	if req.Queue == "" {
+		req.Queue = models.DefaultQueue
+	}
+	if req.MaxAttempts == 0 {
+		req.MaxAttempts = 3
+	}
+
+	child, err := s.create(req)
+	if err != nil {
+		return err
+	}
+	parentID := parent.ID
+	s.tasks[child.ID].ParentID = &parentID
+	return nil
+}
+
+// leaseDuration возвращает длительность выполнения арендованного задания от захвата до now (как leaseDurationMs)
+func leaseDuration(task *models.ScheduledTask, now time.Time) *int64 {
+	if !task.ClaimedAt.Valid {
+		return nil
+	}
+	ms := now.Sub(task.ClaimedAt.Time).Milliseconds()
+	return &ms
+}
+
+// leasedTask возвращает задание в 'processing' с указанным токеном аренды; вызывается под s.mu
+func (s *MemoryTaskStore) leasedTask(id int64, leaseToken string) *models.ScheduledTask {
+	task, ok := s.tasks[id]
+	if !ok || task.Status != "processing" || task.LeaseToken == nil || *task.LeaseToken != leaseToken {
+		return nil
+	}
+	return task
+}
+
+// rawResult копирует результат выполнения; пустой результат - nil (NULL в БД)
+func rawResult(result json.RawMessage) *json.RawMessage {
+	if len(result) == 0 {
+		return nil
+	}
+	copied := append(json.RawMessage(nil), result...)
+	return &copied
+}
+
+// ListTasks возвращает страницу заданий (новые первыми) и общее количество по фильтрам
+func (s *MemoryTaskStore) ListTasks(ctx context.Context, params models.ListTasksParams) ([]models.ScheduledTask, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := []models.ScheduledTask{}
+	for _, task := range s.tasks {
+		if !listMatch(task, params) {
+			continue
+		}
+		matched = append(matched, *task)
+	}
+
+	// Порядок как в listOrderBy; при равенстве - по ID, чтобы порядок был стабильным
+	sort.Slice(matched, func(i, j int) bool {
+		return listLess(&matched[i], &matched[j], params.Sort)
+	})
+
+	total := len(matched)
+	if params.Offset >= total {
+		return []models.ScheduledTask{}, total, nil
+	}
+	end := params.Offset + params.Limit
+	if end > total {
+		end = total
+	}
+
+	return matched[params.Offset:end], total, nil
+}
+
+// ExportTasks передает в fn все задания по фильтрам params (без пагинации) в порядке params.Sort.
+// fn вызывается на копиях заданий вне блокировки хранилища
+func (s *MemoryTaskStore) ExportTasks(ctx context.Context, params models.ListTasksParams, fn func(*models.ScheduledTask) error) error {
+	s.mu.Lock()
+	matched := []models.ScheduledTask{}
+	for _, task := range s.tasks {
+		if listMatch(task, params) {
+			matched = append(matched, *task)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return listLess(&matched[i], &matched[j], params.Sort)
+	})
+	for i := range matched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&matched[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listMatch проверяет задание по фильтрам списка, как listFilter в PostgresTaskStore
+func listMatch(task *models.ScheduledTask, params models.ListTasksParams) bool {
+	if params.Status != "" && task.Status != params.Status {
+		return false
+	}
+	if params.TaskType != "" && task.TaskType != params.TaskType {
+		return false
+	}
+	if params.Queue != "" && task.Queue != params.Queue {
+		return false
+	}
+	hasError := task.ErrorMessage.Valid && task.ErrorMessage.String != ""
+	if params.HasError != nil && *params.HasError != hasError {
+		return false
+	}
+	if params.ErrorContains != "" && !strings.Contains(strings.ToLower(task.ErrorMessage.String), strings.ToLower(params.ErrorContains)) {
+		return false
+	}
+	if params.FailureReason != "" && (task.FailureReason == nil || *task.FailureReason != params.FailureReason) {
+		return false
+	}
+	if params.MinDurationMs != nil && (task.DurationMs == nil || *task.DurationMs < *params.MinDurationMs) {
+		return false
+	}
+	if params.MaxDurationMs != nil && (task.DurationMs == nil || *task.DurationMs > *params.MaxDurationMs) {
+		return false
+	}
+	return true
+}
+
+// listLess сравнивает задания для порядка списка sort (models.ListSort*), как listOrderBy в PostgresTaskStore:
+// задания без duration_ms при сортировке по длительности идут в конце
+func listLess(a, b *models.ScheduledTask, sort string) bool {
+	switch sort {
+	case models.ListSortCreatedAsc:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	case models.ListSortDurationDesc, models.ListSortDurationAsc:
+		if (a.DurationMs == nil) != (b.DurationMs == nil) {
+			return b.DurationMs == nil
+		}
+		if a.DurationMs != nil && *a.DurationMs != *b.DurationMs {
+			if sort == models.ListSortDurationAsc {
+				return *a.DurationMs < *b.DurationMs
+			}
+			return *a.DurationMs > *b.DurationMs
+		}
+		if sort == models.ListSortDurationAsc {
+			return a.ID < b.ID
+		}
+		return a.ID > b.ID
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.ID > b.ID
+}
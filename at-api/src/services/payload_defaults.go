@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"at-api/models"
+)
+
+// ApplyPayloadDefaults подмешивает в payload запроса значения по умолчанию для его task_type
+// (Options.PayloadDefaults): поля из запроса побеждают, вложенные объекты сливаются рекурсивно.
+// Вызывается обработчиками до валидации payload, поэтому обязательное поле (например, queue у rabbitmq)
+// может прийти из значений по умолчанию. Payload, который не является JSON-объектом, не меняется.
+// Значения по умолчанию подмешиваются и в payload заданий цепочки (on_success, on_failure).
+func (s *TaskService) ApplyPayloadDefaults(req *models.CreateTaskRequest) error {
+	if err := s.applyPayloadDefaults(req.TaskType, &req.Payload); err != nil {
+		return err
+	}
+	return s.applyFollowUpPayloadDefaults(req.OnSuccess, req.OnFailure)
+}
+
+// applyFollowUpPayloadDefaults подмешивает значения по умолчанию в payload заданий цепочки рекурсивно
+func (s *TaskService) applyFollowUpPayloadDefaults(tasks ...*models.FollowUpTask) error {
+	for _, task := range tasks {
+		if task == nil {
+			continue
+		}
+		if err := s.applyPayloadDefaults(task.TaskType, &task.Payload); err != nil {
+			return err
+		}
+		if err := s.applyFollowUpPayloadDefaults(task.OnSuccess, task.OnFailure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPayloadDefaults подмешивает в payload значения по умолчанию для taskType, если они заданы
+func (s *TaskService) applyPayloadDefaults(taskType string, payload *json.RawMessage) error {
+	defaults, ok := s.payloadDefaults[taskType]
+	if !ok {
+		return nil
+	}
+	merged, err := mergePayloadDefaults(defaults, *payload)
+	if err != nil {
+		return err
+	}
+	*payload = merged
+	return nil
+}
+
+// mergePayloadDefaults сливает payload с объектом defaults. Числа декодируются как json.Number,
+// чтобы большие целые не теряли точность при повторной сериализации
+func mergePayloadDefaults(defaults, payload json.RawMessage) (json.RawMessage, error) {
+	var base map[string]interface{}
+	if err := decodeJSONNumbers(defaults, &base); err != nil {
+		return nil, fmt.Errorf("invalid payload defaults: %w", err)
+	}
+
+	// Без payload задание получает значения по умолчанию целиком
+	if len(bytes.TrimSpace(payload)) == 0 || bytes.Equal(bytes.TrimSpace(payload), []byte("null")) {
+		return defaults, nil
+	}
+
+	var submitted interface{}
+	if err := decodeJSONNumbers(payload, &submitted); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	object, ok := submitted.(map[string]interface{})
+	if !ok {
+		return payload, nil
+	}
+
+	mergeObjects(object, base)
+	return json.Marshal(object)
+}
+
+// mergeObjects добавляет в dst отсутствующие в нем поля src; если поле - объект в обоих, сливает рекурсивно
+func mergeObjects(dst, src map[string]interface{}) {
+	for key, value := range src {
+		existing, ok := dst[key]
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		dstObject, dstIsObject := existing.(map[string]interface{})
+		srcObject, srcIsObject := value.(map[string]interface{})
+		if dstIsObject && srcIsObject {
+			mergeObjects(dstObject, srcObject)
+		}
+	}
+}
+
+// decodeJSONNumbers декодирует JSON, сохраняя числа как json.Number
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"at-api/models"
+)
+
+// TestApplyPayloadDefaults проверяет слияние payload с значениями по умолчанию типа задания
+func TestApplyPayloadDefaults(t *testing.T) {
+	service := NewTaskService(NewMemoryTaskStore(), Options{
+		PayloadDefaults: map[string]json.RawMessage{
+			"http_callback": json.RawMessage(`{"url": "https://partner.example.com/hook", "headers": {"X-Source": "at", "X-Env": "prod"}, "id": 0}`),
+		},
+	})
+
+	testCases := []struct {
+		name     string
+		taskType string
+		payload  string
+		want     string
+	}{
+		{"submitted fields win", "http_callback", `{"url": "https://other.example.com", "id": 12345678901234567890}`,
+			`{"headers":{"X-Env":"prod","X-Source":"at"},"id":12345678901234567890,"url":"https://other.example.com"}`},
+		{"nested objects merged", "http_callback", `{"headers": {"X-Env": "staging", "X-Trace": "1"}}`,
+			`{"headers":{"X-Env":"staging","X-Source":"at","X-Trace":"1"},"id":0,"url":"https://partner.example.com/hook"}`},
+		{"scalar replaces object", "http_callback", `{"headers": null}`,
+			`{"headers":null,"id":0,"url":"https://partner.example.com/hook"}`},
+		{"empty payload", "http_callback", ``,
+			`{"url": "https://partner.example.com/hook", "headers": {"X-Source": "at", "X-Env": "prod"}, "id": 0}`},
+		{"non-object payload unchanged", "http_callback", `[1, 2]`, `[1, 2]`},
+		{"type without defaults", "email", `{"to": "a@example.com"}`, `{"to": "a@example.com"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &models.CreateTaskRequest{TaskType: tc.taskType, Payload: json.RawMessage(tc.payload)}
+			if err := service.ApplyPayloadDefaults(req); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(req.Payload) != tc.want {
+				t.Errorf("Payload:\n got=%s\nwant=%s", req.Payload, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"at-api/models"
+)
+
+// PayloadStore - внешнее хранилище больших payload (blobstore.S3Store в проде)
+type PayloadStore interface {
+	// Put сохраняет payload и возвращает ссылку на него (s3://bucket/key)
+	Put(ctx context.Context, payload []byte) (string, error)
+}
+
+// emptyPayload - значение колонки payload у задания, payload которого вынесен во внешнее хранилище
+var emptyPayload = json.RawMessage(`{}`)
+
+// offloadPayload выносит payload больше payloadRefThreshold байт во внешнее хранилище:
+// в req остаются ссылка (PayloadRef) и пустой объект вместо payload, поэтому строка задания
+// и запрос опроса worker'ов не тащат за собой большие данные. Без хранилища ничего не делает.
+// Вызывается после проверок запроса, чтобы не загружать payload заданий, которые не будут созданы.
+func (s *TaskService) offloadPayload(ctx context.Context, req *models.CreateTaskRequest) error {
+	if s.payloadStore == nil || len(req.Payload) <= s.payloadRefThreshold {
+		return nil
+	}
+
+	ref, err := s.payloadStore.Put(ctx, req.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to store payload: %w", err)
+	}
+	req.PayloadRef = ref
+	req.Payload = emptyPayload
+	return nil
+}
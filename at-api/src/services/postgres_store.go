@@ -0,0 +1,1024 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"at-api/models"
+
+	"github.com/lib/pq"
+)
+
+// taskColumns - список колонок scheduled_tasks в порядке, ожидаемом scanTask
+const taskColumns = `id, execute_at, task_type, queue, payload, status, attempts, max_attempts,
+	error_message, result, created_at, updated_at, completed_at, claimed_at, dedup_key, timeout_seconds,
+	lease_token, locked_until, warning, result_ttl_seconds, scrubbed_at, notify,
+	skip_if_late_seconds, progress, interval_ms, max_executions, end_at, executions, failure_reason, payload_ref, align,
+	parent_id, on_success, on_failure, duration_ms, priority, response_content_type, response_body_base64,
+	cancel_reason, cron_expression`
+
+// rowScanner - общий интерфейс для *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTask читает строку с колонками taskColumns в структуру ScheduledTask
+func scanTask(row rowScanner, task *models.ScheduledTask) error {
+	return row.Scan(
+		&task.ID,
+		&task.ExecuteAt,
+		&task.TaskType,
+		&task.Queue,
+		&task.Payload,
+		&task.Status,
+		&task.Attempts,
+		&task.MaxAttempts,
+		&task.ErrorMessage,
+		&task.Result,
+		&task.CreatedAt,
+		&task.UpdatedAt,
+		&task.CompletedAt,
+		&task.ClaimedAt,
+		&task.DedupKey,
+		&task.Timeout,
+		&task.LeaseToken,
+		&task.LockedUntil,
+		&task.Warning,
+		&task.ResultTTL,
+		&task.ScrubbedAt,
+		&task.Notify,
+		&task.SkipIfLate,
+		&task.Progress,
+		&task.Interval,
+		&task.MaxExecutions,
+		&task.EndAt,
+		&task.Executions,
+		&task.FailureReason,
+		&task.PayloadRef,
+		&task.Align,
+		&task.ParentID,
+		&task.OnSuccess,
+		&task.OnFailure,
+		&task.DurationMs,
+		&task.Priority,
+		&task.ResponseType,
+		&task.ResponseBody,
+		&task.CancelReason,
+		&task.CronExpression,
+	)
+}
+
+// PostgresTaskStore хранит задания в таблице scheduled_tasks PostgreSQL
+type PostgresTaskStore struct {
+	db timedDB
+}
+
+// PostgresStoreOptions содержит настройки PostgresTaskStore
+type PostgresStoreOptions struct {
+	// SlowQueryThreshold - запросы дольше порога пишутся в лог с текстом и длительностью (см. slowQueryLog).
+	// 0 - лог медленных запросов выключен
+	SlowQueryThreshold time.Duration
+}
+
+// NewPostgresTaskStore создает хранилище заданий поверх пула подключений.
+// Параметры:
+//   - db: указатель на пул подключений к базе данных
+//   - opts: порог лога медленных запросов
+func NewPostgresTaskStore(db *sql.DB, opts PostgresStoreOptions) *PostgresTaskStore {
+	return &PostgresTaskStore{db: timedDB{DB: db, slow: newSlowQueryLog(opts.SlowQueryThreshold)}}
+}
+
+// uniqueViolation - код ошибки PostgreSQL для нарушения уникального индекса
+const uniqueViolation = "23505"
+
+// insertTaskColumns - колонки, которые задаются при создании задания; остальные получают значения по умолчанию
+const insertTaskColumns = `execute_at, task_type, queue, payload, max_attempts, dedup_key, timeout_seconds,
+	result_ttl_seconds, notify, skip_if_late_seconds, interval_ms, max_executions, end_at, payload_ref, align,
+	on_success, on_failure, priority, cron_expression`
+
+// insertTaskParams - число параметров запроса на одно задание (см. insertTaskValues)
+const insertTaskParams = 19
+
+// maxQueryParams - максимальное число параметров одного запроса в протоколе PostgreSQL
+const maxQueryParams = 65535
+
+// insertTaskValues возвращает строку VALUES для одного задания с плейсхолдерами, начиная с $(offset+1).
+// Пустые необязательные поля записываются как NULL.
+func insertTaskValues(offset int) string {
+	p := make([]interface{}, insertTaskParams)
+	for i := range p {
+		p[i] = offset + i + 1
+	}
+	return fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, NULLIF($%d, ''), NULLIF($%d, 0), NULLIF($%d, 0), $%d, NULLIF($%d, 0), NULLIF($%d, 0), NULLIF($%d, 0), $%d, NULLIF($%d, ''), NULLIF($%d, ''), $%d, $%d, $%d, NULLIF($%d, ''))", p...)
+}
+
+// insertTaskArgs возвращает параметры задания в порядке insertTaskColumns
+func insertTaskArgs(req *models.CreateTaskRequest) ([]interface{}, error) {
+	notify, err := notifyJSON(req.Notify)
+	if err != nil {
+		return nil, err
+	}
+	onSuccess, err := followUpJSON(req.OnSuccess)
+	if err != nil {
+		return nil, err
+	}
+	onFailure, err := followUpJSON(req.OnFailure)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{
+		req.ExecuteAt,
+		req.TaskType,
+		req.Queue,
+		req.Payload,
+		req.MaxAttempts,
+		req.DedupKey,
+		req.Timeout,
+		req.ResultTTL,
+		notify,
+		req.SkipIfLate,
+		req.IntervalMillis(),
+		req.MaxExecutions,
+		req.EndAt,
+		req.PayloadRef,
+		req.Align,
+		onSuccess,
+		onFailure,
+		req.Priority,
+		req.CronExpression,
+	}, nil
+}
+
+// isDuplicateDedupKey проверяет, что ошибка - нарушение уникальности активного dedup_key
+func isDuplicateDedupKey(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == uniqueViolation && pqErr.Constraint == "idx_active_dedup_key"
+}
+
+// CreateTask вставляет новое задание и возвращает его со всеми полями из БД.
+// Нарушение idx_active_dedup_key превращается в ErrDuplicateTask.
+func (s *PostgresTaskStore) CreateTask(ctx context.Context, req *models.CreateTaskRequest) (*models.ScheduledTask, error) {
+	query := `INSERT INTO scheduled_tasks (` + insertTaskColumns + `)
+		VALUES ` + insertTaskValues(0) + `
+		RETURNING ` + taskColumns
+
+	args, err := insertTaskArgs(req)
+	if err != nil {
+		return nil, err
+	}
+
+	task := &models.ScheduledTask{}
+	err = scanTask(s.db.QueryRowContext(ctx, query, args...), task)
+
+	if isDuplicateDedupKey(err) {
+		return nil, ErrDuplicateTask
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create task: %w", err)
+	}
+
+	return task, nil
+}
+
+// UpsertTaskByKey создает или заменяет задание с ключом req.DedupKey одним запросом: UPDATE задания с ключом
+// в 'pending' или 'hold' (статус сохраняется, счетчики и результаты прошлых выполнений сбрасываются),
+// а если такого нет - INSERT. INSERT ... ON CONFLICT здесь не подходит: в секционированной
+// таблице (sql/partitioning) уникальный индекс активных ключей есть только у секции, и ON CONFLICT его не найдет.
+// Если задание с ключом создал параллельный запрос между UPDATE и INSERT, уникальный индекс отклонит вставку,
+// и повторный проход обновит это задание; если и он не удался, задание с ключом выполняется (ErrTaskInProgress)
+func (s *PostgresTaskStore) UpsertTaskByKey(ctx context.Context, req *models.CreateTaskRequest) (*models.ScheduledTask, bool, error) {
+	query := `
+		WITH updated AS (
+			UPDATE scheduled_tasks
+			SET (` + insertTaskColumns + `) = ` + insertTaskValues(0) + `,
+			    attempts = 0, executions = 0, error_message = NULL, result = NULL, warning = NULL,
+			    failure_reason = NULL, duration_ms = NULL, progress = NULL, completed_at = NULL, scrubbed_at = NULL,
+			    response_content_type = NULL, response_body_base64 = NULL
+			WHERE dedup_key = $6 AND status IN ('pending', 'hold')
+			RETURNING ` + taskColumns + `
+		), inserted AS (
+			INSERT INTO scheduled_tasks (` + insertTaskColumns + `)
+			SELECT * FROM (VALUES ` + insertTaskValues(0) + `) v
+			WHERE NOT EXISTS (SELECT 1 FROM updated)
+			RETURNING ` + taskColumns + `
+		)
+		SELECT *, false FROM updated
+		UNION ALL
+		SELECT *, true FROM inserted`
+
+	args, err := insertTaskArgs(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		task := &models.ScheduledTask{}
+		var created bool
+		err = scanTask(extraColumn{s.db.QueryRowContext(ctx, query, args...), &created}, task)
+		if isDuplicateDedupKey(err) {
+			if attempt == 0 {
+				continue
+			}
+			return nil, false, ErrTaskInProgress
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to upsert task: %w", err)
+		}
+		return task, created, nil
+	}
+}
+
+// extraColumn читает строку taskColumns с еще одной колонкой в конце (в dest)
+type extraColumn struct {
+	row  rowScanner
+	dest interface{}
+}
+
+func (e extraColumn) Scan(dest ...interface{}) error {
+	return e.row.Scan(append(dest, e.dest)...)
+}
+
+// CreateTasks вставляет задания одной транзакцией многострочными INSERT по chunkSize заданий.
+// Размер части ограничен лимитом параметров запроса PostgreSQL (65535), поэтому батч любого
+// размера вставляется без ошибки "too many parameters". Ход вставки пишется в лог по частям.
+func (s *PostgresTaskStore) CreateTasks(ctx context.Context, reqs []models.CreateTaskRequest, chunkSize int) ([]*models.ScheduledTask, error) {
+	if maxChunk := maxQueryParams / insertTaskParams; chunkSize <= 0 || chunkSize > maxChunk {
+		chunkSize = maxChunk
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tasks := make([]*models.ScheduledTask, 0, len(reqs))
+	for start := 0; start < len(reqs); start += chunkSize {
+		chunk := reqs[start:min(start+chunkSize, len(reqs))]
+		created, err := insertTaskChunk(ctx, timedTx{Tx: tx, slow: s.db.slow}, chunk)
+		if isDuplicateDedupKey(err) {
+			return nil, ErrDuplicateTask
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tasks %d-%d: %w", start, start+len(chunk)-1, err)
+		}
+		tasks = append(tasks, created...)
+		log.Printf("Batch create: inserted %d/%d tasks", len(tasks), len(reqs))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tasks: %w", err)
+	}
+	return tasks, nil
+}
+
+// insertTaskChunk вставляет часть батча одним INSERT и возвращает задания в порядке chunk.
+// Порядок строк RETURNING не гарантирован, но id выдаются последовательностью в порядке VALUES,
+// поэтому задания упорядочиваются по id.
+func insertTaskChunk(ctx context.Context, tx timedTx, chunk []models.CreateTaskRequest) ([]*models.ScheduledTask, error) {
+	values := make([]string, len(chunk))
+	args := make([]interface{}, 0, len(chunk)*insertTaskParams)
+	for i := range chunk {
+		taskArgs, err := insertTaskArgs(&chunk[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = insertTaskValues(len(args))
+		args = append(args, taskArgs...)
+	}
+
+	query := `INSERT INTO scheduled_tasks (` + insertTaskColumns + `)
+		VALUES ` + strings.Join(values, ",\n\t\t") + `
+		RETURNING ` + taskColumns
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]*models.ScheduledTask, 0, len(chunk))
+	for rows.Next() {
+		task := &models.ScheduledTask{}
+		if err := scanTask(rows, task); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks, nil
+}
+
+// notifyJSON возвращает значение колонки notify: JSON массив каналов или NULL, если каналов нет
+func notifyJSON(channels []models.NotifyChannel) (interface{}, error) {
+	if len(channels) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(channels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notify: %w", err)
+	}
+	return data, nil
+}
+
+// followUpJSON сериализует задание цепочки для колонок on_success / on_failure; nil - задания нет
+func followUpJSON(task *models.FollowUpTask) (interface{}, error) {
+	if task == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode follow-up task: %w", err)
+	}
+	return data, nil
+}
+
+// GetTaskByDedupKey получает задание по dedup_key: активное, а если его нет - последнее созданное
+func (s *PostgresTaskStore) GetTaskByDedupKey(ctx context.Context, key string) (*models.ScheduledTask, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM scheduled_tasks
+		WHERE dedup_key = $1
+		ORDER BY status IN ('pending', 'processing', 'hold') DESC, created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, key), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task by dedup key: %w", err)
+	}
+
+	return task, nil
+}
+
+// GetRecentTaskByDedupKey получает последнее задание по dedup_key, созданное за последние window
+func (s *PostgresTaskStore) GetRecentTaskByDedupKey(ctx context.Context, key string, window time.Duration) (*models.ScheduledTask, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM scheduled_tasks
+		WHERE dedup_key = $1 AND created_at > NOW() - INTERVAL '1 second' * $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+	`
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, key, int(window.Seconds())), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent task by dedup key: %w", err)
+	}
+
+	return task, nil
+}
+
+// GetActiveTaskByDedupKey получает активное задание по dedup_key
+func (s *PostgresTaskStore) GetActiveTaskByDedupKey(ctx context.Context, key string) (*models.ScheduledTask, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM scheduled_tasks
+		WHERE dedup_key = $1 AND status IN ('pending', 'processing', 'hold')
+	`
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, key), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task by dedup key: %w", err)
+	}
+
+	return task, nil
+}
+
+// GetTask получает задание по его ID
+func (s *PostgresTaskStore) GetTask(ctx context.Context, id int64) (*models.ScheduledTask, error) {
+	query := `
+		SELECT ` + taskColumns + `
+		FROM scheduled_tasks
+		WHERE id = $1
+	`
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, id), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+
+	return task, nil
+}
+
+// GetTaskHistory возвращает статус задания и историю state_transitions, которую ведет триггер
+// record_state_transition; у заданий, созданных до его появления, история может быть пустой
+func (s *PostgresTaskStore) GetTaskHistory(ctx context.Context, id int64) (*models.TaskHistory, error) {
+	query := `
+		SELECT status, COALESCE(state_transitions, '[]'::jsonb)
+		FROM scheduled_tasks
+		WHERE id = $1
+	`
+
+	history := &models.TaskHistory{TaskID: id}
+	var transitions []byte
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&history.Status, &transitions)
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task history: %w", err)
+	}
+	if err := json.Unmarshal(transitions, &history.Transitions); err != nil {
+		return nil, fmt.Errorf("invalid state_transitions of task %d: %w", id, err)
+	}
+
+	return history, nil
+}
+
+// CancelTask переводит задание в статус 'cancelled', если оно еще не завершено.
+// Задание, ожидающее повторной попытки (pending с execute_at в будущем), отменяется так же:
+// completed_at фиксирует момент отмены, и worker больше его не возьмет. Пустая причина пишется как NULL.
+func (s *PostgresTaskStore) CancelTask(ctx context.Context, id int64, reason string) (*models.ScheduledTask, error) {
+	query := `
+		UPDATE scheduled_tasks
+		SET status = 'cancelled',
+		    completed_at = NOW(),
+		    cancel_reason = NULLIF($2, '')
+		WHERE id = $1 AND status IN ('pending', 'processing', 'hold')
+		RETURNING ` + taskColumns
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, id, reason), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel task: %w", err)
+	}
+
+	return task, nil
+}
+
+// TransitionStatus меняет статус задания с from на to, если задание все еще в статусе from
+func (s *PostgresTaskStore) TransitionStatus(ctx context.Context, id int64, from, to string) (*models.ScheduledTask, error) {
+	query := `
+		UPDATE scheduled_tasks
+		SET status = $3
+		WHERE id = $1 AND status = $2
+		RETURNING ` + taskColumns
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, id, from, to), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to change task status: %w", err)
+	}
+
+	return task, nil
+}
+
+// RequeueTask возвращает задание из 'failed' в 'pending' с новым лимитом попыток.
+// Сбрасывается только completed_at: attempts и error_message сохраняются как история.
+// Условие в WHERE защищает от гонки с параллельным изменением задания.
+func (s *PostgresTaskStore) RequeueTask(ctx context.Context, id int64, maxAttempts int) (*models.ScheduledTask, error) {
+	query := `
+		UPDATE scheduled_tasks
+		SET status = 'pending',
+		    max_attempts = $2,
+		    completed_at = NULL
+		WHERE id = $1 AND status = 'failed' AND attempts < $2
+		RETURNING ` + taskColumns
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, id, maxAttempts), task)
+
+	if isDuplicateDedupKey(err) {
+		return nil, ErrDuplicateTask
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue task: %w", err)
+	}
+
+	return task, nil
+}
+
+// RequeueFailedTasks возвращает в 'pending' до limit упавших заданий по фильтрам списка (см. TaskStore).
+// Номер задания в порядке completed_at задает его долю окна: execute_at = NOW() + n * spread / total.
+// Активное задание с тем же dedup_key проверяется заранее, чтобы одно такое задание не сорвало весь перезапуск
+// ошибкой уникального индекса; повторная проверка status = 'failed' в UPDATE исключает задания,
+// которые успели перезапустить или удалить параллельно
+func (s *PostgresTaskStore) RequeueFailedTasks(ctx context.Context, params models.ListTasksParams, limit int, spread time.Duration, maxAttemptsLimit int) (int, error) {
+	where, args := listFilter(params)
+	if maxAttemptsLimit > 0 {
+		args = append(args, maxAttemptsLimit)
+		where += fmt.Sprintf(" AND attempts < $%d", len(args))
+	}
+	args = append(args, limit, spread.Milliseconds())
+
+	query := fmt.Sprintf(`
+		WITH candidates AS (
+			SELECT id, completed_at FROM (
+				SELECT id, completed_at, dedup_key,
+				       ROW_NUMBER() OVER (PARTITION BY dedup_key ORDER BY id DESC) AS key_rank
+				FROM scheduled_tasks t
+				WHERE 1=1%s
+				  AND (dedup_key IS NULL OR NOT EXISTS (
+				      SELECT 1 FROM scheduled_tasks a
+				      WHERE a.dedup_key = t.dedup_key AND a.status IN ('pending', 'processing', 'hold')))
+			) failed
+			WHERE dedup_key IS NULL OR key_rank = 1
+			ORDER BY completed_at, id
+			LIMIT $%d
+		), numbered AS (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY completed_at, id) - 1 AS n, COUNT(*) OVER () AS total
+			FROM candidates
+		)
+		UPDATE scheduled_tasks s
+		SET status = 'pending',
+		    max_attempts = s.attempts + 1,
+		    completed_at = NULL,
+		    execute_at = NOW() + numbered.n * $%d / numbered.total * INTERVAL '1 millisecond'
+		FROM numbered
+		WHERE s.id = numbered.id AND s.status = 'failed'
+	`, where, len(args)-1, len(args))
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if isDuplicateDedupKey(err) {
+		return 0, ErrDuplicateTask
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue tasks: %w", err)
+	}
+	requeued, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue tasks: %w", err)
+	}
+	return int(requeued), nil
+}
+
+// claimOrder - порядок захвата заданий: эффективный приоритет по убыванию (SQL-версия
+// models.ScheduledTask.EffectivePriority), при равном - по execute_at и id (см. claimLess)
+const claimOrder = "priority + EXTRACT(EPOCH FROM (NOW() - execute_at)) / 60 DESC, execute_at, id"
+
+// claimScanFactor - во сколько раз больше req.Limit ближайших по execute_at заданий просматривается
+// при захвате: сортировка по claimOrder не использует индекс, поэтому применяется только внутри этого окна
+const claimScanFactor = 10
+
+// ClaimTasks захватывает до req.Limit готовых к выполнению заданий для внешнего клиента.
+// Задания переводятся в 'processing' так же, как при захвате worker'ом, и получают аренду:
+// lease_token (leaseToken + ID задания) и locked_until = NOW() + lease.
+// FOR UPDATE SKIP LOCKED не дает двум клиентам (или клиенту и worker'у) захватить одно задание.
+func (s *PostgresTaskStore) ClaimTasks(ctx context.Context, req *models.ClaimTasksRequest, leaseToken string, lease time.Duration) ([]*models.ScheduledTask, error) {
+	query, args := claimTasksQuery(req, leaseToken, lease)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim tasks: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := []*models.ScheduledTask{}
+	for rows.Next() {
+		task := &models.ScheduledTask{}
+		if err := scanTask(rows, task); err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating claimed tasks: %w", err)
+	}
+
+	// RETURNING не гарантирует порядок - отдаем задания в порядке захвата. Старение одинаково для всех
+	// заданий, поэтому порядок по эффективному приоритету не зависит от момента сравнения
+	now := time.Now()
+	sort.Slice(tasks, func(i, j int) bool {
+		return claimLess(tasks[i], tasks[j], now)
+	})
+
+	return tasks, nil
+}
+
+// claimTasksQuery возвращает запрос захвата заданий внешним клиентом и его аргументы.
+// Кандидаты выбираются по execute_at (индекс idx_pending_tasks) в окне из req.Limit*claimScanFactor
+// ближайших заданий, и только внутри окна сортируются по эффективному приоритету - иначе каждый claim
+// сортировал бы все наступившие задания. Так же выбирает задания worker (at-worker, claimQuery)
+func claimTasksQuery(req *models.ClaimTasksRequest, leaseToken string, lease time.Duration) (string, []interface{}) {
+	conditions := []string{"status = 'pending'", "execute_at <= NOW()"}
+	args := []interface{}{req.Limit, leaseToken, int(lease.Seconds())}
+
+	if req.Queue != "" {
+		args = append(args, req.Queue)
+		conditions = append(conditions, fmt.Sprintf("queue = $%d", len(args)))
+	}
+	if len(req.TaskTypes) > 0 {
+		args = append(args, pq.Array(req.TaskTypes))
+		conditions = append(conditions, fmt.Sprintf("task_type = ANY($%d)", len(args)))
+	}
+	args = append(args, req.Limit*claimScanFactor)
+	window := fmt.Sprintf("$%d", len(args))
+
+	query := `
+		WITH nearest AS (
+			SELECT id
+			FROM scheduled_tasks
+			WHERE ` + strings.Join(conditions, " AND ") + `
+			ORDER BY execute_at
+			LIMIT ` + window + `
+		), claimable AS (
+			SELECT id AS claim_id
+			FROM scheduled_tasks
+			WHERE id IN (SELECT id FROM nearest)
+			  AND status = 'pending'
+			ORDER BY ` + claimOrder + `
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE scheduled_tasks
+		SET status = 'processing',
+		    attempts = attempts + 1,
+		    claimed_at = NOW(),
+		    progress = NULL,
+		    lease_token = $2::text || '-' || id,
+		    locked_until = NOW() + INTERVAL '1 second' * $3
+		FROM claimable
+		WHERE id = claimable.claim_id
+		RETURNING ` + taskColumns
+	return query, args
+}
+
+// nextRunQuery возвращает (run_id, next_at) задания $1: время следующего выполнения повторяющегося задания
+// или NULL, если задание не повторяющееся или серия закончилась (SQL-версия models.ScheduledTask.NextRun).
+// С align момент привязывается к ближайшей границе от Unix epoch (UTC), а если она не позже NOW() - к следующей.
+// Следующий момент cron_expression в SQL не вычислить - его передает параметр $5 (см. cronNextRun)
+const nextRunQuery = `
+	SELECT id AS run_id, CASE WHEN run_at > end_at THEN NULL ELSE run_at END AS next_at
+	FROM (
+		SELECT id, end_at,
+		       CASE WHEN snapped IS NULL THEN raw_at
+		            WHEN snapped <= NOW() THEN snapped + INTERVAL '1 second' * align_seconds
+		            ELSE snapped
+		       END AS run_at
+		FROM (
+			SELECT id, end_at, raw_at, align_seconds,
+			       to_timestamp(FLOOR(EXTRACT(EPOCH FROM raw_at) / align_seconds + 0.5) * align_seconds) AS snapped
+			FROM (
+				SELECT id, end_at,
+				       CASE align WHEN 'minute' THEN 60 WHEN 'hour' THEN 3600 WHEN 'day' THEN 86400 END AS align_seconds,
+				       CASE WHEN executions + 1 >= max_executions THEN NULL
+				            WHEN cron_expression IS NOT NULL THEN $5::timestamptz
+				            WHEN interval_ms IS NULL THEN NULL
+				            ELSE execute_at + INTERVAL '1 millisecond' * interval_ms *
+				                 GREATEST(1, FLOOR(EXTRACT(EPOCH FROM NOW() - execute_at) * 1000 / interval_ms) + 1)
+				       END AS raw_at
+				FROM scheduled_tasks
+				WHERE id = $1
+			) raw
+		) aligned
+	) runs`
+
+// leaseDurationMs - длительность выполнения арендованного задания для колонки duration_ms:
+// от захвата (claimed_at) до отчета клиента, включая сетевые задержки клиента
+const leaseDurationMs = `(EXTRACT(EPOCH FROM NOW() - scheduled_tasks.claimed_at) * 1000)::bigint`
+
+// ExtendLease продлевает аренду задания с этим токеном. Аренда, срок которой прошел, но которую Cleaner еще
+// не вернул в очередь, тоже продлевается: задание все это время оставалось за клиентом
+func (s *PostgresTaskStore) ExtendLease(ctx context.Context, id int64, leaseToken string, lease time.Duration) (*models.ScheduledTask, error) {
+	query := `
+		UPDATE scheduled_tasks
+		SET locked_until = NOW() + INTERVAL '1 second' * $3
+		WHERE id = $1 AND status = 'processing' AND lease_token = $2
+		RETURNING ` + taskColumns
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, id, leaseToken, int(lease.Seconds())), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to extend lease: %w", err)
+	}
+
+	return task, nil
+}
+
+// cronNextRun возвращает следующий после текущего момент cron_expression задания (параметр $5 nextRunQuery)
+// или nil, если у задания нет cron_expression или расписание больше не срабатывает. Выражение читается
+// до UPDATE: у задания в 'processing' определение не меняется (замена по ключу отклоняет такие задания)
+func (s *PostgresTaskStore) cronNextRun(ctx context.Context, id int64) (interface{}, error) {
+	var expr sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT cron_expression FROM scheduled_tasks WHERE id = $1`, id).Scan(&expr)
+	if err == sql.ErrNoRows || err == nil && !expr.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Выражение проверено при создании; некорректное (записанное в обход API) завершает серию
+	schedule, err := models.ParseCron(expr.String)
+	if err != nil {
+		return nil, nil
+	}
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		return next, nil
+	}
+	return nil, nil
+}
+
+// chainedTaskQuery - CTE chained запроса finishLeaseQuery: создает задание из on_success задания,
+// перешедшего в completed, или из on_failure - перешедшего в failed. Новое задание получает parent_id,
+// execute_at = NOW() + delay_seconds и вложенные on_success / on_failure. Тот же CTE в at-worker (chainedTaskQuery):
+// задание цепочки создается одинаково, кто бы ни выполнил родителя - worker или внешний клиент
+const chainedTaskQuery = `
+	chained AS (
+		INSERT INTO scheduled_tasks (execute_at, task_type, queue, payload, max_attempts, timeout_seconds, notify,
+		                             parent_id, on_success, on_failure)
+		SELECT NOW() + INTERVAL '1 second' * COALESCE((spec->>'delay_seconds')::int, 0),
+		       spec->>'task_type', COALESCE(spec->>'queue', 'default'), spec->'payload',
+		       COALESCE((spec->>'max_attempts')::int, 3), NULLIF((spec->>'timeout_seconds')::int, 0), spec->'notify',
+		       parent_id, spec->'on_success', spec->'on_failure'
+		FROM (
+			SELECT id AS parent_id,
+			       CASE status WHEN 'completed' THEN on_success WHEN 'failed' THEN on_failure END AS spec
+			FROM done
+		) parent
+		WHERE jsonb_typeof(spec) = 'object'
+		RETURNING id
+	)`
+
+// finishLeaseQuery оформляет итоговый UPDATE арендованного задания (RETURNING taskColumns) как CTE done
+// и добавляет в тот же запрос побочные эффекты завершения, как finishQuery в at-worker: задание цепочки
+// (chainedTaskQuery) и по записи notification_outbox на каждый канал notify задания, перешедшего в completed
+// или failed. Уведомления доставляют worker'ы с включенным outbox (WORKER_NOTIFY_MAX_ATTEMPTS > 0);
+// событие совпадает с notifyEvent worker'а
+func finishLeaseQuery(update string) string {
+	return `
+		WITH done AS (` + update + `
+		),` + chainedTaskQuery + `,
+		outbox AS (
+			INSERT INTO notification_outbox (task_id, channel_type, target, event)
+			SELECT done.id, ch->>'type', ch->>'target',
+			       jsonb_strip_nulls(jsonb_build_object(
+			           'task_id', done.id, 'task_type', done.task_type, 'status', done.status,
+			           'error_message', CASE WHEN done.status = 'failed' THEN NULLIF(done.error_message, '') END,
+			           'warning', NULLIF(done.warning, '')))
+			FROM done, jsonb_array_elements(CASE WHEN jsonb_typeof(done.notify) = 'array' THEN done.notify ELSE '[]' END) ch
+			WHERE done.status IN ('completed', 'failed')
+		)
+		SELECT ` + taskColumns + ` FROM done`
+}
+
+// CompleteLeasedTask переводит арендованное задание в 'completed', если аренда с этим токеном еще действует.
+// Повторяющееся задание с незакончившейся серией возвращается в 'pending' со следующим execute_at.
+// Завершенное задание создает задание цепочки из on_success и уведомления notify (см. finishLeaseQuery)
+func (s *PostgresTaskStore) CompleteLeasedTask(ctx context.Context, id int64, leaseToken, warning string, result json.RawMessage) (*models.ScheduledTask, error) {
+	cronNext, err := s.cronNextRun(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete task: %w", err)
+	}
+
+	query := finishLeaseQuery(`
+		UPDATE scheduled_tasks
+		SET status = CASE WHEN next_run.next_at IS NULL THEN 'completed' ELSE 'pending' END,
+		    completed_at = CASE WHEN next_run.next_at IS NULL THEN NOW() END,
+		    execute_at = COALESCE(next_run.next_at, scheduled_tasks.execute_at),
+		    attempts = CASE WHEN next_run.next_at IS NULL THEN scheduled_tasks.attempts ELSE 0 END,
+		    executions = scheduled_tasks.executions + 1,
+		    result = $3,
+		    warning = NULLIF($4, ''),
+		    failure_reason = NULL,
+		    duration_ms = ` + leaseDurationMs + `,
+		    lease_token = NULL,
+		    locked_until = NULL
+		FROM (` + nextRunQuery + `) next_run
+		WHERE id = next_run.run_id AND status = 'processing' AND lease_token = $2
+		RETURNING ` + taskColumns)
+
+	task := &models.ScheduledTask{}
+	err = scanTask(s.db.QueryRowContext(ctx, query, id, leaseToken, nullableJSON(result), warning, cronNext), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete task: %w", err)
+	}
+
+	return task, nil
+}
+
+// FailLeasedTask фиксирует неудачную попытку арендованного задания: 'pending' для повтора
+// или 'failed', если попытки исчерпаны (как при ошибке у worker'а). Окончательно упавшее задание
+// создает задание цепочки из on_failure и уведомления notify (см. finishLeaseQuery)
+func (s *PostgresTaskStore) FailLeasedTask(ctx context.Context, id int64, leaseToken, errorMessage, failureReason string, result json.RawMessage) (*models.ScheduledTask, error) {
+	query := finishLeaseQuery(`
+		UPDATE scheduled_tasks
+		SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+		    completed_at = CASE WHEN attempts >= max_attempts THEN NOW() END,
+		    error_message = $3,
+		    result = $4,
+		    failure_reason = $5,
+		    duration_ms = ` + leaseDurationMs + `,
+		    lease_token = NULL,
+		    locked_until = NULL
+		WHERE id = $1 AND status = 'processing' AND lease_token = $2
+		RETURNING ` + taskColumns)
+
+	task := &models.ScheduledTask{}
+	err := scanTask(s.db.QueryRowContext(ctx, query, id, leaseToken, errorMessage, nullableJSON(result), failureReason), task)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fail task: %w", err)
+	}
+
+	return task, nil
+}
+
+// nullableJSON возвращает значение для JSONB колонки: NULL, если данных нет
+func nullableJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
+
+// listOrderBy возвращает ORDER BY для порядка списка sort (models.ListSort*); id - для стабильного порядка
+func listOrderBy(sort string) string {
+	switch sort {
+	case models.ListSortCreatedAsc:
+		return "created_at ASC, id ASC"
+	case models.ListSortDurationDesc:
+		return "duration_ms DESC NULLS LAST, id DESC"
+	case models.ListSortDurationAsc:
+		return "duration_ms ASC NULLS LAST, id ASC"
+	}
+	return "created_at DESC, id DESC"
+}
+
+// likeEscaper экранирует спецсимволы LIKE (\ - escape-символ по умолчанию в PostgreSQL)
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// listFilter возвращает условия WHERE (после "WHERE 1=1") и их аргументы для фильтров списка заданий
+func listFilter(params models.ListTasksParams) (string, []interface{}) {
+	where := ""
+	args := []interface{}{}
+	argPos := 1
+
+	// Добавляем фильтр по статусу
+	if params.Status != "" {
+		where += fmt.Sprintf(" AND status = $%d", argPos)
+		args = append(args, params.Status)
+		argPos++
+	}
+
+	// Добавляем фильтр по типу задания
+	if params.TaskType != "" {
+		where += fmt.Sprintf(" AND task_type = $%d", argPos)
+		args = append(args, params.TaskType)
+		argPos++
+	}
+
+	// Добавляем фильтр по очереди
+	if params.Queue != "" {
+		where += fmt.Sprintf(" AND queue = $%d", argPos)
+		args = append(args, params.Queue)
+		argPos++
+	}
+
+	// Добавляем фильтр по наличию ошибки
+	if params.HasError != nil {
+		if *params.HasError {
+			where += " AND error_message IS NOT NULL AND error_message <> ''"
+		} else {
+			where += " AND (error_message IS NULL OR error_message = '')"
+		}
+	}
+
+	// Добавляем фильтр по подстроке ошибки; % и _ из запроса ищутся буквально
+	if params.ErrorContains != "" {
+		where += fmt.Sprintf(" AND error_message ILIKE '%%' || $%d || '%%'", argPos)
+		args = append(args, likeEscaper.Replace(params.ErrorContains))
+		argPos++
+	}
+
+	// Добавляем фильтр по классу ошибки
+	if params.FailureReason != "" {
+		where += fmt.Sprintf(" AND failure_reason = $%d", argPos)
+		args = append(args, params.FailureReason)
+		argPos++
+	}
+
+	// Добавляем фильтры по длительности выполнения
+	if params.MinDurationMs != nil {
+		where += fmt.Sprintf(" AND duration_ms >= $%d", argPos)
+		args = append(args, *params.MinDurationMs)
+		argPos++
+	}
+	if params.MaxDurationMs != nil {
+		where += fmt.Sprintf(" AND duration_ms <= $%d", argPos)
+		args = append(args, *params.MaxDurationMs)
+	}
+
+	return where, args
+}
+
+// ListTasks возвращает страницу заданий с учетом фильтров и общее количество
+func (s *PostgresTaskStore) ListTasks(ctx context.Context, params models.ListTasksParams) ([]models.ScheduledTask, int, error) {
+	where, args := listFilter(params)
+
+	// Получаем общее количество записей
+	var total int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM scheduled_tasks WHERE 1=1`+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
+	}
+
+	// Добавляем сортировку и пагинацию
+	query := `SELECT ` + taskColumns + ` FROM scheduled_tasks WHERE 1=1` + where
+	query += " ORDER BY " + listOrderBy(params.Sort)
+	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, params.Limit, params.Offset)
+
+	// Выполняем запрос
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	defer rows.Close()
+
+	// Читаем результаты
+	tasks := []models.ScheduledTask{}
+	for rows.Next() {
+		var task models.ScheduledTask
+		if err := scanTask(rows, &task); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating tasks: %w", err)
+	}
+
+	return tasks, total, nil
+}
+
+// ExportTasks передает в fn все задания по фильтрам params (без пагинации) в порядке params.Sort,
+// читая их из БД по мере обработки, а не целиком в память
+func (s *PostgresTaskStore) ExportTasks(ctx context.Context, params models.ListTasksParams, fn func(*models.ScheduledTask) error) error {
+	where, args := listFilter(params)
+	query := `SELECT ` + taskColumns + ` FROM scheduled_tasks WHERE 1=1` + where + " ORDER BY " + listOrderBy(params.Sort)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var task models.ScheduledTask
+		if err := scanTask(rows, &task); err != nil {
+			return fmt.Errorf("failed to scan task: %w", err)
+		}
+		if err := fn(&task); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating tasks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"at-api/models"
+)
+
+// openTestDB открывает тестовую базу PostgreSQL или пропускает тест, если она не задана.
+// Нужен PostgreSQL со схемой sql/ddl.sql: API_TEST_DSN="host=localhost user=postgres dbname=at_test sslmode=disable"
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("API_TEST_DSN")
+	if dsn == "" {
+		t.Skip("API_TEST_DSN is not set")
+	}
+
+	database, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+// TestPostgresCompleteLeasedTaskChain проверяет, что завершение арендованного задания создает задание
+// цепочки из on_success с parent_id и запись notification_outbox для канала notify
+func TestPostgresCompleteLeasedTaskChain(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+	queue := fmt.Sprintf("lease-chain-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		database.ExecContext(ctx, `DELETE FROM notification_outbox WHERE task_id IN (SELECT id FROM scheduled_tasks WHERE queue = $1)`, queue)
+		database.ExecContext(ctx, `DELETE FROM scheduled_tasks WHERE queue = $1`, queue)
+	})
+
+	s := NewTaskService(NewPostgresTaskStore(database, PostgresStoreOptions{}),
+		Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute, MaxAttemptsLimit: 100})
+	parent, err := s.CreateTask(ctx, &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Second),
+		TaskType:  "email",
+		Queue:     queue,
+		Payload:   json.RawMessage(`{}`),
+		Notify:    []models.NotifyChannel{{Type: "webhook", Target: "https://example.com/hook"}},
+		OnSuccess: &models.FollowUpTask{TaskType: "http_callback", Queue: queue, Payload: json.RawMessage(`{"step":"success"}`)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if _, err := database.ExecContext(ctx, `UPDATE scheduled_tasks SET execute_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, parent.ID); err != nil {
+		t.Fatalf("Failed to make task due: %v", err)
+	}
+
+	claimed, err := s.ClaimTasks(ctx, &models.ClaimTasksRequest{Queue: queue, Limit: 1})
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Failed to claim task: %v (claimed %d)", err, len(claimed))
+	}
+	completed, err := s.CompleteTask(ctx, parent.ID, &models.FinishTaskRequest{LeaseToken: *claimed[0].LeaseToken})
+	if err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	if completed.Status != "completed" {
+		t.Errorf("Completed task status: got=%s, want=completed", completed.Status)
+	}
+
+	var childType string
+	var childParent int64
+	err = database.QueryRowContext(ctx, `SELECT task_type, parent_id FROM scheduled_tasks WHERE parent_id = $1`, parent.ID).
+		Scan(&childType, &childParent)
+	if err != nil {
+		t.Fatalf("on_success child of task %d not found: %v", parent.ID, err)
+	}
+	if childType != "http_callback" || childParent != parent.ID {
+		t.Errorf("on_success child: got type=%s parent_id=%d, want http_callback with parent_id %d", childType, childParent, parent.ID)
+	}
+
+	var event json.RawMessage
+	err = database.QueryRowContext(ctx, `SELECT event FROM notification_outbox WHERE task_id = $1 AND channel_type = 'webhook'`, parent.ID).Scan(&event)
+	if err != nil {
+		t.Fatalf("Outbox notification of task %d not found: %v", parent.ID, err)
+	}
+	var decoded struct {
+		TaskID int64  `json:"task_id"`
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(event, &decoded); err != nil || decoded.TaskID != parent.ID || decoded.Status != "completed" {
+		t.Errorf("Outbox event: got %s, want completed event of task %d", event, parent.ID)
+	}
+}
+
+// TestPostgresUpsertTaskByKeyResetsHistory проверяет, что замена задания на паузе по ключу сбрасывает
+// попытки и результаты прошлых выполнений, но оставляет задание в hold
+func TestPostgresUpsertTaskByKeyResetsHistory(t *testing.T) {
+	database := openTestDB(t)
+	ctx := context.Background()
+	queue := fmt.Sprintf("upsert-test-%d", time.Now().UnixNano())
+	key := queue + ":report"
+	t.Cleanup(func() {
+		database.ExecContext(ctx, `DELETE FROM scheduled_tasks WHERE queue = $1`, queue)
+	})
+
+	s := NewTaskService(NewPostgresTaskStore(database, PostgresStoreOptions{}), Options{})
+	request := func(payload string) *models.CreateTaskRequest {
+		return &models.CreateTaskRequest{ExecuteAt: time.Now().Add(time.Hour), TaskType: "email", Queue: queue, Payload: json.RawMessage(payload)}
+	}
+	first, created, err := s.UpsertTaskByKey(ctx, key, request(`{"v": 1}`))
+	if err != nil || !created {
+		t.Fatalf("First upsert: created=%v (%v), want created", created, err)
+	}
+	_, err = database.ExecContext(ctx, `
+		UPDATE scheduled_tasks
+		SET status = 'hold', attempts = 2, executions = 3, error_message = 'HTTP 500', result = '{"code": 500}',
+		    warning = 'slow', failure_reason = 'http_5xx', duration_ms = 1200, progress = '{"step": 2}'
+		WHERE id = $1`, first.ID)
+	if err != nil {
+		t.Fatalf("Failed to set task history: %v", err)
+	}
+
+	second, created, err := s.UpsertTaskByKey(ctx, key, request(`{"v": 2}`))
+	if err != nil || created {
+		t.Fatalf("Second upsert: created=%v (%v), want replaced", created, err)
+	}
+	if second.ID != first.ID || second.Status != "hold" || string(second.Payload) != `{"v": 2}` {
+		t.Errorf("Replaced task: got id=%d status=%s payload=%s, want id=%d in hold with new payload",
+			second.ID, second.Status, second.Payload, first.ID)
+	}
+	if second.Attempts != 0 || second.Executions != 0 || second.ErrorMessage.Valid || second.Result != nil ||
+		second.Warning != nil || second.FailureReason != nil || second.DurationMs != nil || second.Progress != nil {
+		t.Errorf("Replaced task keeps stale history: attempts=%d executions=%d error=%v result=%v warning=%v failure_reason=%v duration_ms=%v progress=%v",
+			second.Attempts, second.Executions, second.ErrorMessage, second.Result, second.Warning, second.FailureReason, second.DurationMs, second.Progress)
+	}
+}
@@ -0,0 +1,85 @@
+// Файл slow_query.go - лог медленных запросов хранилища заданий к PostgreSQL.
+// С ростом фильтров и сортировок списка заданий запрос без подходящего индекса обычно замечают по жалобам;
+// лог запросов дольше порога показывает такой запрос сразу: его текст с плейсхолдерами ($1, $2, ...)
+// и длительность. Значения параметров не пишутся - в них payload и данные пользователей.
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxLoggedQueryLen ограничивает длину текста запроса в логе (многострочный INSERT батча - десятки КБ)
+const maxLoggedQueryLen = 2000
+
+// slowQueryLog пишет в лог запросы дольше threshold; nil - лог выключен
+type slowQueryLog struct {
+	threshold time.Duration
+}
+
+// newSlowQueryLog создает лог медленных запросов; при threshold <= 0 возвращает nil
+func newSlowQueryLog(threshold time.Duration) *slowQueryLog {
+	if threshold <= 0 {
+		return nil
+	}
+	return &slowQueryLog{threshold: threshold}
+}
+
+// observe пишет запрос в лог, если он выполнялся с start дольше порога.
+// Строка в формате key=value, как и остальные строки лога, которые разбирает сборщик логов
+func (l *slowQueryLog) observe(query string, args int, start time.Time) {
+	if l == nil {
+		return
+	}
+	duration := time.Since(start)
+	if duration < l.threshold {
+		return
+	}
+	log.Printf("Slow query: duration_ms=%d threshold_ms=%d args=%d query=%q",
+		duration.Milliseconds(), l.threshold.Milliseconds(), args, compactQuery(query))
+}
+
+// compactQuery сворачивает пробелы и переводы строк запроса в один пробел и обрезает длинный запрос
+func compactQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > maxLoggedQueryLen {
+		query = query[:maxLoggedQueryLen] + "..."
+	}
+	return query
+}
+
+// timedDB - пул подключений, запросы которого проходят через лог медленных запросов.
+// Для QueryContext измеряется время до первых строк результата, без их чтения
+type timedDB struct {
+	*sql.DB
+	slow *slowQueryLog
+}
+
+func (db timedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer db.slow.observe(query, len(args), time.Now())
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (db timedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer db.slow.observe(query, len(args), time.Now())
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db timedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer db.slow.observe(query, len(args), time.Now())
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+// timedTx - транзакция, запросы которой проходят через лог медленных запросов (как у timedDB)
+type timedTx struct {
+	*sql.Tx
+	slow *slowQueryLog
+}
+
+func (tx timedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer tx.slow.observe(query, len(args), time.Now())
+	return tx.Tx.QueryContext(ctx, query, args...)
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSlowQueryLog проверяет, что в лог попадают только запросы дольше порога, одной строкой и без значений параметров
+func TestSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	query := `SELECT id
+		FROM scheduled_tasks
+		WHERE status = $1`
+	slow := newSlowQueryLog(100 * time.Millisecond)
+
+	slow.observe(query, 1, time.Now())
+	if buf.Len() != 0 {
+		t.Errorf("Fast query must not be logged, got %q", buf.String())
+	}
+
+	slow.observe(query, 1, time.Now().Add(-150*time.Millisecond))
+	line := buf.String()
+	for _, want := range []string{"Slow query:", "threshold_ms=100", "args=1", `query="SELECT id FROM scheduled_tasks WHERE status = $1"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Slow query log %q must contain %q", line, want)
+		}
+	}
+
+	if newSlowQueryLog(0) != nil {
+		t.Error("Zero threshold must disable the slow query log")
+	}
+	var disabled *slowQueryLog
+	disabled.observe(query, 0, time.Now().Add(-time.Hour))
+
+	if got := compactQuery(strings.Repeat("x", maxLoggedQueryLen+10)); len(got) != maxLoggedQueryLen+3 {
+		t.Errorf("Long query must be truncated, got %d chars", len(got))
+	}
+}
@@ -1,11 +1,14 @@
 // Package services содержит бизнес-логику приложения.
 // TaskService предоставляет методы для работы с запланированными заданиями:
-// создание, получение, отмена и получение списка заданий.
-// Взаимодействует напрямую с базой данных через sql.DB.
+// создание, получение, отмена, приостановка, повторный запуск и получение списка заданий.
+// Работает с данными через интерфейс TaskStore (PostgreSQL в проде, память в тестах).
 package services
 
 import (
-	"database/sql"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -18,237 +21,555 @@ var (
 	ErrTaskNotFound = errors.New("task not found")
 	// ErrInvalidExecuteTime возвращается, когда время выполнения задания в прошлом
 	ErrInvalidExecuteTime = errors.New("execute_at must be in the future")
+	// ErrInvalidMaxAttempts возвращается, когда max_attempts вне допустимого диапазона
+	ErrInvalidMaxAttempts = errors.New("invalid max_attempts")
+	// ErrTaskNotFailed возвращается при попытке перезапустить задание не в статусе 'failed'
+	ErrTaskNotFailed = errors.New("only failed tasks can be requeued")
+	// ErrInvalidTaskStatus возвращается, когда текущий статус задания не допускает операцию
+	ErrInvalidTaskStatus = errors.New("operation is not allowed in current task status")
+	// ErrInvalidLease возвращается, когда запрошенный срок аренды вне допустимого диапазона
+	ErrInvalidLease = errors.New("invalid lease_seconds")
+	// ErrLeaseMismatch возвращается, когда задание не арендовано с указанным токеном
+	// (аренда истекла и задание возвращено в очередь, завершено или отменено)
+	ErrLeaseMismatch = errors.New("task is not leased with this lease_token")
+	// ErrDuplicateTask возвращается, когда уже есть активное задание с тем же dedup_key
+	ErrDuplicateTask = errors.New("active task with this dedup_key already exists")
+	// ErrTaskInProgress возвращается, когда задание с этим dedup_key выполняется и не может быть заменено
+	ErrTaskInProgress = errors.New("task with this dedup_key is being executed")
 )
 
+// DuplicateTaskError - ErrDuplicateTask вместе с уже существующим активным заданием.
+// Позволяет handler'у вернуть существующее задание вместо ошибки (on_duplicate=return_existing).
+type DuplicateTaskError struct {
+	Existing *models.ScheduledTask
+}
+
+func (e *DuplicateTaskError) Error() string {
+	return ErrDuplicateTask.Error()
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrDuplicateTask)
+func (e *DuplicateTaskError) Unwrap() error {
+	return ErrDuplicateTask
+}
+
+// Options содержит настройки TaskService
+type Options struct {
+	MaxAttemptsLimit int           // Максимально допустимое значение max_attempts (0 - без ограничения)
+	DefaultLease     time.Duration // Срок аренды заданий, захваченных через claim, если клиент его не указал
+	MaxLease         time.Duration // Максимальный срок аренды (0 - без ограничения)
+	// ReadStore - хранилище для read-only запросов API (GetTask, GetTaskByKey, ListTasks), например
+	// поверх read-реплики. nil - читать из основного. Записи и проверки перед записью всегда идут в основное.
+	ReadStore TaskStore
+	// BatchChunkSize - сколько заданий батча вставлять одним INSERT (0 - наибольшая часть, допустимая хранилищем)
+	BatchChunkSize int
+	// PayloadDefaults - JSON-объекты значений по умолчанию для payload по типам заданий (см. ApplyPayloadDefaults)
+	PayloadDefaults map[string]json.RawMessage
+	// PayloadStore - внешнее хранилище для payload больше PayloadRefThreshold байт (см. offloadPayload); nil - все payload в строке
+	PayloadStore        PayloadStore
+	PayloadRefThreshold int
+	// CallbackAllowlist, CallbackDenylist и CallbackBlockPrivate - политика адресов url заданий http_callback
+	// (см. urlPolicy): хосты и подсети, к которым разрешено или запрещено обращаться, и запрет приватных адресов
+	CallbackAllowlist    []string
+	CallbackDenylist     []string
+	CallbackBlockPrivate bool
+}
+
+// defaultLease - срок аренды по умолчанию, если Options.DefaultLease не задан
+const defaultLease = time.Minute
+
 // TaskService предоставляет методы для управления заданиями
 type TaskService struct {
-	db *sql.DB
+	store            TaskStore
+	readStore        TaskStore
+	maxAttemptsLimit int
+	defaultLease     time.Duration
+	maxLease         time.Duration
+	batchChunkSize   int
+	payloadDefaults  map[string]json.RawMessage
+
+	payloadStore        PayloadStore
+	payloadRefThreshold int
+	urlPolicy           *urlPolicy // nil - url заданий http_callback не проверяются
 }
 
 // NewTaskService создает новый экземпляр TaskService.
 // Параметры:
-//   - db: указатель на пул подключений к базе данных
-func NewTaskService(db *sql.DB) *TaskService {
-	return &TaskService{db: db}
+//   - store: хранилище заданий (NewPostgresTaskStore или NewMemoryTaskStore)
+//   - opts: настройки сервиса (ограничения на параметры заданий)
+func NewTaskService(store TaskStore, opts Options) *TaskService {
+	if opts.DefaultLease <= 0 {
+		opts.DefaultLease = defaultLease
+	}
+	if opts.ReadStore == nil {
+		opts.ReadStore = store
+	}
+
+	return &TaskService{
+		store:            store,
+		readStore:        opts.ReadStore,
+		maxAttemptsLimit: opts.MaxAttemptsLimit,
+		defaultLease:     opts.DefaultLease,
+		maxLease:         opts.MaxLease,
+		batchChunkSize:   opts.BatchChunkSize,
+		payloadDefaults:  opts.PayloadDefaults,
+
+		payloadStore:        opts.PayloadStore,
+		payloadRefThreshold: opts.PayloadRefThreshold,
+		urlPolicy:           newURLPolicy(opts.CallbackAllowlist, opts.CallbackDenylist, opts.CallbackBlockPrivate),
+	}
 }
 
 // CreateTask создает новое запланированное задание в базе данных.
 // Параметры:
-//   - req: данные для создания задания (execute_at, task_type, payload, max_attempts)
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - req: данные для создания задания (execute_at, task_type, queue, payload, max_attempts)
 //
 // Возвращает созданное задание или ошибку.
-// Валидирует, что execute_at не в прошлом.
-func (s *TaskService) CreateTask(req *models.CreateTaskRequest) (*models.ScheduledTask, error) {
+// Валидирует, что execute_at не в прошлом, а max_attempts не превышает лимит.
+// Если активное задание с тем же dedup_key уже существует, возвращает *DuplicateTaskError.
+// При req.DedupWindow > 0 дубликатом считается и любое (в том числе завершенное) задание с ключом,
+// созданное за последние DedupWindow секунд - "не чаще одного задания на ключ за окно".
+// Payload больше PayloadRefThreshold байт сохраняется во внешнем хранилище (PayloadStore), в задании - ссылка.
+func (s *TaskService) CreateTask(ctx context.Context, req *models.CreateTaskRequest) (*models.ScheduledTask, error) {
+	if err := s.prepareCreate(req); err != nil {
+		return nil, err
+	}
+
+	// Проверка окна не атомарна со вставкой: от одновременного создания защищает
+	// уникальный индекс активных заданий, пока первое задание не выполнено
+	if req.DedupKey != "" && req.DedupWindow > 0 {
+		recent, err := s.store.GetRecentTaskByDedupKey(ctx, req.DedupKey, time.Duration(req.DedupWindow)*time.Second)
+		if err == nil {
+			return nil, &DuplicateTaskError{Existing: recent}
+		}
+		if err != ErrTaskNotFound {
+			return nil, err
+		}
+	}
+
+	if err := s.offloadPayload(ctx, req); err != nil {
+		return nil, err
+	}
+
+	task, err := s.store.CreateTask(ctx, req)
+	if err != ErrDuplicateTask {
+		return task, err
+	}
+
+	// Активное задание с этим ключом уже есть. Если оно успело завершиться между
+	// INSERT и чтением, ключ свободен - пробуем создать задание еще раз
+	existing, err := s.store.GetActiveTaskByDedupKey(ctx, req.DedupKey)
+	if err == ErrTaskNotFound {
+		return s.store.CreateTask(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, &DuplicateTaskError{Existing: existing}
+}
+
+// UpsertTaskByKey гарантирует, что активное задание с ключом key ровно одно и описано запросом req:
+// создает задание или заменяет execute_at, payload и остальные поля задания с этим ключом в 'pending' или 'hold'.
+// Так декларативный клиент перепланирует задание одним запросом, без пары "отменить и создать".
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - key: dedup_key задания; dedup_key в req, если указан, должен совпадать с ним
+//   - req: описание задания, проверки и значения по умолчанию - как в CreateTask (dedup_window_seconds не используется)
+//
+// Возвращает задание и признак создания. Задание с ключом, которое сейчас выполняется, не заменяется: ErrTaskInProgress
+func (s *TaskService) UpsertTaskByKey(ctx context.Context, key string, req *models.CreateTaskRequest) (*models.ScheduledTask, bool, error) {
+	req.DedupKey = key
+	if err := s.prepareCreate(req); err != nil {
+		return nil, false, err
+	}
+	if err := s.offloadPayload(ctx, req); err != nil {
+		return nil, false, err
+	}
+	return s.store.UpsertTaskByKey(ctx, req)
+}
+
+// CreateTasks создает батч заданий одной транзакцией: создаются все задания или ни одного.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает вставку и откатывает транзакцию)
+//   - reqs: задания в порядке создания (проверки и значения по умолчанию - как в CreateTask)
+//
+// Вставка идет частями по batchChunkSize заданий (см. TaskStore.CreateTasks), поэтому
+// размер батча не упирается в лимит параметров одного запроса PostgreSQL.
+// Ошибка проверки содержит индекс задания: "tasks[3]: execute_at must be in the future".
+// Если dedup_key одного из заданий занят активным заданием или повторяется в батче - ErrDuplicateTask.
+func (s *TaskService) CreateTasks(ctx context.Context, reqs []models.CreateTaskRequest) ([]*models.ScheduledTask, error) {
+	for i := range reqs {
+		if err := s.prepareCreate(&reqs[i]); err != nil {
+			return nil, fmt.Errorf("tasks[%d]: %w", i, err)
+		}
+	}
+	for i := range reqs {
+		if err := s.offloadPayload(ctx, &reqs[i]); err != nil {
+			return nil, fmt.Errorf("tasks[%d]: %w", i, err)
+		}
+	}
+	return s.store.CreateTasks(ctx, reqs, s.batchChunkSize)
+}
+
+// prepareCreate проверяет execute_at, max_attempts и url http_callback нового задания и подставляет значения по умолчанию
+func (s *TaskService) prepareCreate(req *models.CreateTaskRequest) error {
 	// Валидация: время выполнения не должно быть в прошлом
 	if req.ExecuteAt.Before(time.Now()) {
-		return nil, ErrInvalidExecuteTime
+		return ErrInvalidExecuteTime
+	}
+	if err := s.urlPolicy.checkCallbackURL(req.TaskType, req.Payload); err != nil {
+		return err
 	}
 
-	// Устанавливаем значение по умолчанию для max_attempts
-	maxAttempts := req.MaxAttempts
-	if maxAttempts == 0 {
-		maxAttempts = 3
-	}
-
-	query := `
-		INSERT INTO scheduled_tasks (execute_at, task_type, payload, max_attempts)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, execute_at, task_type, payload, status, attempts, max_attempts,
-		          error_message, created_at, updated_at, completed_at
-	`
-
-	task := &models.ScheduledTask{}
-	err := s.db.QueryRow(
-		query,
-		req.ExecuteAt,
-		req.TaskType,
-		req.Payload,
-		maxAttempts,
-	).Scan(
-		&task.ID,
-		&task.ExecuteAt,
-		&task.TaskType,
-		&task.Payload,
-		&task.Status,
-		&task.Attempts,
-		&task.MaxAttempts,
-		&task.ErrorMessage,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-		&task.CompletedAt,
-	)
+	if req.MaxAttempts < 0 {
+		return fmt.Errorf("%w: must be positive", ErrInvalidMaxAttempts)
+	}
+	if err := s.checkMaxAttemptsLimit(req.MaxAttempts); err != nil {
+		return err
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to create task: %w", err)
+	// Задания без явной очереди попадают в очередь по умолчанию
+	if req.Queue == "" {
+		req.Queue = models.DefaultQueue
+	}
+
+	// Устанавливаем значение по умолчанию для max_attempts
+	if req.MaxAttempts == 0 {
+		req.MaxAttempts = 3
 	}
+	return s.prepareFollowUps(req.OnSuccess, req.OnFailure)
+}
 
-	return task, nil
+// prepareFollowUps проверяет max_attempts и url http_callback заданий цепочки (on_success, on_failure) и подставляет
+// значения по умолчанию: worker создает их из сохраненного описания как есть
+func (s *TaskService) prepareFollowUps(tasks ...*models.FollowUpTask) error {
+	for _, task := range tasks {
+		if task == nil {
+			continue
+		}
+		if task.MaxAttempts < 0 {
+			return fmt.Errorf("%w: must be positive", ErrInvalidMaxAttempts)
+		}
+		if err := s.checkMaxAttemptsLimit(task.MaxAttempts); err != nil {
+			return err
+		}
+		if err := s.urlPolicy.checkCallbackURL(task.TaskType, task.Payload); err != nil {
+			return err
+		}
+		if task.Queue == "" {
+			task.Queue = models.DefaultQueue
+		}
+		if task.MaxAttempts == 0 {
+			task.MaxAttempts = 3
+		}
+		if err := s.prepareFollowUps(task.OnSuccess, task.OnFailure); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // GetTask получает задание по его ID.
 // Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
 //   - id: идентификатор задания
 //
 // Возвращает задание или ошибку ErrTaskNotFound, если задание не найдено.
-func (s *TaskService) GetTask(id int64) (*models.ScheduledTask, error) {
-	query := `
-		SELECT id, execute_at, task_type, payload, status, attempts, max_attempts,
-		       error_message, created_at, updated_at, completed_at
-		FROM scheduled_tasks
-		WHERE id = $1
-	`
-
-	task := &models.ScheduledTask{}
-	err := s.db.QueryRow(query, id).Scan(
-		&task.ID,
-		&task.ExecuteAt,
-		&task.TaskType,
-		&task.Payload,
-		&task.Status,
-		&task.Attempts,
-		&task.MaxAttempts,
-		&task.ErrorMessage,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-		&task.CompletedAt,
-	)
-
-	if err == sql.ErrNoRows {
-		return nil, ErrTaskNotFound
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to get task: %w", err)
-	}
+// Читает из ReadStore: с read-репликой только что созданное задание может быть еще не видно.
+func (s *TaskService) GetTask(ctx context.Context, id int64) (*models.ScheduledTask, error) {
+	return s.readStore.GetTask(ctx, id)
+}
 
-	return task, nil
+// GetTaskHistory получает историю смен статуса задания: когда и кем (API, worker) задание переводилось
+// из статуса в статус. Возвращает ErrTaskNotFound, если задание не найдено. Читает из ReadStore
+func (s *TaskService) GetTaskHistory(ctx context.Context, id int64) (*models.TaskHistory, error) {
+	return s.readStore.GetTaskHistory(ctx, id)
+}
+
+// GetTaskByKey получает задание по dedup_key.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - key: dedup_key, указанный при создании задания
+//
+// Возвращает активное задание с ключом, а если его нет - последнее созданное,
+// или ошибку ErrTaskNotFound, если заданий с ключом нет.
+func (s *TaskService) GetTaskByKey(ctx context.Context, key string) (*models.ScheduledTask, error) {
+	return s.readStore.GetTaskByDedupKey(ctx, key)
 }
 
 // CancelTask отменяет задание, устанавливая его статус в 'cancelled'.
 // Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
 //   - id: идентификатор задания
+//   - reason: причина отмены для аудита (cancel_reason); пусто - без причины
 //
 // Возвращает обновленное задание или ошибку ErrTaskNotFound, если задание не найдено.
-// Можно отменить только задания в статусе 'pending' или 'processing'.
-func (s *TaskService) CancelTask(id int64) (*models.ScheduledTask, error) {
-	query := `
-		UPDATE scheduled_tasks
-		SET status = 'cancelled'
-		WHERE id = $1 AND status IN ('pending', 'processing')
-		RETURNING id, execute_at, task_type, payload, status, attempts, max_attempts,
-		          error_message, created_at, updated_at, completed_at
-	`
-
-	task := &models.ScheduledTask{}
-	err := s.db.QueryRow(query, id).Scan(
-		&task.ID,
-		&task.ExecuteAt,
-		&task.TaskType,
-		&task.Payload,
-		&task.Status,
-		&task.Attempts,
-		&task.MaxAttempts,
-		&task.ErrorMessage,
-		&task.CreatedAt,
-		&task.UpdatedAt,
-		&task.CompletedAt,
-	)
-
-	if err == sql.ErrNoRows {
-		return nil, ErrTaskNotFound
+// Можно отменить только задания в статусе 'pending', 'processing' или 'hold'.
+// В том числе задания, ожидающие повторной попытки после ошибки: отмена терминальна,
+// задание больше не будет выполнено, а completed_at содержит время отмены.
+func (s *TaskService) CancelTask(ctx context.Context, id int64, reason string) (*models.ScheduledTask, error) {
+	return s.store.CancelTask(ctx, id, reason)
+}
+
+// CancelTaskByKey отменяет активное задание с указанным dedup_key.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - key: dedup_key, указанный при создании задания
+//   - reason: причина отмены для аудита (cancel_reason); пусто - без причины
+//
+// Возвращает отмененное задание или ошибку ErrTaskNotFound, если активного задания с ключом нет
+// (в том числе если оно успело завершиться между поиском и отменой).
+// Поиск идет по основному хранилищу, а не по ReadStore: реплика может отставать.
+func (s *TaskService) CancelTaskByKey(ctx context.Context, key, reason string) (*models.ScheduledTask, error) {
+	task, err := s.store.GetActiveTaskByDedupKey(ctx, key)
+	if err != nil {
+		return nil, err
 	}
+	return s.store.CancelTask(ctx, task.ID, reason)
+}
+
+// HoldTask приостанавливает задание в статусе 'pending' (статус 'hold').
+// Приостановленное задание не забирается worker'ом, пока его не вернут через UnholdTask.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - id: идентификатор задания
+//
+// Возвращает обновленное задание, ErrTaskNotFound или ErrInvalidTaskStatus, если задание не в 'pending'.
+func (s *TaskService) HoldTask(ctx context.Context, id int64) (*models.ScheduledTask, error) {
+	return s.transitionStatus(ctx, id, "pending", "hold")
+}
+
+// UnholdTask возвращает приостановленное задание в 'pending'.
+// Если execute_at уже в прошлом, worker заберет задание при следующем опросе.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - id: идентификатор задания
+//
+// Возвращает обновленное задание, ErrTaskNotFound или ErrInvalidTaskStatus, если задание не в 'hold'.
+func (s *TaskService) UnholdTask(ctx context.Context, id int64) (*models.ScheduledTask, error) {
+	return s.transitionStatus(ctx, id, "hold", "pending")
+}
+
+// transitionStatus меняет статус задания с from на to.
+// Отличает отсутствие задания (ErrTaskNotFound) от неподходящего статуса (ErrInvalidTaskStatus).
+func (s *TaskService) transitionStatus(ctx context.Context, id int64, from, to string) (*models.ScheduledTask, error) {
+	task, err := s.store.GetTask(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to cancel task: %w", err)
+		return nil, err
+	}
+	if task.Status != from {
+		return nil, fmt.Errorf("%w: task is %s, expected %s", ErrInvalidTaskStatus, task.Status, from)
 	}
 
-	return task, nil
+	updated, err := s.store.TransitionStatus(ctx, id, from, to)
+	if err == ErrTaskNotFound {
+		// Статус изменился между чтением и обновлением (например, worker забрал задание)
+		return nil, fmt.Errorf("%w: task is no longer %s", ErrInvalidTaskStatus, from)
+	}
+	return updated, err
 }
 
-// ListTasks возвращает список заданий с фильтрацией и пагинацией.
+// RequeueTask увеличивает max_attempts у задания в статусе 'failed' и возвращает его в 'pending'.
 // Параметры:
-//   - params: параметры фильтрации (status, task_type, limit, offset)
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - id: идентификатор задания
+//   - maxAttempts: новый лимит попыток; 0 - дать заданию еще одну попытку (attempts + 1)
 //
-// Возвращает массив заданий и общее количество заданий, соответствующих фильтрам.
-func (s *TaskService) ListTasks(params models.ListTasksParams) ([]models.ScheduledTask, int, error) {
-	// Устанавливаем значения по умолчанию для пагинации
-	if params.Limit == 0 {
-		params.Limit = 50
+// Возвращает обновленное задание или ошибку:
+//   - ErrTaskNotFound: задание не найдено
+//   - ErrTaskNotFailed: задание не в статусе 'failed' (в том числе если статус изменился параллельно)
+//   - ErrInvalidMaxAttempts: новый лимит не больше уже сделанных попыток или превышает лимит сервиса
+//   - ErrDuplicateTask: уже есть активное задание с тем же dedup_key
+func (s *TaskService) RequeueTask(ctx context.Context, id int64, maxAttempts int) (*models.ScheduledTask, error) {
+	task, err := s.store.GetTask(ctx, id)
+	if err != nil {
+		return nil, err
 	}
-	if params.Limit > 100 {
-		params.Limit = 100
+	if task.Status != "failed" {
+		return nil, ErrTaskNotFailed
+	}
+
+	if maxAttempts == 0 {
+		maxAttempts = task.Attempts + 1
+	}
+	if maxAttempts <= task.Attempts {
+		return nil, fmt.Errorf("%w: must be greater than attempts already made (%d)", ErrInvalidMaxAttempts, task.Attempts)
 	}
+	if err := s.checkMaxAttemptsLimit(maxAttempts); err != nil {
+		return nil, err
+	}
+
+	requeued, err := s.store.RequeueTask(ctx, id, maxAttempts)
+	if err == ErrTaskNotFound {
+		return nil, ErrTaskNotFailed
+	}
+	return requeued, err
+}
+
+// RequeueFailedTasks перезапускает задания в статусе 'failed' по фильтрам запроса: каждое получает еще одну
+// попытку, как RequeueTask без max_attempts. execute_at распределяется равномерно по окну spread_seconds,
+// поэтому перезапуск тысяч заданий не обрушивает разом нагрузку на получателя: 5000 заданий за 600 секунд -
+// это примерно 8 заданий в секунду. Возвращает число перезапущенных заданий
+func (s *TaskService) RequeueFailedTasks(ctx context.Context, req *models.BulkRequeueRequest) (int, error) {
+	params := models.ListTasksParams{
+		Status:        "failed",
+		TaskType:      req.TaskType,
+		Queue:         req.Queue,
+		FailureReason: req.FailureReason,
+		ErrorContains: req.ErrorContains,
+	}
+	return s.store.RequeueFailedTasks(ctx, params, req.Limit, time.Duration(req.SpreadSeconds)*time.Second, s.maxAttemptsLimit)
+}
+
+// checkMaxAttemptsLimit проверяет max_attempts на соответствие настроенному лимиту
+func (s *TaskService) checkMaxAttemptsLimit(maxAttempts int) error {
+	if s.maxAttemptsLimit > 0 && maxAttempts > s.maxAttemptsLimit {
+		return fmt.Errorf("%w: must not exceed %d", ErrInvalidMaxAttempts, s.maxAttemptsLimit)
+	}
+	return nil
+}
 
-	// Строим запрос с учетом фильтров
-	query := `
-		SELECT id, execute_at, task_type, payload, status, attempts, max_attempts,
-		       error_message, created_at, updated_at, completed_at
-		FROM scheduled_tasks
-		WHERE 1=1
-	`
-	countQuery := `SELECT COUNT(*) FROM scheduled_tasks WHERE 1=1`
-	args := []interface{}{}
-	argPos := 1
-
-	// Добавляем фильтр по статусу
-	if params.Status != "" {
-		query += fmt.Sprintf(" AND status = $%d", argPos)
-		countQuery += fmt.Sprintf(" AND status = $%d", argPos)
-		args = append(args, params.Status)
-		argPos++
-	}
-
-	// Добавляем фильтр по типу задания
-	if params.TaskType != "" {
-		query += fmt.Sprintf(" AND task_type = $%d", argPos)
-		countQuery += fmt.Sprintf(" AND task_type = $%d", argPos)
-		args = append(args, params.TaskType)
-		argPos++
-	}
-
-	// Получаем общее количество записей
-	var total int
-	err := s.db.QueryRow(countQuery, args...).Scan(&total)
+// ClaimTasks захватывает готовые к выполнению задания для внешнего (pull) клиента.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - req: фильтры (queue, task_types), количество и срок аренды
+//
+// Каждое задание получает токен аренды и locked_until. Клиент должен отчитаться через
+// CompleteTask или FailTask с этим токеном до истечения аренды, иначе Cleaner вернет задание в очередь.
+// Возвращает ErrInvalidLease, если lease_seconds отрицательный или превышает лимит.
+func (s *TaskService) ClaimTasks(ctx context.Context, req *models.ClaimTasksRequest) ([]*models.ScheduledTask, error) {
+	lease, err := s.leaseFor(req.LeaseSeconds)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to count tasks: %w", err)
+		return nil, err
 	}
 
-	// Добавляем сортировку и пагинацию
-	query += " ORDER BY created_at DESC"
-	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", argPos, argPos+1)
-	args = append(args, params.Limit, params.Offset)
+	if req.Limit <= 0 {
+		req.Limit = models.DefaultClaimLimit
+	}
+	if req.Limit > models.MaxClaimLimit {
+		req.Limit = models.MaxClaimLimit
+	}
 
-	// Выполняем запрос
-	rows, err := s.db.Query(query, args...)
+	leaseToken, err := newLeaseToken()
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to list tasks: %w", err)
-	}
-	defer rows.Close()
-
-	// Читаем результаты
-	tasks := []models.ScheduledTask{}
-	for rows.Next() {
-		var task models.ScheduledTask
-		err := rows.Scan(
-			&task.ID,
-			&task.ExecuteAt,
-			&task.TaskType,
-			&task.Payload,
-			&task.Status,
-			&task.Attempts,
-			&task.MaxAttempts,
-			&task.ErrorMessage,
-			&task.CreatedAt,
-			&task.UpdatedAt,
-			&task.CompletedAt,
-		)
-		if err != nil {
-			return nil, 0, fmt.Errorf("failed to scan task: %w", err)
-		}
-		tasks = append(tasks, task)
+		return nil, err
+	}
+
+	return s.store.ClaimTasks(ctx, req, leaseToken, lease)
+}
+
+// leaseFor возвращает срок аренды lease_seconds: 0 - срок по умолчанию,
+// ErrInvalidLease - если значение отрицательное или превышает лимит
+func (s *TaskService) leaseFor(seconds int) (time.Duration, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("%w: must be positive", ErrInvalidLease)
+	}
+	lease := time.Duration(seconds) * time.Second
+	if lease == 0 {
+		lease = s.defaultLease
 	}
+	if s.maxLease > 0 && lease > s.maxLease {
+		return 0, fmt.Errorf("%w: must not exceed %d", ErrInvalidLease, int(s.maxLease.Seconds()))
+	}
+	return lease, nil
+}
+
+// ExtendLease продлевает аренду задания, захваченного через ClaimTasks, которое клиент еще выполняет.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - id: идентификатор задания
+//   - req: токен аренды и новый срок аренды (отсчитывается от текущего момента, не от прежнего locked_until)
+//
+// Долгое задание клиент продлевает периодически, не дожидаясь истечения аренды: пока аренда действует,
+// Cleaner не возвращает задание в очередь. Продлевать можно сколько угодно раз, каждый срок ограничен лимитом.
+// Возвращает обновленное задание, ErrInvalidLease, ErrTaskNotFound или ErrLeaseMismatch, если токен
+// не совпадает (аренда истекла и задание уже вернулось в очередь, или задание завершено).
+func (s *TaskService) ExtendLease(ctx context.Context, id int64, req *models.ExtendLeaseRequest) (*models.ScheduledTask, error) {
+	lease, err := s.leaseFor(req.LeaseSeconds)
+	if err != nil {
+		return nil, err
+	}
+	task, err := s.store.ExtendLease(ctx, id, req.LeaseToken, lease)
+	if err == ErrTaskNotFound {
+		return nil, s.leaseError(ctx, id)
+	}
+	return task, err
+}
+
+// CompleteTask фиксирует успешное выполнение задания, захваченного через ClaimTasks.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - id: идентификатор задания
+//   - req: токен аренды и результат выполнения
+//
+// Возвращает обновленное задание, ErrTaskNotFound или ErrLeaseMismatch, если токен не совпадает
+// (например, аренда истекла и задание уже вернулось в очередь).
+func (s *TaskService) CompleteTask(ctx context.Context, id int64, req *models.FinishTaskRequest) (*models.ScheduledTask, error) {
+	task, err := s.store.CompleteLeasedTask(ctx, id, req.LeaseToken, req.Warning, req.Result)
+	if err == ErrTaskNotFound {
+		return nil, s.leaseError(ctx, id)
+	}
+	return task, err
+}
+
+// FailTask фиксирует неудачную попытку задания, захваченного через ClaimTasks.
+// Задание возвращается в 'pending' для повтора или переводится в 'failed', если попытки исчерпаны.
+// Параметры:
+//   - ctx: контекст запроса (отмена прерывает запрос к БД)
+//   - id: идентификатор задания
+//   - req: токен аренды, описание и класс ошибки, результат выполнения
+//
+// Возвращает обновленное задание, ErrTaskNotFound или ErrLeaseMismatch.
+func (s *TaskService) FailTask(ctx context.Context, id int64, req *models.FinishTaskRequest) (*models.ScheduledTask, error) {
+	failureReason := req.FailureReason
+	if failureReason == "" {
+		failureReason = models.FailureUnknown
+	}
+	task, err := s.store.FailLeasedTask(ctx, id, req.LeaseToken, req.ErrorMessage, failureReason, req.Result)
+	if err == ErrTaskNotFound {
+		return nil, s.leaseError(ctx, id)
+	}
+	return task, err
+}
+
+// leaseError отличает отсутствующее задание (ErrTaskNotFound) от несовпадения аренды (ErrLeaseMismatch)
+func (s *TaskService) leaseError(ctx context.Context, id int64) error {
+	if _, err := s.store.GetTask(ctx, id); err != nil {
+		return err
+	}
+	return ErrLeaseMismatch
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating tasks: %w", err)
+// newLeaseToken генерирует случайную часть токена аренды; хранилище дополняет ее ID задания
+func newLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lease token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ListTasks возвращает список заданий с фильтрацией и пагинацией.
+// Параметры:
+//   - ctx: контекст запроса; при отключении клиента запросы к БД прерываются и соединение освобождается
+//   - params: параметры фильтрации (status, task_type, queue, limit, offset)
+//
+// Возвращает массив заданий и общее количество заданий, соответствующих фильтрам (из ReadStore).
+func (s *TaskService) ListTasks(ctx context.Context, params models.ListTasksParams) ([]models.ScheduledTask, int, error) {
+	// Устанавливаем значения по умолчанию для пагинации
+	if params.Limit == 0 {
+		params.Limit = 50
 	}
+	if params.Limit > 100 {
+		params.Limit = 100
+	}
+
+	return s.readStore.ListTasks(ctx, params)
+}
 
-	return tasks, total, nil
+// ExportTasks передает в fn все задания по фильтрам params (без пагинации) из ReadStore.
+// Задания читаются по мере обработки, поэтому выгрузка любого размера не держит их в памяти
+func (s *TaskService) ExportTasks(ctx context.Context, params models.ListTasksParams, fn func(*models.ScheduledTask) error) error {
+	return s.readStore.ExportTasks(ctx, params, fn)
 }
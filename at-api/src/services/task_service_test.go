@@ -0,0 +1,1152 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"at-api/models"
+)
+
+// newTestService создает TaskService поверх хранилища в памяти
+func newTestService() *TaskService {
+	return NewTaskService(NewMemoryTaskStore(), Options{})
+}
+
+// createTestTask создает pending задание указанного типа
+func createTestTask(t *testing.T, s *TaskService, taskType string) *models.ScheduledTask {
+	t.Helper()
+	task, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  taskType,
+		Payload:   json.RawMessage(`{"key":"value"}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	return task
+}
+
+// TestCreateTaskDefaults проверяет статус и max_attempts по умолчанию
+func TestCreateTaskDefaults(t *testing.T) {
+	s := newTestService()
+
+	task := createTestTask(t, s, "test_task")
+
+	if task.Status != "pending" {
+		t.Errorf("Status: got=%s, want=pending", task.Status)
+	}
+	if task.MaxAttempts != 3 {
+		t.Errorf("MaxAttempts: got=%d, want=3", task.MaxAttempts)
+	}
+	if task.ID == 0 {
+		t.Error("Task ID should not be 0")
+	}
+}
+
+// TestCreateTasks проверяет, что батч создается целиком или не создается совсем
+func TestCreateTasks(t *testing.T) {
+	s := newTestService()
+	ctx := context.Background()
+	task := func(key string) models.CreateTaskRequest {
+		return models.CreateTaskRequest{
+			ExecuteAt: time.Now().Add(time.Hour),
+			TaskType:  "test_task",
+			Payload:   json.RawMessage(`{}`),
+			DedupKey:  key,
+		}
+	}
+
+	tasks, err := s.CreateTasks(ctx, []models.CreateTaskRequest{task("a"), task(""), task("b")})
+	if err != nil {
+		t.Fatalf("CreateTasks failed: %v", err)
+	}
+	if len(tasks) != 3 || tasks[0].ID >= tasks[1].ID || tasks[1].ID >= tasks[2].ID {
+		t.Fatalf("Expected 3 tasks in request order, got %+v", tasks)
+	}
+	if tasks[1].Queue != models.DefaultQueue || tasks[1].MaxAttempts != 3 {
+		t.Errorf("Defaults not applied: queue=%q max_attempts=%d", tasks[1].Queue, tasks[1].MaxAttempts)
+	}
+
+	// Ключ "a" уже занят: не создается ни одно задание батча
+	if _, err := s.CreateTasks(ctx, []models.CreateTaskRequest{task("c"), task("a")}); !errors.Is(err, ErrDuplicateTask) {
+		t.Errorf("Expected ErrDuplicateTask, got %v", err)
+	}
+	if _, err := s.GetTaskByKey(ctx, "c"); err != ErrTaskNotFound {
+		t.Errorf("Task from rejected batch was created: %v", err)
+	}
+
+	late := task("")
+	late.ExecuteAt = time.Now().Add(-time.Hour)
+	_, err = s.CreateTasks(ctx, []models.CreateTaskRequest{task(""), late})
+	if !errors.Is(err, ErrInvalidExecuteTime) || !strings.HasPrefix(err.Error(), "tasks[1]: ") {
+		t.Errorf("Expected indexed ErrInvalidExecuteTime, got %v", err)
+	}
+}
+
+// TestInsertTaskValues проверяет нумерацию плейсхолдеров строк многострочного INSERT
+func TestInsertTaskValues(t *testing.T) {
+	got := insertTaskValues(insertTaskParams)
+	want := "($20, $21, $22, $23, $24, NULLIF($25, ''), NULLIF($26, 0), NULLIF($27, 0), $28, NULLIF($29, 0), NULLIF($30, 0), NULLIF($31, 0), $32, NULLIF($33, ''), NULLIF($34, ''), $35, $36, $37, NULLIF($38, ''))"
+	if got != want {
+		t.Errorf("insertTaskValues: got=%s, want=%s", got, want)
+	}
+}
+
+// TestClaimTasksQuery проверяет, что кандидаты на захват выбираются по execute_at с ограничением окна,
+// а эффективный приоритет сортируется только внутри окна
+func TestClaimTasksQuery(t *testing.T) {
+	req := &models.ClaimTasksRequest{Queue: "default", TaskTypes: []string{"email"}, Limit: 5}
+	query, args := claimTasksQuery(req, "token", time.Minute)
+
+	// $1-$3 - лимит, токен и аренда, $4-$5 - фильтры, $6 - окно
+	if len(args) != 6 || args[0] != 5 || args[5] != 5*claimScanFactor {
+		t.Fatalf("Expected limit, lease, filters and window in args, got %v", args)
+	}
+	nearest := query[strings.Index(query, "WITH nearest AS"):strings.Index(query, "claimable AS")]
+	if !strings.Contains(nearest, "queue = $4 AND task_type = ANY($5)") ||
+		!strings.Contains(nearest, "ORDER BY execute_at\n\t\t\tLIMIT $6") {
+		t.Errorf("Candidates must be filtered and ordered by execute_at within the window, got: %s", nearest)
+	}
+	if strings.Contains(nearest, claimOrder) {
+		t.Errorf("Effective priority must not be sorted before the window, got: %s", nearest)
+	}
+	if !strings.Contains(query, "ORDER BY "+claimOrder+"\n\t\t\tLIMIT $1\n\t\t\tFOR UPDATE SKIP LOCKED") {
+		t.Errorf("Claimed tasks must be ordered by effective priority within the window, got query: %s", query)
+	}
+}
+
+// TestCreateTaskInPast проверяет отказ в создании задания с execute_at в прошлом
+func TestCreateTaskInPast(t *testing.T) {
+	s := newTestService()
+
+	_, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(-time.Hour),
+		TaskType:  "test_task",
+		Payload:   json.RawMessage(`{}`),
+	})
+	if err != ErrInvalidExecuteTime {
+		t.Errorf("Error: got=%v, want=%v", err, ErrInvalidExecuteTime)
+	}
+}
+
+// TestCancelTask проверяет отмену и повторную отмену задания
+func TestCancelTask(t *testing.T) {
+	s := newTestService()
+	task := createTestTask(t, s, "test_task")
+
+	cancelled, err := s.CancelTask(context.Background(), task.ID, "customer refunded")
+	if err != nil {
+		t.Fatalf("Failed to cancel task: %v", err)
+	}
+	if cancelled.Status != "cancelled" {
+		t.Errorf("Status: got=%s, want=cancelled", cancelled.Status)
+	}
+	if cancelled.CancelReason == nil || *cancelled.CancelReason != "customer refunded" {
+		t.Errorf("Cancel reason: got=%v, want=customer refunded", cancelled.CancelReason)
+	}
+
+	// Отмененное задание повторно отменить нельзя
+	if _, err := s.CancelTask(context.Background(), task.ID, ""); err != ErrTaskNotFound {
+		t.Errorf("Second cancel error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+
+	if _, err := s.GetTask(context.Background(), 999); err != ErrTaskNotFound {
+		t.Errorf("Get missing task error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+}
+
+// TestCancelTaskByKey проверяет отмену активного задания по dedup_key
+func TestCancelTaskByKey(t *testing.T) {
+	s := newTestService()
+	task, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  "test_task",
+		Payload:   json.RawMessage(`{}`),
+		DedupKey:  "orders/42",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	cancelled, err := s.CancelTaskByKey(context.Background(), "orders/42", "")
+	if err != nil {
+		t.Fatalf("Failed to cancel task by key: %v", err)
+	}
+	if cancelled.ID != task.ID || cancelled.Status != "cancelled" {
+		t.Errorf("Cancelled task: got id=%d status=%s, want id=%d status=cancelled", cancelled.ID, cancelled.Status, task.ID)
+	}
+	// Отмена без причины не заполняет cancel_reason
+	if cancelled.CancelReason != nil {
+		t.Errorf("Cancel reason: got=%q, want nil", *cancelled.CancelReason)
+	}
+
+	// Активного задания с ключом больше нет
+	if _, err := s.CancelTaskByKey(context.Background(), "orders/42", ""); err != ErrTaskNotFound {
+		t.Errorf("Second cancel error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+	if _, err := s.CancelTaskByKey(context.Background(), "unknown", ""); err != ErrTaskNotFound {
+		t.Errorf("Unknown key error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+}
+
+// TestCancelTaskInRetryBackoff проверяет отмену задания, которое завершилось ошибкой
+// и ожидает повторной попытки (pending с execute_at в будущем)
+func TestCancelTaskInRetryBackoff(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{})
+	task := createTestTask(t, s, "test_task")
+
+	// Имитируем неудачную попытку worker'а: задание вернулось в pending с отложенным retry
+	store.mu.Lock()
+	failed := store.tasks[task.ID]
+	failed.Attempts = 1
+	failed.ErrorMessage = sql.NullString{String: "HTTP 503", Valid: true}
+	failed.ExecuteAt = time.Now().Add(time.Minute)
+	store.mu.Unlock()
+
+	cancelled, err := s.CancelTask(context.Background(), task.ID, "")
+	if err != nil {
+		t.Fatalf("Failed to cancel task in backoff: %v", err)
+	}
+	if cancelled.Status != "cancelled" {
+		t.Errorf("Status: got=%s, want=cancelled", cancelled.Status)
+	}
+	if !cancelled.CompletedAt.Valid {
+		t.Error("CompletedAt should be set for cancelled task")
+	}
+
+	// Статус терминальный: задание остается отмененным и повторно не отменяется
+	got, err := s.GetTask(context.Background(), task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get task: %v", err)
+	}
+	if got.Status != "cancelled" || got.Attempts != 1 {
+		t.Errorf("Task after cancel: got status=%s attempts=%d, want cancelled with 1 attempt", got.Status, got.Attempts)
+	}
+	if _, err := s.CancelTask(context.Background(), task.ID, ""); err != ErrTaskNotFound {
+		t.Errorf("Second cancel error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+}
+
+// TestHoldAndUnholdTask проверяет приостановку pending задания и возврат его в очередь
+func TestHoldAndUnholdTask(t *testing.T) {
+	s := newTestService()
+	task := createTestTask(t, s, "test_task")
+
+	if _, err := s.UnholdTask(context.Background(), task.ID); !errors.Is(err, ErrInvalidTaskStatus) {
+		t.Errorf("Unhold pending task error: got=%v, want=%v", err, ErrInvalidTaskStatus)
+	}
+
+	held, err := s.HoldTask(context.Background(), task.ID)
+	if err != nil {
+		t.Fatalf("Failed to hold task: %v", err)
+	}
+	if held.Status != "hold" {
+		t.Errorf("Status after hold: got=%s, want=hold", held.Status)
+	}
+
+	// Приостановленное задание не попадает в выборку pending
+	_, total, err := s.ListTasks(context.Background(), models.ListTasksParams{Status: "pending"})
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	if total != 0 {
+		t.Errorf("Pending tasks while held: got=%d, want=0", total)
+	}
+
+	released, err := s.UnholdTask(context.Background(), task.ID)
+	if err != nil {
+		t.Fatalf("Failed to unhold task: %v", err)
+	}
+	if released.Status != "pending" {
+		t.Errorf("Status after unhold: got=%s, want=pending", released.Status)
+	}
+
+	if _, err := s.HoldTask(context.Background(), 999); err != ErrTaskNotFound {
+		t.Errorf("Hold missing task error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+}
+
+// TestRequeueTask проверяет повторный запуск failed задания с увеличенным max_attempts
+func TestRequeueTask(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{MaxAttemptsLimit: 10})
+	task := createTestTask(t, s, "test_task")
+
+	// Pending задание перезапустить нельзя
+	if _, err := s.RequeueTask(context.Background(), task.ID, 5); err != ErrTaskNotFailed {
+		t.Errorf("Requeue pending task error: got=%v, want=%v", err, ErrTaskNotFailed)
+	}
+
+	// Имитируем исчерпание попыток worker'ом
+	store.mu.Lock()
+	failed := store.tasks[task.ID]
+	failed.Status = "failed"
+	failed.Attempts = 3
+	failed.ErrorMessage = sql.NullString{String: "HTTP 500", Valid: true}
+	failed.CompletedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	store.mu.Unlock()
+
+	for _, maxAttempts := range []int{3, 11} {
+		if _, err := s.RequeueTask(context.Background(), task.ID, maxAttempts); !errors.Is(err, ErrInvalidMaxAttempts) {
+			t.Errorf("Requeue with max_attempts=%d error: got=%v, want=%v", maxAttempts, err, ErrInvalidMaxAttempts)
+		}
+	}
+
+	requeued, err := s.RequeueTask(context.Background(), task.ID, 5)
+	if err != nil {
+		t.Fatalf("Failed to requeue task: %v", err)
+	}
+	if requeued.Status != "pending" || requeued.MaxAttempts != 5 || requeued.Attempts != 3 {
+		t.Errorf("Requeued task: got status=%s max_attempts=%d attempts=%d, want pending 5 3",
+			requeued.Status, requeued.MaxAttempts, requeued.Attempts)
+	}
+	if requeued.CompletedAt.Valid {
+		t.Error("CompletedAt should be reset for requeued task")
+	}
+
+	if _, err := s.RequeueTask(context.Background(), 999, 5); err != ErrTaskNotFound {
+		t.Errorf("Requeue missing task error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+}
+
+// TestRequeueFailedTasks проверяет массовый перезапуск: фильтры, лимит попыток, пропуск ключей
+// с активным заданием и равномерное распределение execute_at по окну
+func TestRequeueFailedTasks(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{MaxAttemptsLimit: 10})
+	failedAt := time.Now().Add(-time.Hour)
+	fail := func(taskType, dedupKey string, attempts int) *models.ScheduledTask {
+		task, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+			ExecuteAt: time.Now().Add(time.Hour),
+			TaskType:  taskType,
+			Payload:   json.RawMessage(`{}`),
+			DedupKey:  dedupKey,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		stored := store.tasks[task.ID]
+		stored.Status = "failed"
+		stored.Attempts = attempts
+		failedAt = failedAt.Add(time.Second)
+		stored.CompletedAt = sql.NullTime{Time: failedAt, Valid: true}
+		return stored
+	}
+
+	var retried []*models.ScheduledTask
+	for i := 0; i < 4; i++ {
+		retried = append(retried, fail("http_callback", "", 3))
+	}
+	exhausted := fail("http_callback", "", 10) // Еще одна попытка превысила бы MaxAttemptsLimit
+	other := fail("send_email", "", 1)
+	fail("http_callback", "order-1", 3)
+	createTestTask(t, s, "http_callback") // Pending задания не затрагиваются
+	busy := fail("http_callback", "order-2", 3)
+	if _, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour), TaskType: "http_callback", Payload: json.RawMessage(`{}`), DedupKey: "order-2",
+	}); err != nil {
+		t.Fatalf("Failed to create active task: %v", err)
+	}
+
+	// Лимит 3 берет самые давние упавшие задания
+	start := time.Now()
+	req := &models.BulkRequeueRequest{TaskType: "http_callback", Limit: 3, SpreadSeconds: 60}
+	requeued, err := s.RequeueFailedTasks(context.Background(), req)
+	if err != nil || requeued != 3 {
+		t.Fatalf("RequeueFailedTasks: got=%d (%v), want=3", requeued, err)
+	}
+	for i, task := range retried[:3] {
+		if task.Status != "pending" || task.MaxAttempts != 4 || task.CompletedAt.Valid {
+			t.Errorf("Task %d: got status=%s max_attempts=%d, want pending 4", i, task.Status, task.MaxAttempts)
+		}
+		offset := task.ExecuteAt.Sub(start)
+		if want := time.Duration(i) * 20 * time.Second; offset < want || offset > want+time.Second {
+			t.Errorf("Task %d execute_at offset: got=%v, want=%v", i, offset, want)
+		}
+	}
+
+	req.Limit = 100
+	if requeued, err = s.RequeueFailedTasks(context.Background(), req); err != nil || requeued != 2 {
+		t.Fatalf("Second RequeueFailedTasks: got=%d (%v), want=2 (the rest and order-1)", requeued, err)
+	}
+	if exhausted.Status != "failed" || other.Status != "failed" || busy.Status != "failed" {
+		t.Errorf("Statuses: got exhausted=%s other=%s busy=%s, want failed", exhausted.Status, other.Status, busy.Status)
+	}
+}
+
+// TestCreateTaskDedupWindow проверяет, что с dedup_window_seconds дубликатом считается
+// и уже завершенное задание с ключом, если оно создано в окне
+func TestCreateTaskDedupWindow(t *testing.T) {
+	s := newTestService()
+	req := func(window int) *models.CreateTaskRequest {
+		return &models.CreateTaskRequest{
+			ExecuteAt:   time.Now().Add(time.Hour),
+			TaskType:    "test_task",
+			Payload:     json.RawMessage(`{}`),
+			DedupKey:    "hourly-report",
+			DedupWindow: window,
+		}
+	}
+
+	first, err := s.CreateTask(context.Background(), req(3600))
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if _, err := s.CancelTask(context.Background(), first.ID, ""); err != nil {
+		t.Fatalf("Failed to cancel task: %v", err)
+	}
+
+	// Активного задания нет, но отмененное создано в окне
+	_, err = s.CreateTask(context.Background(), req(3600))
+	var duplicate *DuplicateTaskError
+	if !errors.As(err, &duplicate) || duplicate.Existing.ID != first.ID {
+		t.Fatalf("Create in window: got err=%v, want DuplicateTaskError with task %d", err, first.ID)
+	}
+
+	// Без окна ключ свободен
+	second, err := s.CreateTask(context.Background(), req(0))
+	if err != nil {
+		t.Fatalf("Failed to create task without window: %v", err)
+	}
+	if second.ID == first.ID {
+		t.Errorf("Expected a new task, got existing %d", first.ID)
+	}
+}
+
+// TestCreateTaskDedupKey проверяет, что dedup_key блокирует только активные задания
+func TestCreateTaskDedupKey(t *testing.T) {
+	s := newTestService()
+	req := func() *models.CreateTaskRequest {
+		return &models.CreateTaskRequest{
+			ExecuteAt: time.Now().Add(time.Hour),
+			TaskType:  "test_task",
+			Payload:   json.RawMessage(`{}`),
+			DedupKey:  "send-welcome-user-42",
+		}
+	}
+
+	first, err := s.CreateTask(context.Background(), req())
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	_, err = s.CreateTask(context.Background(), req())
+	var duplicate *DuplicateTaskError
+	if !errors.As(err, &duplicate) || !errors.Is(err, ErrDuplicateTask) {
+		t.Fatalf("Duplicate create error: got=%v, want=%v", err, ErrDuplicateTask)
+	}
+	if duplicate.Existing.ID != first.ID {
+		t.Errorf("Existing task: got id=%d, want=%d", duplicate.Existing.ID, first.ID)
+	}
+
+	// После отмены ключ освобождается
+	if _, err := s.CancelTask(context.Background(), first.ID, ""); err != nil {
+		t.Fatalf("Failed to cancel task: %v", err)
+	}
+	second, err := s.CreateTask(context.Background(), req())
+	if err != nil {
+		t.Fatalf("Create after cancel: %v", err)
+	}
+	if second.ID == first.ID || second.DedupKey == nil || *second.DedupKey != "send-welcome-user-42" {
+		t.Errorf("Unexpected task after cancel: %+v", second)
+	}
+}
+
+// TestListTasksFiltersAndPagination проверяет фильтр по типу, лимит по умолчанию и пагинацию
+func TestListTasksFiltersAndPagination(t *testing.T) {
+	s := newTestService()
+	for i := 0; i < 60; i++ {
+		createTestTask(t, s, fmt.Sprintf("type_%d", i%2))
+	}
+
+	tasks, total, err := s.ListTasks(context.Background(), models.ListTasksParams{})
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	if total != 60 || len(tasks) != 50 {
+		t.Errorf("Default page: got len=%d total=%d, want len=50 total=60", len(tasks), total)
+	}
+
+	tasks, total, err = s.ListTasks(context.Background(), models.ListTasksParams{TaskType: "type_1", Limit: 20, Offset: 20})
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	if total != 30 || len(tasks) != 10 {
+		t.Errorf("Filtered page: got len=%d total=%d, want len=10 total=30", len(tasks), total)
+	}
+	for _, task := range tasks {
+		if task.TaskType != "type_1" {
+			t.Errorf("Task type mismatch: got=%s, want=type_1", task.TaskType)
+		}
+	}
+}
+
+// TestListTasksErrorFilters проверяет фильтры has_error, error_contains и failure_reason
+func TestListTasksErrorFilters(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{})
+	errorsByTask := []struct{ message, reason string }{
+		{"", ""},
+		{"Read TIMEOUT after 30s", models.FailureTimeout},
+		{"connection refused", models.FailureConnectionRefused},
+		{"context deadline exceeded (timeout)", models.FailureTimeout},
+	}
+	for _, e := range errorsByTask {
+		task := createTestTask(t, s, "http_callback")
+		store.mu.Lock()
+		store.tasks[task.ID].ErrorMessage = sql.NullString{String: e.message, Valid: e.message != ""}
+		if e.reason != "" {
+			reason := e.reason
+			store.tasks[task.ID].FailureReason = &reason
+		}
+		store.mu.Unlock()
+	}
+
+	hasError, noError := true, false
+	testCases := []struct {
+		name   string
+		params models.ListTasksParams
+		want   int
+	}{
+		{"has_error=true", models.ListTasksParams{HasError: &hasError}, 3},
+		{"has_error=false", models.ListTasksParams{HasError: &noError}, 1},
+		{"error_contains case-insensitive", models.ListTasksParams{ErrorContains: "timeout"}, 2},
+		{"error_contains no match", models.ListTasksParams{ErrorContains: "refused%"}, 0},
+		{"failure_reason", models.ListTasksParams{FailureReason: models.FailureTimeout}, 2},
+		{"failure_reason no match", models.ListTasksParams{FailureReason: models.FailureHTTP5xx}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, total, err := s.ListTasks(context.Background(), tc.params)
+			if err != nil {
+				t.Fatalf("Failed to list tasks: %v", err)
+			}
+			if total != tc.want {
+				t.Errorf("Total: got=%d, want=%d", total, tc.want)
+			}
+		})
+	}
+}
+
+// TestListTasksDuration проверяет фильтры min_duration_ms / max_duration_ms и сортировку по duration_ms:
+// задания без длительности идут в конце при любом направлении
+func TestListTasksDuration(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{})
+	var ids []int64
+	for _, ms := range []int64{300, -1, 1200, 50} {
+		task := createTestTask(t, s, "http_callback")
+		ids = append(ids, task.ID)
+		if ms >= 0 {
+			duration := ms
+			store.mu.Lock()
+			store.tasks[task.ID].DurationMs = &duration
+			store.mu.Unlock()
+		}
+	}
+
+	min, max := int64(100), int64(1000)
+	testCases := []struct {
+		name   string
+		params models.ListTasksParams
+		want   []int64
+	}{
+		{"slowest first", models.ListTasksParams{Sort: models.ListSortDurationDesc}, []int64{ids[2], ids[0], ids[3], ids[1]}},
+		{"fastest first", models.ListTasksParams{Sort: models.ListSortDurationAsc}, []int64{ids[3], ids[0], ids[2], ids[1]}},
+		{"min_duration_ms", models.ListTasksParams{MinDurationMs: &min, Sort: models.ListSortDurationAsc}, []int64{ids[0], ids[2]}},
+		{"duration range", models.ListTasksParams{MinDurationMs: &min, MaxDurationMs: &max}, []int64{ids[0]}},
+		{"oldest first", models.ListTasksParams{Sort: models.ListSortCreatedAsc}, ids},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params.Limit = 10
+			tasks, _, err := s.ListTasks(context.Background(), tc.params)
+			if err != nil {
+				t.Fatalf("Failed to list tasks: %v", err)
+			}
+			var got []int64
+			for _, task := range tasks {
+				got = append(got, task.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("IDs: got=%v, want=%v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestCreateTaskDefaultQueue проверяет очередь по умолчанию и фильтр списка по очереди
+func TestCreateTaskDefaultQueue(t *testing.T) {
+	s := newTestService()
+	task := createTestTask(t, s, "test_task")
+	if task.Queue != models.DefaultQueue {
+		t.Errorf("Queue: got=%s, want=%s", task.Queue, models.DefaultQueue)
+	}
+
+	_, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt: time.Now().Add(time.Hour),
+		TaskType:  "test_task",
+		Queue:     "high",
+		Payload:   json.RawMessage(`{}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+
+	tasks, total, err := s.ListTasks(context.Background(), models.ListTasksParams{Queue: "high"})
+	if err != nil {
+		t.Fatalf("Failed to list tasks: %v", err)
+	}
+	if total != 1 || tasks[0].Queue != "high" {
+		t.Errorf("Queue filter: got total=%d tasks=%+v, want one task in queue high", total, tasks)
+	}
+}
+
+// TestClaimAndFinishTasks проверяет захват заданий внешним клиентом и отчет с токеном аренды
+func TestClaimAndFinishTasks(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute})
+	emailTask := createTestTask(t, s, "email")
+	callbackTask := createTestTask(t, s, "http_callback")
+	future := createTestTask(t, s, "email")
+
+	// Делаем первые два задания готовыми к выполнению
+	store.mu.Lock()
+	store.tasks[emailTask.ID].ExecuteAt = time.Now().Add(-time.Minute)
+	store.tasks[callbackTask.ID].ExecuteAt = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+
+	if _, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{LeaseSeconds: 120}); !errors.Is(err, ErrInvalidLease) {
+		t.Errorf("Claim with lease over limit error: got=%v, want=%v", err, ErrInvalidLease)
+	}
+
+	claimed, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{TaskTypes: []string{"email"}})
+	if err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+	if len(claimed) != 1 || claimed[0].ID != emailTask.ID {
+		t.Fatalf("Claimed tasks: got=%d, want only task %d (due email task)", len(claimed), emailTask.ID)
+	}
+	task := claimed[0]
+	if task.Status != "processing" || task.Attempts != 1 || task.LeaseToken == nil || task.LockedUntil == nil {
+		t.Fatalf("Claimed task: got status=%s attempts=%d lease=%v, want processing 1 with lease", task.Status, task.Attempts, task.LeaseToken)
+	}
+	if lease := time.Until(*task.LockedUntil); lease <= 0 || lease > 30*time.Second {
+		t.Errorf("Lease: got=%v, want default 30s", lease)
+	}
+
+	if _, err := s.CompleteTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: "wrong"}); err != ErrLeaseMismatch {
+		t.Errorf("Complete with wrong token error: got=%v, want=%v", err, ErrLeaseMismatch)
+	}
+	if _, err := s.CompleteTask(context.Background(), 999, &models.FinishTaskRequest{LeaseToken: "wrong"}); err != ErrTaskNotFound {
+		t.Errorf("Complete missing task error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+
+	failed, err := s.FailTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: *task.LeaseToken, ErrorMessage: "SMTP timeout"})
+	if err != nil {
+		t.Fatalf("Failed to report failure: %v", err)
+	}
+	if failed.Status != "pending" || failed.LeaseToken != nil || failed.ErrorMessage.String != "SMTP timeout" {
+		t.Errorf("Failed task: got status=%s lease=%v error=%q, want pending without lease", failed.Status, failed.LeaseToken, failed.ErrorMessage.String)
+	}
+	if failed.FailureReason == nil || *failed.FailureReason != models.FailureUnknown {
+		t.Errorf("Failure reason: got=%v, want=%s by default", failed.FailureReason, models.FailureUnknown)
+	}
+
+	// Повторный отчет со старым токеном отклоняется
+	if _, err := s.CompleteTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: *task.LeaseToken}); err != ErrLeaseMismatch {
+		t.Errorf("Complete with stale token error: got=%v, want=%v", err, ErrLeaseMismatch)
+	}
+
+	claimed, err = s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{})
+	if err != nil {
+		t.Fatalf("Failed to claim tasks: %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].ID != emailTask.ID || claimed[1].ID != callbackTask.ID {
+		t.Fatalf("Claimed tasks: got=%d, want tasks %d and %d in execute_at order", len(claimed), emailTask.ID, callbackTask.ID)
+	}
+
+	completed, err := s.CompleteTask(context.Background(), callbackTask.ID, &models.FinishTaskRequest{
+		LeaseToken: *claimed[1].LeaseToken,
+		Result:     json.RawMessage(`{"status_code":200}`),
+		Warning:    "2 of 3 recipients rejected",
+	})
+	if err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	if completed.Status != "completed" || completed.Result == nil || string(*completed.Result) != `{"status_code":200}` {
+		t.Errorf("Completed task: got status=%s result=%v", completed.Status, completed.Result)
+	}
+	if completed.Warning == nil || *completed.Warning != "2 of 3 recipients rejected" || completed.ErrorMessage.Valid {
+		t.Errorf("Completed task: got warning=%v error_message=%v, want warning only", completed.Warning, completed.ErrorMessage)
+	}
+
+	if got, _ := s.GetTask(context.Background(), future.ID); got.Status != "pending" {
+		t.Errorf("Future task status: got=%s, want=pending", got.Status)
+	}
+}
+
+// TestFinishLeasedTaskChain проверяет, что задание, выполненное внешним клиентом, создает задание цепочки
+// так же, как у worker'а: on_success после завершения и on_failure после исчерпания попыток
+func TestFinishLeasedTaskChain(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute, MaxAttemptsLimit: 100})
+	create := func(maxAttempts int) *models.ScheduledTask {
+		task, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+			ExecuteAt:   time.Now().Add(time.Hour),
+			TaskType:    "email",
+			Payload:     json.RawMessage(`{}`),
+			MaxAttempts: maxAttempts,
+			OnSuccess:   &models.FollowUpTask{TaskType: "http_callback", Payload: json.RawMessage(`{"step":"success"}`), Delay: 60},
+			OnFailure:   &models.FollowUpTask{TaskType: "http_callback", Payload: json.RawMessage(`{"step":"failure"}`)},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		store.mu.Lock()
+		store.tasks[task.ID].ExecuteAt = time.Now().Add(-time.Minute)
+		store.mu.Unlock()
+		return task
+	}
+	claim := func() *models.ScheduledTask {
+		claimed, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{TaskTypes: []string{"email"}, Limit: 1})
+		if err != nil || len(claimed) != 1 {
+			t.Fatalf("Failed to claim task: %v (claimed %d)", err, len(claimed))
+		}
+		return claimed[0]
+	}
+	children := func(parentID int64) []*models.ScheduledTask {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		var found []*models.ScheduledTask
+		for _, task := range store.tasks {
+			if task.ParentID != nil && *task.ParentID == parentID {
+				found = append(found, task)
+			}
+		}
+		return found
+	}
+
+	succeeded := create(3)
+	task := claim()
+	if _, err := s.CompleteTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: *task.LeaseToken}); err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	if got := children(succeeded.ID); len(got) != 1 || string(got[0].Payload) != `{"step":"success"}` ||
+		got[0].Status != "pending" || got[0].Queue != models.DefaultQueue || got[0].MaxAttempts != 3 {
+		t.Fatalf("on_success child of completed task: got %+v, want one pending task from on_success", got)
+	} else if delay := time.Until(got[0].ExecuteAt); delay < 50*time.Second || delay > time.Minute {
+		t.Errorf("on_success child execute_at: got in %v, want in delay_seconds (60s)", delay)
+	}
+
+	// Неудачная попытка с оставшимися попытками не создает задание цепочки, окончательная - создает из on_failure
+	failed := create(2)
+	for attempt := 1; attempt <= 2; attempt++ {
+		task = claim()
+		if _, err := s.FailTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: *task.LeaseToken, ErrorMessage: "SMTP timeout"}); err != nil {
+			t.Fatalf("Failed to report failure: %v", err)
+		}
+		store.mu.Lock()
+		store.tasks[failed.ID].ExecuteAt = time.Now().Add(-time.Minute)
+		store.mu.Unlock()
+
+		got := children(failed.ID)
+		if attempt == 1 && len(got) != 0 {
+			t.Errorf("Retried task must not chain, got %d children", len(got))
+		}
+		if attempt == 2 && (len(got) != 1 || string(got[0].Payload) != `{"step":"failure"}`) {
+			t.Errorf("on_failure child of failed task: got %+v, want one task from on_failure", got)
+		}
+	}
+}
+
+// TestExtendLease проверяет продление аренды: срок отсчитывается от текущего момента и ограничен лимитом,
+// продлить можно только действующую аренду с верным токеном
+func TestExtendLease(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute})
+	task := createTestTask(t, s, "email")
+	store.mu.Lock()
+	store.tasks[task.ID].ExecuteAt = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+
+	claimed, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{LeaseSeconds: 5})
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Claim: got %d tasks (%v), want 1", len(claimed), err)
+	}
+	token := *claimed[0].LeaseToken
+
+	if _, err := s.ExtendLease(context.Background(), task.ID, &models.ExtendLeaseRequest{LeaseToken: token, LeaseSeconds: 120}); !errors.Is(err, ErrInvalidLease) {
+		t.Errorf("Extend over limit error: got=%v, want=%v", err, ErrInvalidLease)
+	}
+	if _, err := s.ExtendLease(context.Background(), task.ID, &models.ExtendLeaseRequest{LeaseToken: "wrong"}); err != ErrLeaseMismatch {
+		t.Errorf("Extend with wrong token error: got=%v, want=%v", err, ErrLeaseMismatch)
+	}
+	if _, err := s.ExtendLease(context.Background(), 999, &models.ExtendLeaseRequest{LeaseToken: token}); err != ErrTaskNotFound {
+		t.Errorf("Extend missing task error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+
+	extended, err := s.ExtendLease(context.Background(), task.ID, &models.ExtendLeaseRequest{LeaseToken: token, LeaseSeconds: 60})
+	if err != nil {
+		t.Fatalf("Failed to extend lease: %v", err)
+	}
+	if lease := time.Until(*extended.LockedUntil); lease <= 55*time.Second || lease > time.Minute {
+		t.Errorf("Extended lease: got=%v, want 60s", lease)
+	}
+	if extended.Status != "processing" || extended.Attempts != 1 || *extended.LeaseToken != token {
+		t.Errorf("Extended task: got status=%s attempts=%d, want processing 1 with the same token", extended.Status, extended.Attempts)
+	}
+
+	// Без lease_seconds - срок по умолчанию
+	extended, err = s.ExtendLease(context.Background(), task.ID, &models.ExtendLeaseRequest{LeaseToken: token})
+	if err != nil {
+		t.Fatalf("Failed to extend lease: %v", err)
+	}
+	if lease := time.Until(*extended.LockedUntil); lease <= 25*time.Second || lease > 30*time.Second {
+		t.Errorf("Extended lease: got=%v, want default 30s", lease)
+	}
+
+	if _, err := s.CompleteTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: token}); err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+	if _, err := s.ExtendLease(context.Background(), task.ID, &models.ExtendLeaseRequest{LeaseToken: token}); err != ErrLeaseMismatch {
+		t.Errorf("Extend completed task error: got=%v, want=%v", err, ErrLeaseMismatch)
+	}
+}
+
+// TestClaimPriorityAging проверяет, что высокоприоритетные задания захватываются раньше, но долго ожидающее
+// низкоприоритетное задание захватывается, хотя высокоприоритетные поступают быстрее, чем их разбирают
+func TestClaimPriorityAging(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute})
+
+	create := func(priority int) int64 {
+		t.Helper()
+		task, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+			ExecuteAt: time.Now().Add(time.Hour),
+			TaskType:  "email",
+			Payload:   json.RawMessage(`{"to":"user@example.com"}`),
+			Priority:  priority,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create task: %v", err)
+		}
+		return task.ID
+	}
+
+	// Каждую минуту поступают два задания с priority 10, а захватывается одно
+	low := create(0)
+	lowClaimedAt := -1
+	for minute := 0; minute < 60 && lowClaimedAt < 0; minute++ {
+		high := []int64{create(10), create(10)}
+
+		store.mu.Lock()
+		now := time.Now()
+		for _, id := range high {
+			store.tasks[id].ExecuteAt = now
+		}
+		if minute == 0 {
+			store.tasks[low].ExecuteAt = now
+		}
+		store.mu.Unlock()
+
+		claimed, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{Limit: 1})
+		if err != nil || len(claimed) != 1 {
+			t.Fatalf("Minute %d: claimed %d tasks, err=%v, want 1", minute, len(claimed), err)
+		}
+		if claimed[0].ID == low {
+			lowClaimedAt = minute
+		}
+
+		// Проходит минута ожидания для всех незахваченных заданий
+		store.mu.Lock()
+		for _, task := range store.tasks {
+			if task.Status == "pending" {
+				task.ExecuteAt = task.ExecuteAt.Add(-time.Minute)
+			}
+		}
+		store.mu.Unlock()
+	}
+
+	// Задания, поступившие в первые 10 минут, обгоняют низкоприоритетное; затем его старение
+	// сравнивается с приоритетом ожидающих высокоприоритетных заданий
+	if lowClaimedAt < 10 || lowClaimedAt > 30 {
+		t.Errorf("Low priority task claimed at minute %d, want between 10 and 30", lowClaimedAt)
+	}
+}
+
+// TestCompleteRecurringTask проверяет, что выполненное повторяющееся задание возвращается в очередь
+// со следующим execute_at, пока не исчерпано max_executions
+func TestCompleteRecurringTask(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{DefaultLease: 30 * time.Second, MaxLease: time.Minute})
+	task, err := s.CreateTask(context.Background(), &models.CreateTaskRequest{
+		ExecuteAt:     time.Now().Add(time.Hour),
+		TaskType:      "report",
+		Payload:       json.RawMessage(`{}`),
+		Interval:      "15m",
+		MaxExecutions: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create task: %v", err)
+	}
+	if task.Interval == nil || time.Duration(*task.Interval) != 15*time.Minute {
+		t.Fatalf("Interval: got=%v, want 15m", task.Interval)
+	}
+
+	// Первое выполнение наступило минуту назад
+	firstRun := time.Now().Add(-time.Minute).Truncate(time.Second)
+	store.mu.Lock()
+	store.tasks[task.ID].ExecuteAt = firstRun
+	store.mu.Unlock()
+
+	complete := func() *models.ScheduledTask {
+		t.Helper()
+		claimed, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{})
+		if err != nil || len(claimed) != 1 {
+			t.Fatalf("Claim: got %d tasks, err=%v, want 1", len(claimed), err)
+		}
+		done, err := s.CompleteTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: *claimed[0].LeaseToken})
+		if err != nil {
+			t.Fatalf("Failed to complete task: %v", err)
+		}
+		return done
+	}
+
+	done := complete()
+	if done.Status != "pending" || done.Executions != 1 || done.Attempts != 0 || done.CompletedAt.Valid {
+		t.Errorf("After first run: got status=%s executions=%d attempts=%d, want pending 1 0", done.Status, done.Executions, done.Attempts)
+	}
+	if want := firstRun.Add(15 * time.Minute); !done.ExecuteAt.Equal(want) {
+		t.Errorf("Next run: got=%v, want=%v (previous execute_at + interval)", done.ExecuteAt, want)
+	}
+
+	store.mu.Lock()
+	store.tasks[task.ID].ExecuteAt = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+
+	if done := complete(); done.Status != "completed" || done.Executions != 2 {
+		t.Errorf("After last run: got status=%s executions=%d, want completed 2", done.Status, done.Executions)
+	}
+}
+
+// TestNextRun проверяет расчет следующего выполнения, пропуск пропущенных выполнений, конец серии и привязку align
+func TestNextRun(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	interval := models.Interval(time.Hour)
+	two := 2
+	endAt := start.Add(90 * time.Minute)
+	day := models.Interval(24 * time.Hour)
+	hour, dayAlign := models.AlignHour, models.AlignDay
+	everyFive, moscow, invalid := "*/5 * * * *", "CRON_TZ=Europe/Moscow 0 9 * * *", "61 * * * *"
+
+	tests := []struct {
+		name     string
+		task     models.ScheduledTask
+		now      time.Time
+		wantNext time.Time
+		wantOK   bool
+	}{
+		{"one-off", models.ScheduledTask{ExecuteAt: start}, start, time.Time{}, false},
+		{"on time", models.ScheduledTask{ExecuteAt: start, Interval: &interval}, start.Add(time.Minute), start.Add(time.Hour), true},
+		{"after outage", models.ScheduledTask{ExecuteAt: start, Interval: &interval}, start.Add(5*time.Hour + time.Minute), start.Add(6 * time.Hour), true},
+		{"max executions", models.ScheduledTask{ExecuteAt: start, Interval: &interval, MaxExecutions: &two, Executions: 1}, start, time.Time{}, false},
+		{"end_at", models.ScheduledTask{ExecuteAt: start.Add(time.Hour), Interval: &interval, EndAt: &endAt}, start.Add(time.Hour), time.Time{}, false},
+		{"align down", models.ScheduledTask{ExecuteAt: start.Add(17 * time.Minute), Interval: &interval, Align: &hour}, start.Add(18 * time.Minute), start.Add(time.Hour), true},
+		{"align up", models.ScheduledTask{ExecuteAt: start.Add(40 * time.Minute), Interval: &interval, Align: &hour}, start.Add(41 * time.Minute), start.Add(2 * time.Hour), true},
+		{"align past boundary", models.ScheduledTask{ExecuteAt: start.Add(-9 * time.Hour), Interval: &day, Align: &dayAlign}, start.Add(14 * time.Hour), start.Add(36 * time.Hour), true},
+		{"cron", models.ScheduledTask{ExecuteAt: start, CronExpression: &everyFive}, start.Add(7 * time.Minute), start.Add(10 * time.Minute), true},
+		{"cron on boundary", models.ScheduledTask{ExecuteAt: start, CronExpression: &everyFive}, start.Add(5 * time.Minute), start.Add(10 * time.Minute), true},
+		{"cron time zone", models.ScheduledTask{ExecuteAt: start, CronExpression: &moscow}, start, start.Add(18 * time.Hour), true},
+		{"cron end_at", models.ScheduledTask{ExecuteAt: start, CronExpression: &everyFive, EndAt: &endAt}, start.Add(90 * time.Minute), time.Time{}, false},
+		{"cron max executions", models.ScheduledTask{ExecuteAt: start, CronExpression: &everyFive, MaxExecutions: &two, Executions: 1}, start, time.Time{}, false},
+		{"invalid cron", models.ScheduledTask{ExecuteAt: start, CronExpression: &invalid}, start, time.Time{}, false},
+	}
+
+	for _, tc := range tests {
+		next, ok := tc.task.NextRun(tc.now)
+		if ok != tc.wantOK || !next.Equal(tc.wantNext) {
+			t.Errorf("%s: got=%v %v, want=%v %v", tc.name, next, ok, tc.wantNext, tc.wantOK)
+		}
+	}
+}
+
+// TestReadStore проверяет, что чтения идут в ReadStore, а записи и проверки перед записью - в основное хранилище
+func TestReadStore(t *testing.T) {
+	primary := NewMemoryTaskStore()
+	replica := NewMemoryTaskStore()
+	s := NewTaskService(primary, Options{ReadStore: replica})
+
+	// Реплика еще не получила задание: чтение его не видит, но запись через основное хранилище работает
+	task := createTestTask(t, s, "test_task")
+	if _, err := s.GetTask(context.Background(), task.ID); err != ErrTaskNotFound {
+		t.Errorf("GetTask: got err=%v, want ErrTaskNotFound from read store", err)
+	}
+	if _, total, _ := s.ListTasks(context.Background(), models.ListTasksParams{}); total != 0 {
+		t.Errorf("ListTasks: got total=%d, want 0 from read store", total)
+	}
+	if _, err := s.HoldTask(context.Background(), task.ID); err != nil {
+		t.Errorf("HoldTask: got err=%v, want nil (checks must use primary store)", err)
+	}
+	if got, _ := primary.GetTask(context.Background(), task.ID); got.Status != "hold" {
+		t.Errorf("Primary status: got=%s, want=hold", got.Status)
+	}
+}
+
+// fakePayloadStore запоминает сохраненные payload вместо S3
+type fakePayloadStore struct {
+	objects map[string][]byte
+}
+
+func (f *fakePayloadStore) Put(ctx context.Context, payload []byte) (string, error) {
+	ref := fmt.Sprintf("s3://test/payloads/%d.json", len(f.objects)+1)
+	f.objects[ref] = payload
+	return ref, nil
+}
+
+// TestCreateTaskPayloadRef проверяет, что большой payload выносится в хранилище, а маленький остается в задании
+func TestCreateTaskPayloadRef(t *testing.T) {
+	payloads := &fakePayloadStore{objects: make(map[string][]byte)}
+	s := NewTaskService(NewMemoryTaskStore(), Options{PayloadStore: payloads, PayloadRefThreshold: 32})
+	ctx := context.Background()
+
+	large := json.RawMessage(`{"html":"` + strings.Repeat("x", 64) + `"}`)
+	task, err := s.CreateTask(ctx, &models.CreateTaskRequest{ExecuteAt: time.Now().Add(time.Hour), TaskType: "email", Payload: large})
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	if task.PayloadRef == nil || string(payloads.objects[*task.PayloadRef]) != string(large) {
+		t.Fatalf("Expected payload in store under payload_ref, got ref=%v", task.PayloadRef)
+	}
+	if string(task.Payload) != `{}` {
+		t.Errorf("Payload: got=%s, want={}", task.Payload)
+	}
+
+	small := createTestTask(t, s, "test_task")
+	if small.PayloadRef != nil || string(small.Payload) != `{"key":"value"}` {
+		t.Errorf("Small payload must stay inline, got payload=%s ref=%v", small.Payload, small.PayloadRef)
+	}
+}
+
+// TestTaskHistory проверяет, что каждая смена статуса попадает в историю задания по порядку
+func TestTaskHistory(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{})
+	task := createTestTask(t, s, "email")
+
+	if _, err := s.HoldTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Failed to hold task: %v", err)
+	}
+	if _, err := s.UnholdTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Failed to unhold task: %v", err)
+	}
+
+	store.mu.Lock()
+	store.tasks[task.ID].ExecuteAt = time.Now().Add(-time.Second)
+	store.mu.Unlock()
+	claimed, err := s.ClaimTasks(context.Background(), &models.ClaimTasksRequest{})
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Claim: got %d tasks, err=%v, want 1", len(claimed), err)
+	}
+	if _, err := s.CompleteTask(context.Background(), task.ID, &models.FinishTaskRequest{LeaseToken: *claimed[0].LeaseToken}); err != nil {
+		t.Fatalf("Failed to complete task: %v", err)
+	}
+
+	history, err := s.GetTaskHistory(context.Background(), task.ID)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	want := []string{"→pending", "pending→hold", "hold→pending", "pending→processing", "processing→completed"}
+	if history.Status != "completed" || len(history.Transitions) != len(want) {
+		t.Fatalf("History: got status=%s transitions=%+v, want completed with %d transitions", history.Status, history.Transitions, len(want))
+	}
+	for i, transition := range history.Transitions {
+		if got := transition.From + "→" + transition.To; got != want[i] {
+			t.Errorf("Transition %d: got=%s, want=%s", i, got, want[i])
+		}
+		if transition.At.IsZero() || transition.By == "" {
+			t.Errorf("Transition %d: got at=%v by=%q, want time and actor", i, transition.At, transition.By)
+		}
+		if i > 0 && transition.At.Before(history.Transitions[i-1].At) {
+			t.Errorf("Transition %d is earlier than the previous one", i)
+		}
+	}
+
+	if _, err := s.GetTaskHistory(context.Background(), 999); err != ErrTaskNotFound {
+		t.Errorf("Missing task history error: got=%v, want=%v", err, ErrTaskNotFound)
+	}
+}
+
+// TestUpsertTaskByKey проверяет создание задания по ключу, замену времени и payload активного задания
+// со сбросом результатов прошлых выполнений и отказ заменить выполняющееся задание
+func TestUpsertTaskByKey(t *testing.T) {
+	store := NewMemoryTaskStore()
+	s := NewTaskService(store, Options{})
+	request := func(executeAt time.Time, payload string) *models.CreateTaskRequest {
+		return &models.CreateTaskRequest{ExecuteAt: executeAt, TaskType: "email", Payload: json.RawMessage(payload)}
+	}
+
+	first, created, err := s.UpsertTaskByKey(context.Background(), "report:42", request(time.Now().Add(time.Hour), `{"v": 1}`))
+	if err != nil || !created {
+		t.Fatalf("First upsert: created=%v (%v), want created", created, err)
+	}
+	if first.DedupKey == nil || *first.DedupKey != "report:42" {
+		t.Errorf("Dedup key: got=%v, want report:42", first.DedupKey)
+	}
+
+	// Задание на паузе после неудачных попыток: замена сбрасывает их следы, но не снимает паузу
+	stale := "stale"
+	store.mu.Lock()
+	store.tasks[first.ID].Status = "hold"
+	store.tasks[first.ID].Attempts = 2
+	store.tasks[first.ID].Executions = 3
+	store.tasks[first.ID].ErrorMessage = sql.NullString{String: "HTTP 500", Valid: true}
+	store.tasks[first.ID].Warning = &stale
+	store.tasks[first.ID].FailureReason = &stale
+	store.mu.Unlock()
+
+	executeAt := time.Now().Add(2 * time.Hour)
+	second, created, err := s.UpsertTaskByKey(context.Background(), "report:42", request(executeAt, `{"v": 2}`))
+	if err != nil || created {
+		t.Fatalf("Second upsert: created=%v (%v), want replaced", created, err)
+	}
+	if second.Status != "hold" || second.Attempts != 0 || second.Executions != 0 || second.ErrorMessage.Valid ||
+		second.Warning != nil || second.FailureReason != nil {
+		t.Errorf("Replaced task: got status=%s attempts=%d executions=%d error=%v warning=%v failure_reason=%v, want hold with reset history",
+			second.Status, second.Attempts, second.Executions, second.ErrorMessage, second.Warning, second.FailureReason)
+	}
+	if second.ID != first.ID || !second.ExecuteAt.Equal(executeAt) || string(second.Payload) != `{"v": 2}` {
+		t.Errorf("Replaced task: got id=%d execute_at=%v payload=%s, want id=%d execute_at=%v payload={\"v\": 2}",
+			second.ID, second.ExecuteAt, second.Payload, first.ID, executeAt)
+	}
+	if tasks, _, _ := s.ListTasks(context.Background(), models.ListTasksParams{Limit: 10}); len(tasks) != 1 {
+		t.Errorf("Tasks after upsert: got=%d, want 1", len(tasks))
+	}
+
+	// Выполняющееся задание не заменяется
+	store.mu.Lock()
+	store.tasks[first.ID].Status = "processing"
+	store.mu.Unlock()
+	if _, _, err := s.UpsertTaskByKey(context.Background(), "report:42", request(executeAt, `{}`)); err != ErrTaskInProgress {
+		t.Errorf("Upsert of processing task error: got=%v, want=%v", err, ErrTaskInProgress)
+	}
+
+	// После завершения задания ключ свободен: создается новое задание
+	store.mu.Lock()
+	store.tasks[first.ID].Status = "completed"
+	store.mu.Unlock()
+	third, created, err := s.UpsertTaskByKey(context.Background(), "report:42", request(executeAt, `{}`))
+	if err != nil || !created || third.ID == first.ID {
+		t.Errorf("Upsert after completion: created=%v (%v), want a new task", created, err)
+	}
+}
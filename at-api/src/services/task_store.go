@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"at-api/models"
+)
+
+// TaskStore описывает хранилище заданий, от которого зависит TaskService.
+// Валидация и значения по умолчанию - забота TaskService, хранилище только читает и пишет данные.
+// Реализации:
+//   - PostgresTaskStore: таблица scheduled_tasks в PostgreSQL
+//   - MemoryTaskStore: хранение в памяти для тестов без БД
+type TaskStore interface {
+	// CreateTask сохраняет новое задание в статусе 'pending' и возвращает его.
+	// Если активное задание с тем же dedup_key уже есть - ErrDuplicateTask
+	CreateTask(ctx context.Context, req *models.CreateTaskRequest) (*models.ScheduledTask, error)
+	// CreateTasks сохраняет все задания одной транзакцией, вставляя их частями не больше chunkSize
+	// (0 - наибольшая часть, допустимая хранилищем). Возвращает задания в порядке reqs.
+	// Если хотя бы одно задание нарушает уникальность dedup_key - ErrDuplicateTask, и не создается ни одно
+	CreateTasks(ctx context.Context, reqs []models.CreateTaskRequest, chunkSize int) ([]*models.ScheduledTask, error)
+	// GetTaskByDedupKey возвращает задание с ключом: активное, а если его нет - последнее созданное; иначе ErrTaskNotFound
+	GetTaskByDedupKey(ctx context.Context, key string) (*models.ScheduledTask, error)
+	// GetRecentTaskByDedupKey возвращает последнее задание с ключом (в любом статусе), созданное не раньше,
+	// чем window назад по времени БД; иначе ErrTaskNotFound
+	GetRecentTaskByDedupKey(ctx context.Context, key string, window time.Duration) (*models.ScheduledTask, error)
+	// GetActiveTaskByDedupKey возвращает активное ('pending', 'processing' или 'hold') задание с ключом или ErrTaskNotFound
+	GetActiveTaskByDedupKey(ctx context.Context, key string) (*models.ScheduledTask, error)
+	// GetTask возвращает задание по ID или ErrTaskNotFound
+	GetTask(ctx context.Context, id int64) (*models.ScheduledTask, error)
+	// GetTaskHistory возвращает текущий статус задания и историю смен статуса или ErrTaskNotFound
+	GetTaskHistory(ctx context.Context, id int64) (*models.TaskHistory, error)
+	// CancelTask переводит задание в 'cancelled', если оно в 'pending', 'processing' или 'hold', иначе ErrTaskNotFound.
+	// Непустая reason сохраняется в cancel_reason
+	CancelTask(ctx context.Context, id int64, reason string) (*models.ScheduledTask, error)
+	// TransitionStatus меняет статус задания с from на to, если оно все еще в статусе from, иначе ErrTaskNotFound
+	TransitionStatus(ctx context.Context, id int64, from, to string) (*models.ScheduledTask, error)
+	// RequeueTask возвращает задание из 'failed' в 'pending' с новым max_attempts,
+	// если оно все еще 'failed' и maxAttempts больше attempts, иначе ErrTaskNotFound.
+	// Если уже есть активное задание с тем же dedup_key - ErrDuplicateTask
+	RequeueTask(ctx context.Context, id int64, maxAttempts int) (*models.ScheduledTask, error)
+	// RequeueFailedTasks возвращает в 'pending' до limit заданий 'failed', подходящих под params, начиная с давно
+	// упавших: max_attempts = attempts + 1, execute_at равномерно распределяется по окну spread от текущего момента.
+	// Пропускает задания, у которых attempts + 1 превысил бы maxAttemptsLimit (если > 0), и задания с dedup_key,
+	// у которого есть активное задание (из нескольких упавших с одним ключом берется последнее). Возвращает их число
+	RequeueFailedTasks(ctx context.Context, params models.ListTasksParams, limit int, spread time.Duration, maxAttemptsLimit int) (int, error)
+	// ClaimTasks захватывает до req.Limit готовых pending заданий для внешнего клиента:
+	// переводит их в 'processing' и выдает аренду (lease_token = leaseToken-ID, locked_until = сейчас + lease)
+	ClaimTasks(ctx context.Context, req *models.ClaimTasksRequest, leaseToken string, lease time.Duration) ([]*models.ScheduledTask, error)
+	// UpsertTaskByKey создает задание с ключом req.DedupKey или, если есть задание с этим ключом в 'pending'
+	// или 'hold', заменяет его описание (execute_at, payload и остальные поля запроса создания) и сбрасывает attempts.
+	// created сообщает, что задание создано. Задание с ключом в 'processing' не меняется: ErrTaskInProgress
+	UpsertTaskByKey(ctx context.Context, req *models.CreateTaskRequest) (task *models.ScheduledTask, created bool, err error)
+	// ExtendLease продлевает аренду задания: locked_until = сейчас + lease,
+	// если оно в 'processing' с этим токеном аренды, иначе ErrTaskNotFound
+	ExtendLease(ctx context.Context, id int64, leaseToken string, lease time.Duration) (*models.ScheduledTask, error)
+	// CompleteLeasedTask переводит задание в 'completed' (с предупреждением, если warning не пустой),
+	// если оно в 'processing' с этим токеном аренды, иначе ErrTaskNotFound
+	CompleteLeasedTask(ctx context.Context, id int64, leaseToken, warning string, result json.RawMessage) (*models.ScheduledTask, error)
+	// FailLeasedTask возвращает задание в 'pending' (или 'failed', если попытки исчерпаны),
+	// если оно в 'processing' с этим токеном аренды, иначе ErrTaskNotFound
+	FailLeasedTask(ctx context.Context, id int64, leaseToken, errorMessage, failureReason string, result json.RawMessage) (*models.ScheduledTask, error)
+	// ListTasks возвращает страницу заданий (новые первыми) и общее количество по фильтрам
+	ListTasks(ctx context.Context, params models.ListTasksParams) ([]models.ScheduledTask, int, error)
+	// ExportTasks передает в fn по одному все задания по фильтрам params в порядке params.Sort,
+	// без пагинации (Limit и Offset не учитываются). Ошибка fn прерывает выгрузку и возвращается как есть
+	ExportTasks(ctx context.Context, params models.ListTasksParams, fn func(*models.ScheduledTask) error) error
+}
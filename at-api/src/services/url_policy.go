@@ -0,0 +1,158 @@
+// Файл url_policy.go - проверка url заданий http_callback при создании (защита от SSRF).
+// Задания создают пользователи API, поэтому url не должен вести во внутреннюю сеть (сервис метаданных облака
+// 169.254.169.254, localhost, приватные подсети). Здесь url проверяется без DNS - по имени хоста и IP-адресу,
+// указанному в url; worker повторяет проверку перед запросом по фактическим адресам (at-worker, urlPolicy).
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrBlockedURL возвращается, когда url задания http_callback запрещен политикой адресов
+var ErrBlockedURL = errors.New("url is blocked by callback policy")
+
+// hostRules - список хостов и подсетей allowlist или denylist.
+// Элемент списка - CIDR (10.0.0.0/8), IP-адрес, имя хоста (api.example.com) или "*.example.com" (любой поддомен)
+type hostRules struct {
+	hosts []string
+	nets  []*net.IPNet
+}
+
+// parseHostRules разбирает элементы списка; CIDR должны быть уже проверены при загрузке конфигурации
+func parseHostRules(entries []string) hostRules {
+	var rules hostRules
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if entry == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			rules.nets = append(rules.nets, ipNet)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			rules.nets = append(rules.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		rules.hosts = append(rules.hosts, entry)
+	}
+	return rules
+}
+
+func (r hostRules) empty() bool {
+	return len(r.hosts) == 0 && len(r.nets) == 0
+}
+
+// matchHost проверяет имя хоста (в нижнем регистре) по именам списка
+func (r hostRules) matchHost(host string) bool {
+	for _, rule := range r.hosts {
+		if rule == host || strings.HasPrefix(rule, "*.") && strings.HasSuffix(host, rule[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIP проверяет адрес по подсетям списка
+func (r hostRules) matchIP(ip net.IP) bool {
+	for _, ipNet := range r.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlPolicy - политика адресов http_callback. Denylist проверяется первым и побеждает всегда.
+// Если allowlist не пуст, разрешены только его хосты и подсети (в том числе приватные).
+// Без allowlist при blockPrivate запрещены приватные, loopback и link-local адреса.
+// Та же логика в at-worker (urlPolicy), там адреса проверяются еще и после DNS.
+type urlPolicy struct {
+	allow        hostRules
+	deny         hostRules
+	blockPrivate bool
+}
+
+// newURLPolicy создает политику адресов; без списков и blockPrivate возвращает nil (без ограничений)
+func newURLPolicy(allow, deny []string, blockPrivate bool) *urlPolicy {
+	p := &urlPolicy{allow: parseHostRules(allow), deny: parseHostRules(deny), blockPrivate: blockPrivate}
+	if p.allow.empty() && p.deny.empty() && !blockPrivate {
+		return nil
+	}
+	return p
+}
+
+// checkURL проверяет url: схема http или https, хост не запрещен. Имя хоста без DNS проверяется только
+// по спискам (и localhost): если allowlist состоит из подсетей, хост по имени проверит worker
+func (p *urlPolicy) checkURL(raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: invalid url", ErrBlockedURL)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrBlockedURL)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(target.Hostname()), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(ip)
+	}
+	switch {
+	case p.deny.matchHost(host):
+		return fmt.Errorf("%w: host %s is in the denylist", ErrBlockedURL, host)
+	case p.allow.matchHost(host):
+		return nil
+	case len(p.allow.hosts) > 0 && len(p.allow.nets) == 0:
+		return fmt.Errorf("%w: host %s is not in the allowlist", ErrBlockedURL, host)
+	case p.allow.empty() && p.blockPrivate &&
+		(host == "localhost" || strings.HasSuffix(host, ".localhost")):
+		return fmt.Errorf("%w: host %s is a private address", ErrBlockedURL, host)
+	}
+	return nil
+}
+
+// checkIP проверяет IP-адрес, указанный в url
+func (p *urlPolicy) checkIP(ip net.IP) error {
+	switch {
+	case p.deny.matchIP(ip):
+		return fmt.Errorf("%w: address %s is in the denylist", ErrBlockedURL, ip)
+	case p.allow.matchIP(ip):
+		return nil
+	case !p.allow.empty():
+		return fmt.Errorf("%w: address %s is not in the allowlist", ErrBlockedURL, ip)
+	case p.blockPrivate && isPrivateIP(ip):
+		return fmt.Errorf("%w: address %s is private", ErrBlockedURL, ip)
+	}
+	return nil
+}
+
+// isPrivateIP - адреса внутренней сети: приватные подсети, loopback, link-local (в том числе 169.254.169.254),
+// unspecified (0.0.0.0 ведет на локальный хост) и multicast
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkCallbackURL проверяет url из payload задания http_callback; задания других типов и payload
+// без url (его отсутствие - ошибка выполнения, а не политики) не проверяются
+func (p *urlPolicy) checkCallbackURL(taskType string, payload json.RawMessage) error {
+	if p == nil || taskType != "http_callback" {
+		return nil
+	}
+	var fields struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(payload, &fields); err != nil || fields.URL == "" {
+		return nil
+	}
+	return p.checkURL(fields.URL)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"at-api/models"
+)
+
+// TestURLPolicy проверяет allowlist, denylist и запрет приватных адресов в url http_callback
+func TestURLPolicy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  *urlPolicy
+		url     string
+		blocked bool
+	}{
+		{"metadata endpoint", newURLPolicy(nil, nil, true), "http://169.254.169.254/latest/meta-data/", true},
+		{"loopback", newURLPolicy(nil, nil, true), "http://127.0.0.1:8080/", true},
+		{"localhost", newURLPolicy(nil, nil, true), "http://LOCALHOST./", true},
+		{"private ipv6", newURLPolicy(nil, nil, true), "http://[fd00::1]/", true},
+		{"public hostname", newURLPolicy(nil, nil, true), "https://example.com/hook", false},
+		{"not http", newURLPolicy(nil, nil, true), "gopher://example.com/", true},
+		{"denied host", newURLPolicy(nil, []string{"*.example.com"}, false), "https://api.example.com/", true},
+		{"denied subnet", newURLPolicy(nil, []string{"203.0.113.0/24"}, false), "http://203.0.113.7/", true},
+		{"private allowed", newURLPolicy(nil, []string{"203.0.113.0/24"}, false), "http://10.1.2.3/", false},
+		{"allowlisted subnet", newURLPolicy([]string{"10.0.0.0/8"}, nil, true), "http://10.1.2.3/", false},
+		{"address not in allowlist", newURLPolicy([]string{"10.0.0.0/8"}, nil, true), "http://192.168.1.1/", true},
+		{"hostname checked by worker", newURLPolicy([]string{"10.0.0.0/8"}, nil, true), "http://billing.internal/", false},
+		{"host not in allowlist", newURLPolicy([]string{"hooks.example.com"}, nil, true), "https://evil.example.org/", true},
+		{"allowlisted host", newURLPolicy([]string{"hooks.example.com"}, nil, true), "https://hooks.example.com/x", false},
+	}
+
+	for _, tc := range testCases {
+		err := tc.policy.checkURL(tc.url)
+		if got := err != nil; got != tc.blocked {
+			t.Errorf("%s: blocked got=%v (%v), want=%v", tc.name, got, err, tc.blocked)
+		}
+		if err != nil && !errors.Is(err, ErrBlockedURL) {
+			t.Errorf("%s: error must wrap ErrBlockedURL, got %v", tc.name, err)
+		}
+	}
+}
+
+// TestCreateTaskBlockedURL проверяет отклонение запрещенного url при создании задания и задания цепочки
+func TestCreateTaskBlockedURL(t *testing.T) {
+	s := NewTaskService(NewMemoryTaskStore(), Options{CallbackBlockPrivate: true})
+	request := func(url string) *models.CreateTaskRequest {
+		return &models.CreateTaskRequest{
+			ExecuteAt: time.Now().Add(time.Hour),
+			TaskType:  "http_callback",
+			Payload:   json.RawMessage(`{"url": "` + url + `"}`),
+		}
+	}
+
+	if _, err := s.CreateTask(context.Background(), request("http://169.254.169.254/latest/meta-data/")); !errors.Is(err, ErrBlockedURL) {
+		t.Errorf("Metadata url error: got=%v, want=%v", err, ErrBlockedURL)
+	}
+
+	req := request("https://example.com/charge")
+	req.OnFailure = &models.FollowUpTask{TaskType: "http_callback", Payload: json.RawMessage(`{"url": "http://10.0.0.5/refund"}`)}
+	if _, err := s.CreateTask(context.Background(), req); !errors.Is(err, ErrBlockedURL) {
+		t.Errorf("Follow-up with private url error: got=%v, want=%v", err, ErrBlockedURL)
+	}
+
+	if _, err := s.CreateTask(context.Background(), request("https://example.com/hook")); err != nil {
+		t.Errorf("Public url must be allowed, got %v", err)
+	}
+}
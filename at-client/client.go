@@ -0,0 +1,317 @@
+// Package atclient - типизированный Go клиент AT API (/api/v1/tasks).
+// Избавляет потребителей от ручных HTTP вызовов и собственных копий структур задания.
+package atclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultTimeout - таймаут HTTP клиента по умолчанию, если Options.HTTPClient не задан
+const defaultTimeout = 30 * time.Second
+
+// Options содержит настройки клиента
+type Options struct {
+	BaseURL    string       // Адрес AT API, например http://localhost:8080
+	APIKey     string       // Передается как Authorization: Bearer <APIKey>; пусто - без заголовка
+	HTTPClient *http.Client // HTTP клиент; по умолчанию http.Client с таймаутом 30 секунд
+}
+
+// Client - клиент AT API. Безопасен для одновременного использования из нескольких goroutine.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// New создает клиент AT API
+func New(opts Options) *Client {
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(opts.BaseURL, "/"),
+		apiKey:     opts.APIKey,
+		httpClient: httpClient,
+	}
+}
+
+// APIError - ответ API с кодом ошибки (4xx, 5xx)
+type APIError struct {
+	StatusCode int    // HTTP статус ответа
+	Message    string // Поле error тела ответа или само тело, если это не JSON
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("at-api: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound сообщает, что API ответил 404 (задание не найдено)
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict сообщает, что API ответил 409 (дубликат dedup_key, неподходящий статус, чужая аренда)
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func hasStatus(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
+
+// taskEnvelope - ответ API с одним заданием
+type taskEnvelope struct {
+	Task *Task `json:"task"`
+}
+
+// CreateTask создает задание. С OnDuplicateReturnExisting при дубликате dedup_key возвращается
+// существующее задание и Duplicate = true; иначе дубликат - ошибка 409 (см. IsConflict).
+func (c *Client) CreateTask(ctx context.Context, req CreateTaskRequest) (*CreateTaskResponse, error) {
+	var resp CreateTaskResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/tasks", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateTasks создает несколько заданий одной транзакцией: либо все, либо ни одного
+func (c *Client) CreateTasks(ctx context.Context, reqs []CreateTaskRequest) (*CreateTasksResponse, error) {
+	body := struct {
+		Tasks []CreateTaskRequest `json:"tasks"`
+	}{Tasks: reqs}
+
+	var resp CreateTasksResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v1/tasks/batch", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetTask возвращает задание по ID
+func (c *Client) GetTask(ctx context.Context, id int64) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodGet, taskPath(id, ""), nil)
+}
+
+// GetTaskHistory возвращает историю смен статуса задания: когда и кем оно переводилось из статуса в статус
+func (c *Client) GetTaskHistory(ctx context.Context, id int64) (*TaskHistory, error) {
+	var history TaskHistory
+	if err := c.do(ctx, http.MethodGet, taskPath(id, "history"), nil, nil, &history); err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// GetTaskByKey возвращает последнее задание с указанным dedup_key
+func (c *Client) GetTaskByKey(ctx context.Context, key string) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodGet, keyPath(key), nil)
+}
+
+// CancelTask отменяет ожидающее задание
+func (c *Client) CancelTask(ctx context.Context, id int64) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodDelete, taskPath(id, ""), nil)
+}
+
+// CancelTaskWithReason отменяет ожидающее задание и сохраняет причину отмены (поле CancelReason задания)
+func (c *Client) CancelTaskWithReason(ctx context.Context, id int64, reason string) (*Task, error) {
+	body := struct {
+		Reason string `json:"reason,omitempty"`
+	}{Reason: reason}
+	return c.taskRequest(ctx, http.MethodDelete, taskPath(id, ""), body)
+}
+
+// CancelTaskByKey отменяет активное задание с указанным dedup_key
+func (c *Client) CancelTaskByKey(ctx context.Context, key string) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodDelete, keyPath(key), nil)
+}
+
+// UpsertTaskByKey создает задание с ключом key или заменяет время, payload и остальные поля
+// ожидающего задания с этим ключом (PUT /by-key). Задание с ключом, которое сейчас выполняется, не заменяется (409)
+func (c *Client) UpsertTaskByKey(ctx context.Context, key string, req CreateTaskRequest) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodPut, keyPath(key), req)
+}
+
+// RetryTask повторно ставит в очередь задание, завершившееся ошибкой (POST /requeue).
+// maxAttempts - новый лимит попыток; 0 - по умолчанию (attempts + 1)
+func (c *Client) RetryTask(ctx context.Context, id int64, maxAttempts int) (*Task, error) {
+	body := struct {
+		MaxAttempts int `json:"max_attempts,omitempty"`
+	}{MaxAttempts: maxAttempts}
+	return c.taskRequest(ctx, http.MethodPost, taskPath(id, "requeue"), body)
+}
+
+// HoldTask приостанавливает ожидающее задание
+func (c *Client) HoldTask(ctx context.Context, id int64) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodPost, taskPath(id, "hold"), nil)
+}
+
+// UnholdTask возвращает приостановленное задание в очередь
+func (c *Client) UnholdTask(ctx context.Context, id int64) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodPost, taskPath(id, "unhold"), nil)
+}
+
+// ListTasks возвращает страницу списка заданий с фильтрами
+func (c *Client) ListTasks(ctx context.Context, params ListTasksParams) (*TaskList, error) {
+	query := url.Values{}
+	setQuery(query, "status", params.Status)
+	setQuery(query, "task_type", params.TaskType)
+	setQuery(query, "queue", params.Queue)
+	setQuery(query, "error_contains", params.ErrorContains)
+	setQuery(query, "failure_reason", params.FailureReason)
+	setQuery(query, "sort", params.Sort)
+	if params.MinDurationMs != nil {
+		query.Set("min_duration_ms", strconv.FormatInt(*params.MinDurationMs, 10))
+	}
+	if params.MaxDurationMs != nil {
+		query.Set("max_duration_ms", strconv.FormatInt(*params.MaxDurationMs, 10))
+	}
+	if params.HasError != nil {
+		query.Set("has_error", strconv.FormatBool(*params.HasError))
+	}
+	if params.Limit > 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Offset > 0 {
+		query.Set("offset", strconv.Itoa(params.Offset))
+	}
+
+	var list TaskList
+	if err := c.do(ctx, http.MethodGet, "/api/v1/tasks", query, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ClaimTasks захватывает готовые задания для выполнения внешним исполнителем.
+// Пустой список - заданий нет; результат сообщается через CompleteTask или FailTask с lease_token.
+func (c *Client) ClaimTasks(ctx context.Context, req ClaimTasksRequest) ([]ClaimedTask, error) {
+	var resp struct {
+		Tasks []ClaimedTask `json:"tasks"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/tasks/claim", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tasks, nil
+}
+
+// ExtendLease продлевает аренду захваченного задания, которое еще выполняется: locked_until становится
+// сейчас + leaseSeconds (0 - срок аренды по умолчанию). Долгое задание продлевают, не дожидаясь истечения аренды
+func (c *Client) ExtendLease(ctx context.Context, id int64, leaseToken string, leaseSeconds int) (*Task, error) {
+	body := struct {
+		LeaseToken   string `json:"lease_token"`
+		LeaseSeconds int    `json:"lease_seconds,omitempty"`
+	}{leaseToken, leaseSeconds}
+	return c.taskRequest(ctx, http.MethodPost, taskPath(id, "extend"), body)
+}
+
+// CompleteTask сообщает об успешном выполнении захваченного задания
+func (c *Client) CompleteTask(ctx context.Context, id int64, req FinishTaskRequest) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodPost, taskPath(id, "complete"), req)
+}
+
+// FailTask сообщает о неудачной попытке выполнения захваченного задания
+func (c *Client) FailTask(ctx context.Context, id int64, req FinishTaskRequest) (*Task, error) {
+	return c.taskRequest(ctx, http.MethodPost, taskPath(id, "fail"), req)
+}
+
+// taskRequest выполняет запрос, ответ на который - одно задание
+func (c *Client) taskRequest(ctx context.Context, method, path string, body interface{}) (*Task, error) {
+	var resp taskEnvelope
+	if err := c.do(ctx, method, path, nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Task, nil
+}
+
+// do отправляет запрос к API и декодирует JSON ответ в out.
+// Ответ с кодом 4xx/5xx возвращается как *APIError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+			apiErr.Message = errResp.Error
+		}
+		return apiErr
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// taskPath возвращает путь задания /api/v1/tasks/{id}[/action]
+func taskPath(id int64, action string) string {
+	path := "/api/v1/tasks/" + strconv.FormatInt(id, 10)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+// keyPath возвращает путь задания по dedup_key; ключ может содержать "/", остальное экранируется
+func keyPath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/api/v1/tasks/by-key/" + strings.Join(segments, "/")
+}
+
+// setQuery добавляет непустой параметр запроса
+func setQuery(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
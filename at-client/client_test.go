@@ -0,0 +1,229 @@
+package atclient
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordedRequest - запрос, полученный тестовым сервером
+type recordedRequest struct {
+	method string
+	path   string
+	query  string
+	auth   string
+	body   string
+}
+
+// newTestServer запускает httptest сервер, который запоминает запрос и отвечает status и response
+func newTestServer(t *testing.T, status int, response string) (*Client, *recordedRequest) {
+	t.Helper()
+	got := &recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*got = recordedRequest{
+			method: r.Method,
+			path:   r.URL.EscapedPath(),
+			query:  r.URL.RawQuery,
+			auth:   r.Header.Get("Authorization"),
+			body:   string(body),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+
+	return New(Options{BaseURL: server.URL + "/", APIKey: "secret"}), got
+}
+
+const taskJSON = `{"task":{"id":42,"execute_at":"2025-11-10T15:00:00Z","task_type":"http_callback","queue":"default",
+	"payload":{"url":"https://example.com"},"status":"failed","attempts":3,"max_attempts":3,
+	"error_message":{"String":"HTTP 500","Valid":true},"created_at":"2025-11-10T14:00:00Z","updated_at":"2025-11-10T15:00:01Z",
+	"completed_at":{"Time":"2025-11-10T15:00:01Z","Valid":true},"claimed_at":{"Time":"0001-01-01T00:00:00Z","Valid":false}}}`
+
+// TestCreateTask проверяет запрос создания задания и разбор ответа
+func TestCreateTask(t *testing.T) {
+	client, got := newTestServer(t, http.StatusCreated, taskJSON)
+
+	executeAt := time.Date(2025, 11, 10, 15, 0, 0, 0, time.UTC)
+	resp, err := client.CreateTask(context.Background(), CreateTaskRequest{
+		ExecuteAt: executeAt,
+		TaskType:  "http_callback",
+		Payload:   json.RawMessage(`{"url":"https://example.com"}`),
+		DedupKey:  "order-1",
+	})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	if got.method != http.MethodPost || got.path != "/api/v1/tasks" {
+		t.Errorf("Expected POST /api/v1/tasks, got %s %s", got.method, got.path)
+	}
+	if got.auth != "Bearer secret" {
+		t.Errorf("Expected API key in Authorization header, got %q", got.auth)
+	}
+	var sent map[string]interface{}
+	if err := json.Unmarshal([]byte(got.body), &sent); err != nil {
+		t.Fatalf("Request body is not JSON: %v", err)
+	}
+	if sent["execute_at"] != "2025-11-10T15:00:00Z" || sent["dedup_key"] != "order-1" {
+		t.Errorf("Unexpected request body: %s", got.body)
+	}
+	if _, ok := sent["max_attempts"]; ok {
+		t.Errorf("Zero fields must be omitted, got body: %s", got.body)
+	}
+
+	task := resp.Task
+	if task.ID != 42 || task.Status != StatusFailed || !task.ExecuteAt.Equal(executeAt) {
+		t.Errorf("Unexpected task: %+v", task)
+	}
+	if !task.ErrorMessage.Valid || task.ErrorMessage.String != "HTTP 500" {
+		t.Errorf("Unexpected error_message: %+v", task.ErrorMessage)
+	}
+	if !task.CompletedAt.Valid || task.ClaimedAt.Valid {
+		t.Errorf("Unexpected completed_at/claimed_at: %+v %+v", task.CompletedAt, task.ClaimedAt)
+	}
+}
+
+// TestTaskRequests проверяет метод и путь запросов, возвращающих одно задание
+func TestTaskRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		call       func(c *Client) (*Task, error)
+		wantMethod string
+		wantPath   string
+		wantBody   string
+	}{
+		{"get", func(c *Client) (*Task, error) { return c.GetTask(context.Background(), 42) }, "GET", "/api/v1/tasks/42", ""},
+		{"cancel", func(c *Client) (*Task, error) { return c.CancelTask(context.Background(), 42) }, "DELETE", "/api/v1/tasks/42", ""},
+		{"cancel with reason", func(c *Client) (*Task, error) {
+			return c.CancelTaskWithReason(context.Background(), 42, "duplicate order")
+		}, "DELETE", "/api/v1/tasks/42", `{"reason":"duplicate order"}`},
+		{"retry", func(c *Client) (*Task, error) { return c.RetryTask(context.Background(), 42, 5) }, "POST", "/api/v1/tasks/42/requeue", `{"max_attempts":5}`},
+		{"retry default", func(c *Client) (*Task, error) { return c.RetryTask(context.Background(), 42, 0) }, "POST", "/api/v1/tasks/42/requeue", `{}`},
+		{"hold", func(c *Client) (*Task, error) { return c.HoldTask(context.Background(), 42) }, "POST", "/api/v1/tasks/42/hold", ""},
+		{"unhold", func(c *Client) (*Task, error) { return c.UnholdTask(context.Background(), 42) }, "POST", "/api/v1/tasks/42/unhold", ""},
+		{"get by key", func(c *Client) (*Task, error) { return c.GetTaskByKey(context.Background(), "orders/a b") }, "GET", "/api/v1/tasks/by-key/orders/a%20b", ""},
+		{"cancel by key", func(c *Client) (*Task, error) { return c.CancelTaskByKey(context.Background(), "order-1") }, "DELETE", "/api/v1/tasks/by-key/order-1", ""},
+		{"upsert by key", func(c *Client) (*Task, error) {
+			return c.UpsertTaskByKey(context.Background(), "report-1", CreateTaskRequest{ExecuteAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), TaskType: "email", Payload: json.RawMessage(`{}`)})
+		}, "PUT", "/api/v1/tasks/by-key/report-1", `{"execute_at":"2030-01-01T00:00:00Z","task_type":"email","payload":{}}`},
+		{"complete", func(c *Client) (*Task, error) {
+			return c.CompleteTask(context.Background(), 42, FinishTaskRequest{LeaseToken: "lt"})
+		}, "POST", "/api/v1/tasks/42/complete", `{"lease_token":"lt"}`},
+		{"extend", func(c *Client) (*Task, error) {
+			return c.ExtendLease(context.Background(), 42, "lt", 300)
+		}, "POST", "/api/v1/tasks/42/extend", `{"lease_token":"lt","lease_seconds":300}`},
+		{"fail", func(c *Client) (*Task, error) {
+			return c.FailTask(context.Background(), 42, FinishTaskRequest{LeaseToken: "lt", ErrorMessage: "boom"})
+		}, "POST", "/api/v1/tasks/42/fail", `{"lease_token":"lt","error_message":"boom"}`},
+	}
+
+	for _, tc := range tests {
+		client, got := newTestServer(t, http.StatusOK, taskJSON)
+		task, err := tc.call(client)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if task == nil || task.ID != 42 {
+			t.Errorf("%s: unexpected task: %+v", tc.name, task)
+		}
+		if got.method != tc.wantMethod || got.path != tc.wantPath {
+			t.Errorf("%s: expected %s %s, got %s %s", tc.name, tc.wantMethod, tc.wantPath, got.method, got.path)
+		}
+		if got.body != tc.wantBody {
+			t.Errorf("%s: body got %q, want %q", tc.name, got.body, tc.wantBody)
+		}
+	}
+}
+
+// TestGetTaskHistory проверяет путь запроса и разбор истории смен статуса
+func TestGetTaskHistory(t *testing.T) {
+	client, got := newTestServer(t, http.StatusOK, `{"task_id": 42, "status": "completed", "transitions": [
+		{"to": "pending", "at": "2025-11-10T10:00:00Z", "by": "at-api"},
+		{"from": "pending", "to": "processing", "at": "2025-11-10T10:05:00.123456+00:00", "by": "at-worker:w1"}]}`)
+
+	history, err := client.GetTaskHistory(context.Background(), 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.method != http.MethodGet || got.path != "/api/v1/tasks/42/history" {
+		t.Errorf("expected GET /api/v1/tasks/42/history, got %s %s", got.method, got.path)
+	}
+	if history.Status != "completed" || len(history.Transitions) != 2 {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	if tr := history.Transitions[1]; tr.From != "pending" || tr.To != "processing" || tr.By != "at-worker:w1" || tr.At.IsZero() {
+		t.Errorf("unexpected transition: %+v", tr)
+	}
+}
+
+// TestListTasks проверяет передачу фильтров в query параметрах
+func TestListTasks(t *testing.T) {
+	client, got := newTestServer(t, http.StatusOK, `{"tasks":[{"id":1,"status":"pending"},{"id":2,"status":"pending"}],"total":7}`)
+
+	hasError := false
+	list, err := client.ListTasks(context.Background(), ListTasksParams{Status: StatusPending, HasError: &hasError, Limit: 2, Offset: 4})
+	if err != nil {
+		t.Fatalf("ListTasks: %v", err)
+	}
+
+	if got.query != "has_error=false&limit=2&offset=4&status=pending" {
+		t.Errorf("Unexpected query: %s", got.query)
+	}
+	if list.Total != 7 || len(list.Tasks) != 2 || list.Tasks[1].ID != 2 {
+		t.Errorf("Unexpected list: %+v", list)
+	}
+}
+
+// TestClaimTasks проверяет разбор захваченных заданий вместе с токеном аренды
+func TestClaimTasks(t *testing.T) {
+	client, got := newTestServer(t, http.StatusOK, `{"tasks":[{"id":5,"task_type":"email","status":"processing","lease_token":"lt-5"}]}`)
+
+	tasks, err := client.ClaimTasks(context.Background(), ClaimTasksRequest{TaskTypes: []string{"email"}, Limit: 1})
+	if err != nil {
+		t.Fatalf("ClaimTasks: %v", err)
+	}
+	if got.path != "/api/v1/tasks/claim" || got.body != `{"task_types":["email"],"limit":1}` {
+		t.Errorf("Unexpected request: %s %s", got.path, got.body)
+	}
+	if len(tasks) != 1 || tasks[0].ID != 5 || tasks[0].LeaseToken != "lt-5" {
+		t.Errorf("Unexpected claimed tasks: %+v", tasks)
+	}
+}
+
+// TestAPIError проверяет разбор ответов с ошибкой
+func TestAPIError(t *testing.T) {
+	client, _ := newTestServer(t, http.StatusNotFound, `{"error":"Task not found"}`)
+	_, err := client.GetTask(context.Background(), 1)
+	if !IsNotFound(err) || IsConflict(err) {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+	if apiErr := err.(*APIError); apiErr.Message != "Task not found" {
+		t.Errorf("Unexpected message: %q", apiErr.Message)
+	}
+
+	// Ответ не в формате API (например, от прокси) - сообщение берется из тела как есть
+	client, _ = newTestServer(t, http.StatusBadGateway, "bad gateway\n")
+	_, err = client.CancelTask(context.Background(), 1)
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "bad gateway" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// TestContextCancel проверяет, что запрос прерывается отменой контекста
+func TestContextCancel(t *testing.T) {
+	client, _ := newTestServer(t, http.StatusOK, taskJSON)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.GetTask(ctx, 1); err == nil {
+		t.Error("Expected error for cancelled context")
+	}
+}
@@ -0,0 +1,3 @@
+module at-client
+
+go 1.22
@@ -0,0 +1,216 @@
+package atclient
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Task - запланированное задание в том виде, в каком его возвращает API.
+// error_message, completed_at и claimed_at сериализуются API как sql.NullString / sql.NullTime
+// ({"String": ..., "Valid": ...}), поэтому здесь используются те же типы.
+type Task struct {
+	ID            int64            `json:"id"`
+	ExecuteAt     time.Time        `json:"execute_at"`
+	TaskType      string           `json:"task_type"`
+	Queue         string           `json:"queue"`
+	Payload       json.RawMessage  `json:"payload"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	MaxAttempts   int              `json:"max_attempts"`
+	ErrorMessage  sql.NullString   `json:"error_message,omitempty"`
+	Result        *json.RawMessage `json:"result,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	CompletedAt   sql.NullTime     `json:"completed_at,omitempty"`
+	ClaimedAt     sql.NullTime     `json:"claimed_at,omitempty"`
+	DedupKey      *string          `json:"dedup_key,omitempty"`
+	Timeout       *int             `json:"timeout_seconds,omitempty"`
+	LockedUntil   *time.Time       `json:"locked_until,omitempty"`
+	Warning       *string          `json:"warning,omitempty"`
+	ResultTTL     *int             `json:"result_ttl_seconds,omitempty"`
+	ScrubbedAt    *time.Time       `json:"scrubbed_at,omitempty"`
+	Notify        *json.RawMessage `json:"notify,omitempty"`
+	SkipIfLate    *int             `json:"skip_if_late_seconds,omitempty"`
+	Progress      *json.RawMessage `json:"progress,omitempty"`
+	Interval      string           `json:"interval,omitempty"` // Период повторяющегося задания (Go duration)
+	MaxExecutions *int             `json:"max_executions,omitempty"`
+	EndAt         *time.Time       `json:"end_at,omitempty"`
+	Executions    int              `json:"executions"`
+	FailureReason *string          `json:"failure_reason,omitempty"` // Класс причины последней ошибки (FailureTimeout, ...)
+	PayloadRef    *string          `json:"payload_ref,omitempty"`    // Ссылка на payload во внешнем хранилище (s3://bucket/key)
+	Align         string           `json:"align,omitempty"`          // Граница привязки повторяющегося задания (minute, hour, day)
+	ParentID      *int64           `json:"parent_id,omitempty"`      // Задание, по итогу которого создано это (on_success / on_failure)
+	OnSuccess     *FollowUpTask    `json:"on_success,omitempty"`
+	OnFailure     *FollowUpTask    `json:"on_failure,omitempty"`
+	DurationMs    *int64           `json:"duration_ms,omitempty"` // Длительность последнего выполнения в миллисекундах
+	Priority      int              `json:"priority"`              // Приоритет захвата
+
+	// Последний ответ http_callback; бинарное тело сохраняется только при WORKER_BINARY_RESPONSE_MODE=base64
+	ResponseType *string `json:"response_content_type,omitempty"` // Content-Type ответа
+	ResponseBody *string `json:"response_body_base64,omitempty"`  // Бинарное тело ответа в base64
+
+	CancelReason *string `json:"cancel_reason,omitempty"` // Причина отмены, указанная при отмене
+
+	CronExpression *string `json:"cron_expression,omitempty"` // Cron-расписание повторяющегося задания
+}
+
+// Статусы заданий
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusHold       = "hold"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+	StatusSkipped    = "skipped"
+)
+
+// Классы причин ошибок (failure_reason)
+const (
+	FailureTimeout           = "timeout"
+	FailureConnectionRefused = "connection_refused"
+	FailureHTTP4xx           = "http_4xx"
+	FailureHTTP5xx           = "http_5xx"
+	FailureValidation        = "validation"
+	FailureUnknown           = "unknown"
+)
+
+// CreateTaskRequest - запрос на создание задания (POST /api/v1/tasks)
+type CreateTaskRequest struct {
+	ExecuteAt     time.Time       `json:"execute_at"`
+	TaskType      string          `json:"task_type"`
+	Queue         string          `json:"queue,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	MaxAttempts   int             `json:"max_attempts,omitempty"`
+	DedupKey      string          `json:"dedup_key,omitempty"`
+	OnDuplicate   string          `json:"on_duplicate,omitempty"` // OnDuplicateReject или OnDuplicateReturnExisting
+	DedupWindow   int             `json:"dedup_window_seconds,omitempty"`
+	Timeout       int             `json:"timeout_seconds,omitempty"`
+	ResultTTL     int             `json:"result_ttl_seconds,omitempty"`
+	Notify        []NotifyChannel `json:"notify,omitempty"`
+	SkipIfLate    int             `json:"skip_if_late_seconds,omitempty"`
+	Interval      string          `json:"interval,omitempty"` // Период повторения в формате Go duration, например "15m"
+	MaxExecutions int             `json:"max_executions,omitempty"`
+	EndAt         *time.Time      `json:"end_at,omitempty"`
+	Align         string          `json:"align,omitempty"`      // Привязка выполнений к границе: AlignMinute, AlignHour или AlignDay (UTC)
+	Schedule      string          `json:"schedule,omitempty"`   // Сокращение расписания вместо Interval: "hourly@:15", "daily@14:30", "weekly@mon,09:00" (UTC)
+	OnSuccess     *FollowUpTask   `json:"on_success,omitempty"` // Задание, которое создается после успешного завершения
+	OnFailure     *FollowUpTask   `json:"on_failure,omitempty"` // Задание, которое создается после окончательной ошибки
+	Priority      int             `json:"priority,omitempty"`   // Приоритет захвата от -100 до 100; просроченные задания стареют (+1 в минуту)
+
+	// Cron-расписание вместо Interval и Schedule: "*/5 * * * *", "@daily", "CRON_TZ=Europe/Moscow 0 9 * * *" (по умолчанию UTC)
+	CronExpression string `json:"cron_expression,omitempty"`
+}
+
+// FollowUpTask - задание цепочки (CreateTaskRequest.OnSuccess / OnFailure). Worker создает его по итогу
+// родительского задания с execute_at = время завершения родителя + Delay
+type FollowUpTask struct {
+	TaskType    string          `json:"task_type"`
+	Queue       string          `json:"queue,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	MaxAttempts int             `json:"max_attempts,omitempty"`
+	Timeout     int             `json:"timeout_seconds,omitempty"`
+	Delay       int             `json:"delay_seconds,omitempty"`
+	Notify      []NotifyChannel `json:"notify,omitempty"`
+	OnSuccess   *FollowUpTask   `json:"on_success,omitempty"`
+	OnFailure   *FollowUpTask   `json:"on_failure,omitempty"`
+}
+
+// Границы привязки повторяющегося задания (CreateTaskRequest.Align)
+const (
+	AlignMinute = "minute"
+	AlignHour   = "hour"
+	AlignDay    = "day"
+)
+
+// Поведение при создании задания с dedup_key, для которого уже есть активное задание
+const (
+	OnDuplicateReject         = "reject"          // Ошибка 409 Conflict (по умолчанию)
+	OnDuplicateReturnExisting = "return_existing" // Возврат существующего задания
+)
+
+// NotifyChannel - канал уведомления о завершении задания
+type NotifyChannel struct {
+	Type   string `json:"type"`   // "webhook" или "slack"
+	Target string `json:"target"` // URL получателя
+}
+
+// CreateTaskResponse - результат создания задания
+type CreateTaskResponse struct {
+	Task      *Task `json:"task"`
+	Duplicate bool  `json:"duplicate,omitempty"` // Вместо создания возвращено существующее задание с тем же dedup_key
+}
+
+// CreateTasksResponse - результат создания батча заданий (POST /api/v1/tasks/batch)
+type CreateTasksResponse struct {
+	Created int     `json:"created"`
+	Tasks   []*Task `json:"tasks"`
+}
+
+// ListTasksParams - фильтры и пагинация списка заданий (GET /api/v1/tasks); пустые поля не передаются
+type ListTasksParams struct {
+	Status        string
+	TaskType      string
+	Queue         string
+	HasError      *bool
+	ErrorContains string
+	FailureReason string
+	MinDurationMs *int64 // Только задания с duration_ms не меньше указанного
+	MaxDurationMs *int64 // Только задания с duration_ms не больше указанного
+	Sort          string // SortCreatedDesc (по умолчанию), SortCreatedAsc, SortDurationDesc или SortDurationAsc
+	Limit         int
+	Offset        int
+}
+
+// Порядок списка заданий (ListTasksParams.Sort)
+const (
+	SortCreatedDesc  = "-created_at"
+	SortCreatedAsc   = "created_at"
+	SortDurationDesc = "-duration_ms"
+	SortDurationAsc  = "duration_ms"
+)
+
+// TaskList - страница списка заданий и общее число заданий под фильтром
+type TaskList struct {
+	Tasks []Task `json:"tasks"`
+	Total int    `json:"total"`
+}
+
+// StateTransition - смена статуса задания: из From в To в момент At; By - кто сменил (at-api, at-worker:<id>)
+type StateTransition struct {
+	From string    `json:"from,omitempty"` // Пусто у первой записи (создание задания)
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+	By   string    `json:"by,omitempty"`
+}
+
+// TaskHistory - текущий статус задания и смены статуса по порядку (GET /api/v1/tasks/{id}/history)
+type TaskHistory struct {
+	TaskID      int64             `json:"task_id"`
+	Status      string            `json:"status"`
+	Transitions []StateTransition `json:"transitions"`
+}
+
+// ClaimTasksRequest - запрос на захват заданий внешним исполнителем (POST /api/v1/tasks/claim)
+type ClaimTasksRequest struct {
+	Queue        string   `json:"queue,omitempty"`
+	TaskTypes    []string `json:"task_types,omitempty"`
+	Limit        int      `json:"limit,omitempty"`
+	LeaseSeconds int      `json:"lease_seconds,omitempty"`
+}
+
+// ClaimedTask - захваченное задание вместе с токеном аренды для complete/fail
+type ClaimedTask struct {
+	Task
+	LeaseToken string `json:"lease_token"`
+}
+
+// FinishTaskRequest - отчет о выполнении захваченного задания (complete или fail)
+type FinishTaskRequest struct {
+	LeaseToken    string          `json:"lease_token"`
+	ErrorMessage  string          `json:"error_message,omitempty"`  // Для FailTask
+	FailureReason string          `json:"failure_reason,omitempty"` // Для FailTask; пусто - FailureUnknown
+	Warning       string          `json:"warning,omitempty"`        // Для CompleteTask
+	Result        json.RawMessage `json:"result,omitempty"`
+}
@@ -0,0 +1,156 @@
+// Package blobstore читает payload заданий, вынесенные API во внешнее S3-совместимое хранилище
+// (AWS S3, MinIO, Ceph RGW). В строке задания хранится только ссылка вида s3://bucket/key (колонка payload_ref).
+// Запросы подписываются AWS Signature Version 4 без внешнего SDK; используется path-style адресация
+// (endpoint/bucket/key), как и в at-api.
+package blobstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxPayloadSize ограничивает размер скачиваемого payload, чтобы ошибочная ссылка не исчерпала память worker'а
+const maxPayloadSize = 64 << 20
+
+// ErrNotFound возвращается, когда объекта по ссылке нет: повтор не поможет
+var ErrNotFound = errors.New("payload object not found")
+
+// Options содержит параметры подключения к S3-совместимому хранилищу
+type Options struct {
+	Endpoint  string // Базовый URL хранилища, например https://s3.eu-central-1.amazonaws.com или http://minio:9000
+	Region    string // Регион для подписи запросов; для MinIO обычно us-east-1
+	AccessKey string
+	SecretKey string
+}
+
+// S3Store скачивает payload из S3-совместимого хранилища
+type S3Store struct {
+	endpoint   *url.URL
+	region     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+// NewS3Store создает клиент хранилища payload. Endpoint должен быть абсолютным http(s) URL.
+// Параметры:
+//   - opts: адрес хранилища, регион и ключи доступа
+func NewS3Store(opts Options) (*S3Store, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q: must be an absolute http or https URL", opts.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+
+	return &S3Store{
+		endpoint:   endpoint,
+		region:     opts.Region,
+		accessKey:  opts.AccessKey,
+		secretKey:  opts.SecretKey,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// Get скачивает payload по ссылке s3://bucket/key. Время ограничивает контекст задания.
+// Отсутствующий объект - ErrNotFound.
+func (s *S3Store) Get(ctx context.Context, ref string) ([]byte, error) {
+	bucket, key, err := parseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	u.Path += "/" + bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxPayloadSize {
+		return nil, fmt.Errorf("payload %s is larger than %d bytes", ref, maxPayloadSize)
+	}
+	return data, nil
+}
+
+// parseRef разбирает ссылку s3://bucket/key
+func parseRef(ref string) (string, string, error) {
+	rest, ok := strings.CutPrefix(ref, "s3://")
+	bucket, key, found := strings.Cut(rest, "/")
+	if !ok || !found || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid payload_ref %q: expected s3://bucket/key", ref)
+	}
+	return bucket, key, nil
+}
+
+// emptyPayloadHash - SHA-256 пустого тела GET запроса
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign подписывает запрос AWS Signature Version 4 (заголовок Authorization).
+// Подписываются заголовки host, x-amz-content-sha256 и x-amz-date.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + emptyPayloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"database/sql"
+
+	"at-worker/metrics"
+)
+
+// Метрики пула соединений, метка pool - имя пула (например, "worker")
+var (
+	poolOpenConnections = metrics.NewGauge("at_worker_db_pool_open_connections", "Number of established connections in the DB pool.", "pool")
+	poolInUse           = metrics.NewGauge("at_worker_db_pool_in_use_connections", "Number of DB connections currently in use.", "pool")
+	poolIdle            = metrics.NewGauge("at_worker_db_pool_idle_connections", "Number of idle DB connections.", "pool")
+	poolMaxOpen         = metrics.NewGauge("at_worker_db_pool_max_open_connections", "Maximum number of open DB connections.", "pool")
+	poolWaitCount       = metrics.NewCounter("at_worker_db_pool_wait_count_total", "Total number of connections waited for.", "pool")
+	poolWaitSeconds     = metrics.NewCounter("at_worker_db_pool_wait_seconds_total", "Total time blocked waiting for a new connection.", "pool")
+)
+
+// ObservePool экспортирует статистику пула соединений (sql.DBStats) в метрики.
+// Значения обновляются при каждом сборе метрик.
+// Параметры:
+//   - name: имя пула для метки pool
+//   - database: пул соединений
+func ObservePool(name string, database *sql.DB) {
+	metrics.OnCollect(func() {
+		stats := database.Stats()
+		poolOpenConnections.Set(float64(stats.OpenConnections), name)
+		poolInUse.Set(float64(stats.InUse), name)
+		poolIdle.Set(float64(stats.Idle), name)
+		poolMaxOpen.Set(float64(stats.MaxOpenConnections), name)
+		poolWaitCount.Set(float64(stats.WaitCount), name)
+		poolWaitSeconds.Set(stats.WaitDuration.Seconds(), name)
+	})
+}
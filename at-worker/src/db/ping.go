@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// pingAttemptTimeout ограничивает одну попытку ping, чтобы зависшее соединение не съедало весь бюджет проверки
+const pingAttemptTimeout = time.Second
+
+// Pinger - минимальный интерфейс для проверки соединения (реализуется *sql.DB)
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// PingWithRetry проверяет доступность БД, повторяя ping до retries раз с паузой interval.
+// Используется readiness-проверкой: одиночный медленный или неудачный ping при кратковременной
+// нагрузке на БД не должен сразу переводить сервис в not-ready.
+// Возвращает nil при первом успешном ping или ошибку последней попытки.
+func PingWithRetry(ctx context.Context, p Pinger, retries int, interval time.Duration) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, pingAttemptTimeout)
+		err = p.PingContext(attemptCtx)
+		cancel()
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+	}
+}
@@ -6,13 +6,26 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"at-worker/blobstore"
 	"at-worker/config"
 	"at-worker/db"
+	"at-worker/metrics"
+	"at-worker/schema"
 	"at-worker/worker"
 
 	"github.com/joho/godotenv"
@@ -35,43 +48,267 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	log.Printf("Worker ID: %s", cfg.Worker.WorkerID)
-	log.Printf("Polling interval: %v", cfg.Worker.PollingInterval)
-	log.Printf("Batch size: %d", cfg.Worker.BatchSize)
+	log.Printf("Worker ID: %s (from %s)", cfg.Worker.WorkerID, cfg.Worker.WorkerIDSource)
+	log.Printf("Polling interval: %v, lookahead: %v", cfg.Worker.PollingInterval, cfg.Worker.Lookahead)
+	log.Printf("Batch size: %d, claim window: %d, type quota: %d, payload budget: %d bytes",
+		cfg.Worker.BatchSize, cfg.Worker.ClaimWindow, cfg.Worker.TypeQuota, cfg.Worker.PayloadBudget)
+	if len(cfg.Worker.TypeBatchSizes) > 0 || len(cfg.Worker.QueueBatchSizes) > 0 {
+		log.Printf("Batch size per type: %v, per queue: %v", cfg.Worker.TypeBatchSizes, cfg.Worker.QueueBatchSizes)
+	}
 	log.Printf("Cleaner interval: %v", cfg.Worker.CleanerInterval)
 	log.Printf("Stuck timeout: %v", cfg.Worker.StuckTimeout)
+	log.Printf("Queues: %v", cfg.Worker.Queues)
+	log.Printf("Task timeout: %v, per type: %v", cfg.Worker.TaskTimeout, cfg.Worker.TypeTimeouts)
+	log.Printf("HTTP network retries: %d", cfg.Worker.NetworkRetries)
+	log.Printf("Unknown task type action: %s", cfg.Worker.UnknownTypeAction)
+	log.Printf("HTTP callback body without data: %s", cfg.Worker.CallbackEmptyData)
+	if cfg.Worker.NotifyBatchWindow > 0 {
+		log.Printf("Webhook notifications batched: window %v, up to %d events", cfg.Worker.NotifyBatchWindow, cfg.Worker.NotifyBatchSize)
+	}
+	if cfg.Worker.NotifyMaxAttempts > 0 {
+		log.Printf("Notifications via outbox: up to %d attempts, retry base %v", cfg.Worker.NotifyMaxAttempts, cfg.Worker.NotifyRetryBase)
+	} else {
+		log.Printf("Notifications outbox disabled: single delivery attempt")
+	}
+	if len(cfg.Worker.CallbackAllowlist) > 0 {
+		log.Printf("HTTP callbacks allowed only to: %s", strings.Join(cfg.Worker.CallbackAllowlist, ","))
+	}
+	if len(cfg.Worker.CallbackDenylist) > 0 {
+		log.Printf("HTTP callbacks denied to: %s", strings.Join(cfg.Worker.CallbackDenylist, ","))
+	}
+	if cfg.Worker.CallbackPrivate {
+		log.Println("HTTP callbacks to private addresses are allowed (WORKER_CALLBACK_ALLOW_PRIVATE)")
+	}
+	if cfg.Worker.CallbackDNSCache > 0 {
+		log.Printf("HTTP callback targets cached for %v after DNS and address policy checks", cfg.Worker.CallbackDNSCache)
+	}
+	if len(cfg.Worker.FleetTypeLimits) > 0 {
+		log.Printf("Fleet-wide concurrency limits per task type: %v", cfg.Worker.FleetTypeLimits)
+	}
+	if cfg.Worker.CatchUpRate > 0 {
+		log.Printf("Catch-up throttle: up to %d tasks per type every %v for tasks overdue by more than %v",
+			cfg.Worker.CatchUpRate, cfg.Worker.CatchUpInterval, cfg.Worker.CatchUpOverdue)
+	}
+	if cfg.Worker.RetryToBack {
+		log.Println("Retried tasks are moved to the back of the queue")
+	}
+	if cfg.Worker.DryRun {
+		log.Println("DRY RUN mode: tasks will be logged and marked completed without side effects")
+	}
+	log.Printf("Shutdown timeout: %v (metrics flush %v)", cfg.Worker.ShutdownTimeout, cfg.Worker.MetricsFlush)
+
+	log.Printf("DB pools: worker max %d (idle %d), cleaner max %d, statement timeout %v",
+		cfg.Pools.WorkerMaxOpenConns, cfg.Pools.WorkerMaxIdleConns, cfg.Pools.CleanerMaxOpenConns, cfg.Database.StatementTimeout)
 
 	// Подключение к базе данных PostgreSQL
-	database, err := db.NewPostgresDB(cfg.Database.DSN())
+	log.Printf("Connecting to database: %s", cfg.Database.RedactedDSN())
+	database, err := db.NewPostgresDB(cfg.Database.DSN(), db.PoolConfig{
+		MaxOpenConns: cfg.Pools.WorkerMaxOpenConns,
+		MaxIdleConns: cfg.Pools.WorkerMaxIdleConns,
+
+		StatementTimeout: cfg.Database.StatementTimeout,
+		ApplicationName:  "at-worker:" + cfg.Worker.WorkerID,
+	})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to connect to database (%s): %v", cfg.Database.RedactedDSN(), err)
 	}
 	defer database.Close()
 
 	log.Println("Successfully connected to database")
+	db.ObservePool("worker", database)
+
+	// Проверяем, что соединение действительно зашифровано, если DB_SSLMODE этого требует
+	encrypted, err := db.VerifySSL(context.Background(), database, cfg.Database.SSLMode)
+	if errors.Is(err, db.ErrConnectionNotEncrypted) {
+		log.Fatalf("Database SSL check failed: %v", err)
+	}
+	if err != nil {
+		log.Printf("Could not verify database connection encryption: %v", err)
+	} else {
+		log.Printf("Database connection encrypted: %v (sslmode=%s)", encrypted, cfg.Database.SSLMode)
+	}
+
+	// Отдельный небольшой пул для Cleaner'а, чтобы его UPDATE'ы не отнимали соединения у Worker'а
+	cleanerDB := database
+	if cfg.Pools.CleanerMaxOpenConns > 0 {
+		cleanerDB, err = db.NewPostgresDB(cfg.Database.DSN(), db.PoolConfig{
+			MaxOpenConns: cfg.Pools.CleanerMaxOpenConns,
+			MaxIdleConns: 1,
+
+			StatementTimeout: cfg.Database.StatementTimeout,
+			ApplicationName:  "at-worker:" + cfg.Worker.WorkerID,
+		})
+		if err != nil {
+			log.Fatalf("Failed to connect to database (cleaner pool): %v", err)
+		}
+		defer cleanerDB.Close()
+		db.ObservePool("cleaner", cleanerDB)
+	}
+
+	// Отдельное подключение для заданий типа "sql" (только если явно включено)
+	var sqlTaskDB *sql.DB
+	if cfg.Worker.EnableSQL {
+		sqlTaskDB, err = db.NewPostgresDB(cfg.Worker.SQLDSN, db.DefaultPool)
+		if err != nil {
+			log.Fatalf("Failed to connect to SQL task database: %v", err)
+		}
+		defer sqlTaskDB.Close()
+		log.Println("SQL tasks enabled, connected to SQL task database")
+	}
+
+	// Схемы payload по типам заданий (только если задан каталог)
+	var schemas *schema.Registry
+	if cfg.Worker.SchemaDir != "" {
+		schemas, err = schema.NewRegistry(cfg.Worker.SchemaDir)
+		if err != nil {
+			log.Fatalf("Failed to load payload schemas: %v", err)
+		}
+
+		// SIGHUP перечитывает схемы без перезапуска worker'а
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for range hupChan {
+				if err := schemas.Reload(); err != nil {
+					log.Printf("Failed to reload payload schemas, keeping previous: %v", err)
+				}
+			}
+		}()
+	}
+
+	// Хранилище payload, вынесенных API во внешнее S3-совместимое хранилище (payload_ref)
+	var payloads worker.PayloadFetcher
+	if cfg.PayloadStore.Endpoint != "" {
+		payloads, err = blobstore.NewS3Store(blobstore.Options{
+			Endpoint:  cfg.PayloadStore.Endpoint,
+			Region:    cfg.PayloadStore.Region,
+			AccessKey: cfg.PayloadStore.AccessKey,
+			SecretKey: cfg.PayloadStore.SecretKey,
+		})
+		if err != nil {
+			log.Fatalf("Invalid payload store configuration: %v", err)
+		}
+		log.Printf("Payload store enabled: %s", cfg.PayloadStore.Endpoint)
+	}
 
 	// Создание контекста с возможностью отмены для graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Executor заданий; соединение с RabbitMQ он устанавливает при первом задании rabbitmq
+	executor := worker.NewExecutor(worker.ExecutorOptions{
+		SQLDB:       sqlTaskDB,
+		Schemas:     schemas,
+		Payloads:    payloads,
+		DryRun:      cfg.Worker.DryRun,
+		RabbitMQURL: cfg.RabbitMQ.URL,
+
+		NetworkRetries:    cfg.Worker.NetworkRetries,
+		QuarantineUnknown: cfg.Worker.UnknownTypeAction == "quarantine",
+		HostMaxInFlight:   cfg.Worker.HostMaxInFlight,
+
+		CallbackAllowlist:    cfg.Worker.CallbackAllowlist,
+		CallbackDenylist:     cfg.Worker.CallbackDenylist,
+		CallbackBlockPrivate: !cfg.Worker.CallbackPrivate,
+		TargetCacheTTL:       cfg.Worker.CallbackDNSCache,
+		OmitEmptyData:        cfg.Worker.CallbackEmptyData == "omit",
+		BinaryResponses:      cfg.Worker.BinaryResponses,
+	})
+	defer executor.Close()
+	if cfg.RabbitMQ.URL != "" {
+		log.Printf("RabbitMQ enabled: %s", cfg.RabbitMQ.RedactedURL())
+	}
+
 	// Создание и запуск Worker
 	w := worker.NewWorker(
 		database,
-		cfg.Worker.WorkerID,
-		cfg.Worker.PollingInterval,
-		cfg.Worker.BatchSize,
+		executor,
+		worker.Options{
+			WorkerID:        cfg.Worker.WorkerID,
+			PollingInterval: cfg.Worker.PollingInterval,
+			MinPollInterval: cfg.Worker.MinPollInterval,
+			MaxPollInterval: cfg.Worker.MaxPollInterval,
+			DBBackoffMax:    cfg.Worker.DBBackoffMax,
+			BatchSize:       cfg.Worker.BatchSize,
+			TypeBatchSizes:  cfg.Worker.TypeBatchSizes,
+			QueueBatchSizes: cfg.Worker.QueueBatchSizes,
+			Queues:          cfg.Worker.Queues,
+			MinFreeConns:    cfg.Worker.MinFreeConns,
+			ClaimWindow:     cfg.Worker.ClaimWindow,
+			TypeQuota:       cfg.Worker.TypeQuota,
+			FleetLimits:     cfg.Worker.FleetTypeLimits,
+			CatchUpOverdue:  cfg.Worker.CatchUpOverdue,
+			CatchUpRate:     cfg.Worker.CatchUpRate,
+			CatchUpInterval: cfg.Worker.CatchUpInterval,
+			Lookahead:       cfg.Worker.Lookahead,
+			PayloadBudget:   cfg.Worker.PayloadBudget,
+			TaskTimeout:     cfg.Worker.TaskTimeout,
+			TypeTimeouts:    cfg.Worker.TypeTimeouts,
+			MetricTaskTypes: cfg.Worker.MetricTaskTypes,
+			RetryToBack:     cfg.Worker.RetryToBack,
+			RetryBaseDelay:  cfg.Worker.RetryBaseDelay,
+			RetryMaxDelay:   cfg.Worker.RetryMaxDelay,
+
+			NotifyBatchInterval: cfg.Worker.NotifyBatchWindow,
+			NotifyBatchSize:     cfg.Worker.NotifyBatchSize,
+			NotifyMaxAttempts:   cfg.Worker.NotifyMaxAttempts,
+			NotifyRetryBase:     cfg.Worker.NotifyRetryBase,
+		},
 	)
 
 	// Создание и запуск Cleaner
 	c := worker.NewCleaner(
-		database,
+		cleanerDB,
 		cfg.Worker.CleanerInterval,
 		cfg.Worker.StuckTimeout,
 	)
 
+	// Внутренний HTTP сервер для метрик (только если задан порт)
+	var httpServer *http.Server
+	if cfg.Worker.HTTPPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+			// Кратковременные сбои ping сглаживаются повторами, чтобы probe не "мигал"
+			if err := db.PingWithRetry(r.Context(), database, cfg.Worker.ReadyRetries, cfg.Worker.ReadyInterval); err != nil {
+				log.Printf("Readiness check failed: %v", err)
+				http.Error(w, "database is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("OK"))
+		})
+		mux.HandleFunc("/config", configHandler(cfg.Effective(), cfg.Worker.AdminToken))
+		mux.HandleFunc("/cleaner/run", cleanerRunHandler(c, cfg.Worker.AdminToken))
+		if cfg.Worker.EnablePprof {
+			registerPprof(mux)
+			log.Println("pprof profiling endpoints enabled on /debug/pprof/")
+		}
+
+		httpServer = &http.Server{Addr: fmt.Sprintf(":%s", cfg.Worker.HTTPPort), Handler: mux}
+		go func() {
+			log.Printf("Starting internal HTTP server on %s", httpServer.Addr)
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Internal HTTP server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Подсчет очереди нужен для /metrics или для предупреждений об отставании от расписания в логе
+	if (httpServer != nil || cfg.Worker.MaxSchedulingLag > 0) && cfg.Worker.QueueDepthInterval > 0 {
+		go worker.NewQueueDepthMonitor(cleanerDB, cfg.Worker.QueueDepthInterval, cfg.Worker.MaxSchedulingLag).Start(ctx)
+	}
+
 	// Запуск Worker и Cleaner в отдельных goroutines
-	go w.Start(ctx)
-	go c.Start(ctx)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w.Start(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		c.Start(ctx)
+	}()
 
 	log.Println("Worker and Cleaner started successfully")
 
@@ -87,8 +324,115 @@ func main() {
 	// Отменяем контекст, что приведет к остановке Worker и Cleaner
 	cancel()
 
-	// Даем время на завершение текущих задач (можно добавить sync.WaitGroup для более точного контроля)
-	// time.Sleep(5 * time.Second)
+	// Все этапы остановки укладываются в WORKER_SHUTDOWN_TIMEOUT
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Worker.ShutdownTimeout)
+	defer shutdownCancel()
+
+	// Ждем завершения Worker и Cleaner, чтобы метрики отражали итог их работы
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Println("Shutdown timeout reached while waiting for Worker and Cleaner")
+	}
+
+	if httpServer != nil {
+		flushMetrics(shutdownCtx, cfg.Worker.MetricsFlush)
+
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down internal HTTP server: %v", err)
+		}
+	}
 
 	log.Println("=== AT Worker Stopped ===")
 }
+
+// flushMetrics дожидается финального scrape метрик перед выходом процесса.
+// Метрики хранятся только в памяти, а Prometheus забирает их сам (pull), поэтому
+// без ожидания значения за последний интервал перед остановкой были бы потеряны.
+// Ожидание ограничено timeout и общим таймаутом остановки (ctx).
+func flushMetrics(ctx context.Context, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	flushCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("Waiting up to %v for final metrics scrape...", timeout)
+	if err := metrics.WaitForScrape(flushCtx); err != nil {
+		log.Printf("Final metrics scrape did not happen: %v", err)
+		return
+	}
+	log.Println("Final metrics scraped")
+}
+
+// configHandler обрабатывает GET /config - действующая конфигурация worker'а (пароли и токены замаскированы).
+// Доступен только с заголовком Authorization: Bearer <WORKER_ADMIN_TOKEN>; без настроенного токена - 404.
+func configHandler(settings map[string]string, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"config": settings})
+	}
+}
+
+// cleanerRunHandler обрабатывает POST /cleaner/run - внеочередной запуск поиска зависших заданий.
+// Отвечает числом возвращенных в очередь и переведенных в 'failed' заданий. Доступ - как у GET /config.
+func cleanerRunHandler(c *worker.Cleaner, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizeAdmin(w, r, adminToken) {
+			return
+		}
+
+		result, err := c.RunNow(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// authorizeAdmin проверяет заголовок Authorization: Bearer <WORKER_ADMIN_TOKEN> служебного эндпоинта.
+// Без настроенного токена отвечает 404 (эндпоинт выключен), с неверным токеном - 401.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// registerPprof регистрирует обработчики net/http/pprof (/debug/pprof/...) на внутреннем сервере,
+// чтобы снять CPU/heap профиль работающего worker'а: go tool pprof http://worker:9090/debug/pprof/profile
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
@@ -0,0 +1,300 @@
+// Package metrics содержит минимальную реализацию метрик worker'а в формате Prometheus.
+// Метрики хранятся в памяти процесса и отдаются через HTTP (text exposition format 0.0.4),
+// поэтому для сбора достаточно добавить worker в scrape-конфигурацию Prometheus.
+// Сторонние клиентские библиотеки не используются, чтобы не раздувать зависимости worker'а.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricKind - тип метрики в терминах Prometheus
+type metricKind string
+
+const (
+	kindCounter metricKind = "counter"
+	kindGauge   metricKind = "gauge"
+)
+
+// sample - значение метрики для конкретного набора значений меток
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// family - метрика с именем, описанием и набором значений по меткам
+type family struct {
+	name       string
+	help       string
+	kind       metricKind
+	labelNames []string
+	valueFunc  func() float64 // Для метрик, вычисляемых в момент сбора (без меток)
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+// registry хранит все зарегистрированные метрики в порядке регистрации
+var registry = struct {
+	mu         sync.Mutex
+	families   []*family
+	names      map[string]bool
+	collectors []func()
+}{names: make(map[string]bool)}
+
+// OnCollect регистрирует функцию, вызываемую перед каждым сбором метрик.
+// Используется для обновления значений, которые дорого или неудобно поддерживать постоянно
+// (например, статистика пула соединений).
+func OnCollect(fn func()) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = append(registry.collectors, fn)
+}
+
+// register добавляет метрику в реестр; повторная регистрация имени - ошибка программиста
+func register(f *family) *family {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if registry.names[f.name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", f.name))
+	}
+	registry.names[f.name] = true
+	registry.families = append(registry.families, f)
+	return f
+}
+
+func newFamily(name, help string, kind metricKind, labelNames []string) *family {
+	return register(&family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		samples:    make(map[string]*sample),
+	})
+}
+
+// update изменяет значение метрики для набора значений меток
+func (f *family) update(labelValues []string, fn func(v float64) float64) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		f.samples[key] = s
+	}
+	s.value = fn(s.value)
+}
+
+// Counter - монотонно растущий счетчик
+type Counter struct {
+	f *family
+}
+
+// NewCounter регистрирует счетчик с указанными именами меток
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{f: newFamily(name, help, kindCounter, labelNames)}
+}
+
+// Inc увеличивает счетчик на 1
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add увеличивает счетчик на v (v должно быть неотрицательным)
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.f.update(labelValues, func(old float64) float64 { return old + v })
+}
+
+// Set устанавливает значение счетчика.
+// Только для отражения накопительных значений, которые считаются в другом месте (например, sql.DBStats.WaitCount).
+func (c *Counter) Set(v float64, labelValues ...string) {
+	c.f.update(labelValues, func(float64) float64 { return v })
+}
+
+// Gauge - значение, которое может как расти, так и уменьшаться
+type Gauge struct {
+	f *family
+}
+
+// NewGauge регистрирует gauge с указанными именами меток
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{f: newFamily(name, help, kindGauge, labelNames)}
+}
+
+// Set устанавливает значение
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(float64) float64 { return v })
+}
+
+// Add изменяет значение на v (может быть отрицательным)
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(old float64) float64 { return old + v })
+}
+
+// NewCounterFunc регистрирует счетчик, значение которого вычисляется в момент сбора.
+// Удобно для накопительных значений, которые уже считает кто-то другой (например, sql.DBStats).
+func NewCounterFunc(name, help string, fn func() float64) {
+	f := newFamily(name, help, kindCounter, nil)
+	f.valueFunc = fn
+}
+
+// NewGaugeFunc регистрирует gauge, значение которого вычисляется в момент сбора
+func NewGaugeFunc(name, help string, fn func() float64) {
+	f := newFamily(name, help, kindGauge, nil)
+	f.valueFunc = fn
+}
+
+// WriteText записывает все метрики в формате Prometheus text exposition
+func WriteText(w io.Writer) error {
+	registry.mu.Lock()
+	families := append([]*family(nil), registry.families...)
+	collectors := append([]func(){}, registry.collectors...)
+	registry.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	for _, f := range families {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind); err != nil {
+			return err
+		}
+
+		if f.valueFunc != nil {
+			if _, err := fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.valueFunc())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f.mu.Lock()
+		samples := make([]sample, 0, len(f.samples))
+		for _, s := range f.samples {
+			samples = append(samples, *s)
+		}
+		f.mu.Unlock()
+
+		sort.Slice(samples, func(i, j int) bool {
+			return strings.Join(samples[i].labelValues, "\xff") < strings.Join(samples[j].labelValues, "\xff")
+		})
+
+		for _, s := range samples {
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues), formatValue(s.value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// OtherLabelValue - значение метки для значений, не входящих в LabelAllowlist
+const OtherLabelValue = "other"
+
+// LabelAllowlist ограничивает кардинальность метки: значения вне списка заменяются на OtherLabelValue.
+// Нужен для меток из пользовательских данных (например, task_type), иначе каждый новый
+// произвольный тип порождает отдельный временной ряд в Prometheus.
+type LabelAllowlist struct {
+	values map[string]bool
+}
+
+// NewLabelAllowlist создает allowlist из списка допустимых значений метки
+func NewLabelAllowlist(values []string) *LabelAllowlist {
+	a := &LabelAllowlist{values: make(map[string]bool, len(values))}
+	for _, v := range values {
+		a.values[v] = true
+	}
+	return a
+}
+
+// Value возвращает v, если оно разрешено, иначе OtherLabelValue
+func (a *LabelAllowlist) Value(v string) string {
+	if a.values[v] {
+		return v
+	}
+	return OtherLabelValue
+}
+
+// scrape сигнализирует ожидающим WaitForScrape о завершенном сборе метрик.
+// Канал закрывается после каждого сбора и заменяется новым.
+var scrape = struct {
+	mu   sync.Mutex
+	done chan struct{}
+}{done: make(chan struct{})}
+
+// Handler возвращает HTTP обработчик для endpoint'а /metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteText(w); err != nil {
+			return
+		}
+
+		scrape.mu.Lock()
+		close(scrape.done)
+		scrape.done = make(chan struct{})
+		scrape.mu.Unlock()
+	})
+}
+
+// WaitForScrape блокируется до следующего успешного сбора метрик через Handler или отмены ctx.
+// Используется при остановке worker'а: метрики живут только в памяти процесса и pull-модель
+// Prometheus заберет финальные значения, только если процесс дождется очередного scrape.
+func WaitForScrape(ctx context.Context) error {
+	scrape.mu.Lock()
+	done := scrape.done
+	scrape.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// formatLabels формирует строку вида {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeLabelValue экранирует обратный слэш, кавычки и перевод строки в значении метки
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// escapeHelp экранирует обратный слэш и перевод строки в описании метрики
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// formatValue форматирует значение метрики без лишних нулей
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
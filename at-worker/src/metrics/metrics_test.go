@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteText проверяет формат вывода счетчиков и gauge с метками
+func TestWriteText(t *testing.T) {
+	counter := NewCounter("test_events_total", "Test events.", "type")
+	counter.Inc("b")
+	counter.Add(2, "a")
+	gauge := NewGauge("test_depth", "Test depth.")
+	gauge.Set(7)
+	NewGaugeFunc("test_func", "Test func.", func() float64 { return 1.5 })
+	quoted := NewCounter("test_quoted_total", "Test quoting.", "value")
+	quoted.Inc(`say "hi"`)
+
+	var sb strings.Builder
+	if err := WriteText(&sb); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := []string{
+		"# TYPE test_events_total counter\ntest_events_total{type=\"a\"} 2\ntest_events_total{type=\"b\"} 1\n",
+		"# TYPE test_depth gauge\ntest_depth 7\n",
+		"test_func 1.5\n",
+		`test_quoted_total{value="say \"hi\""} 1`,
+	}
+	for _, w := range want {
+		if !strings.Contains(sb.String(), w) {
+			t.Errorf("Output does not contain %q:\n%s", w, sb.String())
+		}
+	}
+}
+
+// TestLabelAllowlist проверяет замену неизвестных значений метки на "other"
+func TestLabelAllowlist(t *testing.T) {
+	allowlist := NewLabelAllowlist([]string{"email", "http_callback"})
+
+	testCases := map[string]string{
+		"email":         "email",
+		"http_callback": "http_callback",
+		"tenant-42-job": OtherLabelValue,
+		"":              OtherLabelValue,
+	}
+	for value, want := range testCases {
+		if got := allowlist.Value(value); got != want {
+			t.Errorf("Value(%q): got=%q, want=%q", value, got, want)
+		}
+	}
+}
+
+// TestWaitForScrape проверяет, что WaitForScrape дожидается следующего сбора метрик
+func TestWaitForScrape(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := WaitForScrape(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitForScrape without scrape: got=%v, want=%v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- WaitForScrape(context.Background())
+	}()
+
+	// Собираем метрики, пока ожидающий не получит сигнал
+	for {
+		Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("WaitForScrape after scrape: unexpected error %v", err)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -16,6 +16,7 @@ type ScheduledTask struct {
 	ID           int64           `json:"id"`
 	ExecuteAt    time.Time       `json:"execute_at"`
 	TaskType     string          `json:"task_type"`
+	Queue        string          `json:"queue"`
 	Payload      json.RawMessage `json:"payload"`
 	Status       string          `json:"status"`
 	Attempts     int             `json:"attempts"`
@@ -24,12 +25,45 @@ type ScheduledTask struct {
 	CreatedAt    time.Time       `json:"created_at"`
 	UpdatedAt    time.Time       `json:"updated_at"`
 	CompletedAt  sql.NullTime    `json:"completed_at,omitempty"`
+	ClaimedAt    sql.NullTime    `json:"claimed_at,omitempty"`
+	Timeout      sql.NullInt32   `json:"timeout_seconds,omitempty"` // Таймаут выполнения; NULL - по умолчанию для типа
+	Notify       []byte          `json:"-"`                         // Каналы уведомлений о завершении (JSON колонки notify); nil - нет
+	PayloadRef   sql.NullString  `json:"payload_ref,omitempty"`     // Ссылка на payload во внешнем хранилище (s3://bucket/key); payload при этом {}
+
+	CronExpression sql.NullString `json:"cron_expression,omitempty"` // Cron-расписание повторяющегося задания; NULL - без cron
 }
 
 // TaskResult представляет результат выполнения задания.
-// Содержит ID задания, признак успешности выполнения и сообщение об ошибке (если есть).
+// Содержит ID задания, признак успешности выполнения, сообщение об ошибке (если есть)
+// и структурированный результат, который сохраняется в колонку result.
 type TaskResult struct {
-	TaskID       int64
-	Success      bool
-	ErrorMessage string
+	TaskID        int64
+	TaskType      string // Тип задания (для метрик)
+	Attempt       int    // Номер попытки (attempts после захвата): результат записывается только для нее
+	Success       bool
+	ErrorMessage  string
+	RetryAfter    time.Duration   // Задержка перед повтором, запрошенная получателем (Retry-After); 0 - не задана
+	Result        json.RawMessage // Структурированный результат выполнения (колонка result); nil - нет результата
+	Warning       string          // Предупреждение успешного выполнения (частичный успех, колонка warning); пусто - нет
+	Notify        []byte          // Каналы уведомлений задания (из ScheduledTask.Notify)
+	Permanent     bool            // Ошибку не исправит повтор (некорректный payload): задание сразу переходит в failed
+	Quarantine    bool            // Тип задания неизвестен этому worker'у: задание возвращается в pending без траты попытки
+	Deferred      bool            // Лимит одновременных запросов к хосту исчерпан: задание отложено без траты попытки и без выполнения
+	FailureReason string          // Класс ошибки неуспешного выполнения (FailureTimeout, FailureHTTP5xx, ...; колонка failure_reason)
+	Duration      time.Duration   // Длительность выполнения (колонка duration_ms)
+	ContentType   string          // Content-Type ответа http_callback (колонка response_content_type); пусто - нет ответа
+	BodyBase64    string          // Бинарное тело ответа в base64 (колонка response_body_base64, WORKER_BINARY_RESPONSE_MODE=base64)
+
+	CronExpression string // Cron-расписание задания (из ScheduledTask.CronExpression): по нему считается следующее выполнение
 }
+
+// Классы ошибок выполнения (колонка failure_reason и метка reason метрики at_worker_task_failures_total).
+// Свободный текст error_message удобен для разбора отдельного случая, класс - для агрегации в дашбордах
+const (
+	FailureTimeout           = "timeout"            // Истек таймаут задания или сетевой таймаут
+	FailureConnectionRefused = "connection_refused" // Получатель отказал в соединении
+	FailureHTTP4xx           = "http_4xx"           // Получатель ответил 4xx
+	FailureHTTP5xx           = "http_5xx"           // Получатель ответил 5xx
+	FailureValidation        = "validation"         // Некорректный payload, URL или неизвестный тип задания
+	FailureUnknown           = "unknown"            // Остальные ошибки
+)
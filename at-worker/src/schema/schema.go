@@ -0,0 +1,256 @@
+// Package schema содержит валидацию payload заданий по JSON Schema.
+// Схемы лежат в каталоге WORKER_SCHEMA_DIR, по одному файлу <task_type>.json на тип задания.
+// Схемы разбираются один раз и кэшируются в Registry; перечитать каталог без перезапуска
+// worker'а можно сигналом SIGHUP (см. main.go).
+//
+// Поддерживается подмножество JSON Schema, достаточное для проверки структуры payload:
+// type, properties, required, additionalProperties (bool), items, enum,
+// minLength, maxLength, minimum, maximum. Остальные ключевые слова игнорируются.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Schema - разобранная JSON Schema (поддерживаемое подмножество)
+type Schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+}
+
+// Parse разбирает JSON Schema и проверяет, что указанные типы поддерживаются
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if err := s.check("$"); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// check рекурсивно проверяет корректность схемы
+func (s *Schema) check(path string) error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("%s: unsupported type %q", path, s.Type)
+	}
+	for name, prop := range s.Properties {
+		if err := prop.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// Validate проверяет payload на соответствие схеме.
+// Ошибка содержит путь к первому несоответствующему полю, например "$.data.email: expected string".
+func (s *Schema) Validate(payload []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return s.validate("$", value)
+}
+
+func (s *Schema) validate(path string, value interface{}) error {
+	if s.Type != "" && !matchesType(s.Type, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, s.Type, typeName(value))
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		return fmt.Errorf("%s: value is not one of the allowed values", path)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+		// Сортируем ключи, чтобы ошибка была детерминированной
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected field %q", path, name)
+				}
+				continue
+			}
+			if err := prop.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: length must be at least %d", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: length must be at most %d", path, *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: must be >= %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: must be <= %v", path, *s.Maximum)
+		}
+	}
+
+	return nil
+}
+
+// matchesType проверяет тип значения, полученного через json.Unmarshal в interface{}
+func matchesType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+// typeName возвращает название JSON типа значения для сообщений об ошибках
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return "unknown"
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry хранит разобранные схемы по типам заданий.
+// Безопасен для конкурентного использования: Validate вызывается из goroutine'ов выполнения,
+// Reload - из обработчика SIGHUP.
+type Registry struct {
+	dir string
+
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+}
+
+// NewRegistry создает реестр и загружает схемы из каталога dir.
+// Возвращает ошибку, если каталог не читается или какая-либо схема невалидна.
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{dir: dir, schemas: make(map[string]*Schema)}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload перечитывает все файлы *.json из каталога.
+// Схемы заменяются атомарно и только если все файлы разобраны успешно:
+// ошибка в одной схеме не должна отключить валидацию остальных типов.
+func (r *Registry) Reload() error {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list schema dir: %w", err)
+	}
+
+	schemas := make(map[string]*Schema, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read schema %s: %w", file, err)
+		}
+		s, err := Parse(data)
+		if err != nil {
+			return fmt.Errorf("invalid schema %s: %w", file, err)
+		}
+		schemas[strings.TrimSuffix(filepath.Base(file), ".json")] = s
+	}
+
+	r.mu.Lock()
+	r.schemas = schemas
+	r.mu.Unlock()
+
+	log.Printf("[Schema] Loaded %d payload schema(s) from %s", len(schemas), r.dir)
+	return nil
+}
+
+// Validate проверяет payload задания по схеме его типа.
+// Если схемы для типа нет, payload считается валидным.
+func (r *Registry) Validate(taskType string, payload []byte) error {
+	r.mu.RLock()
+	s, ok := r.schemas[taskType]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	return s.Validate(payload)
+}
@@ -0,0 +1,94 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const httpCallbackSchema = `{
+	"type": "object",
+	"required": ["url"],
+	"properties": {
+		"url": {"type": "string", "minLength": 1},
+		"method": {"enum": ["GET", "POST"]},
+		"retries": {"type": "integer", "minimum": 0, "maximum": 5},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	},
+	"additionalProperties": false
+}`
+
+// TestSchemaValidate проверяет поддерживаемые ключевые слова и путь к полю в ошибке
+func TestSchemaValidate(t *testing.T) {
+	s, err := Parse([]byte(httpCallbackSchema))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	testCases := []struct {
+		payload string
+		wantErr string // пусто - payload валиден
+	}{
+		{`{"url": "http://example.com", "method": "GET", "retries": 2, "tags": ["a"]}`, ""},
+		{`{"method": "GET"}`, `$: missing required field "url"`},
+		{`{"url": ""}`, "$.url: length must be at least 1"},
+		{`{"url": "x", "method": "PUT"}`, "$.method: value is not one of the allowed values"},
+		{`{"url": "x", "retries": 1.5}`, "$.retries: expected integer, got number"},
+		{`{"url": "x", "retries": 10}`, "$.retries: must be <= 5"},
+		{`{"url": "x", "tags": ["a", 1]}`, "$.tags[1]: expected string, got number"},
+		{`{"url": "x", "extra": true}`, `$: unexpected field "extra"`},
+		{`[]`, "$: expected object, got array"},
+	}
+
+	for _, tc := range testCases {
+		err := s.Validate([]byte(tc.payload))
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("Validate(%s): unexpected error %v", tc.payload, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tc.wantErr {
+			t.Errorf("Validate(%s): got=%v, want=%s", tc.payload, err, tc.wantErr)
+		}
+	}
+}
+
+// TestRegistryReload проверяет загрузку схем из каталога и перезагрузку без перезапуска
+func TestRegistryReload(t *testing.T) {
+	dir := t.TempDir()
+	writeSchema := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write schema: %v", err)
+		}
+	}
+
+	r, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v", err)
+	}
+
+	// Без схемы любой payload валиден
+	if err := r.Validate("http_callback", []byte(`{}`)); err != nil {
+		t.Errorf("Validate without schema: unexpected error %v", err)
+	}
+
+	writeSchema("http_callback.json", httpCallbackSchema)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	if err := r.Validate("http_callback", []byte(`{}`)); err == nil {
+		t.Error("Validate after reload: expected error for payload without url")
+	}
+
+	// Невалидная схема не применяется, ранее загруженные остаются в силе
+	writeSchema("email.json", `{"type": "tuple"}`)
+	if err := r.Reload(); err == nil || !strings.Contains(err.Error(), "unsupported type") {
+		t.Errorf("Reload with invalid schema: got=%v, want unsupported type error", err)
+	}
+	if err := r.Validate("http_callback", []byte(`{}`)); err == nil {
+		t.Error("Previously loaded schema should stay active after failed reload")
+	}
+}
@@ -0,0 +1,57 @@
+// Файл adaptive_poll.go - адаптивный интервал опроса по заполненности батчей.
+// Пустая очередь не должна нагружать БД запросами каждые pollingInterval, а глубокая -
+// ждать pollingInterval между батчами, когда заданий заведомо больше, чем помещается в один.
+package worker
+
+import "time"
+
+// pollResult - итог одного опроса для адаптивного интервала
+type pollResult int
+
+const (
+	pollEmpty   pollResult = iota // Заданий к выполнению нет
+	pollPartial                   // Захвачена часть батча: очередь разобрана
+	pollFull                      // Батч заполнен (или уперся в бюджет payload): в очереди, вероятно, есть еще задания
+	pollError                     // Опрос завершился ошибкой БД: интервал не меняется, опрос повторяется с паузой (см. backoff)
+)
+
+// adaptiveInterval подстраивает паузу между опросами: после полного батча она уменьшается вдвое
+// (но не меньше min), после пустого опроса - удваивается (но не больше max), после неполного не меняется.
+// Используется только из цикла Start, поэтому без блокировок.
+type adaptiveInterval struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+// newAdaptiveInterval создает адаптивный интервал, начинающийся с initial (приводится к [min, max]);
+// при max <= 0 возвращает nil (опрос с постоянным интервалом)
+func newAdaptiveInterval(initial, min, max time.Duration) *adaptiveInterval {
+	if max <= 0 {
+		return nil
+	}
+	a := &adaptiveInterval{min: min, max: max, current: initial}
+	a.current = a.clamp(a.current)
+	return a
+}
+
+// next учитывает итог опроса и возвращает паузу до следующего опроса
+func (a *adaptiveInterval) next(result pollResult) time.Duration {
+	switch result {
+	case pollFull:
+		a.current = a.clamp(a.current / 2)
+	case pollEmpty:
+		a.current = a.clamp(a.current * 2)
+	}
+	return a.current
+}
+
+func (a *adaptiveInterval) clamp(d time.Duration) time.Duration {
+	if d < a.min {
+		return a.min
+	}
+	if d > a.max {
+		return a.max
+	}
+	return d
+}
@@ -0,0 +1,31 @@
+// Файл backoff.go - экспоненциальная пауза перед повтором после ошибки.
+// Общая для повторов заданий (WORKER_RETRY_BASE_DELAY), доставки уведомлений из outbox и опроса
+// при недоступной БД: короткий сбой переживается быстрым повтором, а долгий не превращается в поток запросов.
+package worker
+
+import (
+	"math"
+	"time"
+)
+
+// backoff возвращает паузу после неудачной попытки attempt (с 1): base, 2*base, 4*base...
+// но не больше max (0 - без ограничения). При base = 0 пауза не делается
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay > 0; i++ {
+		if (max > 0 && delay >= max) || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		return max
+	}
+	return delay
+}
+
+// retryDelay возвращает паузу перед повтором задания после attempts неудачных попыток:
+// base * 2^attempts (2*base после первой, 4*base после второй...), но не больше max
+func retryDelay(attempts int, base, max time.Duration) time.Duration {
+	return backoff(attempts+1, base, max)
+}
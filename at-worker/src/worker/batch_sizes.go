@@ -0,0 +1,110 @@
+// Файл batch_sizes.go - размер батча по типам заданий и очередям.
+// Общий batchSize одинаков для всех заданий worker'а: медленные email хочется забирать маленькими батчами,
+// а быстрые http_callback - большими. Размер, заданный для типа или очереди, ограничивает число заданий
+// этого типа (очереди) в одном батче; остальные задания вместе ограничены общим batchSize.
+package worker
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// batchSizes - размеры батча по типам и очередям. Размер типа важнее размера очереди:
+// задание типа с заданным размером учитывается только в группе своего типа
+type batchSizes struct {
+	defaultSize int
+	types       map[string]int
+	queues      map[string]int
+}
+
+// newBatchSizes создает размеры батча; без размеров по типам и очередям возвращает nil (общий batchSize для всех)
+func newBatchSizes(defaultSize int, types, queues map[string]int) *batchSizes {
+	if len(types) == 0 && len(queues) == 0 {
+		return nil
+	}
+	return &batchSizes{defaultSize: defaultSize, types: types, queues: queues}
+}
+
+// limit возвращает размер всего батча: наибольший из размеров групп, чтобы большой размер типа
+// не упирался в общий batchSize. Без размеров по типам и очередям - defaultSize.
+// Заполненность батча по limit не определяется: группа может упереться в свой размер раньше (см. batchFill)
+func (s *batchSizes) limit(defaultSize int) int {
+	if s == nil {
+		return defaultSize
+	}
+	limit := s.defaultSize
+	for _, sizes := range []map[string]int{s.types, s.queues} {
+		for _, size := range sizes {
+			limit = max(limit, size)
+		}
+	}
+	return limit
+}
+
+// groupOf возвращает группу задания и ее размер так же, как SQL выражения sqlExprs
+func (s *batchSizes) groupOf(taskType, queue string) (string, int) {
+	if size, ok := s.types[taskType]; ok {
+		return "type:" + taskType, size
+	}
+	if size, ok := s.queues[queue]; ok {
+		return "queue:" + queue, size
+	}
+	return "", s.defaultSize
+}
+
+// batchFill считает задания опроса по группам: батч заполнен, если хотя бы одна группа набрала свой размер -
+// в ней, вероятно, остались задания, даже если весь батч меньше limit
+type batchFill struct {
+	sizes  *batchSizes
+	counts map[string]int
+	full   bool
+}
+
+// newFill создает счетчик заполненности групп; без размеров по типам и очередям группы не считаются
+func (s *batchSizes) newFill() *batchFill {
+	return &batchFill{sizes: s, counts: make(map[string]int)}
+}
+
+// add учитывает выбранное запросом задание
+func (f *batchFill) add(taskType, queue string) {
+	if f.sizes == nil {
+		return
+	}
+	group, size := f.sizes.groupOf(taskType, queue)
+	f.counts[group]++
+	if f.counts[group] >= size {
+		f.full = true
+	}
+}
+
+// sqlExprs добавляет в args параметры размеров и возвращает SQL выражения группы задания
+// (тип с размером, очередь с размером или общая группа) и размера батча этой группы
+func (s *batchSizes) sqlExprs(args []interface{}) ([]interface{}, string, string) {
+	types, typeSizes := sizeArrays(s.types)
+	queues, queueSizes := sizeArrays(s.queues)
+	args = append(args, types, typeSizes, queues, queueSizes, s.defaultSize)
+	n := len(args)
+
+	group := fmt.Sprintf("CASE WHEN task_type = ANY($%d::text[]) THEN 'type:' || task_type WHEN queue = ANY($%d::text[]) THEN 'queue:' || queue ELSE '' END",
+		n-4, n-2)
+	size := fmt.Sprintf("COALESCE(($%d::int[])[array_position($%d::text[], task_type)], ($%d::int[])[array_position($%d::text[], queue)], $%d)",
+		n-3, n-4, n-1, n-2, n)
+	return args, group, size
+}
+
+// sizeArrays возвращает ключи и размеры в виде параллельных массивов для запроса (в порядке ключей)
+func sizeArrays(sizes map[string]int) (interface{}, interface{}) {
+	keys := make([]string, 0, len(sizes))
+	for key := range sizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		values[i] = int64(sizes[key])
+	}
+	return pq.Array(keys), pq.Array(values)
+}
@@ -0,0 +1,80 @@
+// Файл binary_response.go - сохранение бинарного тела ответа http_callback.
+// Тело ответа пишется в error_message и result как текст. Бинарное тело (картинка, protobuf) при этом
+// искажается: невалидный UTF-8 заменяется, а байт 0 PostgreSQL в TEXT не примет вовсе. Поэтому вместо такого
+// тела сохраняется его описание (тип и размер), а само тело - в зависимости от WORKER_BINARY_RESPONSE_MODE.
+package worker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Режимы сохранения бинарного тела ответа http_callback (WORKER_BINARY_RESPONSE_MODE)
+const (
+	BinaryResponseSkip   = "skip"   // Только Content-Type и размер тела
+	BinaryResponseHash   = "hash"   // Дополнительно SHA-256 тела (body_sha256 в result)
+	BinaryResponseBase64 = "base64" // Тело в base64 в колонке response_body_base64
+)
+
+// textMediaTypes - типы application/*, тело которых - текст
+var textMediaTypes = map[string]bool{
+	"application/json":                  true,
+	"application/xml":                   true,
+	"application/javascript":            true,
+	"application/x-ndjson":              true,
+	"application/x-www-form-urlencoded": true,
+}
+
+// isBinaryResponse определяет, что тело ответа нельзя сохранить как текст. Тело, которое не является
+// корректным UTF-8 или содержит байт 0, бинарное при любом Content-Type. Остальное бинарное, если Content-Type
+// указан и это не text/*, не JSON или XML (в том числе application/problem+json, image/svg+xml) и не форма
+func isBinaryResponse(contentType string, body []byte) bool {
+	if len(body) == 0 {
+		return false
+	}
+	if !utf8.Valid(body) || bytes.IndexByte(body, 0) >= 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return false
+	}
+	return !textMediaTypes[mediaType]
+}
+
+// binaryBodySummary - описание бинарного тела для error_message вместо самого тела
+func binaryBodySummary(contentType string, body []byte) string {
+	if contentType == "" {
+		contentType = "no Content-Type"
+	}
+	return fmt.Sprintf("<binary body: %d bytes, %s>", len(body), contentType)
+}
+
+// binaryBodyBase64 возвращает тело для колонки response_body_base64: только в режиме BinaryResponseBase64
+// и для тела не длиннее maxResultBodySize (обрезанное бинарное тело бесполезно, вместо него - body_sha256)
+func binaryBodyBase64(body []byte, mode string) string {
+	if mode != BinaryResponseBase64 || len(body) > maxResultBodySize {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(body)
+}
+
+// binaryBodyHash возвращает SHA-256 бинарного тела для result: в режиме BinaryResponseHash и в режиме
+// BinaryResponseBase64, если тело слишком велико для response_body_base64
+func binaryBodyHash(body []byte, mode string) string {
+	if mode != BinaryResponseHash && (mode != BinaryResponseBase64 || len(body) <= maxResultBodySize) {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,78 @@
+// Файл catchup.go - ограничение скорости догоняющего выполнения просроченных заданий.
+// После долгого простоя worker'ов тысячи заданий с прошедшим execute_at становятся доступны разом;
+// выполненные одновременно, они могут положить downstream, который только что восстановился.
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// catchUpThrottle ограничивает число просроченных заданий каждого типа, захватываемых за интервал.
+// Просроченным считается задание, чей execute_at прошел больше чем overdue назад: задания
+// "на сейчас" и с обычным отставанием опроса не ограничиваются и выполняются как обычно.
+// Счетчики локальны для worker'а: при N worker'ах парк догоняет со скоростью N*rate за интервал.
+type catchUpThrottle struct {
+	overdue  time.Duration
+	rate     int
+	interval time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	taken       map[string]int
+}
+
+// newCatchUpThrottle создает ограничитель; при rate <= 0 возвращает nil (ограничение выключено)
+func newCatchUpThrottle(overdue time.Duration, rate int, interval time.Duration) *catchUpThrottle {
+	if rate <= 0 {
+		return nil
+	}
+	return &catchUpThrottle{
+		overdue:  overdue,
+		rate:     rate,
+		interval: interval,
+		taken:    make(map[string]int),
+	}
+}
+
+// isOverdue сообщает, просрочено ли задание с этим execute_at настолько, что попадает под ограничение
+func (c *catchUpThrottle) isOverdue(executeAt, now time.Time) bool {
+	return executeAt.Before(now.Add(-c.overdue))
+}
+
+// resetLocked начинает новый интервал, если текущий истек
+func (c *catchUpThrottle) resetLocked(now time.Time) {
+	if now.Sub(c.windowStart) >= c.interval {
+		c.windowStart = now
+		c.taken = make(map[string]int)
+	}
+}
+
+// take учитывает захват просроченного задания типа; false - лимит типа в этом интервале исчерпан
+func (c *catchUpThrottle) take(taskType string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resetLocked(now)
+	if c.taken[taskType] >= c.rate {
+		return false
+	}
+	c.taken[taskType]++
+	return true
+}
+
+// exhausted возвращает типы, у которых лимит текущего интервала исчерпан: их просроченные
+// задания не нужно даже выбирать, чтобы они не занимали батч вместо заданий "на сейчас"
+func (c *catchUpThrottle) exhausted(now time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resetLocked(now)
+	var types []string
+	for taskType, taken := range c.taken {
+		if taken >= c.rate {
+			types = append(types, taskType)
+		}
+	}
+	return types
+}
@@ -7,10 +7,25 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"time"
 )
 
+const (
+	// scrubBatchSize ограничивает число заданий, очищаемых по result_ttl_seconds за один запуск,
+	// чтобы большой накопившийся хвост не держал блокировки одной длинной транзакцией
+	scrubBatchSize = 1000
+	// stuckTimeoutMargin - запас к timeout_seconds задания, после которого оно считается зависшим:
+	// worker успевает записать результат задания, выполнявшегося весь свой таймаут
+	stuckTimeoutMargin = time.Minute
+)
+
+// stuckCondition - условие зависшего задания для запросов cleanStuckTasks: захвачено раньше, чем
+// stuckTimeout ($1) назад, а задание с timeout_seconds - раньше, чем timeout_seconds + stuckTimeoutMargin ($2) назад.
+// Для строк до миграции claimed_at - updated_at
+const stuckCondition = "COALESCE(claimed_at, updated_at) < NOW() - INTERVAL '1 second' * GREATEST($1, COALESCE(timeout_seconds, 0) + $2)"
+
 // Cleaner отвечает за поиск и восстановление зависших заданий
 type Cleaner struct {
 	db              *sql.DB
@@ -44,6 +59,8 @@ func (c *Cleaner) Start(ctx context.Context) {
 
 	// Сразу выполняем первую проверку
 	c.cleanStuckTasks(ctx)
+	c.reclaimExpiredLeases(ctx)
+	c.scrubExpiredResults(ctx)
 
 	for {
 		select {
@@ -52,22 +69,46 @@ func (c *Cleaner) Start(ctx context.Context) {
 			return
 		case <-ticker.C:
 			c.cleanStuckTasks(ctx)
+			c.reclaimExpiredLeases(ctx)
+			c.scrubExpiredResults(ctx)
 		}
 	}
 }
 
+// CleanupResult - итог внеочередного запуска Cleaner'а
+type CleanupResult struct {
+	Restored int `json:"restored"` // Задания, возвращенные в 'pending'
+	Failed   int `json:"failed"`   // Задания, переведенные в 'failed' (попытки исчерпаны)
+}
+
+// RunNow немедленно выполняет поиск зависших заданий, не дожидаясь очередного cleanerInterval
+// (например, при восстановлении после инцидента). Может выполняться одновременно с периодическим
+// запуском: обе проверки захватывают строки через FOR UPDATE SKIP LOCKED, и задание обрабатывается один раз.
+func (c *Cleaner) RunNow(ctx context.Context) (CleanupResult, error) {
+	log.Println("[Cleaner] Forced run requested")
+	restored, failed, err := c.cleanStuckTasks(ctx)
+	return CleanupResult{Restored: restored, Failed: failed}, err
+}
+
 // cleanStuckTasks ищет зависшие задания и возвращает их в статус 'pending'.
 // Зависшим считается задание, которое находится в статусе 'processing'
-// и не обновлялось дольше, чем stuckTimeout.
+// и было захвачено worker'ом (claimed_at) раньше, чем stuckTimeout назад. Задание с timeout_seconds
+// больше stuckTimeout (до 24 часов) считается зависшим только после timeout_seconds + stuckTimeoutMargin,
+// иначе Cleaner вернул бы в очередь задание, которое еще выполняется (см. stuckCondition).
+// Используется именно claimed_at, а не updated_at: другие изменения строки
+// не должны продлевать "жизнь" зависшему заданию.
+// Задания, арендованные внешними клиентами (locked_until), обрабатывает reclaimExpiredLeases.
 // Для каждого зависшего задания:
 //   - Статус меняется на 'pending'
 //   - Инкрементируется счетчик попыток (attempts)
 //   - Если достигнут max_attempts, задание переводится в статус 'failed'
-func (c *Cleaner) cleanStuckTasks(ctx context.Context) {
+//
+// Возвращает число возвращенных в очередь и переведенных в 'failed' заданий; ошибка уже записана в лог.
+func (c *Cleaner) cleanStuckTasks(ctx context.Context) (int, int, error) {
 	// SQL запрос для поиска и обновления зависших заданий
 	// Задание считается зависшим, если:
 	// 1. Статус = 'processing'
-	// 2. updated_at < NOW() - stuckTimeout
+	// 2. claimed_at < NOW() - max(stuckTimeout, timeout_seconds + stuckTimeoutMargin) (stuckCondition)
 	// 3. attempts < max_attempts
 	query := `
 		UPDATE scheduled_tasks
@@ -77,17 +118,18 @@ func (c *Cleaner) cleanStuckTasks(ctx context.Context) {
 			SELECT id
 			FROM scheduled_tasks
 			WHERE status = 'processing'
-			  AND updated_at < NOW() - INTERVAL '1 second' * $1
+			  AND locked_until IS NULL
+			  AND ` + stuckCondition + `
 			  AND attempts < max_attempts
 			FOR UPDATE SKIP LOCKED
-		)
+		) AND status = 'processing'
 		RETURNING id, attempts, max_attempts
 	`
 
-	rows, err := c.db.QueryContext(ctx, query, int(c.stuckTimeout.Seconds()))
+	rows, err := c.db.QueryContext(ctx, query, int(c.stuckTimeout.Seconds()), int(stuckTimeoutMargin.Seconds()))
 	if err != nil {
 		log.Printf("[Cleaner] Error cleaning stuck tasks: %v", err)
-		return
+		return 0, 0, fmt.Errorf("failed to restore stuck tasks: %w", err)
 	}
 	defer rows.Close()
 
@@ -105,7 +147,7 @@ func (c *Cleaner) cleanStuckTasks(ctx context.Context) {
 
 	if err := rows.Err(); err != nil {
 		log.Printf("[Cleaner] Error iterating rows: %v", err)
-		return
+		return restoredCount, 0, fmt.Errorf("failed to restore stuck tasks: %w", err)
 	}
 
 	// Дополнительно помечаем как failed задания, которые исчерпали попытки
@@ -113,22 +155,24 @@ func (c *Cleaner) cleanStuckTasks(ctx context.Context) {
 		UPDATE scheduled_tasks
 		SET status = 'failed',
 		    error_message = 'Max attempts reached',
+		    failure_reason = 'timeout',
 		    completed_at = NOW()
 		WHERE id IN (
 			SELECT id
 			FROM scheduled_tasks
 			WHERE status = 'processing'
-			  AND updated_at < NOW() - INTERVAL '1 second' * $1
+			  AND locked_until IS NULL
+			  AND ` + stuckCondition + `
 			  AND attempts >= max_attempts
 			FOR UPDATE SKIP LOCKED
-		)
+		) AND status = 'processing'
 		RETURNING id
 	`
 
-	failRows, err := c.db.QueryContext(ctx, failQuery, int(c.stuckTimeout.Seconds()))
+	failRows, err := c.db.QueryContext(ctx, failQuery, int(c.stuckTimeout.Seconds()), int(stuckTimeoutMargin.Seconds()))
 	if err != nil {
 		log.Printf("[Cleaner] Error marking failed tasks: %v", err)
-		return
+		return restoredCount, 0, fmt.Errorf("failed to mark stuck tasks as failed: %w", err)
 	}
 	defer failRows.Close()
 
@@ -143,7 +187,109 @@ func (c *Cleaner) cleanStuckTasks(ctx context.Context) {
 		log.Printf("[Cleaner] Marked task %d as failed (max attempts reached)", id)
 	}
 
+	if err := failRows.Err(); err != nil {
+		log.Printf("[Cleaner] Error iterating failed rows: %v", err)
+		return restoredCount, failedCount, fmt.Errorf("failed to mark stuck tasks as failed: %w", err)
+	}
+
 	if restoredCount > 0 || failedCount > 0 {
 		log.Printf("[Cleaner] Cleanup complete: restored %d tasks, failed %d tasks", restoredCount, failedCount)
 	}
+	return restoredCount, failedCount, nil
+}
+
+// reclaimExpiredLeases возвращает в очередь задания, захваченные внешними клиентами
+// через POST /api/v1/tasks/claim, аренда которых истекла (locked_until в прошлом).
+// Клиент мог упасть, не отчитавшись о выполнении; без этого задание навсегда осталось бы в 'processing'.
+// attempts уже увеличен при захвате, поэтому здесь не меняется:
+//   - если попытки остались, задание возвращается в 'pending'
+//   - иначе переводится в 'failed'
+//
+// Токен аренды сбрасывается, так что поздний отчет упавшего клиента будет отклонен.
+func (c *Cleaner) reclaimExpiredLeases(ctx context.Context) {
+	query := `
+		UPDATE scheduled_tasks
+		SET status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+		    completed_at = CASE WHEN attempts >= max_attempts THEN NOW() END,
+		    error_message = 'Lease expired',
+		    failure_reason = 'timeout',
+		    lease_token = NULL,
+		    locked_until = NULL
+		WHERE id IN (
+			SELECT id
+			FROM scheduled_tasks
+			WHERE status = 'processing'
+			  AND locked_until < NOW()
+			FOR UPDATE SKIP LOCKED
+		) AND status = 'processing'
+		RETURNING id, status, attempts, max_attempts
+	`
+
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		log.Printf("[Cleaner] Error reclaiming expired leases: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	reclaimedCount := 0
+	for rows.Next() {
+		var id int64
+		var status string
+		var attempts, maxAttempts int
+		if err := rows.Scan(&id, &status, &attempts, &maxAttempts); err != nil {
+			log.Printf("[Cleaner] Error scanning row: %v", err)
+			continue
+		}
+		reclaimedCount++
+		log.Printf("[Cleaner] Lease expired for task %d, now %s (attempt %d/%d)", id, status, attempts, maxAttempts)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("[Cleaner] Error iterating rows: %v", err)
+		return
+	}
+
+	if reclaimedCount > 0 {
+		log.Printf("[Cleaner] Reclaimed %d tasks with expired leases", reclaimedCount)
+	}
+}
+
+// scrubExpiredResults удаляет данные выполненных заданий, у которых истек result_ttl_seconds
+// (отсчитывается от completed_at). Очищаются payload (становится {}), payload_ref, result, error_message, warning,
+// progress и response_body_base64 - в них могут быть персональные данные (сам объект во внешнем хранилище удаляется правилами жизненного
+// цикла бакета). Остается минимальная запись для аудита: id, тип, очередь,
+// статус, попытки и временные метки; scrubbed_at фиксирует время очистки.
+func (c *Cleaner) scrubExpiredResults(ctx context.Context) {
+	query := `
+		UPDATE scheduled_tasks
+		SET payload = '{}'::jsonb,
+		    payload_ref = NULL,
+		    result = NULL,
+		    error_message = NULL,
+		    warning = NULL,
+		    progress = NULL,
+		    response_body_base64 = NULL,
+		    scrubbed_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM scheduled_tasks
+			WHERE status = 'completed'
+			  AND result_ttl_seconds IS NOT NULL
+			  AND scrubbed_at IS NULL
+			  AND completed_at < NOW() - INTERVAL '1 second' * result_ttl_seconds
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) AND status = 'completed'
+	`
+
+	res, err := c.db.ExecContext(ctx, query, scrubBatchSize)
+	if err != nil {
+		log.Printf("[Cleaner] Error scrubbing expired results: %v", err)
+		return
+	}
+
+	if scrubbed, err := res.RowsAffected(); err == nil && scrubbed > 0 {
+		log.Printf("[Cleaner] Scrubbed payload and result of %d completed tasks past result_ttl_seconds", scrubbed)
+	}
 }
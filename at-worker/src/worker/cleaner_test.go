@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestCleanStuckTasksLongRunning проверяет, что Cleaner не возвращает в очередь задание, которое выполняется
+// дольше интервала polling, но меньше stuckTimeout, и возвращает задание, захваченное раньше stuckTimeout.
+// Задание с timeout_seconds больше stuckTimeout возвращается только после timeout_seconds + stuckTimeoutMargin.
+// Время захвата задается через NOW() базы, как и в запросе Cleaner'а, поэтому расхождение часов
+// приложения и БД на результат не влияет.
+//
+// Нужен PostgreSQL со схемой sql/ddl.sql: WORKER_TEST_DSN="host=localhost user=postgres dbname=at_test sslmode=disable"
+func TestCleanStuckTasksLongRunning(t *testing.T) {
+	dsn := os.Getenv("WORKER_TEST_DSN")
+	if dsn == "" {
+		t.Skip("WORKER_TEST_DSN is not set")
+	}
+
+	database, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	queue := fmt.Sprintf("stuck-test-%d", time.Now().UnixNano())
+	defer database.ExecContext(ctx, `DELETE FROM scheduled_tasks WHERE queue = $1`, queue)
+
+	const pollingInterval = time.Second
+	const stuckTimeout = 2 * time.Minute
+
+	// claimedAgo - сколько времени назад (по часам БД) задание захвачено worker'ом; timeout - timeout_seconds (0 - нет)
+	insert := func(claimedAgo, timeout time.Duration) int64 {
+		var id int64
+		err := database.QueryRowContext(ctx, `
+			INSERT INTO scheduled_tasks (execute_at, task_type, queue, payload, status, attempts, max_attempts, claimed_at, timeout_seconds)
+			VALUES (NOW() - INTERVAL '1 hour', 'http_callback', $1, '{}', 'processing', 1, 3, NOW() - INTERVAL '1 second' * $2, NULLIF($3, 0))
+			RETURNING id
+		`, queue, int(claimedAgo.Seconds()), int(timeout.Seconds())).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to insert task: %v", err)
+		}
+		return id
+	}
+
+	running := insert(30*pollingInterval, 0)
+	stuck := insert(stuckTimeout+time.Minute, 0)
+	// Задание с таймаутом в час выполняется 10 минут - дольше stuckTimeout, но меньше своего таймаута
+	longTimeout := insert(10*time.Minute, time.Hour)
+	longTimeoutStuck := insert(time.Hour+stuckTimeoutMargin+time.Minute, time.Hour)
+
+	NewCleaner(database, time.Minute, stuckTimeout).cleanStuckTasks(ctx)
+
+	want := map[int64]string{running: "processing", stuck: "pending", longTimeout: "processing", longTimeoutStuck: "pending"}
+	for id, want := range want {
+		var status string
+		if err := database.QueryRowContext(ctx, `SELECT status FROM scheduled_tasks WHERE id = $1`, id).Scan(&status); err != nil {
+			t.Fatalf("Failed to read task %d: %v", id, err)
+		}
+		if status != want {
+			t.Errorf("Task %d: got status=%s, want=%s", id, status, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Файл cron.go - следующее выполнение заданий с cron_expression.
+// Момент cron-расписания не вычислить в SQL, поэтому worker считает его сам после успешного выполнения
+// и передает в запрос завершения (см. nextRunQuery): задание возвращается в 'pending' к этому моменту.
+package worker
+
+import (
+	"strings"
+	"time"
+	// База часовых поясов для CRON_TZ: в образе alpine ее нет
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronNextRun возвращает первый момент cron-выражения после now для параметра nextRunQuery или nil,
+// если выражения нет, оно некорректно (задание записано в обход API) или расписание больше не срабатывает -
+// тогда задание завершается как обычное. Разбор тот же, что в at-api (models.ParseCron): стандартные 5 полей,
+// дескрипторы (@hourly, @every 90s) и префикс CRON_TZ=; без префикса - UTC
+func cronNextRun(expr string, now time.Time) (interface{}, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(expr, "CRON_TZ=") && !strings.HasPrefix(expr, "TZ=") {
+		expr = "CRON_TZ=UTC " + expr
+	}
+
+	schedule, err := cron.ParseStandard(expr)
+	if err != nil {
+		return nil, err
+	}
+	if next := schedule.Next(now); !next.IsZero() {
+		return next, nil
+	}
+	return nil, nil
+}
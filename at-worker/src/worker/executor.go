@@ -1,84 +1,390 @@
 // Package worker содержит логику выполнения запланированных заданий.
 // Файл executor.go отвечает за маршрутизацию и выполнение заданий в зависимости от их типа (task_type).
-// Поддерживает различные типы выполнения: HTTP callback, отправку в RabbitMQ, и другие.
+// Поддерживает различные типы выполнения: HTTP callback, отправку в RabbitMQ, SQL-запросы и другие.
 package worker
 
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"at-worker/blobstore"
 	"at-worker/models"
+	"at-worker/schema"
 )
 
+// maxRetryAfter ограничивает задержку из заголовка Retry-After, чтобы задание не "уснуло" навсегда
+const maxRetryAfter = 24 * time.Hour
+
+// maxResultBodySize ограничивает размер тела ответа, сохраняемого в result
+const maxResultBodySize = 64 * 1024
+
+// networkRetryDelay - пауза перед повтором HTTP запроса после сетевой ошибки
+const networkRetryDelay = 200 * time.Millisecond
+
+// warningHeader - заголовок успешного ответа HTTP callback, которым получатель сообщает о частичном успехе
+const warningHeader = "X-Task-Warning"
+
+// resultHeaders - заголовки ответа HTTP callback, которые сохраняются в result
+var resultHeaders = []string{"Content-Type", "Location", "ETag", "Retry-After"}
+
+// failureResultHeaders - заголовки, которые дополнительно сохраняются в result неуспешного ответа:
+// по ним разбираются отказы авторизации, прокси и редиректы, а по ID запроса - логи получателя
+var failureResultHeaders = []string{"WWW-Authenticate", "Proxy-Authenticate", "X-Request-Id", "X-Correlation-Id"}
+
+// httpCallbackResult - структурированный результат HTTP callback (колонка result)
+type httpCallbackResult struct {
+	StatusCode    int               `json:"status_code"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	FinalURL      string            `json:"final_url,omitempty"`      // Адрес после редиректов, если неуспешный ответ получен не с url задания
+	Body          json.RawMessage   `json:"body,omitempty"`           // JSON ответа как есть или строка
+	BodyTruncated bool              `json:"body_truncated,omitempty"` // Тело длиннее maxResultBodySize и обрезано
+	BodyBinary    bool              `json:"body_binary,omitempty"`    // Тело бинарное и не сохранено как текст (см. isBinaryResponse)
+	BodySize      int               `json:"body_size,omitempty"`      // Размер бинарного тела в байтах
+	BodySHA256    string            `json:"body_sha256,omitempty"`    // SHA-256 бинарного тела (hex), если тело не сохранено целиком
+}
+
+// newHTTPCallbackResult формирует result из ответа: код, подмножество заголовков и тело.
+// Для неуспешного ответа сохраняются также заголовки failureResultHeaders и адрес после редиректов.
+// Повторяющиеся заголовки (например, несколько WWW-Authenticate) объединяются через запятую.
+// JSON-тело сохраняется как структура, остальное - строкой; вместо бинарного тела - его размер
+// и, в зависимости от binaryMode, SHA-256 (см. binaryBodyHash).
+func newHTTPCallbackResult(resp *http.Response, body []byte, binaryMode string) json.RawMessage {
+	result := httpCallbackResult{StatusCode: resp.StatusCode}
+	failed := resp.StatusCode < 200 || resp.StatusCode >= 300
+
+	names := resultHeaders
+	if failed {
+		names = append(append([]string{}, resultHeaders...), failureResultHeaders...)
+	}
+	for _, name := range names {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			if result.Headers == nil {
+				result.Headers = make(map[string]string)
+			}
+			result.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	// resp.Request - последний запрос цепочки редиректов; у первого запроса Response пустой
+	if failed && resp.Request != nil && resp.Request.Response != nil {
+		result.FinalURL = resp.Request.URL.Redacted()
+	}
+
+	switch {
+	case isBinaryResponse(resp.Header.Get("Content-Type"), body):
+		result.BodyBinary = true
+		result.BodySize = len(body)
+		result.BodySHA256 = binaryBodyHash(body, binaryMode)
+	case len(body) > maxResultBodySize:
+		result.Body, _ = json.Marshal(string(body[:maxResultBodySize]))
+		result.BodyTruncated = true
+	case len(bytes.TrimSpace(body)) == 0:
+	case json.Valid(body):
+		result.Body = body
+	default:
+		result.Body, _ = json.Marshal(string(body))
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
 // Executor отвечает за выполнение заданий различных типов
 type Executor struct {
 	httpClient *http.Client
+	sqlDB      *sql.DB          // Отдельное подключение для заданий типа "sql"; nil - тип отключен
+	schemas    *schema.Registry // Схемы payload по типам заданий; nil - валидация отключена
+	payloads   PayloadFetcher   // Хранилище вынесенных payload (payload_ref); nil - не настроено
+	rabbit     *rabbitPublisher // Соединение с RabbitMQ для заданий "rabbitmq"; nil - брокер не настроен
+	dryRun     bool             // Только логировать задания, без побочных эффектов
+
+	networkRetries    int          // Повторы HTTP запроса при временной сетевой ошибке в рамках одного выполнения
+	quarantineUnknown bool         // Задания неизвестного типа возвращаются в очередь, а не завершаются ошибкой
+	hostLimits        *hostLimiter // Лимит одновременных запросов к одному хосту; nil - без ограничения
+	urlPolicy         *urlPolicy   // Разрешенные адреса http_callback (защита от SSRF); nil - без ограничений
+	omitEmptyData     bool         // http_callback без data отправляется без тела, а не с телом {}
+	binaryResponses   string       // Что сохранять вместо бинарного тела ответа (BinaryResponseSkip, ...)
+}
+
+// PayloadFetcher скачивает payload, вынесенный API во внешнее хранилище (blobstore.S3Store)
+type PayloadFetcher interface {
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// ExecutorOptions содержит настройки Executor'а
+type ExecutorOptions struct {
+	SQLDB    *sql.DB          // Подключение для заданий типа "sql" (nil, если WORKER_ENABLE_SQL не включен)
+	Schemas  *schema.Registry // Реестр схем payload (nil, если WORKER_SCHEMA_DIR не задан)
+	Payloads PayloadFetcher   // Хранилище вынесенных payload (nil, если PAYLOAD_STORE_ENDPOINT не задан)
+	DryRun   bool             // Режим WORKER_DRY_RUN: задания логируются и считаются выполненными
+
+	// RabbitMQURL - AMQP URL брокера для заданий типа "rabbitmq" (RABBITMQ_URL). Пусто - такие задания
+	// завершаются ошибкой. Соединение устанавливается при первом задании и переиспользуется
+	RabbitMQURL string
+
+	// NetworkRetries - сколько раз повторить HTTP запрос при временной сетевой ошибке
+	// (DNS, сброс или отказ соединения) до того, как засчитать неудачную попытку задания
+	NetworkRetries int
+
+	// QuarantineUnknown - задание неизвестного типа не завершается ошибкой, а возвращается в 'pending'
+	// без траты попытки (WORKER_UNKNOWN_TYPE_ACTION=quarantine): при поэтапном выкатывании его
+	// выполнит worker с новым кодом
+	QuarantineUnknown bool
+
+	// HostMaxInFlight - максимум одновременных http_callback запросов этого worker'а к одному хосту
+	// (host:port из url задания); задания сверх лимита откладываются без траты попытки. 0 - без ограничения
+	HostMaxInFlight int
+
+	// CallbackAllowlist, CallbackDenylist и CallbackBlockPrivate - политика адресов http_callback (см. urlPolicy):
+	// хосты и подсети, к которым разрешено или запрещено обращаться, и запрет приватных адресов
+	CallbackAllowlist    []string
+	CallbackDenylist     []string
+	CallbackBlockPrivate bool
+
+	// TargetCacheTTL - сколько хранить адреса хоста http_callback после DNS и решение политики адресов
+	// по нему (см. targetCache), чтобы не разрешать и не проверять хост заново на каждом соединении. 0 - без кэша
+	TargetCacheTTL time.Duration
+
+	// OmitEmptyData - http_callback без data (или с пустым data) отправляется без тела и Content-Type
+	// (WORKER_CALLBACK_EMPTY_DATA=omit). По умолчанию тело - пустой объект {}
+	OmitEmptyData bool
+
+	// BinaryResponses - что сохранять вместо бинарного тела ответа http_callback (WORKER_BINARY_RESPONSE_MODE):
+	// BinaryResponseSkip (только размер), BinaryResponseHash или BinaryResponseBase64. Пусто - как BinaryResponseSkip
+	BinaryResponses string
 }
 
 // NewExecutor создает новый экземпляр Executor с настроенным HTTP клиентом.
 // HTTP клиент используется для отправки callback-запросов к внешним API.
-func NewExecutor() *Executor {
+// Собственного таймаута у клиента нет: время выполнения ограничивает контекст задания
+// (timeout_seconds задания или таймаут его типа, см. Worker.timeoutFor).
+// Параметры:
+//   - opts: подключение для заданий "sql", схемы payload и режим dry run
+func NewExecutor(opts ExecutorOptions) *Executor {
+	httpClient := &http.Client{}
+	policy := newURLPolicy(opts.CallbackAllowlist, opts.CallbackDenylist, opts.CallbackBlockPrivate)
+	cache := newTargetCache(opts.TargetCacheTTL)
+	if policy != nil || cache != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if policy != nil {
+			// Прокси из окружения не используется: соединение шло бы к прокси, и проверялся бы его адрес, а не получателя
+			transport.Proxy = nil
+		}
+		resolve := policy.resolve
+		if cache != nil {
+			resolve = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				return cache.resolve(ctx, host, policy.resolve)
+			}
+		}
+		transport.DialContext = dialContext(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}, resolve)
+		httpClient = &http.Client{Transport: transport}
+	}
+
 	return &Executor{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second, // Таймаут для HTTP запросов
-		},
+		httpClient: httpClient,
+		sqlDB:      opts.SQLDB,
+		schemas:    opts.Schemas,
+		payloads:   opts.Payloads,
+		rabbit:     newRabbitPublisher(opts.RabbitMQURL),
+		dryRun:     opts.DryRun,
+
+		networkRetries:    opts.NetworkRetries,
+		quarantineUnknown: opts.QuarantineUnknown,
+		hostLimits:        newHostLimiter(opts.HostMaxInFlight),
+		urlPolicy:         policy,
+		omitEmptyData:     opts.OmitEmptyData,
+		binaryResponses:   opts.BinaryResponses,
 	}
 }
 
+// Close закрывает соединение с RabbitMQ; вызывается при остановке worker'а, после завершения заданий
+func (e *Executor) Close() {
+	e.rabbit.close()
+}
+
 // Execute выполняет задание в зависимости от его типа (task_type).
 // Параметры:
 //   - ctx: контекст для отмены выполнения
 //   - task: задание для выполнения
 //
 // Возвращает результат выполнения (TaskResult) с информацией об успехе или ошибке.
+// Payload, вынесенный во внешнее хранилище (payload_ref), сначала скачивается (см. fetchPayload).
+// Перед выполнением payload проверяется по схеме типа задания, если она есть в WORKER_SCHEMA_DIR.
+// В режиме dry run (WORKER_DRY_RUN) задание только логируется и считается выполненным.
 // Поддерживаемые типы заданий:
 //   - "http_callback": выполняет HTTP POST запрос к URL из payload
-//   - "rabbitmq": отправляет сообщение в RabbitMQ (заглушка)
+//   - "rabbitmq": публикует сообщение в RabbitMQ (только при заданном RABBITMQ_URL)
 //   - "email": отправляет email (заглушка)
-//   - другие типы: возвращают ошибку "unknown task type"
+//   - "sql": выполняет параметризованный SQL-запрос (только при WORKER_ENABLE_SQL=true)
+//   - другие типы: возвращают ошибку "unknown task type" (или карантин, см. unknownTypeResult)
 func (e *Executor) Execute(ctx context.Context, task *models.ScheduledTask) models.TaskResult {
 	log.Printf("[Executor] Executing task %d (type: %s)", task.ID, task.TaskType)
 
+	if task.PayloadRef.Valid {
+		if result, ok := e.fetchPayload(ctx, task); !ok {
+			return result
+		}
+	}
+
+	// Проверяем payload по схеме типа задания (если схема загружена)
+	if e.schemas != nil {
+		if err := e.schemas.Validate(task.TaskType, task.Payload); err != nil {
+			return invalidPayloadResult(task, fmt.Errorf("payload validation failed: %w", err))
+		}
+	}
+
+	// В режиме dry run только сообщаем, что было бы выполнено
+	if e.dryRun {
+		return e.executeDryRun(task)
+	}
+
 	// Маршрутизация по типу задания
+	var result models.TaskResult
 	switch task.TaskType {
 	case "http_callback":
-		return e.executeHTTPCallback(ctx, task)
+		result = e.executeHTTPCallback(ctx, task)
 	case "rabbitmq":
-		return e.executeRabbitMQ(ctx, task)
+		result = e.executeRabbitMQ(ctx, task)
 	case "email":
-		return e.executeEmail(ctx, task)
+		result = e.executeEmail(ctx, task)
+	case "sql":
+		result = e.executeSQL(ctx, task)
 	default:
-		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("unknown task type: %s", task.TaskType),
+		return e.unknownTypeResult(task)
+	}
+
+	// Ошибка, которую обработчик типа не классифицировал: истекший таймаут задания - timeout, иначе unknown
+	if !result.Success && !result.Deferred && result.FailureReason == "" {
+		result.FailureReason = models.FailureUnknown
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			result.FailureReason = models.FailureTimeout
 		}
 	}
+	return result
+}
+
+// fetchPayload скачивает payload задания по payload_ref и подставляет его в task.Payload.
+// Если скачать не удалось, возвращает результат с ошибкой и false: отсутствующий объект - постоянная ошибка,
+// сбой хранилища - обычная неудачная попытка с повтором.
+func (e *Executor) fetchPayload(ctx context.Context, task *models.ScheduledTask) (models.TaskResult, bool) {
+	if e.payloads == nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  "task has payload_ref, but payload store is not configured (set PAYLOAD_STORE_ENDPOINT)",
+			FailureReason: models.FailureValidation,
+		}, false
+	}
+
+	payload, err := e.payloads.Get(ctx, task.PayloadRef.String)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return invalidPayloadResult(task, fmt.Errorf("failed to fetch payload: %w", err)), false
+	}
+	if err != nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to fetch payload %s: %v", task.PayloadRef.String, err),
+			FailureReason: classifyError(err),
+		}, false
+	}
+	task.Payload = payload
+	return models.TaskResult{}, true
+}
+
+// unknownTypeResult формирует результат для задания, тип которого этот worker не умеет выполнять.
+// Обычно это значит, что worker запущен со старым кодом, а не что задание некорректно,
+// поэтому в режиме карантина задание возвращается в очередь для worker'а с новым кодом.
+func (e *Executor) unknownTypeResult(task *models.ScheduledTask) models.TaskResult {
+	return models.TaskResult{
+		TaskID:        task.ID,
+		Success:       false,
+		ErrorMessage:  fmt.Sprintf("unknown task type: %s", task.TaskType),
+		FailureReason: models.FailureValidation,
+		Quarantine:    e.quarantineUnknown,
+	}
+}
+
+// executeDryRun логирует, что было бы выполнено, и возвращает успех без побочных эффектов.
+// Захват, маршрутизация, валидация payload и запись статуса проходят как обычно,
+// поэтому режим подходит для проверки расписания в pre-prod окружении.
+func (e *Executor) executeDryRun(task *models.ScheduledTask) models.TaskResult {
+	switch task.TaskType {
+	case "http_callback", "rabbitmq", "email", "sql":
+	default:
+		// Неизвестный тип - ошибка и в обычном режиме, dry run не должен ее скрывать
+		return e.unknownTypeResult(task)
+	}
+
+	log.Printf("[Executor] DRY RUN: would execute task %d (type: %s, queue: %s), payload: %s",
+		task.ID, task.TaskType, task.Queue, string(task.Payload))
+
+	return models.TaskResult{
+		TaskID:       task.ID,
+		Success:      true,
+		ErrorMessage: "dry run: not executed",
+		Result:       json.RawMessage(`{"dry_run":true}`),
+	}
 }
 
+// Режимы формирования тела запроса http_callback (поле body_mode в payload)
+const (
+	bodyModeData    = "data"    // Тело - содержимое поля data (по умолчанию)
+	bodyModePayload = "payload" // Тело - весь payload без управляющих полей
+)
+
+// httpCallbackControlFields - поля payload, которые управляют запросом и не отправляются получателю в режиме "payload"
+var httpCallbackControlFields = []string{"url", "method", "body_mode", "accept"}
+
+// methodsWithoutBody - методы, для которых при пустом data запрос отправляется без тела и Content-Type
+// (для остальных - тоже, если включен OmitEmptyData)
+var methodsWithoutBody = map[string]bool{"GET": true, "DELETE": true}
+
 // executeHTTPCallback выполняет HTTP запрос к URL, указанному в payload.
 // Ожидает, что payload содержит поля: {"url": "http://...", "method": "GET|POST|PUT|DELETE|PATCH", "data": {...}}
 // Если method не указан, используется POST по умолчанию.
+// По умолчанию отправляется только data; при "body_mode": "payload" отправляется весь payload
+// без управляющих полей (url, method, body_mode).
 // Возвращает успех, если HTTP статус 2xx, иначе ошибку.
+// В обоих случаях ответ сохраняется в result: код, часть заголовков и тело (см. newHTTPCallbackResult).
 func (e *Executor) executeHTTPCallback(ctx context.Context, task *models.ScheduledTask) models.TaskResult {
 	// Парсим payload
 	var payload struct {
-		URL  string                 `json:"url"`
-		Method string 				`json:"method"`
-		Data map[string]interface{} `json:"data"`
+		URL      string                 `json:"url"`
+		Method   string                 `json:"method"`
+		Data     map[string]interface{} `json:"data"`
+		BodyMode string                 `json:"body_mode"`
+		Accept   string                 `json:"accept"`
 	}
 
-	if err := json.Unmarshal(task.Payload, &payload); err != nil {
+	// UseNumber сохраняет числа в data как есть: при разборе в float64 большие целые (например, ID)
+	// теряли бы точность и уходили получателю измененными
+	decoder := json.NewDecoder(bytes.NewReader(task.Payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("failed to parse payload: %v", err),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to parse payload: %v", err),
+			FailureReason: models.FailureValidation,
 		}
 	}
 
@@ -99,41 +405,128 @@ func (e *Executor) executeHTTPCallback(ctx context.Context, task *models.Schedul
 
 	if !allowedMethods[payload.Method] {
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("invalid method '%s', allowed: POST, PUT, GET, DELETE, PATCH", payload.Method),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("invalid method '%s', allowed: POST, PUT, GET, DELETE, PATCH", payload.Method),
+			FailureReason: models.FailureValidation,
 		}
 	}
 
 	// Подготовка данных для отправки
-	jsonData, err := json.Marshal(payload.Data)
-	if err != nil {
+	var jsonData []byte
+	var err error
+	switch payload.BodyMode {
+	case "", bodyModeData:
+		// Без data тело - {}, а не null: строгие получатели отвергают null вместо объекта.
+		// GET и DELETE без data отправляются без тела: часть серверов отвергает такие запросы с телом
+		switch {
+		case len(payload.Data) > 0:
+			jsonData, err = json.Marshal(payload.Data)
+		case !methodsWithoutBody[payload.Method] && !e.omitEmptyData:
+			jsonData = []byte("{}")
+		}
+	case bodyModePayload:
+		jsonData, err = forwardedPayload(task.Payload)
+	default:
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("failed to marshal data: %v", err),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("invalid body_mode '%s', allowed: %s, %s", payload.BodyMode, bodyModeData, bodyModePayload),
+			FailureReason: models.FailureValidation,
 		}
 	}
-
-	// Создание HTTP запроса с указанным методом
-	req, err := http.NewRequestWithContext(ctx, payload.Method, payload.URL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("failed to create request: %v", err),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to marshal data: %v", err),
+			FailureReason: models.FailureValidation,
+		}
+	}
+
+	// url разбирается один раз для политики адресов и лимита хоста; без них его проверяет http.NewRequest
+	var target *url.URL
+	if e.urlPolicy != nil || e.hostLimits != nil {
+		target, err = url.Parse(payload.URL)
+		if err != nil {
+			return invalidPayloadResult(task, fmt.Errorf("invalid url: %w", err))
 		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	// Политика адресов (защита от SSRF): запрещенный url не исправится повтором, задание сразу получает failed.
+	// Фактические адреса после DNS и редиректов еще раз проверяются при соединении (urlPolicy.resolve)
+	if e.urlPolicy != nil {
+		if err := e.urlPolicy.checkURL(target); err != nil {
+			log.Printf("[Executor] Task %d: %v", task.ID, err)
+			return invalidPayloadResult(task, err)
+		}
+	}
 
-	// Выполнение запроса
-	resp, err := e.httpClient.Do(req)
+	// Лимит одновременных запросов к хосту: задание сверх лимита не выполняется, а откладывается.
+	// Слот держится до конца выполнения, включая повторы при сетевых ошибках и чтение ответа
+	if e.hostLimits != nil {
+		if !e.hostLimits.acquire(target.Host) {
+			return models.TaskResult{
+				TaskID:       task.ID,
+				Success:      false,
+				ErrorMessage: fmt.Sprintf("host %s has %d requests in flight", target.Host, e.hostLimits.limit),
+				Deferred:     true,
+			}
+		}
+		defer e.hostLimits.release(target.Host)
+	}
+
+	// Выполнение запроса; временные сетевые ошибки повторяются здесь же,
+	// не расходуя попытку задания и не обращаясь к БД
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		// Запрос создается заново: тело предыдущего уже прочитано
+		var reqBody io.Reader
+		if jsonData != nil {
+			reqBody = bytes.NewReader(jsonData)
+		}
+		req, reqErr := http.NewRequestWithContext(ctx, payload.Method, payload.URL, reqBody)
+		if reqErr != nil {
+			return models.TaskResult{
+				TaskID:        task.ID,
+				Success:       false,
+				ErrorMessage:  fmt.Sprintf("failed to create request: %v", reqErr),
+				FailureReason: models.FailureValidation,
+			}
+		}
+		if reqBody != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if payload.Accept != "" {
+			req.Header.Set("Accept", payload.Accept)
+		}
+
+		resp, err = e.httpClient.Do(req)
+		if err == nil || attempt >= e.networkRetries || !isTransientNetworkError(err) || ctx.Err() != nil {
+			break
+		}
+
+		log.Printf("[Executor] Task %d: transient network error (retry %d/%d in %v): %v",
+			task.ID, attempt+1, e.networkRetries, networkRetryDelay, err)
+		ReportProgress(ctx, map[string]interface{}{
+			"detail":        fmt.Sprintf("retrying after network error: %v", err),
+			"network_retry": attempt + 1,
+		})
+		select {
+		case <-ctx.Done():
+		case <-time.After(networkRetryDelay):
+		}
+	}
+	if errors.Is(err, errBlockedURL) {
+		log.Printf("[Executor] Task %d: %v", task.ID, err)
+		return invalidPayloadResult(task, fmt.Errorf("failed to execute request: %w", err))
+	}
 	if err != nil {
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("failed to execute request: %v", err),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to execute request: %v", err),
+			FailureReason: classifyError(err),
 		}
 	}
 	defer resp.Body.Close()
@@ -142,53 +535,311 @@ func (e *Executor) executeHTTPCallback(ctx context.Context, task *models.Schedul
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("failed to read response body: %v", err),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to read response body: %v", err),
+			FailureReason: classifyError(err),
 		}
 	}
 
+	// Бинарное тело (картинка, protobuf) не пишется как текст: в error_message попадает только его описание
+	contentType := resp.Header.Get("Content-Type")
+	bodyText := string(body)
+	var bodyBase64 string
+	if isBinaryResponse(contentType, body) {
+		bodyText = binaryBodySummary(contentType, body)
+		bodyBase64 = binaryBodyBase64(body, e.binaryResponses)
+	}
+
 	// Проверка статуса ответа
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Получатель может попросить повторить позже (обычно 429 или 503) - следуем его указанию
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
 		return models.TaskResult{
-			TaskID:       task.ID,
-			Success:      false,
-			ErrorMessage: fmt.Sprintf("HTTP request failed with status: %d, body: %s", resp.StatusCode, string(body)),
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("HTTP request failed with status: %d, body: %s", resp.StatusCode, bodyText),
+			FailureReason: httpStatusReason(resp.StatusCode),
+			RetryAfter:    retryAfter,
+			Result:        newHTTPCallbackResult(resp, body, e.binaryResponses),
+			ContentType:   contentType,
+			BodyBase64:    bodyBase64,
 		}
 	}
 
 	log.Printf("[Executor] Task %d completed successfully (HTTP %d)", task.ID, resp.StatusCode)
 
+	// Ответ не того типа, что просили в accept, не делает задание неудачным, но попадает в warning
+	warnings := []string{}
+	if warning := resp.Header.Get(warningHeader); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	if payload.Accept != "" {
+		if warning := unexpectedResponseWarning(payload.Accept, contentType, body); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
 	return models.TaskResult{
 		TaskID:       task.ID,
 		Success:      true,
-		ErrorMessage: string(body),	// Даже если запрос выполнился успешно, запишем ответ
+		ErrorMessage: bodyText, // Даже если запрос выполнился успешно, запишем ответ
+		Result:       newHTTPCallbackResult(resp, body, e.binaryResponses),
+		Warning:      strings.Join(warnings, "; "),
+		ContentType:  contentType,
+		BodyBase64:   bodyBase64,
+	}
+}
+
+// unexpectedResponseWarning проверяет ответ на соответствие заголовку Accept запроса.
+// Возвращает текст предупреждения, если Content-Type ответа не входит в accept
+// или ответ с типом application/json не является валидным JSON; иначе пустую строку.
+func unexpectedResponseWarning(accept, contentType string, body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	if !acceptsMediaType(accept, mediaType) {
+		return fmt.Sprintf("unexpected response Content-Type %q, accept: %q", contentType, accept)
+	}
+	if mediaType == "application/json" && len(bytes.TrimSpace(body)) > 0 && !json.Valid(body) {
+		return "response Content-Type is application/json, but the body is not valid JSON"
+	}
+	return ""
+}
+
+// acceptsMediaType проверяет, входит ли mediaType в список Accept ("application/json, text/*;q=0.5").
+// Поддерживаются шаблоны "*/*" и "type/*"; параметры (q и т.п.) игнорируются.
+func acceptsMediaType(accept, mediaType string) bool {
+	for _, item := range strings.Split(accept, ",") {
+		pattern := strings.ToLower(strings.TrimSpace(strings.SplitN(item, ";", 2)[0]))
+		switch {
+		case pattern == "*/*":
+			return true
+		case mediaType == "":
+			continue
+		case pattern == mediaType:
+			return true
+		case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedPayload возвращает payload без управляющих полей http_callback.
+// Значения остальных полей передаются как есть (json.RawMessage), без повторного разбора.
+func forwardedPayload(raw json.RawMessage) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range httpCallbackControlFields {
+		delete(fields, name)
+	}
+	return json.Marshal(fields)
+}
+
+// isTransientNetworkError определяет сетевые ошибки, после которых повтор запроса скорее всего успешен:
+// временный сбой DNS, сброс или отказ соединения, соединение закрыто до ответа.
+// Таймауты контекста задания сюда не относятся - время выполнения уже исчерпано.
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// classifyError относит сетевую ошибку или ошибку БД к классу failure_reason:
+// таймаут (контекста задания или сетевой) - timeout, отказ в соединении - connection_refused, остальное - unknown
+func classifyError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return models.FailureTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return models.FailureConnectionRefused
+	default:
+		return models.FailureUnknown
+	}
+}
+
+// httpStatusReason возвращает класс failure_reason для неуспешного HTTP статуса ответа
+func httpStatusReason(code int) string {
+	switch {
+	case code >= 500:
+		return models.FailureHTTP5xx
+	case code >= 400:
+		return models.FailureHTTP4xx
+	default:
+		return models.FailureUnknown
+	}
+}
+
+// parseRetryAfter разбирает значение заголовка Retry-After (RFC 9110).
+// Поддерживаются оба формата: число секунд ("120") и HTTP-дата ("Wed, 21 Oct 2015 07:28:00 GMT").
+// Возвращает задержку относительно now (не больше maxRetryAfter) и признак того, что заголовок разобран.
+// Дата в прошлом дает нулевую задержку.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter, true
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+	} else {
+		return 0, false
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay, true
+}
+
+// invalidPayloadResult возвращает ошибку некорректного payload. Такая ошибка не исправится повтором,
+// поэтому результат помечается Permanent и задание сразу переходит в failed, не тратя оставшиеся попытки.
+func invalidPayloadResult(task *models.ScheduledTask, err error) models.TaskResult {
+	return models.TaskResult{
+		TaskID:        task.ID,
+		Success:       false,
+		ErrorMessage:  err.Error(),
+		Permanent:     true,
+		FailureReason: models.FailureValidation,
+	}
+}
+
+// validateRabbitMQPayload проверяет payload задания rabbitmq до отправки:
+// обязательное непустое поле queue и поле message
+func validateRabbitMQPayload(raw json.RawMessage) error {
+	var payload struct {
+		Queue   string          `json:"queue"`
+		Message json.RawMessage `json:"message"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fmt.Errorf("failed to parse payload: %w", err)
+	}
+	if strings.TrimSpace(payload.Queue) == "" {
+		return errors.New("payload.queue is required")
+	}
+	if len(payload.Message) == 0 {
+		return errors.New("payload.message is required")
+	}
+	return nil
+}
+
+// validateEmailPayload проверяет payload задания email до отправки:
+// обязательное поле to с корректным адресом и поле subject
+func validateEmailPayload(raw json.RawMessage) error {
+	var payload struct {
+		To      string `json:"to"`
+		Subject string `json:"subject"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fmt.Errorf("failed to parse payload: %w", err)
 	}
+	if strings.TrimSpace(payload.To) == "" {
+		return errors.New("payload.to is required")
+	}
+	if _, err := mail.ParseAddress(payload.To); err != nil {
+		return fmt.Errorf("payload.to is not a valid email address: %w", err)
+	}
+	if payload.Subject == "" {
+		return errors.New("payload.subject is required")
+	}
+	return nil
 }
 
-// executeRabbitMQ отправляет сообщение в RabbitMQ очередь.
-// Ожидает, что payload содержит поля: {"queue": "queue_name", "message": {...}}
-// Примечание: это заглушка, требуется реализация подключения к RabbitMQ.
+// executeRabbitMQ публикует сообщение в RabbitMQ и ждет подтверждения брокера.
+// Ожидает, что payload содержит поля: {"queue": "queue_name", "message": {...}} и необязательные
+// "exchange" и "routing_key". Без exchange сообщение уходит в exchange по умолчанию прямо в очередь queue;
+// routing_key по умолчанию - имя очереди. Тело сообщения - message как JSON, доставка persistent.
+// Payload без queue или message сразу завершает задание ошибкой без повторов (см. validateRabbitMQPayload).
+// Ошибка соединения, публикации или отказ брокера подтвердить сообщение - неудачная попытка с повтором.
+// Сообщение публикуется с флагом mandatory: если очереди нет (маршрута нет), брокер возвращает сообщение,
+// и попытка тоже неудачная с повтором - очередь могут создать позже
 func (e *Executor) executeRabbitMQ(ctx context.Context, task *models.ScheduledTask) models.TaskResult {
-	// TODO: Реализовать отправку в RabbitMQ
-	// Для этого нужно:
-	// 1. Установить соединение с RabbitMQ (амqp)
-	// 2. Парсить payload для получения имени очереди и сообщения
-	// 3. Отправить сообщение в очередь
+	if err := validateRabbitMQPayload(task.Payload); err != nil {
+		return invalidPayloadResult(task, err)
+	}
+	if e.rabbit == nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  "rabbitmq task, but RabbitMQ is not configured (set RABBITMQ_URL)",
+			FailureReason: models.FailureValidation,
+		}
+	}
+
+	var payload struct {
+		Queue      string          `json:"queue"`
+		Message    json.RawMessage `json:"message"`
+		Exchange   string          `json:"exchange"`
+		RoutingKey string          `json:"routing_key"`
+	}
+	if err := json.Unmarshal(task.Payload, &payload); err != nil {
+		return invalidPayloadResult(task, fmt.Errorf("failed to parse payload: %w", err))
+	}
+	if payload.RoutingKey == "" {
+		payload.RoutingKey = payload.Queue
+	}
 
-	log.Printf("[Executor] RabbitMQ execution for task %d (not implemented yet)", task.ID)
+	err := e.rabbit.publish(ctx, rabbitMessage{
+		Exchange:   payload.Exchange,
+		RoutingKey: payload.RoutingKey,
+		MessageID:  strconv.FormatInt(task.ID, 10),
+		Body:       payload.Message,
+	})
+	if err != nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("rabbitmq publish to exchange %q with routing key %q failed: %v", payload.Exchange, payload.RoutingKey, err),
+			FailureReason: classifyError(err),
+		}
+	}
 
+	log.Printf("[Executor] Task %d published to RabbitMQ (exchange: %q, routing key: %q)", task.ID, payload.Exchange, payload.RoutingKey)
+	result, _ := json.Marshal(map[string]string{"exchange": payload.Exchange, "routing_key": payload.RoutingKey})
 	return models.TaskResult{
-		TaskID:       task.ID,
-		Success:      false,
-		ErrorMessage: "RabbitMQ execution not implemented",
+		TaskID:  task.ID,
+		Success: true,
+		Result:  result,
 	}
 }
 
 // executeEmail отправляет email уведомление.
 // Ожидает, что payload содержит поля: {"to": "email@example.com", "subject": "...", "body": "..."}
+// Payload без получателя или темы сразу завершает задание ошибкой без повторов (см. validateEmailPayload).
 // Примечание: это заглушка, требуется реализация отправки email.
 func (e *Executor) executeEmail(ctx context.Context, task *models.ScheduledTask) models.TaskResult {
+	if err := validateEmailPayload(task.Payload); err != nil {
+		return invalidPayloadResult(task, err)
+	}
+
 	// TODO: Реализовать отправку email
 	// Для этого нужно:
 	// 1. Настроить SMTP клиент
@@ -203,3 +854,72 @@ func (e *Executor) executeEmail(ctx context.Context, task *models.ScheduledTask)
 		ErrorMessage: "Email execution not implemented",
 	}
 }
+
+// executeSQL выполняет параметризованный SQL-запрос через отдельное подключение.
+// Ожидает, что payload содержит поля: {"query": "UPDATE ... WHERE id = $1", "args": [...]}
+// Значения передаются только через args (плейсхолдеры $1, $2, ...), текст запроса не модифицируется.
+// Количество затронутых строк сохраняется в error_message, как и ответ HTTP callback,
+// и в result: {"rows_affected": N}.
+// Ошибки выполнения считаются временными: задание уходит на retry по общим правилам.
+func (e *Executor) executeSQL(ctx context.Context, task *models.ScheduledTask) models.TaskResult {
+	if e.sqlDB == nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  "sql tasks are disabled (set WORKER_ENABLE_SQL=true)",
+			FailureReason: models.FailureValidation,
+		}
+	}
+
+	var payload struct {
+		Query string        `json:"query"`
+		Args  []interface{} `json:"args"`
+	}
+
+	if err := json.Unmarshal(task.Payload, &payload); err != nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to parse payload: %v", err),
+			FailureReason: models.FailureValidation,
+		}
+	}
+
+	if payload.Query == "" {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  "payload.query is required",
+			FailureReason: models.FailureValidation,
+		}
+	}
+
+	res, err := e.sqlDB.ExecContext(ctx, payload.Query, payload.Args...)
+	if err != nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to execute query: %v", err),
+			FailureReason: classifyError(err),
+		}
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return models.TaskResult{
+			TaskID:        task.ID,
+			Success:       false,
+			ErrorMessage:  fmt.Sprintf("failed to get rows affected: %v", err),
+			FailureReason: classifyError(err),
+		}
+	}
+
+	log.Printf("[Executor] Task %d completed successfully (SQL, rows affected: %d)", task.ID, rowsAffected)
+
+	return models.TaskResult{
+		TaskID:       task.ID,
+		Success:      true,
+		ErrorMessage: fmt.Sprintf("rows affected: %d", rowsAffected),
+		Result:       json.RawMessage(fmt.Sprintf(`{"rows_affected":%d}`, rowsAffected)),
+	}
+}
@@ -0,0 +1,917 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"at-worker/blobstore"
+	"at-worker/models"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// TestParseRetryAfter проверяет разбор заголовка Retry-After в обоих форматах
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"empty", "", 0, false},
+		{"seconds", "120", 120 * time.Second, true},
+		{"zero seconds", "0", 0, true},
+		{"negative seconds", "-5", 0, false},
+		{"http date", "Mon, 10 Nov 2025 12:01:30 GMT", 90 * time.Second, true},
+		{"http date in past", "Mon, 10 Nov 2025 11:00:00 GMT", 0, true},
+		{"too large", "999999999", maxRetryAfter, true},
+		{"garbage", "soon", 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tc.value, now)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("parseRetryAfter(%q): got=(%v, %v), want=(%v, %v)", tc.value, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+// TestExecuteDryRun проверяет, что в режиме dry run HTTP запрос не отправляется
+func TestExecuteDryRun(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	executor := NewExecutor(ExecutorOptions{DryRun: true})
+	result := executor.Execute(context.Background(), &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `"}`),
+	})
+
+	if !result.Success || called {
+		t.Errorf("Dry run: got success=%v called=%v, want success without HTTP call", result.Success, called)
+	}
+
+	result = executor.Execute(context.Background(), &models.ScheduledTask{ID: 2, TaskType: "unknown", Payload: json.RawMessage(`{}`)})
+	if result.Success {
+		t.Error("Dry run should still fail tasks of unknown type")
+	}
+}
+
+// TestExecuteUnknownTypeQuarantine проверяет, что в режиме карантина неизвестный тип не расходует попытку
+func TestExecuteUnknownTypeQuarantine(t *testing.T) {
+	task := &models.ScheduledTask{ID: 1, TaskType: "report_v2", Payload: json.RawMessage(`{}`)}
+
+	result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task)
+	if result.Success || result.Quarantine {
+		t.Errorf("fail mode: got success=%v quarantine=%v, want plain failure", result.Success, result.Quarantine)
+	}
+
+	result = NewExecutor(ExecutorOptions{QuarantineUnknown: true}).Execute(context.Background(), task)
+	if result.Success || !result.Quarantine {
+		t.Errorf("quarantine mode: got success=%v quarantine=%v, want quarantined failure", result.Success, result.Quarantine)
+	}
+
+	result = NewExecutor(ExecutorOptions{QuarantineUnknown: true}).Execute(context.Background(), &models.ScheduledTask{
+		ID: 2, TaskType: "email", Payload: json.RawMessage(`{}`),
+	})
+	if result.Quarantine {
+		t.Error("Known task types must never be quarantined")
+	}
+}
+
+// TestExecuteHTTPCallbackResult проверяет структурированный result для JSON и текстового ответа
+func TestExecuteHTTPCallbackResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Internal", "secret")
+			w.Write([]byte(`{"order_id": 42}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("maintenance"))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		path    string
+		success bool
+		want    string
+	}{
+		{"/json", true, `{"status_code":200,"headers":{"Content-Type":"application/json"},"body":{"order_id":42}}`},
+		{"/text", false, `{"status_code":503,"headers":{"Content-Type":"text/plain"},"body":"maintenance"}`},
+	}
+
+	executor := NewExecutor(ExecutorOptions{})
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			task := &models.ScheduledTask{
+				ID:       1,
+				TaskType: "http_callback",
+				Payload:  json.RawMessage(`{"url": "` + server.URL + tc.path + `"}`),
+			}
+
+			result := executor.Execute(context.Background(), task)
+			if result.Success != tc.success {
+				t.Fatalf("Success: got=%v, want=%v (%s)", result.Success, tc.success, result.ErrorMessage)
+			}
+			if string(result.Result) != tc.want {
+				t.Errorf("Result: got=%s, want=%s", result.Result, tc.want)
+			}
+		})
+	}
+}
+
+// TestExecuteHTTPCallbackBinaryResponse проверяет, что бинарное тело ответа не пишется как текст,
+// а сохраняется по режиму BinaryResponses; текстовое тело сохраняется как раньше
+func TestExecuteHTTPCallbackBinaryResponse(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte("<svg/>"))
+		case "/untyped":
+			w.Header()["Content-Type"] = nil
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte{0xff, 0xfe, 0x00})
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		path       string
+		mode       string
+		message    string
+		wantResult string
+		wantBase64 string
+	}{
+		{"/png", BinaryResponseSkip, "<binary body: 10 bytes, image/png>",
+			`{"status_code":200,"headers":{"Content-Type":"image/png"},"body_binary":true,"body_size":10}`, ""},
+		{"/png", BinaryResponseHash, "<binary body: 10 bytes, image/png>",
+			`{"status_code":200,"headers":{"Content-Type":"image/png"},"body_binary":true,"body_size":10,"body_sha256":"` +
+				fmt.Sprintf("%x", sha256.Sum256(png)) + `"}`, ""},
+		{"/png", BinaryResponseBase64, "<binary body: 10 bytes, image/png>",
+			`{"status_code":200,"headers":{"Content-Type":"image/png"},"body_binary":true,"body_size":10}`, "iVBORw0KGgoAAA=="},
+		{"/svg", BinaryResponseBase64, "<svg/>",
+			`{"status_code":200,"headers":{"Content-Type":"image/svg+xml"},"body":"\u003csvg/\u003e"}`, ""},
+		{"/untyped", BinaryResponseSkip, "HTTP request failed with status: 502, body: <binary body: 3 bytes, no Content-Type>",
+			`{"status_code":502,"body_binary":true,"body_size":3}`, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path+" "+tc.mode, func(t *testing.T) {
+			task := &models.ScheduledTask{
+				ID:       1,
+				TaskType: "http_callback",
+				Payload:  json.RawMessage(`{"url": "` + server.URL + tc.path + `"}`),
+			}
+
+			result := NewExecutor(ExecutorOptions{BinaryResponses: tc.mode}).Execute(context.Background(), task)
+			if result.ErrorMessage != tc.message {
+				t.Errorf("ErrorMessage: got=%q, want=%q", result.ErrorMessage, tc.message)
+			}
+			if string(result.Result) != tc.wantResult {
+				t.Errorf("Result: got=%s, want=%s", result.Result, tc.wantResult)
+			}
+			if result.BodyBase64 != tc.wantBase64 {
+				t.Errorf("BodyBase64: got=%q, want=%q", result.BodyBase64, tc.wantBase64)
+			}
+		})
+	}
+}
+
+// TestExecuteHTTPCallbackFailureHeaders проверяет, что для неуспешного ответа сохраняются заголовки
+// авторизации и ID запроса, а после редиректа - адрес, с которого пришел ответ
+func TestExecuteHTTPCallbackFailureHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/login?next=hook", http.StatusFound)
+			return
+		}
+		w.Header().Add("WWW-Authenticate", `Bearer realm="hooks"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="hooks"`)
+		w.Header().Set("X-Request-Id", "req-7")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	executor := NewExecutor(ExecutorOptions{})
+
+	result := executor.Execute(context.Background(), &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `/hook"}`),
+	})
+	want := `{"status_code":401,"headers":{"WWW-Authenticate":"Bearer realm=\"hooks\", Basic realm=\"hooks\"","X-Request-Id":"req-7"}}`
+	if string(result.Result) != want {
+		t.Errorf("Result: got=%s, want=%s", result.Result, want)
+	}
+
+	result = executor.Execute(context.Background(), &models.ScheduledTask{
+		ID:       2,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `/old", "method": "GET"}`),
+	})
+	var got httpCallbackResult
+	if err := json.Unmarshal(result.Result, &got); err != nil {
+		t.Fatalf("Failed to parse result %s: %v", result.Result, err)
+	}
+	if got.FinalURL != server.URL+"/login?next=hook" {
+		t.Errorf("FinalURL: got=%q, want=%q", got.FinalURL, server.URL+"/login?next=hook")
+	}
+}
+
+// TestExecuteHTTPCallbackHostLimit проверяет, что задание сверх лимита запросов к хосту откладывается
+// без выполнения, а после завершения запроса слот хоста освобождается
+func TestExecuteHTTPCallbackHostLimit(t *testing.T) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-unblock
+		}
+	}))
+	defer server.Close()
+
+	executor := NewExecutor(ExecutorOptions{HostMaxInFlight: 1})
+	task := func(id int64, path string) *models.ScheduledTask {
+		return &models.ScheduledTask{
+			ID:       id,
+			TaskType: "http_callback",
+			Payload:  json.RawMessage(`{"url": "` + server.URL + path + `"}`),
+		}
+	}
+
+	slow := make(chan models.TaskResult)
+	go func() { slow <- executor.Execute(context.Background(), task(1, "/slow")) }()
+	<-entered
+
+	result := executor.Execute(context.Background(), task(2, "/fast"))
+	if result.Success || !result.Deferred {
+		t.Errorf("Task over the host limit must be deferred, got %+v", result)
+	}
+	if result.FailureReason != "" {
+		t.Errorf("Deferred task must not have failure_reason, got %q", result.FailureReason)
+	}
+
+	close(unblock)
+	if result := <-slow; !result.Success {
+		t.Fatalf("Slow task failed: %s", result.ErrorMessage)
+	}
+	if result := executor.Execute(context.Background(), task(3, "/fast")); !result.Success {
+		t.Errorf("Host slot must be released after the request, got %+v", result)
+	}
+}
+
+// TestURLPolicy проверяет allowlist, denylist и запрет приватных адресов без DNS
+func TestURLPolicy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		policy  *urlPolicy
+		url     string
+		blocked bool
+	}{
+		{"metadata endpoint", newURLPolicy(nil, nil, true), "http://169.254.169.254/latest/meta-data/", true},
+		{"loopback", newURLPolicy(nil, nil, true), "http://127.0.0.1:8080/", true},
+		{"localhost", newURLPolicy(nil, nil, true), "http://localhost/", true},
+		{"private ipv6", newURLPolicy(nil, nil, true), "http://[fd00::1]/", true},
+		{"ipv4-mapped loopback", newURLPolicy(nil, nil, true), "http://[::ffff:127.0.0.1]/", true},
+		{"public address", newURLPolicy(nil, nil, true), "https://93.184.216.34/hook", false},
+		{"public hostname", newURLPolicy(nil, nil, true), "https://example.com/hook", false},
+		{"not http", newURLPolicy(nil, nil, true), "file:///etc/passwd", true},
+		{"denied host", newURLPolicy(nil, []string{"*.example.com"}, false), "https://api.example.com/", true},
+		{"denied subnet", newURLPolicy(nil, []string{"203.0.113.0/24"}, false), "http://203.0.113.7/", true},
+		{"private allowed", newURLPolicy(nil, []string{"203.0.113.0/24"}, false), "http://10.1.2.3/", false},
+		{"allowlisted private subnet", newURLPolicy([]string{"10.0.0.0/8"}, nil, true), "http://10.1.2.3/", false},
+		{"not in allowlist", newURLPolicy([]string{"10.0.0.0/8"}, nil, true), "http://192.168.1.1/", true},
+		{"allowlisted host", newURLPolicy([]string{"hooks.example.com"}, nil, true), "https://HOOKS.example.com./x", false},
+		{"deny wins over allow", newURLPolicy([]string{"10.0.0.0/8"}, []string{"10.0.0.1"}, true), "http://10.0.0.1/", true},
+	}
+
+	for _, tc := range testCases {
+		target, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		err = tc.policy.checkURL(target)
+		if got := err != nil; got != tc.blocked {
+			t.Errorf("%s: blocked got=%v (%v), want=%v", tc.name, got, err, tc.blocked)
+		}
+		if err != nil && !errors.Is(err, errBlockedURL) {
+			t.Errorf("%s: error must wrap errBlockedURL, got %v", tc.name, err)
+		}
+	}
+
+	if newURLPolicy(nil, nil, false) != nil {
+		t.Error("Policy without lists and private blocking must be nil")
+	}
+}
+
+// TestTargetCache проверяет кэш хостов http_callback: адреса и запреты политики берутся из кэша до истечения ttl,
+// ошибки DNS не кэшируются
+func TestTargetCache(t *testing.T) {
+	if newTargetCache(0) != nil {
+		t.Fatal("Cache must be disabled without ttl")
+	}
+
+	cache := newTargetCache(50 * time.Millisecond)
+	lookups := 0
+	lookup := func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		switch host {
+		case "blocked.example.com":
+			return nil, fmt.Errorf("%w: host %s is in the denylist", errBlockedURL, host)
+		case "flaky.example.com":
+			return nil, errors.New("temporary DNS failure")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		ips, err := cache.resolve(ctx, "Hooks.example.com", lookup)
+		if err != nil || len(ips) != 1 {
+			t.Fatalf("Resolve: got=%v, %v", ips, err)
+		}
+		if _, err := cache.resolve(ctx, "blocked.example.com", lookup); !errors.Is(err, errBlockedURL) {
+			t.Fatalf("Blocked host: got=%v, want=%v", err, errBlockedURL)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("Cached hosts must be looked up once, got %d lookups", lookups)
+	}
+
+	lookups = 0
+	cache.resolve(ctx, "flaky.example.com", lookup)
+	cache.resolve(ctx, "flaky.example.com", lookup)
+	if lookups != 2 {
+		t.Errorf("DNS errors must not be cached, got %d lookups", lookups)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	lookups = 0
+	cache.resolve(ctx, "hooks.example.com", lookup)
+	if lookups != 1 {
+		t.Errorf("Expired entry must be looked up again, got %d lookups", lookups)
+	}
+}
+
+// TestExecuteHTTPCallbackBlockedURL проверяет, что запрещенный url сразу завершает задание без повторов,
+// в том числе если запрещенный хост появляется только после редиректа
+func TestExecuteHTTPCallbackBlockedURL(t *testing.T) {
+	var requests int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)+"/internal", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	task := func(path string) *models.ScheduledTask {
+		return &models.ScheduledTask{
+			ID:       1,
+			TaskType: "http_callback",
+			Payload:  json.RawMessage(`{"url": "` + server.URL + path + `"}`),
+		}
+	}
+
+	result := NewExecutor(ExecutorOptions{CallbackBlockPrivate: true}).Execute(context.Background(), task("/"))
+	if result.Success || !result.Permanent || result.FailureReason != models.FailureValidation {
+		t.Errorf("Private url: got %+v, want permanent validation failure", result)
+	}
+	if !strings.Contains(result.ErrorMessage, "blocked by callback policy") {
+		t.Errorf("Error message must explain the block, got %q", result.ErrorMessage)
+	}
+	if requests != 0 {
+		t.Errorf("Blocked url must not be requested, got %d requests", requests)
+	}
+
+	executor := NewExecutor(ExecutorOptions{CallbackDenylist: []string{"localhost"}})
+	result = executor.Execute(context.Background(), task("/redirect"))
+	if result.Success || !result.Permanent || !strings.Contains(result.ErrorMessage, "denylist") {
+		t.Errorf("Redirect to denied host: got %+v, want permanent failure", result)
+	}
+	if requests != 1 {
+		t.Errorf("Only the redirecting request must reach the server, got %d requests", requests)
+	}
+}
+
+// TestExecuteHTTPCallbackPreservesNumbers проверяет, что числа из data уходят получателю без потери точности
+func TestExecuteHTTPCallbackPreservesNumbers(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	task := &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `", "data": {"id": 9007199254740993, "price": 10.50, "ratio": 1e3}}`),
+	}
+
+	result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task)
+	if !result.Success {
+		t.Fatalf("Execute failed: %s", result.ErrorMessage)
+	}
+
+	if want := `{"id":9007199254740993,"price":10.50,"ratio":1e3}`; string(received) != want {
+		t.Errorf("Request body: got=%s, want=%s", received, want)
+	}
+}
+
+// TestExecuteHTTPCallbackBodyMode проверяет отправку data (по умолчанию) и всего payload без управляющих полей
+func TestExecuteHTTPCallbackBodyMode(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		bodyMode string
+		want     string
+	}{
+		{"", `{"id":42}`},
+		{"data", `{"id":42}`},
+		{"payload", `{"context":{"tenant":"acme"},"data":{"id":42}}`},
+	}
+
+	executor := NewExecutor(ExecutorOptions{})
+	for _, tc := range testCases {
+		t.Run("mode="+tc.bodyMode, func(t *testing.T) {
+			received = nil
+			task := &models.ScheduledTask{
+				ID:       1,
+				TaskType: "http_callback",
+				Payload: json.RawMessage(`{"url": "` + server.URL + `", "method": "POST", "body_mode": "` + tc.bodyMode + `",
+					"data": {"id": 42}, "context": {"tenant": "acme"}}`),
+			}
+
+			result := executor.Execute(context.Background(), task)
+			if !result.Success {
+				t.Fatalf("Execute failed: %s", result.ErrorMessage)
+			}
+			if string(received) != tc.want {
+				t.Errorf("Request body: got=%s, want=%s", received, tc.want)
+			}
+		})
+	}
+
+	task := &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `", "body_mode": "raw"}`),
+	}
+	if result := executor.Execute(context.Background(), task); result.Success {
+		t.Errorf("Invalid body_mode: expected failure")
+	}
+}
+
+// TestExecuteHTTPCallbackNetworkRetry проверяет повтор запроса после обрыва соединения в рамках одного выполнения
+func TestExecuteHTTPCallbackNetworkRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Закрываем соединение без ответа - клиент получит EOF
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	task := &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `", "data": {"id": 1}}`),
+	}
+
+	result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task)
+	if result.Success {
+		t.Fatalf("Without network retries: expected failure after dropped connection")
+	}
+
+	requests = 0
+	result = NewExecutor(ExecutorOptions{NetworkRetries: 1}).Execute(context.Background(), task)
+	if !result.Success {
+		t.Fatalf("With network retries: got failure %q", result.ErrorMessage)
+	}
+	if requests != 2 {
+		t.Errorf("Requests: got=%d, want=2", requests)
+	}
+}
+
+// TestExecuteHTTPCallbackGetWithoutBody проверяет, что GET без data отправляется без тела и Content-Type
+func TestExecuteHTTPCallbackGetWithoutBody(t *testing.T) {
+	var gotMethod, gotContentType string
+	var gotBody []byte
+	var gotContentLength int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotContentType = r.Header.Get("Content-Type")
+		gotContentLength = r.ContentLength
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	task := &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `", "method": "GET"}`),
+	}
+
+	result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task)
+	if !result.Success {
+		t.Fatalf("Execute: got failure %q", result.ErrorMessage)
+	}
+	if gotMethod != "GET" {
+		t.Errorf("Method: got=%q, want=GET", gotMethod)
+	}
+	if len(gotBody) != 0 || gotContentLength != 0 {
+		t.Errorf("Body: got=%q (Content-Length %d), want empty", gotBody, gotContentLength)
+	}
+	if gotContentType != "" {
+		t.Errorf("Content-Type: got=%q, want empty", gotContentType)
+	}
+}
+
+// TestExecuteHTTPCallbackEmptyData проверяет тело запроса без data: {} по умолчанию и без тела при OmitEmptyData
+func TestExecuteHTTPCallbackEmptyData(t *testing.T) {
+	var gotBody []byte
+	var gotContentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotContentType = r.Header.Get("Content-Type")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name        string
+		fields      string
+		omit        bool
+		wantBody    string
+		contentType string
+	}{
+		{"data missing", ``, false, `{}`, "application/json"},
+		{"data null", `, "data": null`, false, `{}`, "application/json"},
+		{"data empty", `, "data": {}`, false, `{}`, "application/json"},
+		{"data present", `, "data": {"id": 42}`, false, `{"id":42}`, "application/json"},
+		{"data missing, omit", ``, true, ``, ""},
+		{"data present, omit", `, "data": {"id": 42}`, true, `{"id":42}`, "application/json"},
+		{"put without data", `, "method": "PUT"`, false, `{}`, "application/json"},
+	}
+
+	for _, tc := range testCases {
+		gotBody, gotContentType = nil, ""
+		task := &models.ScheduledTask{
+			ID:       1,
+			TaskType: "http_callback",
+			Payload:  json.RawMessage(`{"url": "` + server.URL + `"` + tc.fields + `}`),
+		}
+
+		result := NewExecutor(ExecutorOptions{OmitEmptyData: tc.omit}).Execute(context.Background(), task)
+		if !result.Success {
+			t.Fatalf("%s: got failure %q", tc.name, result.ErrorMessage)
+		}
+		if string(gotBody) != tc.wantBody {
+			t.Errorf("%s: body got=%q, want=%q", tc.name, gotBody, tc.wantBody)
+		}
+		if gotContentType != tc.contentType {
+			t.Errorf("%s: Content-Type got=%q, want=%q", tc.name, gotContentType, tc.contentType)
+		}
+	}
+}
+
+// TestExecuteHTTPCallbackWarning проверяет, что заголовок X-Task-Warning успешного ответа становится предупреждением
+func TestExecuteHTTPCallbackWarning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Task-Warning", "1 of 3 recipients bounced")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	task := &models.ScheduledTask{
+		ID:       1,
+		TaskType: "http_callback",
+		Payload:  json.RawMessage(`{"url": "` + server.URL + `"}`),
+	}
+
+	result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task)
+	if !result.Success {
+		t.Fatalf("Execute: got failure %q", result.ErrorMessage)
+	}
+	if result.Warning != "1 of 3 recipients bounced" {
+		t.Errorf("Warning: got=%q, want=%q", result.Warning, "1 of 3 recipients bounced")
+	}
+}
+
+// TestAcceptsMediaType проверяет сопоставление Content-Type ответа со списком Accept
+func TestAcceptsMediaType(t *testing.T) {
+	testCases := []struct {
+		accept    string
+		mediaType string
+		want      bool
+	}{
+		{"application/json", "application/json", true},
+		{"application/json", "text/html", false},
+		{"text/html, application/json;q=0.9", "application/json", true},
+		{"text/*", "text/plain", true},
+		{"text/*", "application/json", false},
+		{"*/*", "", true},
+		{"application/json", "", false},
+		{"Application/JSON", "application/json", true},
+	}
+
+	for _, tc := range testCases {
+		if got := acceptsMediaType(tc.accept, tc.mediaType); got != tc.want {
+			t.Errorf("acceptsMediaType(%q, %q): got=%v, want=%v", tc.accept, tc.mediaType, got, tc.want)
+		}
+	}
+}
+
+// TestExecuteHTTPCallbackAccept проверяет заголовок Accept и предупреждение об ответе другого типа
+func TestExecuteHTTPCallbackAccept(t *testing.T) {
+	var gotAccept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"ok": true}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>login</html>"))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		path        string
+		wantWarning bool
+	}{
+		{"/json", false},
+		{"/html", true},
+	}
+
+	executor := NewExecutor(ExecutorOptions{})
+	for _, tc := range testCases {
+		task := &models.ScheduledTask{
+			ID:       1,
+			TaskType: "http_callback",
+			Payload:  json.RawMessage(`{"url": "` + server.URL + tc.path + `", "accept": "application/json"}`),
+		}
+
+		result := executor.Execute(context.Background(), task)
+		if !result.Success {
+			t.Fatalf("%s: got failure %q", tc.path, result.ErrorMessage)
+		}
+		if gotAccept != "application/json" {
+			t.Errorf("%s: Accept got=%q, want=application/json", tc.path, gotAccept)
+		}
+		if (result.Warning != "") != tc.wantWarning {
+			t.Errorf("%s: warning got=%q, want warning=%v", tc.path, result.Warning, tc.wantWarning)
+		}
+	}
+}
+
+// TestExecuteInvalidPayloadIsPermanent проверяет, что rabbitmq и email с некорректным payload
+// завершаются понятной ошибкой без повторов, а корректный payload проходит проверку
+func TestExecuteInvalidPayloadIsPermanent(t *testing.T) {
+	testCases := []struct {
+		name          string
+		taskType      string
+		payload       string
+		wantPermanent bool
+		wantError     string
+	}{
+		{"rabbitmq without queue", "rabbitmq", `{"message": {"id": 1}}`, true, "payload.queue is required"},
+		{"rabbitmq without message", "rabbitmq", `{"queue": "orders"}`, true, "payload.message is required"},
+		{"rabbitmq valid", "rabbitmq", `{"queue": "orders", "message": {"id": 1}}`, false, ""},
+		{"email without to", "email", `{"subject": "Hi"}`, true, "payload.to is required"},
+		{"email invalid to", "email", `{"to": "not-an-address", "subject": "Hi"}`, true, "payload.to is not a valid email address"},
+		{"email without subject", "email", `{"to": "user@example.com"}`, true, "payload.subject is required"},
+		{"email valid", "email", `{"to": "User <user@example.com>", "subject": "Hi", "body": "..."}`, false, ""},
+		{"malformed payload", "email", `[1, 2]`, true, "failed to parse payload"},
+	}
+
+	executor := NewExecutor(ExecutorOptions{})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := executor.Execute(context.Background(), &models.ScheduledTask{
+				ID:       1,
+				TaskType: tc.taskType,
+				Payload:  json.RawMessage(tc.payload),
+			})
+			if result.Permanent != tc.wantPermanent {
+				t.Errorf("Permanent got=%v, want=%v (error: %s)", result.Permanent, tc.wantPermanent, result.ErrorMessage)
+			}
+			if tc.wantError != "" && !strings.HasPrefix(result.ErrorMessage, tc.wantError) {
+				t.Errorf("ErrorMessage got=%q, want prefix %q", result.ErrorMessage, tc.wantError)
+			}
+		})
+	}
+}
+
+// TestExecuteFailureReason проверяет классификацию ошибок выполнения в failure_reason
+func TestExecuteFailureReason(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/502":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	// Адрес, на котором гарантированно никто не слушает: занимаем порт и сразу освобождаем
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closed.URL
+	closed.Close()
+
+	testCases := []struct {
+		name     string
+		taskType string
+		payload  string
+		timeout  time.Duration
+		want     string
+	}{
+		{"http 4xx", "http_callback", `{"url": "` + server.URL + `/404"}`, 0, models.FailureHTTP4xx},
+		{"http 5xx", "http_callback", `{"url": "` + server.URL + `/502"}`, 0, models.FailureHTTP5xx},
+		{"timeout", "http_callback", `{"url": "` + server.URL + `/slow"}`, 50 * time.Millisecond, models.FailureTimeout},
+		{"connection refused", "http_callback", `{"url": "` + closedURL + `"}`, 0, models.FailureConnectionRefused},
+		{"invalid method", "http_callback", `{"url": "` + server.URL + `", "method": "TRACE"}`, 0, models.FailureValidation},
+		{"invalid payload", "email", `{"subject": "Hi"}`, 0, models.FailureValidation},
+		{"unknown type", "fax", `{}`, 0, models.FailureValidation},
+		{"not implemented", "email", `{"to": "user@example.com", "subject": "Hi"}`, 0, models.FailureUnknown},
+	}
+
+	executor := NewExecutor(ExecutorOptions{})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			result := executor.Execute(ctx, &models.ScheduledTask{ID: 1, TaskType: tc.taskType, Payload: json.RawMessage(tc.payload)})
+			if result.Success || result.FailureReason != tc.want {
+				t.Errorf("FailureReason got=%q (success=%v, error: %s), want %q", result.FailureReason, result.Success, result.ErrorMessage, tc.want)
+			}
+		})
+	}
+}
+
+// TestExecutePayloadRef проверяет, что payload по payload_ref скачивается из хранилища перед выполнением,
+// а отсутствующий объект завершает задание без повторов
+func TestExecutePayloadRef(t *testing.T) {
+	var received string
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer callback.Close()
+
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/payloads/1.json" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"url": "` + callback.URL + `", "data": {"html": "large"}}`))
+	}))
+	defer storage.Close()
+
+	payloads, err := blobstore.NewS3Store(blobstore.Options{Endpoint: storage.URL, Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("NewS3Store failed: %v", err)
+	}
+	executor := NewExecutor(ExecutorOptions{Payloads: payloads})
+
+	task := &models.ScheduledTask{
+		ID:         1,
+		TaskType:   "http_callback",
+		Payload:    json.RawMessage(`{}`),
+		PayloadRef: sql.NullString{String: "s3://bucket/payloads/1.json", Valid: true},
+	}
+	if result := executor.Execute(context.Background(), task); !result.Success {
+		t.Fatalf("Expected success, got error: %s", result.ErrorMessage)
+	}
+	if received != `{"html":"large"}` {
+		t.Errorf("Callback body: got=%s", received)
+	}
+
+	task.PayloadRef.String = "s3://bucket/payloads/missing.json"
+	if result := executor.Execute(context.Background(), task); result.Success || !result.Permanent {
+		t.Errorf("Missing object: expected permanent failure, got success=%v permanent=%v", result.Success, result.Permanent)
+	}
+
+	task.PayloadRef.String = "s3://bucket/payloads/1.json"
+	if result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task); result.Success || result.FailureReason != models.FailureValidation {
+		t.Errorf("Without store: expected validation failure, got success=%v reason=%q", result.Success, result.FailureReason)
+	}
+}
+
+// TestExecuteRabbitMQUnavailable проверяет, что задание rabbitmq без настроенного или доступного брокера
+// завершается понятной ошибкой с повтором, а не считается выполненным
+func TestExecuteRabbitMQUnavailable(t *testing.T) {
+	task := func() *models.ScheduledTask {
+		return &models.ScheduledTask{ID: 1, TaskType: "rabbitmq", Payload: json.RawMessage(`{"queue": "orders", "message": {"id": 1}}`)}
+	}
+
+	result := NewExecutor(ExecutorOptions{}).Execute(context.Background(), task())
+	if result.Success || result.Permanent || !strings.Contains(result.ErrorMessage, "RABBITMQ_URL") {
+		t.Errorf("Without broker: got success=%v permanent=%v error=%q, want a retryable error about RABBITMQ_URL",
+			result.Success, result.Permanent, result.ErrorMessage)
+	}
+
+	// Порт, на котором никто не слушает: соединение отклоняется
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve port: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	executor := NewExecutor(ExecutorOptions{RabbitMQURL: "amqp://guest:guest@" + addr + "/"})
+	defer executor.Close()
+	result = executor.Execute(context.Background(), task())
+	if result.Success || result.Permanent {
+		t.Fatalf("Unreachable broker: got success=%v permanent=%v, want a retryable error", result.Success, result.Permanent)
+	}
+	if !strings.Contains(result.ErrorMessage, "failed to connect to RabbitMQ") || result.FailureReason != models.FailureConnectionRefused {
+		t.Errorf("Unreachable broker: got error=%q reason=%s, want connection refused", result.ErrorMessage, result.FailureReason)
+	}
+}
+
+// TestRabbitTakeReturn проверяет, что возврат сообщения (basic.return) находится по MessageId, даже если
+// его вычитала другая публикация, и забирается только один раз
+func TestRabbitTakeReturn(t *testing.T) {
+	p := newRabbitPublisher("amqp://localhost/")
+	returns := make(chan amqp.Return, 4)
+	returns <- amqp.Return{MessageId: "1", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	returns <- amqp.Return{MessageId: "2", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+
+	if _, ok := p.takeReturn(returns, "3"); ok {
+		t.Error("Message without return must not be reported as returned")
+	}
+	ret, ok := p.takeReturn(returns, "2")
+	if !ok || ret.ReplyText != "NO_ROUTE" {
+		t.Errorf("Return drained by another publish: got %+v ok=%v, want NO_ROUTE", ret, ok)
+	}
+	if _, ok := p.takeReturn(returns, "2"); ok {
+		t.Error("Return must be taken only once")
+	}
+
+	close(returns)
+	if _, ok := p.takeReturn(returns, "1"); !ok {
+		t.Error("Return must be kept after the channel is closed")
+	}
+
+	// Возврат, не забранный своей публикацией дольше rabbitReturnTTL, удаляется
+	p.returned["4"] = rabbitReturn{ret: amqp.Return{MessageId: "4"}, at: time.Now().Add(-rabbitReturnTTL - time.Second)}
+	p.returned["5"] = rabbitReturn{ret: amqp.Return{MessageId: "5"}, at: time.Now()}
+	p.takeReturn(returns, "3")
+	if _, ok := p.returned["4"]; ok {
+		t.Error("Stale return must be pruned")
+	}
+	if _, ok := p.returned["5"]; !ok {
+		t.Error("Fresh return must be kept")
+	}
+}
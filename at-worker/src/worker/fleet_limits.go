@@ -0,0 +1,76 @@
+// Файл fleet_limits.go - лимиты одновременного выполнения по типам заданий на весь парк worker'ов.
+// Лимит защищает общий downstream (например, API партнера) независимо от числа запущенных worker'ов.
+package worker
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// fleetLimitsLockKey - ключ advisory-блокировки, которой сериализуются захваты с лимитами:
+// иначе два worker'а одновременно увидят одинаковое число выполняемых заданий и вместе превысят лимит
+const fleetLimitsLockKey int64 = 0x61745f666c656574 // "at_fleet"
+
+// fleetCapacity возвращает, сколько еще заданий каждого ограниченного типа можно захватить (remaining),
+// и типы, у которых лимит уже исчерпан (saturated) - их не нужно даже выбирать.
+// Выполняемыми считаются все задания в 'processing' (в том числе захваченные внешними клиентами через API).
+// Вызывается в транзакции захвата: advisory-блокировка держится до ее коммита, поэтому следующий
+// worker посчитает уже захваченные этим батчем задания. Без лимитов возвращает nil, nil, nil.
+func (w *Worker) fleetCapacity(ctx context.Context, tx *sql.Tx) (map[string]int, []string, error) {
+	if len(w.fleetLimits) == 0 {
+		return nil, nil, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, fleetLimitsLockKey); err != nil {
+		return nil, nil, err
+	}
+
+	types := make([]string, 0, len(w.fleetLimits))
+	for taskType := range w.fleetLimits {
+		types = append(types, taskType)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT task_type, COUNT(*)
+		FROM scheduled_tasks
+		WHERE status = 'processing' AND task_type = ANY($1)
+		GROUP BY task_type
+	`, pq.Array(types))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	running := make(map[string]int, len(types))
+	for rows.Next() {
+		var taskType string
+		var count int
+		if err := rows.Scan(&taskType, &count); err != nil {
+			return nil, nil, err
+		}
+		running[taskType] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	remaining, saturated := fleetRemaining(w.fleetLimits, running)
+	return remaining, saturated, nil
+}
+
+// fleetRemaining считает остаток лимита по типам: типы с остатком попадают в remaining,
+// типы с исчерпанным лимитом - в saturated
+func fleetRemaining(limits, running map[string]int) (map[string]int, []string) {
+	remaining := make(map[string]int, len(limits))
+	var saturated []string
+	for taskType, limit := range limits {
+		if left := limit - running[taskType]; left > 0 {
+			remaining[taskType] = left
+		} else {
+			saturated = append(saturated, taskType)
+		}
+	}
+	return remaining, saturated
+}
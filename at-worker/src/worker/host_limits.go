@@ -0,0 +1,55 @@
+// Файл host_limits.go - ограничение одновременных HTTP callback запросов к одному хосту.
+// Лимит по типу задания (fleet_limits.go) не различает получателей: один медленный хост может занять
+// все слоты http_callback. Лимит по хосту защищает каждый downstream отдельно.
+package worker
+
+import (
+	"strings"
+	"sync"
+)
+
+// hostLimiter считает выполняемые этим worker'ом запросы к каждому хосту (host:port из url задания).
+// Счетчики локальны: при N worker'ах к хосту одновременно идет не больше N*limit запросов.
+type hostLimiter struct {
+	limit int
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// newHostLimiter создает ограничитель; при limit <= 0 возвращает nil (без ограничения)
+func newHostLimiter(limit int) *hostLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &hostLimiter{limit: limit, inFlight: make(map[string]int)}
+}
+
+// acquire занимает слот хоста; false - к хосту уже выполняется limit запросов.
+// Занятый слот освобождается через release.
+func (l *hostLimiter) acquire(host string) bool {
+	host = strings.ToLower(host)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[host] >= l.limit {
+		return false
+	}
+	l.inFlight[host]++
+	return true
+}
+
+// release освобождает слот хоста, занятый acquire
+func (l *hostLimiter) release(host string) {
+	host = strings.ToLower(host)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[host] <= 1 {
+		delete(l.inFlight, host)
+		return
+	}
+	l.inFlight[host]--
+}
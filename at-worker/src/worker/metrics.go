@@ -0,0 +1,13 @@
+package worker
+
+import "at-worker/metrics"
+
+// Метрики worker'а
+var (
+	claimsSkipped      = metrics.NewCounter("at_worker_claims_skipped_total", "Number of polls skipped because the DB pool had no free connections.")
+	tasksFinished      = metrics.NewCounter("at_worker_tasks_finished_total", "Number of task executions by task type and outcome (completed, retry, failed, skipped, quarantined, deferred).", "task_type", "outcome")
+	taskFailures       = metrics.NewCounter("at_worker_task_failures_total", "Number of failed task executions (retried or final) by task type and failure reason (timeout, connection_refused, http_4xx, http_5xx, validation, unknown).", "task_type", "reason")
+	notificationsTotal = metrics.NewCounter("at_worker_notifications_total", "Number of notification delivery attempts from the outbox by outcome (delivered, retry, failed).", "outcome")
+	pollErrors         = metrics.NewCounter("at_worker_poll_errors_total", "Number of polls that failed with a DB error.")
+	dbUnavailable      = metrics.NewGauge("at_worker_db_unavailable", "1 while consecutive polls fail with DB errors (the worker backs off), 0 otherwise.")
+)
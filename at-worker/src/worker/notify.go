@@ -0,0 +1,265 @@
+// Package worker содержит логику опроса и обработки запланированных заданий.
+// Файл notify.go доставляет уведомления о завершении задания в каналы из колонки notify.
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"at-worker/models"
+)
+
+// notifyTimeout - ограничение на доставку уведомления в один канал
+const notifyTimeout = 10 * time.Second
+
+// NotifyChannel - канал уведомления о завершении задания (элемент JSON массива колонки notify)
+type NotifyChannel struct {
+	Type   string `json:"type"`   // "webhook" или "slack"
+	Target string `json:"target"` // URL получателя
+}
+
+// notifyEvent - тело уведомления канала webhook
+type notifyEvent struct {
+	TaskID       int64  `json:"task_id"`
+	TaskType     string `json:"task_type"`
+	Status       string `json:"status"` // completed или failed
+	ErrorMessage string `json:"error_message,omitempty"`
+	Warning      string `json:"warning,omitempty"`
+}
+
+// newNotifyEvent формирует событие о завершении задания с итоговым статусом status
+func newNotifyEvent(result models.TaskResult, status string) notifyEvent {
+	event := notifyEvent{
+		TaskID:   result.TaskID,
+		TaskType: result.TaskType,
+		Status:   status,
+		Warning:  result.Warning,
+	}
+	if status == "failed" {
+		event.ErrorMessage = result.ErrorMessage
+	}
+	return event
+}
+
+// Notifier доставляет уведомления о завершении заданий.
+// Каждый канал обслуживается независимо: ошибка доставки в один канал логируется
+// и не влияет ни на остальные каналы, ни на статус задания.
+type Notifier struct {
+	client   *http.Client
+	workerID string
+	wg       sync.WaitGroup
+
+	batchInterval time.Duration // Окно накопления событий webhook; 0 - отправка по одному
+	batchSize     int           // Максимум событий в одном запросе webhook
+
+	mu      sync.Mutex
+	batches map[string]*webhookBatch // Накопленные события по URL webhook'а
+}
+
+// webhookBatch - события, накопленные для одного URL webhook'а
+type webhookBatch struct {
+	events []notifyEvent
+	timer  *time.Timer
+}
+
+// NotifierOptions содержит настройки Notifier'а
+type NotifierOptions struct {
+	WorkerID string // Идентификатор worker'а для логов
+
+	// BatchInterval - режим пакетной отправки webhook: события одного URL накапливаются не дольше
+	// BatchInterval и отправляются одним запросом с JSON массивом. 0 - каждое событие отдельным запросом
+	BatchInterval time.Duration
+	// BatchSize - максимум событий в одном запросе; набранный пакет отправляется, не дожидаясь BatchInterval
+	BatchSize int
+}
+
+// defaultNotifyBatchSize - размер пакета, если NotifierOptions.BatchSize не задан
+const defaultNotifyBatchSize = 100
+
+// NewNotifier создает новый экземпляр Notifier.
+// Параметры:
+//   - opts: идентификатор worker'а и настройки пакетной отправки webhook
+func NewNotifier(opts NotifierOptions) *Notifier {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultNotifyBatchSize
+	}
+	return &Notifier{
+		client:        &http.Client{Timeout: notifyTimeout},
+		workerID:      opts.WorkerID,
+		batchInterval: opts.BatchInterval,
+		batchSize:     opts.BatchSize,
+		batches:       make(map[string]*webhookBatch),
+	}
+}
+
+// Notify асинхронно отправляет уведомление о задании в его каналы (result.Notify) без повторов.
+// Вызывается после того, как итоговый статус (completed или failed) записан в БД, если outbox
+// уведомлений выключен (см. notifyOutbox).
+func (n *Notifier) Notify(result models.TaskResult, status string) {
+	if len(result.Notify) == 0 {
+		return
+	}
+
+	var channels []NotifyChannel
+	if err := json.Unmarshal(result.Notify, &channels); err != nil {
+		log.Printf("[Worker %s] Task %d: invalid notify channels: %v", n.workerID, result.TaskID, err)
+		return
+	}
+
+	event := newNotifyEvent(result, status)
+	for _, channel := range channels {
+		// В пакетном режиме событие webhook'а уходит позже вместе с другими событиями того же URL
+		if channel.Type == "webhook" && n.batchInterval > 0 {
+			n.enqueue(channel.Target, event)
+			continue
+		}
+
+		n.wg.Add(1)
+		go func(channel NotifyChannel) {
+			defer n.wg.Done()
+			if err := n.deliver(channel, event); err != nil {
+				log.Printf("[Worker %s] Task %d: %s notification to %s failed: %v",
+					n.workerID, event.TaskID, channel.Type, redactTarget(channel.Target), err)
+			}
+		}(channel)
+	}
+}
+
+// Wait отправляет накопленные пакеты, не дожидаясь их окна, и ждет завершения начатых доставок
+// (при остановке worker'а)
+func (n *Notifier) Wait() {
+	n.mu.Lock()
+	for target, batch := range n.batches {
+		batch.timer.Stop()
+		n.sendBatchLocked(target, batch)
+	}
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+// enqueue добавляет событие в пакет webhook'а target. Первое событие пакета запускает таймер окна,
+// набранный до batchSize пакет отправляется сразу
+func (n *Notifier) enqueue(target string, event notifyEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	batch, ok := n.batches[target]
+	if !ok {
+		batch = &webhookBatch{}
+		batch.timer = time.AfterFunc(n.batchInterval, func() { n.flushBatch(target, batch) })
+		n.batches[target] = batch
+	}
+
+	batch.events = append(batch.events, event)
+	if len(batch.events) >= n.batchSize {
+		batch.timer.Stop()
+		n.sendBatchLocked(target, batch)
+	}
+}
+
+// flushBatch отправляет пакет по истечении окна, если его еще не отправили по размеру или при остановке
+func (n *Notifier) flushBatch(target string, batch *webhookBatch) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.batches[target] == batch {
+		n.sendBatchLocked(target, batch)
+	}
+}
+
+// sendBatchLocked убирает пакет из накопленных и асинхронно отправляет его; вызывается под n.mu.
+// wg.Add под той же блокировкой, что и в Wait, поэтому Wait не пропустит начатую отправку
+func (n *Notifier) sendBatchLocked(target string, batch *webhookBatch) {
+	delete(n.batches, target)
+
+	n.wg.Add(1)
+	go func(events []notifyEvent) {
+		defer n.wg.Done()
+		if err := n.post(target, events); err != nil {
+			log.Printf("[Worker %s] Batch of %d task notifications to %s failed: %v",
+				n.workerID, len(events), redactTarget(target), err)
+		}
+	}(batch.events)
+}
+
+// deliver отправляет уведомление в один канал
+func (n *Notifier) deliver(channel NotifyChannel, event notifyEvent) error {
+	var body interface{}
+	switch channel.Type {
+	case "webhook":
+		body = event
+	case "slack":
+		body = map[string]string{"text": slackText(event)}
+	default:
+		return fmt.Errorf("unknown channel type %q", channel.Type)
+	}
+
+	return n.post(channel.Target, body)
+}
+
+// post отправляет body в формате JSON на target
+func (n *Notifier) post(target string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	// Контекст worker'а при остановке уже отменен, поэтому у доставки собственный таймаут
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// slackErrorLimit - сколько символов error_message попадает в сообщение Slack (там может быть тело ответа)
+const slackErrorLimit = 500
+
+// slackText формирует текст сообщения для Slack
+func slackText(event notifyEvent) string {
+	text := fmt.Sprintf("Task %d (%s) %s", event.TaskID, event.TaskType, event.Status)
+	if event.ErrorMessage != "" {
+		message := []rune(event.ErrorMessage)
+		if len(message) > slackErrorLimit {
+			message = append(message[:slackErrorLimit], '…')
+		}
+		text += ": " + string(message)
+	}
+	if event.Warning != "" {
+		text += " (warning: " + event.Warning + ")"
+	}
+	return text
+}
+
+// redactTarget оставляет от URL канала только схему и хост: путь Slack webhook'а - это секрет
+func redactTarget(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "<invalid url>"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"at-worker/models"
+)
+
+// TestNotifierChannelsAreIndependent проверяет доставку в несколько каналов: отказ одного канала
+// не мешает остальным, webhook получает итог задания, Slack - текст сообщения
+func TestNotifierChannelsAreIndependent(t *testing.T) {
+	var mu sync.Mutex
+	var webhookEvent notifyEvent
+	var slackMessage map[string]string
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewDecoder(r.Body).Decode(&webhookEvent)
+	}))
+	defer webhook.Close()
+
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewDecoder(r.Body).Decode(&slackMessage)
+	}))
+	defer slack.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	channels, _ := json.Marshal([]NotifyChannel{
+		{Type: "webhook", Target: broken.URL},
+		{Type: "webhook", Target: webhook.URL},
+		{Type: "slack", Target: slack.URL},
+	})
+
+	notifier := NewNotifier(NotifierOptions{WorkerID: "test"})
+	notifier.Notify(models.TaskResult{
+		TaskID:       42,
+		TaskType:     "http_callback",
+		ErrorMessage: "HTTP 500",
+		Notify:       channels,
+	}, "failed")
+	notifier.Wait()
+
+	if webhookEvent.TaskID != 42 || webhookEvent.Status != "failed" || webhookEvent.ErrorMessage != "HTTP 500" {
+		t.Errorf("Webhook event: got %+v", webhookEvent)
+	}
+	if want := "Task 42 (http_callback) failed: HTTP 500"; slackMessage["text"] != want {
+		t.Errorf("Slack text: got=%q, want=%q", slackMessage["text"], want)
+	}
+}
+
+// TestRedactTarget проверяет, что в лог не попадает путь URL канала
+func TestRedactTarget(t *testing.T) {
+	if got := redactTarget("https://hooks.slack.com/services/T000/B000/secret"); got != "https://hooks.slack.com" {
+		t.Errorf("redactTarget: got=%q, want=https://hooks.slack.com", got)
+	}
+}
+
+// TestNotifierBatchesWebhookEvents проверяет пакетную отправку webhook: полный пакет уходит сразу,
+// остаток - при Wait, события разных URL не смешиваются
+func TestNotifierBatchesWebhookEvents(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]notifyEvent
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []notifyEvent
+		if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+			t.Errorf("Batch body must be a JSON array: %v", err)
+		}
+		mu.Lock()
+		batches = append(batches, events)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	notifier := NewNotifier(NotifierOptions{WorkerID: "test", BatchInterval: time.Hour, BatchSize: 2})
+	channels, _ := json.Marshal([]NotifyChannel{{Type: "webhook", Target: webhook.URL}})
+	otherChannels, _ := json.Marshal([]NotifyChannel{{Type: "webhook", Target: webhook.URL + "/other"}})
+
+	for id := int64(1); id <= 3; id++ {
+		notifier.Notify(models.TaskResult{TaskID: id, TaskType: "email", Notify: channels}, "completed")
+	}
+	notifier.Notify(models.TaskResult{TaskID: 4, TaskType: "email", Notify: otherChannels}, "completed")
+	notifier.Wait()
+
+	sizes := map[int]int{}
+	for _, batch := range batches {
+		sizes[len(batch)]++
+	}
+	if len(batches) != 3 || sizes[2] != 1 || sizes[1] != 2 {
+		t.Errorf("Expected batches of sizes 2, 1 and 1, got %v", batches)
+	}
+}
+
+// TestNotifyOutboxGroup проверяет разбиение захваченных уведомлений на доставки:
+// в пакетном режиме webhook'и одного URL объединяются не больше batchSize, Slack - по одному
+func TestNotifyOutboxGroup(t *testing.T) {
+	entry := func(id int64, channelType, target string) outboxEntry {
+		return outboxEntry{id: id, channel: NotifyChannel{Type: channelType, Target: target}}
+	}
+	entries := []outboxEntry{
+		entry(1, "webhook", "https://a.example.com"),
+		entry(2, "slack", "https://hooks.slack.com/x"),
+		entry(3, "webhook", "https://a.example.com"),
+		entry(4, "webhook", "https://b.example.com"),
+		entry(5, "webhook", "https://a.example.com"),
+		entry(6, "slack", "https://hooks.slack.com/x"),
+	}
+
+	single := newNotifyOutbox(nil, NewNotifier(NotifierOptions{WorkerID: "test"}), "test", 3, time.Second)
+	if groups := single.group(entries); len(groups) != len(entries) {
+		t.Errorf("Without batching expected %d groups, got %d", len(entries), len(groups))
+	}
+
+	batched := newNotifyOutbox(nil, NewNotifier(NotifierOptions{WorkerID: "test", BatchInterval: time.Second, BatchSize: 2}), "test", 3, time.Second)
+	var got [][]int64
+	for _, group := range batched.group(entries) {
+		var ids []int64
+		for _, e := range group {
+			ids = append(ids, e.id)
+		}
+		got = append(got, ids)
+	}
+	want := [][]int64{{1, 3}, {2}, {4}, {5}, {6}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Batched groups: got=%v, want=%v", got, want)
+	}
+
+	if newNotifyOutbox(nil, NewNotifier(NotifierOptions{}), "test", 0, time.Second) != nil {
+		t.Error("Expected nil outbox with max attempts 0")
+	}
+}
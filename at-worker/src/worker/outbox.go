@@ -0,0 +1,259 @@
+// Файл outbox.go - гарантированная доставка уведомлений о завершении через таблицу notification_outbox.
+// Записи outbox добавляются тем же запросом, что и итоговый статус задания (см. Worker.finishQuery),
+// поэтому уведомление не теряется ни при ошибке доставки, ни при перезапуске worker'а.
+// Неудачная доставка повторяется с собственным backoff, независимым от повторов задания.
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// outboxPollInterval - как часто проверяются уведомления, которые пора (повторно) доставить.
+	// Новые уведомления доставляются сразу: worker будит отправку после записи итогового статуса
+	outboxPollInterval = time.Second
+	// outboxClaimLimit - максимум уведомлений, захватываемых за один проход
+	outboxClaimLimit = 100
+	// outboxLease - на сколько захваченное уведомление скрывается от других worker'ов. Больше таймаута доставки,
+	// поэтому уведомление, захваченное остановившимся worker'ом, будет доставлено повторно, но не дважды параллельно
+	outboxLease = 3 * notifyTimeout
+	// maxOutboxBackoff - верхняя граница паузы между попытками доставки
+	maxOutboxBackoff = time.Hour
+)
+
+// outboxEntry - захваченная запись notification_outbox
+type outboxEntry struct {
+	id       int64
+	channel  NotifyChannel
+	event    notifyEvent
+	attempts int // Номер текущей попытки доставки
+}
+
+// notifyOutbox доставляет записи notification_outbox через Notifier и планирует повторы
+type notifyOutbox struct {
+	db          *sql.DB
+	notifier    *Notifier
+	workerID    string
+	maxAttempts int
+	retryBase   time.Duration
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// newNotifyOutbox создает отправку уведомлений из outbox; при maxAttempts <= 0 возвращает nil
+// (уведомления отправляются сразу и без повторов, см. Notifier.Notify)
+func newNotifyOutbox(db *sql.DB, notifier *Notifier, workerID string, maxAttempts int, retryBase time.Duration) *notifyOutbox {
+	if maxAttempts <= 0 {
+		return nil
+	}
+	return &notifyOutbox{
+		db:          db,
+		notifier:    notifier,
+		workerID:    workerID,
+		maxAttempts: maxAttempts,
+		retryBase:   retryBase,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// notifyNow будит отправку, не дожидаясь outboxPollInterval (после записи нового уведомления).
+// В пакетном режиме не используется: события копятся в outbox до следующего окна
+func (o *notifyOutbox) notifyNow() {
+	if o.notifier.batchInterval > 0 {
+		return
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run доставляет уведомления из outbox до отмены ctx. В пакетном режиме проход выполняется раз в окно
+// накопления, и webhook'и одного URL уходят одним запросом
+func (o *notifyOutbox) run(ctx context.Context) {
+	defer close(o.done)
+
+	interval := outboxPollInterval
+	if o.notifier.batchInterval > 0 {
+		interval = o.notifier.batchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+		// Полный проход означает, что в outbox могут быть еще записи к доставке
+		for ctx.Err() == nil {
+			if o.dispatch(ctx) < outboxClaimLimit {
+				break
+			}
+		}
+	}
+}
+
+// wait ждет завершения run (при остановке worker'а). Начатые доставки и их учет
+// используют собственные таймауты, недоставленное останется в outbox
+func (o *notifyOutbox) wait() {
+	<-o.done
+}
+
+// dispatch захватывает уведомления, которые пора доставить, и доставляет их.
+// Возвращает число захваченных записей
+func (o *notifyOutbox) dispatch(ctx context.Context) int {
+	entries, err := o.claim(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[Worker %s] Error claiming notifications: %v", o.workerID, err)
+		}
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, group := range o.group(entries) {
+		wg.Add(1)
+		go func(group []outboxEntry) {
+			defer wg.Done()
+			o.deliver(group)
+		}(group)
+	}
+	wg.Wait()
+	return len(entries)
+}
+
+// claim захватывает до outboxClaimLimit уведомлений, которые пора доставить: увеличивает attempts
+// и скрывает их на outboxLease. FOR UPDATE SKIP LOCKED не дает двум worker'ам захватить одну запись
+func (o *notifyOutbox) claim(ctx context.Context) ([]outboxEntry, error) {
+	query := `
+		UPDATE notification_outbox
+		SET attempts = attempts + 1,
+		    next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM notification_outbox
+			WHERE failed_at IS NULL AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, channel_type, target, event, attempts
+	`
+	rows, err := o.db.QueryContext(ctx, query, outboxClaimLimit, outboxLease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []outboxEntry
+	for rows.Next() {
+		var entry outboxEntry
+		var event []byte
+		if err := rows.Scan(&entry.id, &entry.channel.Type, &entry.channel.Target, &event, &entry.attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(event, &entry.event); err != nil {
+			return nil, fmt.Errorf("notification %d: invalid event: %w", entry.id, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// group делит записи на доставки: в пакетном режиме webhook'и одного URL объединяются
+// (не больше batchSize в запросе), остальные уведомления доставляются по одному
+func (o *notifyOutbox) group(entries []outboxEntry) [][]outboxEntry {
+	var groups [][]outboxEntry
+	batches := make(map[string]int) // URL webhook'а -> индекс его незаполненного пакета в groups
+	for _, entry := range entries {
+		if entry.channel.Type != "webhook" || o.notifier.batchInterval <= 0 {
+			groups = append(groups, []outboxEntry{entry})
+			continue
+		}
+		i, ok := batches[entry.channel.Target]
+		if !ok || len(groups[i]) >= o.notifier.batchSize {
+			i = len(groups)
+			groups = append(groups, nil)
+			batches[entry.channel.Target] = i
+		}
+		groups[i] = append(groups[i], entry)
+	}
+	return groups
+}
+
+// deliver доставляет группу уведомлений одного канала и записывает итог: доставленные записи удаляются,
+// остальные откладываются по backoff или, если попытки исчерпаны, помечаются failed_at
+func (o *notifyOutbox) deliver(group []outboxEntry) {
+	// В пакетном режиме webhook получает JSON массив, даже если в пакете одно событие
+	var err error
+	if group[0].channel.Type != "webhook" || o.notifier.batchInterval <= 0 {
+		err = o.notifier.deliver(group[0].channel, group[0].event)
+	} else {
+		events := make([]notifyEvent, len(group))
+		for i, entry := range group {
+			events[i] = entry.event
+		}
+		err = o.notifier.post(group[0].channel.Target, events)
+	}
+
+	// Контекст worker'а при остановке уже отменен, поэтому у записи итога собственный таймаут
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	if err == nil {
+		ids := make([]int64, len(group))
+		for i, entry := range group {
+			ids[i] = entry.id
+		}
+		if _, dbErr := o.db.ExecContext(ctx, `DELETE FROM notification_outbox WHERE id = ANY($1)`, pq.Array(ids)); dbErr != nil {
+			log.Printf("[Worker %s] Error removing %d delivered notifications: %v", o.workerID, len(ids), dbErr)
+		}
+		notificationsTotal.Add(float64(len(group)), "delivered")
+		return
+	}
+
+	for _, entry := range group {
+		o.reschedule(ctx, entry, err)
+	}
+}
+
+// reschedule записывает неудачную попытку доставки: следующая попытка через backoff (не больше maxOutboxBackoff),
+// после maxAttempts попыток запись помечается failed_at и больше не доставляется
+func (o *notifyOutbox) reschedule(ctx context.Context, entry outboxEntry, deliveryErr error) {
+	final := entry.attempts >= o.maxAttempts
+	delay := backoff(entry.attempts, o.retryBase, maxOutboxBackoff)
+
+	query := `
+		UPDATE notification_outbox
+		SET last_error = $2,
+		    next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond',
+		    failed_at = CASE WHEN $4 THEN NOW() END
+		WHERE id = $1
+	`
+	if _, err := o.db.ExecContext(ctx, query, entry.id, deliveryErr.Error(), delay.Milliseconds(), final); err != nil {
+		log.Printf("[Worker %s] Error rescheduling notification %d: %v", o.workerID, entry.id, err)
+	}
+
+	target := redactTarget(entry.channel.Target)
+	if final {
+		notificationsTotal.Inc("failed")
+		log.Printf("[Worker %s] Task %d: %s notification to %s failed after %d attempts: %v",
+			o.workerID, entry.event.TaskID, entry.channel.Type, target, entry.attempts, deliveryErr)
+		return
+	}
+	notificationsTotal.Inc("retry")
+	log.Printf("[Worker %s] Task %d: %s notification to %s failed (attempt %d/%d), retry in %v: %v",
+		o.workerID, entry.event.TaskID, entry.channel.Type, target, entry.attempts, o.maxAttempts, delay, deliveryErr)
+}
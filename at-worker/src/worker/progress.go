@@ -0,0 +1,102 @@
+// Package worker содержит логику выполнения запланированных заданий.
+// Файл progress.go позволяет исполнителю сообщать прогресс долгого задания
+// (колонка progress, видна в GET /api/v1/tasks/:id).
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// progressMinInterval - не чаще одной записи прогресса задания в БД за этот интервал
+	progressMinInterval = time.Second
+	// progressWriteTimeout ограничивает одну запись прогресса, чтобы медленная БД не задерживала задание
+	progressWriteTimeout = 2 * time.Second
+)
+
+// progressKey - ключ progressReporter в контексте выполнения задания
+type progressKey struct{}
+
+// progressReporter записывает прогресс одного выполняющегося задания.
+// Записи прореживаются: чаще progressMinInterval в БД уходит только последнее значение,
+// которое сохранится следующей записью или flush после выполнения.
+type progressReporter struct {
+	save     func(ctx context.Context, data []byte) error // Запись значения в БД
+	workerID string
+	taskID   int64
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	pending   json.RawMessage
+}
+
+// newProgressReporter создает progressReporter для попытки attempt задания taskID.
+// Запись только для задания в 'processing' с той же попыткой: отмененное, уже завершенное
+// или захваченное заново задание не трогаем
+func newProgressReporter(db *sql.DB, workerID string, taskID int64, attempt int) *progressReporter {
+	save := func(ctx context.Context, data []byte) error {
+		_, err := db.ExecContext(ctx, `
+			UPDATE scheduled_tasks
+			SET progress = $2
+			WHERE id = $1 AND status = 'processing' AND attempts = $3
+		`, taskID, data, attempt)
+		return err
+	}
+	return &progressReporter{save: save, workerID: workerID, taskID: taskID}
+}
+
+// withProgressReporter возвращает контекст выполнения задания, через который доступен ReportProgress
+func withProgressReporter(ctx context.Context, r *progressReporter) context.Context {
+	return context.WithValue(ctx, progressKey{}, r)
+}
+
+// ReportProgress сохраняет прогресс выполняемого задания в колонку progress, например
+// ReportProgress(ctx, map[string]interface{}{"percent": 40, "detail": "downloaded 40%"}).
+// progress должен сериализоваться в JSON. Вызывается исполнителем задания с контекстом,
+// переданным в Execute; вне выполнения задания (нет progressReporter в контексте) ничего не делает.
+// Ошибка записи только логируется: прогресс - справочная информация и не влияет на результат.
+func ReportProgress(ctx context.Context, progress interface{}) {
+	r, ok := ctx.Value(progressKey{}).(*progressReporter)
+	if !ok {
+		return
+	}
+
+	data, err := json.Marshal(progress)
+	if err != nil {
+		log.Printf("[Worker %s] Task %d: failed to encode progress: %v", r.workerID, r.taskID, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = data
+	if time.Since(r.lastWrite) >= progressMinInterval {
+		r.writeLocked()
+	}
+}
+
+// flush записывает отложенный прогресс, если он есть
+func (r *progressReporter) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending != nil {
+		r.writeLocked()
+	}
+}
+
+// writeLocked записывает r.pending; вызывается под r.mu
+func (r *progressReporter) writeLocked() {
+	ctx, cancel := context.WithTimeout(context.Background(), progressWriteTimeout)
+	defer cancel()
+
+	if err := r.save(ctx, r.pending); err != nil {
+		log.Printf("[Worker %s] Task %d: failed to save progress: %v", r.workerID, r.taskID, err)
+	}
+	r.lastWrite = time.Now()
+	r.pending = nil
+}
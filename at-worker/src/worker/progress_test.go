@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"testing"
+)
+
+// TestReportProgress проверяет прореживание записей прогресса и запись последнего значения в flush
+func TestReportProgress(t *testing.T) {
+	// Вне выполнения задания ReportProgress ничего не делает
+	ReportProgress(context.Background(), map[string]int{"percent": 10})
+
+	var saved []string
+	r := &progressReporter{
+		save: func(ctx context.Context, data []byte) error {
+			saved = append(saved, string(data))
+			return nil
+		},
+		workerID: "test",
+		taskID:   1,
+	}
+	ctx := withProgressReporter(context.Background(), r)
+
+	ReportProgress(ctx, map[string]int{"percent": 10})
+	ReportProgress(ctx, map[string]int{"percent": 20})
+	ReportProgress(ctx, map[string]int{"percent": 30})
+	if len(saved) != 1 || saved[0] != `{"percent":10}` {
+		t.Fatalf("Expected only the first value to be written immediately, got %v", saved)
+	}
+
+	r.flush()
+	if len(saved) != 2 || saved[1] != `{"percent":30}` {
+		t.Fatalf("Expected flush to write the latest value, got %v", saved)
+	}
+
+	r.flush()
+	if len(saved) != 2 {
+		t.Errorf("flush without new progress must not write, got %v", saved)
+	}
+}
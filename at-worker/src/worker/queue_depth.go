@@ -0,0 +1,135 @@
+// Package worker содержит логику опроса и обработки запланированных заданий.
+// Файл queue_depth.go периодически считает задания по статусам и экспортирует их
+// как gauge at_worker_queue_depth - основной сигнал для алертов на рост очереди,
+// а также отставание выполнения от расписания (at_worker_scheduling_lag_seconds).
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"at-worker/metrics"
+)
+
+// queueDepth - текущее число заданий в каждом статусе
+var queueDepth = metrics.NewGauge("at_worker_queue_depth", "Number of tasks by status, refreshed periodically.", "status")
+
+// schedulingLag - насколько execute_at самого старого наступившего pending задания в прошлом
+var schedulingLag = metrics.NewGauge("at_worker_scheduling_lag_seconds", "Age of the oldest due pending task (NOW() - execute_at), 0 if none.")
+
+// queueDepthStatuses - статусы, которые всегда присутствуют в метрике (0, если заданий нет),
+// чтобы алерты не теряли ряд, когда очередь опустела
+var queueDepthStatuses = []string{"pending", "processing", "hold", "completed", "failed", "cancelled", "skipped"}
+
+// QueueDepthMonitor периодически обновляет gauge at_worker_queue_depth и at_worker_scheduling_lag_seconds
+type QueueDepthMonitor struct {
+	db       *sql.DB
+	interval time.Duration // Интервал между подсчетами (каждый - GROUP BY по всей таблице)
+	maxLag   time.Duration // Отставание от расписания, после которого пишется предупреждение; 0 - не писать
+}
+
+// NewQueueDepthMonitor создает новый экземпляр QueueDepthMonitor.
+// Параметры:
+//   - db: подключение к базе данных (лучше пул Cleaner'а, чтобы не отнимать соединения у Worker'а)
+//   - interval: интервал между подсчетами
+//   - maxLag: отставание самого старого наступившего pending задания, после которого пишется
+//     предупреждение в лог (все worker'ы остановлены или не успевают); 0 - не писать
+func NewQueueDepthMonitor(db *sql.DB, interval, maxLag time.Duration) *QueueDepthMonitor {
+	return &QueueDepthMonitor{
+		db:       db,
+		interval: interval,
+		maxLag:   maxLag,
+	}
+}
+
+// Start считает задания сразу и затем каждые interval, пока ctx не отменен
+func (m *QueueDepthMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	log.Printf("[QueueDepth] Started with interval %v, max scheduling lag %v", m.interval, m.maxLag)
+
+	m.refresh(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.refresh(ctx)
+		}
+	}
+}
+
+// refresh выполняет подсчет и обновляет gauge; при ошибке остаются прежние значения
+func (m *QueueDepthMonitor) refresh(ctx context.Context) {
+	m.refreshCounts(ctx)
+	m.refreshLag(ctx)
+}
+
+// refreshCounts считает задания по статусам
+func (m *QueueDepthMonitor) refreshCounts(ctx context.Context) {
+	rows, err := m.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM scheduled_tasks GROUP BY status`)
+	if err != nil {
+		log.Printf("[QueueDepth] Error counting tasks: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			log.Printf("[QueueDepth] Error scanning row: %v", err)
+			return
+		}
+		counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("[QueueDepth] Error iterating rows: %v", err)
+		return
+	}
+
+	setQueueDepth(counts)
+}
+
+// setQueueDepth записывает подсчет в gauge; отсутствующие статусы получают 0
+func setQueueDepth(counts map[string]int64) {
+	for _, status := range queueDepthStatuses {
+		queueDepth.Set(float64(counts[status]), status)
+	}
+}
+
+// refreshLag находит самое старое наступившее pending задание и обновляет at_worker_scheduling_lag_seconds.
+// Задания, ожидающие повторной попытки, не учитываются, пока не наступил их execute_at.
+// Время считается по NOW() базы, как и в запросе захвата.
+func (m *QueueDepthMonitor) refreshLag(ctx context.Context) {
+	var id int64
+	var lagSeconds float64
+	err := m.db.QueryRowContext(ctx, `
+		SELECT id, EXTRACT(EPOCH FROM NOW() - execute_at)
+		FROM scheduled_tasks
+		WHERE status = 'pending' AND execute_at <= NOW()
+		ORDER BY execute_at ASC
+		LIMIT 1
+	`).Scan(&id, &lagSeconds)
+	if err == sql.ErrNoRows {
+		schedulingLag.Set(0)
+		return
+	}
+	if err != nil {
+		log.Printf("[QueueDepth] Error measuring scheduling lag: %v", err)
+		return
+	}
+
+	schedulingLag.Set(lagSeconds)
+
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	if m.maxLag > 0 && lag > m.maxLag {
+		log.Printf("[QueueDepth] WARNING: scheduling lag %v exceeds %v (oldest due pending task %d), workers may be down or overloaded",
+			lag.Truncate(time.Second), m.maxLag, id)
+	}
+}
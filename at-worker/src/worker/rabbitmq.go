@@ -0,0 +1,195 @@
+// Файл rabbitmq.go - отправка сообщений заданий rabbitmq в брокер RabbitMQ (AMQP 0-9-1).
+// Соединение с брокером одно на worker: устанавливается при первом задании rabbitmq и переиспользуется,
+// а после обрыва устанавливается заново при следующем задании. Канал работает в режиме подтверждений
+// (publisher confirms): задание выполнено, только когда брокер подтвердил, что принял сообщение.
+// Сообщения публикуются с флагом mandatory: сообщение без маршрута брокер возвращает (basic.return)
+// перед подтверждением, и попытка считается неудачной, а не выполненной.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// rabbitDialTimeout ограничивает установку соединения с брокером
+	rabbitDialTimeout = 10 * time.Second
+	// rabbitReturnBuffer - емкость канала возвращенных сообщений: пока он заполнен, amqp091 не читает
+	// следующие кадры соединения, поэтому емкость с запасом больше числа одновременных публикаций
+	rabbitReturnBuffer = 1024
+	// rabbitReturnTTL - сколько хранится возврат, не забранный своей публикацией: брокер отправляет
+	// basic.return до подтверждения, поэтому позже приходят только возвраты публикаций, чье ожидание
+	// прервал ctx, и их уже никто не заберет
+	rabbitReturnTTL = 5 * time.Minute
+)
+
+var (
+	// errNotConfirmed - брокер отклонил сообщение (basic.nack) или канал закрылся до подтверждения
+	errNotConfirmed = errors.New("message was not confirmed by broker")
+	// errUnroutable - брокер вернул сообщение (basic.return): ни одна очередь не подходит под маршрут
+	errUnroutable = errors.New("message was returned by broker as unroutable")
+)
+
+// rabbitMessage - сообщение задания rabbitmq
+type rabbitMessage struct {
+	Exchange   string // Exchange; пусто - exchange по умолчанию, который маршрутизирует по имени очереди
+	RoutingKey string // Ключ маршрутизации
+	MessageID  string
+	Body       []byte // JSON сообщения
+}
+
+// rabbitPublisher публикует сообщения через общее соединение с брокером.
+// Публикация в канал и ожидание подтверждения безопасны из нескольких горутин (amqp091),
+// mu защищает только установку соединения
+type rabbitPublisher struct {
+	url string
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	returns <-chan amqp.Return // Возвращенные брокером сообщения канала channel
+
+	// returnsMu защищает returned - возвращенные сообщения, вычитанные из returns, по MessageId
+	returnsMu sync.Mutex
+	returned  map[string]rabbitReturn
+}
+
+// rabbitReturn - возвращенное брокером сообщение и время, когда его вычитали из канала возвратов
+type rabbitReturn struct {
+	ret amqp.Return
+	at  time.Time
+}
+
+// newRabbitPublisher создает publisher для брокера по AMQP URL; без URL возвращает nil (тип не настроен)
+func newRabbitPublisher(url string) *rabbitPublisher {
+	if url == "" {
+		return nil
+	}
+	return &rabbitPublisher{url: url}
+}
+
+// openChannel возвращает канал в режиме подтверждений и его уведомления о возвращенных сообщениях,
+// при необходимости заново соединяясь с брокером
+func (p *rabbitPublisher) openChannel() (*amqp.Channel, <-chan amqp.Return, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.channel != nil && !p.channel.IsClosed() {
+		return p.channel, p.returns, nil
+	}
+	if p.conn == nil || p.conn.IsClosed() {
+		conn, err := amqp.DialConfig(p.url, amqp.Config{Dial: amqp.DefaultDial(rabbitDialTimeout)})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		}
+		p.conn = conn
+	}
+
+	channel, err := p.conn.Channel()
+	if err != nil {
+		// Канал не открывается на живом соединении - соединение пересоздается при следующей попытке
+		p.conn.Close()
+		return nil, nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return nil, nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	p.channel = channel
+	p.returns = channel.NotifyReturn(make(chan amqp.Return, rabbitReturnBuffer))
+
+	// Возвраты закрытого канала относятся к публикациям, которые уже завершились ошибкой
+	p.returnsMu.Lock()
+	p.returned = nil
+	p.returnsMu.Unlock()
+	return channel, p.returns, nil
+}
+
+// publish отправляет постоянное (persistent) сообщение с флагом mandatory и ждет подтверждения брокера
+// в пределах ctx. Сообщение, возвращенное брокером как немаршрутизируемое, - ошибка errUnroutable
+func (p *rabbitPublisher) publish(ctx context.Context, msg rabbitMessage) error {
+	channel, returns, err := p.openChannel()
+	if err != nil {
+		return err
+	}
+	// Возврат предыдущей публикации с тем же MessageId (ее ожидание прервал ctx) не относится к этой
+	p.takeReturn(returns, msg.MessageID)
+
+	confirm, err := channel.PublishWithDeferredConfirmWithContext(ctx, msg.Exchange, msg.RoutingKey, true, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.MessageID,
+		Timestamp:    time.Now(),
+		Body:         msg.Body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		p.takeReturn(returns, msg.MessageID)
+		return fmt.Errorf("failed to wait for publish confirm: %w", err)
+	}
+	if !acked {
+		p.takeReturn(returns, msg.MessageID)
+		return errNotConfirmed
+	}
+	// Брокер отправляет basic.return раньше подтверждения, а amqp091 кладет его в returns до того,
+	// как обработает подтверждение, поэтому после ack возврат этого сообщения уже вычитывается
+	if ret, ok := p.takeReturn(returns, msg.MessageID); ok {
+		return fmt.Errorf("%w: %d %s", errUnroutable, ret.ReplyCode, ret.ReplyText)
+	}
+	return nil
+}
+
+// takeReturn вычитывает накопившиеся в returns возвращенные сообщения и забирает возврат сообщения
+// messageID, если он был. Канал возвратов общий для всех публикаций, поэтому чужие возвраты
+// сохраняются в returned до вызова их публикацией, но не дольше rabbitReturnTTL
+func (p *rabbitPublisher) takeReturn(returns <-chan amqp.Return, messageID string) (amqp.Return, bool) {
+	p.returnsMu.Lock()
+	defer p.returnsMu.Unlock()
+
+	now := time.Now()
+	if p.returned == nil {
+		p.returned = make(map[string]rabbitReturn)
+	}
+	for drained := false; !drained; {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				drained = true
+				break
+			}
+			p.returned[ret.MessageId] = rabbitReturn{ret: ret, at: now}
+		default:
+			drained = true
+		}
+	}
+
+	ret, ok := p.returned[messageID]
+	delete(p.returned, messageID)
+	for id, stale := range p.returned {
+		if now.Sub(stale.at) > rabbitReturnTTL {
+			delete(p.returned, id)
+		}
+	}
+	return ret.ret, ok
+}
+
+// close закрывает соединение с брокером (при остановке worker'а)
+func (p *rabbitPublisher) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil && !p.conn.IsClosed() {
+		p.conn.Close()
+	}
+}
@@ -0,0 +1,81 @@
+// Файл target_cache.go - кэш разрешенных и проверенных хостов http_callback.
+// Без кэша каждое новое соединение заново разрешает хост через DNS и проверяет его адреса политикой
+// (url_policy.go). При всплеске заданий к нескольким одинаковым хостам это лишние DNS-запросы и проверки.
+// Кэш хранит итог на ttl, поэтому смена DNS-записи или адреса хоста становится видна не сразу, а через ttl.
+package worker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTargetCacheEntries - максимум хостов в кэше; при переполнении кэш очищается от устаревших записей,
+// а если их нет - целиком, чтобы задания к множеству разных хостов не раздували память
+const maxTargetCacheEntries = 1000
+
+// targetEntry - итог разрешения хоста: адреса или решение политики (запрет)
+type targetEntry struct {
+	ips     []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// targetCache - кэш итогов resolve по хосту. Кэшируются адреса и запреты политики (errBlockedURL);
+// ошибки DNS не кэшируются - они бывают временными, и следующее задание должно попробовать снова
+type targetCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]targetEntry
+}
+
+// newTargetCache создает кэш; при ttl <= 0 возвращает nil (без кэша)
+func newTargetCache(ttl time.Duration) *targetCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &targetCache{ttl: ttl, entries: make(map[string]targetEntry)}
+}
+
+// resolve возвращает адреса хоста из кэша, а при промахе или устаревшей записи - через lookup.
+// Параллельные промахи по одному хосту выполняют lookup каждый: это дешевле блокировки на время DNS
+func (c *targetCache) resolve(ctx context.Context, host string, lookup func(ctx context.Context, host string) ([]net.IPAddr, error)) ([]net.IPAddr, error) {
+	host = strings.ToLower(host)
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.ips, entry.err
+	}
+
+	ips, err := lookup(ctx, host)
+	if err != nil && !errors.Is(err, errBlockedURL) {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxTargetCacheEntries {
+		c.evict(now)
+	}
+	c.entries[host] = targetEntry{ips: ips, err: err, expires: now.Add(c.ttl)}
+	return ips, err
+}
+
+// evict удаляет устаревшие записи, а если таких нет - все. Вызывается под c.mu
+func (c *targetCache) evict(now time.Time) {
+	for host, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, host)
+		}
+	}
+	if len(c.entries) >= maxTargetCacheEntries {
+		c.entries = make(map[string]targetEntry)
+	}
+}
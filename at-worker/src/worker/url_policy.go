@@ -0,0 +1,189 @@
+// Файл url_policy.go - защита http_callback от SSRF: к каким адресам worker может обращаться.
+// Задания создают пользователи API, поэтому url из payload не должен вести во внутреннюю сеть
+// (сервис метаданных облака 169.254.169.254, localhost, приватные подсети).
+// Адрес проверяется дважды: до запроса по url (понятная ошибка без сетевых обращений) и при каждом
+// соединении по фактическим IP после DNS - так проверку не обойти DNS-записью на внутренний адрес или редиректом.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// errBlockedURL - url задания запрещен политикой адресов; такая ошибка не исправится повтором
+var errBlockedURL = errors.New("url is blocked by callback policy")
+
+// hostRules - список хостов и подсетей allowlist или denylist.
+// Элемент списка - CIDR (10.0.0.0/8), IP-адрес, имя хоста (api.example.com) или "*.example.com" (любой поддомен)
+type hostRules struct {
+	hosts []string
+	nets  []*net.IPNet
+}
+
+// parseHostRules разбирает элементы списка; CIDR должны быть уже проверены при загрузке конфигурации
+func parseHostRules(entries []string) hostRules {
+	var rules hostRules
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if entry == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			rules.nets = append(rules.nets, ipNet)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			rules.nets = append(rules.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		rules.hosts = append(rules.hosts, entry)
+	}
+	return rules
+}
+
+func (r hostRules) empty() bool {
+	return len(r.hosts) == 0 && len(r.nets) == 0
+}
+
+// matchHost проверяет имя хоста (в нижнем регистре) по именам списка
+func (r hostRules) matchHost(host string) bool {
+	for _, rule := range r.hosts {
+		if rule == host || strings.HasPrefix(rule, "*.") && strings.HasSuffix(host, rule[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIP проверяет адрес по подсетям списка
+func (r hostRules) matchIP(ip net.IP) bool {
+	for _, ipNet := range r.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlPolicy - политика адресов http_callback. Denylist проверяется первым и побеждает всегда.
+// Если allowlist не пуст, разрешены только его хосты и подсети (в том числе приватные).
+// Без allowlist при blockPrivate запрещены приватные, loopback и link-local адреса.
+type urlPolicy struct {
+	allow        hostRules
+	deny         hostRules
+	blockPrivate bool
+}
+
+// newURLPolicy создает политику адресов; без списков и blockPrivate возвращает nil (без ограничений)
+func newURLPolicy(allow, deny []string, blockPrivate bool) *urlPolicy {
+	p := &urlPolicy{allow: parseHostRules(allow), deny: parseHostRules(deny), blockPrivate: blockPrivate}
+	if p.allow.empty() && p.deny.empty() && !blockPrivate {
+		return nil
+	}
+	return p
+}
+
+// checkURL проверяет url задания до запроса: схема http или https и имя хоста (без DNS)
+func (p *urlPolicy) checkURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", errBlockedURL)
+	}
+	_, err := p.checkHost(target.Hostname())
+	return err
+}
+
+// checkHost проверяет хост по имени, без DNS; IP-адрес в url проверяется сразу как адрес.
+// allowed - хост разрешен allowlist по имени, тогда его адреса проверяются только по denylist
+func (p *urlPolicy) checkHost(host string) (allowed bool, err error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return false, p.checkIP(host, ip, false)
+	}
+	if p.deny.matchHost(host) {
+		return false, fmt.Errorf("%w: host %s is in the denylist", errBlockedURL, host)
+	}
+	if p.allow.matchHost(host) {
+		return true, nil
+	}
+	if p.allow.empty() && p.blockPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return false, fmt.Errorf("%w: host %s is a private address", errBlockedURL, host)
+	}
+	return false, nil
+}
+
+// checkIP проверяет адрес ip хоста host (после DNS или указанный в url)
+func (p *urlPolicy) checkIP(host string, ip net.IP, hostAllowed bool) error {
+	switch {
+	case p.deny.matchIP(ip):
+		return fmt.Errorf("%w: address %s of host %s is in the denylist", errBlockedURL, ip, host)
+	case hostAllowed || p.allow.matchIP(ip):
+		return nil
+	case !p.allow.empty():
+		return fmt.Errorf("%w: host %s (%s) is not in the allowlist", errBlockedURL, host, ip)
+	case p.blockPrivate && isPrivateIP(ip):
+		return fmt.Errorf("%w: address %s of host %s is private", errBlockedURL, ip, host)
+	}
+	return nil
+}
+
+// isPrivateIP - адреса внутренней сети: приватные подсети, loopback, link-local (в том числе 169.254.169.254),
+// unspecified (0.0.0.0 ведет на локальный хост) и multicast
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// resolve разрешает хост и проверяет все его адреса; запрещен хотя бы один адрес - запрещен хост,
+// иначе результат зависел бы от порядка записей DNS. Без политики (nil) хост только разрешается
+func (p *urlPolicy) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if p == nil {
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+	hostAllowed, err := p.checkHost(host)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if err := p.checkIP(host, ip.IP, hostAllowed); err != nil {
+			return nil, err
+		}
+	}
+	return ips, nil
+}
+
+// dialContext возвращает DialContext транспорта http_callback: разрешает хост через resolve (с проверкой
+// адресов политикой) и соединяется с полученным адресом, чтобы DNS не вернул другой адрес между проверкой
+// и соединением. Вызывается для каждого соединения, в том числе после редиректа на другой хост
+func dialContext(dialer *net.Dialer, resolve func(ctx context.Context, host string) ([]net.IPAddr, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
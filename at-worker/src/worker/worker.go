@@ -11,13 +11,39 @@ package worker
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"sync"
 	"time"
 
+	"at-worker/metrics"
 	"at-worker/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// resultWriteAttempts - сколько раз пытаемся записать результат задания в БД
+	resultWriteAttempts = 3
+	// resultWriteBaseDelay - пауза перед первым повтором записи, далее удваивается
+	resultWriteBaseDelay = 200 * time.Millisecond
+	// releaseTimeout - сколько ждем возврата в очередь заданий, захваченных заранее (lookahead), при остановке
+	releaseTimeout = 5 * time.Second
+	// claimScanFactor - во сколько раз больше batchSize ближайших по execute_at заданий просматривается
+	// для приоритетов и квоты по типам, если окно захвата не задано
+	claimScanFactor = 10
+	// quarantineDelay - на сколько откладывается задание неизвестного типа в карантине,
+	// чтобы worker со старым кодом не захватывал его на каждом опросе
+	quarantineDelay = time.Minute
+	// hostDeferDelay - на сколько откладывается задание, хост которого исчерпал лимит одновременных запросов
+	hostDeferDelay = time.Second
+	// priorityOrder - порядок захвата: эффективный приоритет по убыванию, затем execute_at. Эффективный приоритет -
+	// priority плюс 1 за каждую минуту ожидания после execute_at, поэтому поток высокоприоритетных заданий
+	// не откладывает низкоприоритетные навсегда. Та же логика в at-api (models.ScheduledTask.EffectivePriority)
+	priorityOrder = "priority + EXTRACT(EPOCH FROM (NOW() - execute_at)) / 60 DESC, execute_at ASC"
 )
 
 // Worker отвечает за опрос и обработку запланированных заданий
@@ -27,42 +53,169 @@ type Worker struct {
 	workerID        string
 	pollingInterval time.Duration
 	batchSize       int
+	batchSizes      *batchSizes
+	queues          []string
+	minFreeConns    int
+	claimWindow     int
+	lookahead       time.Duration
+	payloadBudget   int
+	taskTimeout     time.Duration
+	typeTimeouts    map[string]time.Duration
+	metricTypes     *metrics.LabelAllowlist
+	notifier        *Notifier
+	outbox          *notifyOutbox
+	retryToBack     bool
+	retryBaseDelay  time.Duration
+	retryMaxDelay   time.Duration
+	typeQuota       int
+	fleetLimits     map[string]int
+	catchUp         *catchUpThrottle
+	adaptive        *adaptiveInterval
+	dbBackoffMax    time.Duration
+}
+
+// Options содержит настройки Worker'а
+type Options struct {
+	WorkerID        string                   // Уникальный идентификатор worker'а для логирования
+	PollingInterval time.Duration            // Интервал опроса БД для новых заданий
+	BatchSize       int                      // Количество заданий, извлекаемых за один запрос
+	TypeBatchSizes  map[string]int           // Размер батча для типов заданий: максимум заданий типа в одном батче (см. batchSizes)
+	QueueBatchSizes map[string]int           // Размер батча для очередей; размер типа важнее размера очереди
+	Queues          []string                 // Очереди, из которых забираются задания; пусто - все очереди
+	MinFreeConns    int                      // Минимум свободных соединений в пуле, при котором worker начинает захват
+	ClaimWindow     int                      // Батч выбирается случайно среди ClaimWindow ближайших заданий; <= BatchSize - строго по порядку захвата
+	Lookahead       time.Duration            // Захватывать задания, которые наступят в течение Lookahead, и запускать их точно в срок; 0 - выключено
+	PayloadBudget   int                      // Суммарный размер payload батча в байтах, после которого захват прекращается; 0 - без ограничения
+	TaskTimeout     time.Duration            // Таймаут выполнения задания по умолчанию
+	TypeTimeouts    map[string]time.Duration // Таймауты по умолчанию для типов заданий (перекрывают TaskTimeout)
+	MetricTaskTypes []string                 // Типы заданий, попадающие в метку task_type как есть; остальные - "other"
+	RetryToBack     bool                     // Повторная попытка ставится в конец очереди (execute_at = NOW()), а не на прежнее место
+	RetryBaseDelay  time.Duration            // Пауза перед повтором после первой неудачной попытки, дальше удваивается (см. backoff); 0 - без паузы
+	RetryMaxDelay   time.Duration            // Верхняя граница паузы перед повтором; 0 - без ограничения
+	TypeQuota       int                      // Максимум заданий одного типа в батче; 0 - без ограничения
+	FleetLimits     map[string]int           // Максимум одновременно выполняемых заданий типа на все worker'ы (см. fleetCapacity)
+	CatchUpOverdue  time.Duration            // Задание просрочено для догоняющего ограничения, если execute_at прошел больше чем CatchUpOverdue назад
+	CatchUpRate     int                      // Максимум просроченных заданий типа за CatchUpInterval; 0 - без ограничения
+	CatchUpInterval time.Duration            // Интервал, на который действует CatchUpRate
+	MinPollInterval time.Duration            // Нижняя граница адаптивного интервала опроса
+	MaxPollInterval time.Duration            // Верхняя граница адаптивного интервала опроса; 0 - интервал постоянный (PollingInterval)
+	DBBackoffMax    time.Duration            // Верхняя граница паузы между опросами при ошибках БД подряд; 0 - опрос с обычным интервалом
+
+	NotifyBatchInterval time.Duration // Окно накопления событий webhook для отправки пакетом; 0 - по одному
+	NotifyBatchSize     int           // Максимум событий в одном пакете webhook
+	NotifyMaxAttempts   int           // Попыток доставки уведомления из outbox; 0 - outbox выключен, уведомления без повторов
+	NotifyRetryBase     time.Duration // Пауза после первой неудачной доставки; дальше удваивается (см. backoff)
 }
 
 // NewWorker создает новый экземпляр Worker.
 // Параметры:
 //   - db: подключение к базе данных
-//   - workerID: уникальный идентификатор worker'а для логирования
-//   - pollingInterval: интервал опроса БД для новых заданий
-//   - batchSize: количество заданий, извлекаемых за один запрос
-func NewWorker(db *sql.DB, workerID string, pollingInterval time.Duration, batchSize int) *Worker {
+//   - executor: исполнитель заданий
+//   - opts: настройки опроса (идентификатор, интервал, размер батча, очереди)
+func NewWorker(db *sql.DB, executor *Executor, opts Options) *Worker {
+	notifier := NewNotifier(NotifierOptions{
+		WorkerID:      opts.WorkerID,
+		BatchInterval: opts.NotifyBatchInterval,
+		BatchSize:     opts.NotifyBatchSize,
+	})
+
 	return &Worker{
 		db:              db,
-		executor:        NewExecutor(),
-		workerID:        workerID,
-		pollingInterval: pollingInterval,
-		batchSize:       batchSize,
+		executor:        executor,
+		workerID:        opts.WorkerID,
+		pollingInterval: opts.PollingInterval,
+		batchSize:       opts.BatchSize,
+		batchSizes:      newBatchSizes(opts.BatchSize, opts.TypeBatchSizes, opts.QueueBatchSizes),
+		queues:          opts.Queues,
+		minFreeConns:    opts.MinFreeConns,
+		claimWindow:     opts.ClaimWindow,
+		lookahead:       opts.Lookahead,
+		payloadBudget:   opts.PayloadBudget,
+		taskTimeout:     opts.TaskTimeout,
+		typeTimeouts:    opts.TypeTimeouts,
+		metricTypes:     metrics.NewLabelAllowlist(opts.MetricTaskTypes),
+		notifier:        notifier,
+		outbox:          newNotifyOutbox(db, notifier, opts.WorkerID, opts.NotifyMaxAttempts, opts.NotifyRetryBase),
+		retryToBack:     opts.RetryToBack,
+		retryBaseDelay:  opts.RetryBaseDelay,
+		retryMaxDelay:   opts.RetryMaxDelay,
+		typeQuota:       opts.TypeQuota,
+		fleetLimits:     opts.FleetLimits,
+		catchUp:         newCatchUpThrottle(opts.CatchUpOverdue, opts.CatchUpRate, opts.CatchUpInterval),
+		adaptive:        newAdaptiveInterval(opts.PollingInterval, opts.MinPollInterval, opts.MaxPollInterval),
+		dbBackoffMax:    opts.DBBackoffMax,
 	}
 }
 
 // Start запускает основной polling loop worker'а.
 // Worker периодически (каждые pollingInterval) опрашивает БД на наличие заданий к выполнению.
+// С адаптивным интервалом пауза между опросами зависит от заполненности предыдущего батча (см. adaptiveInterval).
+// Пока опросы завершаются ошибками БД, пауза между ними растет до dbBackoffMax (см. backoff).
 // Использует FOR UPDATE SKIP LOCKED для безопасного конкурентного доступа нескольких worker'ов.
 // Параметры:
 //   - ctx: контекст для остановки worker'а при завершении работы приложения
 func (w *Worker) Start(ctx context.Context) {
-	ticker := time.NewTicker(w.pollingInterval)
+	interval := w.pollingInterval
+	if w.adaptive != nil {
+		interval = w.adaptive.current
+		log.Printf("[Worker %s] Adaptive polling between %v and %v", w.workerID, w.adaptive.min, w.adaptive.max)
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	log.Printf("[Worker %s] Started with polling interval %v, batch size %d", w.workerID, w.pollingInterval, w.batchSize)
+	log.Printf("[Worker %s] Started with polling interval %v, batch size %d, queues %v", w.workerID, interval, w.batchSize, w.queueNames())
+
+	if w.outbox != nil {
+		go w.outbox.run(ctx)
+	}
+
+	// Ошибки БД подряд: пока они идут, пауза между опросами растет (см. backoff)
+	var dbFailures int
+	var downSince time.Time
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Printf("[Worker %s] Shutting down...", w.workerID)
+			// Уведомления о последних завершенных заданиях доставляются с собственным таймаутом
+			w.notifier.Wait()
+			if w.outbox != nil {
+				w.outbox.wait()
+			}
 			return
 		case <-ticker.C:
-			w.processBatch(ctx)
+			result := w.processBatch(ctx)
+			if result == pollError {
+				// При остановке запросы прерываются отменой ctx - это не недоступность БД
+				if ctx.Err() != nil {
+					continue
+				}
+				dbFailures++
+				pollErrors.Inc()
+				if dbFailures == 1 {
+					downSince = time.Now()
+					dbUnavailable.Set(1)
+					log.Printf("[Worker %s] DB unavailable, retrying polls with backoff up to %v", w.workerID, max(w.dbBackoffMax, interval))
+				}
+				if w.dbBackoffMax > 0 {
+					// 2*interval, 4*interval... не больше dbBackoffMax, но и не меньше обычного интервала
+					ticker.Reset(backoff(dbFailures+1, interval, max(w.dbBackoffMax, interval)))
+				}
+				continue
+			}
+			if dbFailures > 0 {
+				log.Printf("[Worker %s] DB available again after %d failed polls (%v)",
+					w.workerID, dbFailures, time.Since(downSince).Round(time.Second))
+				dbFailures = 0
+				dbUnavailable.Set(0)
+				ticker.Reset(interval)
+			}
+			if w.adaptive != nil {
+				if next := w.adaptive.next(result); next != interval {
+					interval = next
+					ticker.Reset(interval)
+				}
+			}
 		}
 	}
 }
@@ -70,42 +223,63 @@ func (w *Worker) Start(ctx context.Context) {
 // processBatch извлекает пакет заданий из БД и обрабатывает их.
 // Основные шаги:
 // 1. SELECT заданий с FOR UPDATE SKIP LOCKED (конкурентная безопасность)
-// 2. Атомарное обновление статуса на 'processing'
+// 2. Атомарное обновление статуса на 'processing' с фиксацией claimed_at
 // 3. Параллельное выполнение заданий в goroutines
 // 4. Обработка результатов и обновление статусов
-func (w *Worker) processBatch(ctx context.Context) {
+//
+// Возвращает заполненность батча для адаптивного интервала опроса или pollError при ошибке БД.
+func (w *Worker) processBatch(ctx context.Context) pollResult {
+	// Если пул исчерпан, BeginTx заблокируется в ожидании соединения и задержит
+	// запись результатов и Cleaner. Пропускаем опрос - задания подождут следующего тика.
+	if !w.poolHasCapacity() {
+		return pollEmpty
+	}
+
 	// Начинаем транзакцию для атомарного захвата заданий
 	tx, err := w.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("[Worker %s] Error starting transaction: %v", w.workerID, err)
-		return
+		return pollError
 	}
 	defer tx.Rollback()
 
-	// КРИТИЧНО: Используем FOR UPDATE SKIP LOCKED для избежания конфликтов между worker'ами
-	// SKIP LOCKED означает, что если строка уже заблокирована другим worker'ом, мы её пропускаем
-	// Это гарантирует, что одно и то же задание не попадет в разные worker'ы
-	query := `
-		SELECT id, execute_at, task_type, payload, status, attempts, max_attempts,
-		       error_message, created_at, updated_at, completed_at
-		FROM scheduled_tasks
-		WHERE status = 'pending'
-		  AND execute_at <= NOW()
-		ORDER BY execute_at ASC
-		LIMIT $1
-		FOR UPDATE SKIP LOCKED
-	`
+	// Лимиты на весь парк: типы с исчерпанным лимитом не выбираем, остальные ограничиваем остатком
+	fleetRemaining, saturated, err := w.fleetCapacity(ctx, tx)
+	if err != nil {
+		log.Printf("[Worker %s] Error checking fleet type limits: %v", w.workerID, err)
+		return pollError
+	}
+
+	// Догоняющее ограничение: просроченные задания типов с исчерпанным лимитом интервала не выбираем
+	now := time.Now()
+	var throttled []string
+	if w.catchUp != nil {
+		throttled = w.catchUp.exhausted(now)
+	}
 
-	rows, err := tx.QueryContext(ctx, query, w.batchSize)
+	query, args := w.claimQuery(saturated, throttled)
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
+		if isRowMovedConflict(err) {
+			log.Printf("[Worker %s] Claim conflicted with a concurrent status change, retrying on next poll", w.workerID)
+			return pollEmpty
+		}
 		log.Printf("[Worker %s] Error querying tasks: %v", w.workerID, err)
-		return
+		return pollError
 	}
 	defer rows.Close()
 
-	// Читаем задания и сразу обновляем их статус на 'processing'
+	// Читаем задания и сразу обновляем их статус на 'processing'.
+	// Батч ограничен не только batchSize, но и суммарным размером payload (payloadBudget):
+	// задания, не попавшие в бюджет, не захватываются и остаются 'pending' до следующего опроса
 	var tasks []*models.ScheduledTask
 	var taskIDs []int64
+	payloadBytes := 0
+	scanned := 0
+	fill := w.batchSizes.newFill()
+	budgetReached := false
+	fleetDeferred := 0
+	catchUpDeferred := 0
 
 	for rows.Next() {
 		task := &models.ScheduledTask{}
@@ -113,6 +287,7 @@ func (w *Worker) processBatch(ctx context.Context) {
 			&task.ID,
 			&task.ExecuteAt,
 			&task.TaskType,
+			&task.Queue,
 			&task.Payload,
 			&task.Status,
 			&task.Attempts,
@@ -121,33 +296,107 @@ func (w *Worker) processBatch(ctx context.Context) {
 			&task.CreatedAt,
 			&task.UpdatedAt,
 			&task.CompletedAt,
+			&task.ClaimedAt,
+			&task.Timeout,
+			&task.Notify,
+			&task.PayloadRef,
+			&task.CronExpression,
 		)
 		if err != nil {
 			log.Printf("[Worker %s] Error scanning task: %v", w.workerID, err)
 			continue
 		}
+		scanned++
+		fill.add(task.TaskType, task.Queue)
+
+		// Задания сверх остатка лимита типа не захватываем: строка разблокируется при коммите и останется 'pending'
+		if left, limited := fleetRemaining[task.TaskType]; limited {
+			if left == 0 {
+				fleetDeferred++
+				continue
+			}
+			fleetRemaining[task.TaskType] = left - 1
+		}
+
+		// Просроченное задание сверх лимита догоняющего выполнения тоже остается 'pending'
+		if w.catchUp != nil && w.catchUp.isOverdue(task.ExecuteAt, now) && !w.catchUp.take(task.TaskType, now) {
+			catchUpDeferred++
+			continue
+		}
 
 		tasks = append(tasks, task)
 		taskIDs = append(taskIDs, task.ID)
+
+		// Первое задание берется всегда, иначе задание с payload больше бюджета не выполнилось бы никогда
+		payloadBytes += len(task.Payload)
+		if w.payloadBudget > 0 && payloadBytes >= w.payloadBudget {
+			log.Printf("[Worker %s] Payload budget reached (%d bytes in %d tasks), claiming the rest on next poll",
+				w.workerID, payloadBytes, len(tasks))
+			budgetReached = true
+			break
+		}
 	}
 
 	if err := rows.Err(); err != nil {
+		if isRowMovedConflict(err) {
+			log.Printf("[Worker %s] Claim conflicted with a concurrent status change, retrying on next poll", w.workerID)
+			return pollEmpty
+		}
 		log.Printf("[Worker %s] Error iterating rows: %v", w.workerID, err)
-		return
+		return pollError
+	}
+	// Закрываем курсор до UPDATE в той же транзакции (при раннем выходе из цикла он еще открыт)
+	rows.Close()
+
+	if fleetDeferred > 0 {
+		log.Printf("[Worker %s] Fleet type limits reached, %d tasks left pending", w.workerID, fleetDeferred)
+	}
+	if catchUpDeferred > 0 {
+		log.Printf("[Worker %s] Catch-up throttle reached, %d overdue tasks left pending", w.workerID, catchUpDeferred)
+	}
+
+	// Заполненность батча - признак глубины очереди для адаптивного интервала опроса.
+	// С размерами по типам и очередям батч заполнен и тогда, когда свой размер набрала одна из групп
+	result := pollPartial
+	if scanned >= w.batchSizes.limit(w.batchSize) || fill.full || budgetReached {
+		result = pollFull
+	} else if scanned == 0 {
+		result = pollEmpty
 	}
 
 	if len(tasks) == 0 {
 		// Нет заданий для обработки
-		return
+		return result
 	}
 
 	log.Printf("[Worker %s] Found %d tasks to process", w.workerID, len(tasks))
 
+	// Опоздавшие задания с skip_if_late_seconds не выполняем: их результат уже бесполезен
+	skipped, err := w.skipLateTasks(ctx, tx, taskIDs)
+	if err != nil {
+		log.Printf("[Worker %s] Error skipping late tasks: %v", w.workerID, err)
+		return pollError
+	}
+	if len(skipped) > 0 {
+		tasks, taskIDs = withoutSkipped(tasks, skipped)
+		if len(tasks) == 0 {
+			if err := tx.Commit(); err != nil {
+				log.Printf("[Worker %s] Error committing transaction: %v", w.workerID, err)
+				return pollError
+			}
+			w.reportSkipped(skipped)
+			return result
+		}
+	}
+
 	// Атомарно обновляем статус всех захваченных заданий на 'processing'
-	// Это важно сделать в той же транзакции, чтобы гарантировать атомарность
+	// (прогресс прошлой попытки сбрасывается - новая попытка начинает с начала).
+	// Это важно сделать в той же транзакции, чтобы гарантировать атомарность.
+	// Условие status = 'pending' (строки уже заблокированы, оно заведомо выполнено) позволяет
+	// планировщику при секционировании по status обращаться только к секции активных заданий
 	// Формируем плейсхолдеры для IN clause
 	placeholders := make([]string, len(taskIDs))
-	args := make([]interface{}, len(taskIDs))
+	args = make([]interface{}, len(taskIDs))
 	for i, id := range taskIDs {
 		placeholders[i] = fmt.Sprintf("$%d", i+1)
 		args[i] = id
@@ -156,24 +405,236 @@ func (w *Worker) processBatch(ctx context.Context) {
 	updateQuery := fmt.Sprintf(`
 		UPDATE scheduled_tasks
 		SET status = 'processing',
-		    attempts = attempts + 1
-		WHERE id IN (%s)
+		    attempts = attempts + 1,
+		    claimed_at = NOW(),
+		    progress = NULL
+		WHERE id IN (%s) AND status = 'pending'
 	`, strings.Join(placeholders, ", "))
 
 	_, err = tx.ExecContext(ctx, updateQuery, args...)
 	if err != nil {
 		log.Printf("[Worker %s] Error updating task status: %v", w.workerID, err)
-		return
+		return pollError
+	}
+	// attempts в БД увеличен захватом: это номер текущей попытки, по нему пишется результат
+	for _, task := range tasks {
+		task.Attempts++
 	}
 
 	// Коммитим транзакцию - задания теперь принадлежат этому worker'у
 	if err := tx.Commit(); err != nil {
 		log.Printf("[Worker %s] Error committing transaction: %v", w.workerID, err)
-		return
+		return pollError
 	}
+	w.reportSkipped(skipped)
 
 	// Выполняем задания параллельно в goroutines
 	w.executeTasks(ctx, tasks)
+	return result
+}
+
+// skipLateTasks переводит в 'skipped' те из захватываемых заданий, у которых задан skip_if_late_seconds
+// и execute_at прошел больше чем skip_if_late_seconds назад (worker отстал от расписания).
+// Опоздание считается по NOW() базы данных, как и все сравнения времени в Cleaner'е.
+// Выполняется в транзакции захвата до перевода остальных заданий в 'processing', поэтому
+// пропущенное задание не тратит попытку. Возвращает id пропущенных заданий и их типы.
+func (w *Worker) skipLateTasks(ctx context.Context, tx *sql.Tx, taskIDs []int64) (map[int64]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE scheduled_tasks
+		SET status = 'skipped',
+		    completed_at = NOW(),
+		    error_message = 'skipped: execute_at missed by more than ' || skip_if_late_seconds || ' seconds'
+		WHERE id = ANY($1) AND status = 'pending'
+		  AND skip_if_late_seconds IS NOT NULL
+		  AND execute_at < NOW() - INTERVAL '1 second' * skip_if_late_seconds
+		RETURNING id, task_type
+	`, pq.Array(taskIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var skipped map[int64]string
+	for rows.Next() {
+		var id int64
+		var taskType string
+		if err := rows.Scan(&id, &taskType); err != nil {
+			return nil, err
+		}
+		if skipped == nil {
+			skipped = make(map[int64]string)
+		}
+		skipped[id] = taskType
+	}
+	return skipped, rows.Err()
+}
+
+// withoutSkipped убирает из батча пропущенные задания
+func withoutSkipped(tasks []*models.ScheduledTask, skipped map[int64]string) ([]*models.ScheduledTask, []int64) {
+	var kept []*models.ScheduledTask
+	var keptIDs []int64
+	for _, task := range tasks {
+		if _, ok := skipped[task.ID]; ok {
+			continue
+		}
+		kept = append(kept, task)
+		keptIDs = append(keptIDs, task.ID)
+	}
+	return kept, keptIDs
+}
+
+// reportSkipped пишет в лог и метрики задания, пропущенные из-за опоздания (после коммита захвата)
+func (w *Worker) reportSkipped(skipped map[int64]string) {
+	for id, taskType := range skipped {
+		tasksFinished.Inc(w.metricTypes.Value(taskType), "skipped")
+		log.Printf("[Worker %s] Task %d skipped: execute_at missed by more than its skip_if_late_seconds", w.workerID, id)
+	}
+}
+
+// claimQuery возвращает запрос захвата батча и его аргументы.
+// КРИТИЧНО: Используем FOR UPDATE SKIP LOCKED для избежания конфликтов между worker'ами
+// SKIP LOCKED означает, что если строка уже заблокирована другим worker'ом, мы её пропускаем
+// Это гарантирует, что одно и то же задание не попадет в разные worker'ы
+// Если worker обслуживает только часть очередей, забираем задания только из них.
+//
+// Если claimWindow больше batchSize, батч выбирается в случайном порядке среди claimWindow
+// ближайших по execute_at заданий. Иначе все worker'ы, опрашивающие БД одновременно, начинают
+// с одних и тех же строк в голове очереди и тратят время на пропуск заблокированных друг другом строк.
+// Строки окна выбираются без блокировки (OFFSET здесь не подходит: пропущенные им строки тоже блокируются),
+// блокируются только попавшие в батч; условие status = 'pending' перепроверяется после блокировки.
+//
+// Если задан lookahead, захватываются и задания, которые наступят в течение lookahead;
+// executeTasks дождется их execute_at, прежде чем выполнить.
+//
+// Если задан typeQuota, в батч попадает не больше typeQuota заданий одного типа: при перекосе очереди
+// (например, тысячи медленных email) батч разбавляется заданиями других типов, и они не ждут
+// следующего опроса. Типы считаются среди claimWindow ближайших заданий, а без окна -
+// среди batchSize*claimScanFactor ближайших; задания сверх квоты остаются 'pending'.
+//
+// Задания упорядочиваются по эффективному приоритету (priorityOrder): priority плюс старение за время
+// ожидания после execute_at. Сортировка по выражению не использует индекс, поэтому кандидаты всегда
+// выбираются по execute_at (индекс idx_pending_tasks) с ограничением окна, а приоритет и квота по типам
+// применяются только внутри окна: задание с высоким приоритетом, но далеко за окном, ждет, пока
+// ближайшие задания не будут разобраны.
+//
+// Если заданы размеры батча по типам и очередям (batchSizes), задания группируются по типу или очереди
+// с заданным размером, и из каждой группы берется не больше ее размера (остальные задания - не больше
+// batchSize); весь батч ограничен наибольшим из размеров. Группы считаются среди тех же ближайших заданий,
+// что и квота по типам.
+//
+// Задания типов из excludeTypes (исчерпан лимит на весь парк, см. fleetCapacity) не выбираются.
+// Из типов throttledTypes (исчерпан лимит догоняющего выполнения, см. catchUpThrottle) не выбираются
+// только просроченные задания - задания "на сейчас" этих типов захватываются как обычно.
+func (w *Worker) claimQuery(excludeTypes, throttledTypes []string) (string, []interface{}) {
+	limit := w.batchSizes.limit(w.batchSize)
+	args := []interface{}{limit}
+	queueFilter := ""
+	if len(w.queues) > 0 {
+		args = append(args, pq.Array(w.queues))
+		queueFilter = fmt.Sprintf("AND queue = ANY($%d)", len(args))
+	}
+	if len(excludeTypes) > 0 {
+		args = append(args, pq.Array(excludeTypes))
+		queueFilter += fmt.Sprintf(" AND NOT (task_type = ANY($%d))", len(args))
+	}
+	if len(throttledTypes) > 0 {
+		args = append(args, pq.Array(throttledTypes), w.catchUp.overdue.Milliseconds())
+		queueFilter += fmt.Sprintf(" AND NOT (task_type = ANY($%d) AND execute_at < NOW() - INTERVAL '1 millisecond' * $%d)",
+			len(args)-1, len(args))
+	}
+	dueFilter := "execute_at <= NOW()"
+	if w.lookahead > 0 {
+		args = append(args, w.lookahead.Milliseconds())
+		dueFilter = fmt.Sprintf("execute_at <= NOW() + INTERVAL '1 millisecond' * $%d", len(args))
+	}
+
+	window := w.claimWindow
+	order := "random()"
+	if window <= limit {
+		window = limit * claimScanFactor
+		order = priorityOrder
+	}
+	args = append(args, window)
+	windowArg := len(args)
+	// Ближайшие по execute_at задания берутся по индексу; сортировка по приоритету - только внутри окна
+	nearest := fmt.Sprintf(`
+					SELECT id, task_type, queue, execute_at, priority
+					FROM scheduled_tasks
+					WHERE status = 'pending'
+					  AND %s
+					  %s
+					ORDER BY execute_at
+					LIMIT $%d`, dueFilter, queueFilter, windowArg)
+	candidates := fmt.Sprintf(`
+			SELECT id
+			FROM (%s
+			) nearest`, nearest)
+
+	if w.typeQuota > 0 || w.batchSizes != nil {
+		// Номер задания внутри своего типа (группы) считается только среди ближайших window заданий,
+		// чтобы не сортировать всю очередь на каждом опросе
+		var ranks, filters []string
+		if w.batchSizes != nil {
+			var group, size string
+			args, group, size = w.batchSizes.sqlExprs(args)
+			ranks = append(ranks, fmt.Sprintf("ROW_NUMBER() OVER (PARTITION BY %s ORDER BY %s) AS group_rank, %s AS group_size",
+				group, priorityOrder, size))
+			filters = append(filters, "group_rank <= group_size")
+		}
+		if w.typeQuota > 0 {
+			args = append(args, w.typeQuota)
+			ranks = append(ranks, fmt.Sprintf("ROW_NUMBER() OVER (PARTITION BY task_type ORDER BY %s) AS type_rank", priorityOrder))
+			filters = append(filters, fmt.Sprintf("type_rank <= $%d", len(args)))
+		}
+		candidates = fmt.Sprintf(`
+			SELECT id
+			FROM (
+				SELECT id, %s
+				FROM (%s
+				) nearest
+			) ranked
+			WHERE %s`, strings.Join(ranks, ", "), nearest, strings.Join(filters, " AND "))
+	}
+
+	return fmt.Sprintf(`
+		SELECT id, execute_at, task_type, queue, payload, status, attempts, max_attempts,
+		       error_message, created_at, updated_at, completed_at, claimed_at, timeout_seconds, notify, payload_ref,
+		       cron_expression
+		FROM scheduled_tasks
+		WHERE id IN (%s
+		)
+		  AND status = 'pending'
+		ORDER BY %s
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, candidates, order), args
+}
+
+// poolHasCapacity проверяет, что в пуле соединений есть хотя бы minFreeConns свободных мест.
+// Пул без ограничения (MaxOpenConnections == 0) считается всегда свободным.
+func (w *Worker) poolHasCapacity() bool {
+	stats := w.db.Stats()
+	if stats.MaxOpenConnections == 0 || w.minFreeConns <= 0 {
+		return true
+	}
+
+	free := stats.MaxOpenConnections - stats.InUse
+	if free >= w.minFreeConns {
+		return true
+	}
+
+	claimsSkipped.Inc()
+	log.Printf("[Worker %s] DB pool exhausted (in use %d/%d, waited %v total), skipping poll",
+		w.workerID, stats.InUse, stats.MaxOpenConnections, stats.WaitDuration)
+	return false
+}
+
+// queueNames возвращает список обслуживаемых очередей для логов
+func (w *Worker) queueNames() string {
+	if len(w.queues) == 0 {
+		return "[all]"
+	}
+	return fmt.Sprintf("%v", w.queues)
 }
 
 // executeTasks выполняет задания параллельно в goroutines и обрабатывает результаты.
@@ -189,12 +650,35 @@ func (w *Worker) executeTasks(ctx context.Context, tasks []*models.ScheduledTask
 		go func(t *models.ScheduledTask) {
 			defer wg.Done()
 
-			// Создаем контекст с таймаутом для выполнения задания
-			taskCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			// Задание, захваченное заранее (lookahead), запускаем точно в срок.
+			// Если worker останавливается раньше, возвращаем задание в очередь
+			held := time.Now().Before(t.ExecuteAt)
+			if !waitUntilDue(ctx, t.ExecuteAt) {
+				w.releaseTask(t.ID)
+				return
+			}
+			// Пока задание ждало своего времени, его могли отменить через API
+			if held && !w.stillProcessing(ctx, t.ID) {
+				log.Printf("[Worker %s] Task %d was cancelled before its execute_at, skipping", w.workerID, t.ID)
+				return
+			}
+
+			// Создаем контекст с таймаутом для выполнения задания.
+			// Через контекст исполнитель может сообщать прогресс (ReportProgress)
+			progress := newProgressReporter(w.db, w.workerID, t.ID, t.Attempts)
+			taskCtx, cancel := context.WithTimeout(withProgressReporter(ctx, progress), w.timeoutFor(t))
 			defer cancel()
 
-			// Выполняем задание через Executor
+			// Выполняем задание через Executor; отложенный из-за частоты прогресс записываем до результата.
+			// Длительность - только выполнение, без ожидания execute_at и записи результата
+			started := time.Now()
 			result := w.executor.Execute(taskCtx, t)
+			result.Duration = time.Since(started)
+			progress.flush()
+			result.TaskType = t.TaskType
+			result.Attempt = t.Attempts
+			result.Notify = t.Notify
+			result.CronExpression = t.CronExpression.String
 			resultsChan <- result
 		}(task)
 	}
@@ -209,66 +693,471 @@ func (w *Worker) executeTasks(ctx context.Context, tasks []*models.ScheduledTask
 	}
 }
 
+// waitUntilDue ждет наступления executeAt. Возвращает false, если ctx отменен раньше
+func waitUntilDue(ctx context.Context, executeAt time.Time) bool {
+	delay := time.Until(executeAt)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// stillProcessing проверяет, что задание все еще в 'processing' (не отменено через API).
+// При ошибке БД считаем, что задание не отменено: результат все равно запишется только для 'processing'.
+func (w *Worker) stillProcessing(ctx context.Context, taskID int64) bool {
+	var status string
+	err := w.db.QueryRowContext(ctx, `SELECT status FROM scheduled_tasks WHERE id = $1`, taskID).Scan(&status)
+	if err != nil {
+		log.Printf("[Worker %s] Error checking status of task %d: %v", w.workerID, taskID, err)
+		return true
+	}
+	return status == "processing"
+}
+
+// releaseTask возвращает захваченное, но не начатое задание в 'pending', не расходуя попытку.
+// Контекст worker'а в этот момент уже отменен, поэтому запрос выполняется с собственным таймаутом.
+func (w *Worker) releaseTask(taskID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE scheduled_tasks
+		SET status = 'pending',
+		    attempts = attempts - 1
+		WHERE id = $1 AND status = 'processing'
+	`
+	if _, err := w.db.ExecContext(ctx, query, taskID); err != nil {
+		log.Printf("[Worker %s] Error releasing task %d: %v", w.workerID, taskID, err)
+		return
+	}
+	log.Printf("[Worker %s] Released task %d back to queue before its execute_at", w.workerID, taskID)
+}
+
+// timeoutFor возвращает таймаут выполнения задания.
+// Приоритет: timeout_seconds задания, затем таймаут типа (WORKER_TASK_TYPE_TIMEOUTS),
+// затем общий таймаут (WORKER_TASK_TIMEOUT).
+func (w *Worker) timeoutFor(task *models.ScheduledTask) time.Duration {
+	if task.Timeout.Valid && task.Timeout.Int32 > 0 {
+		return time.Duration(task.Timeout.Int32) * time.Second
+	}
+	if timeout, ok := w.typeTimeouts[task.TaskType]; ok {
+		return timeout
+	}
+	return w.taskTimeout
+}
+
 // handleTaskResult обрабатывает результат выполнения задания и обновляет его статус в БД.
-// Если выполнение успешно - статус 'completed'
-// Если ошибка и не исчерпаны попытки - статус 'pending' (для retry)
-// Если ошибка и исчерпаны попытки - статус 'failed'
+// Если выполнение успешно - статус 'completed', а повторяющееся задание (interval или cron_expression)
+// с незакончившейся серией возвращается в 'pending' со следующим execute_at и обнуленными attempts
+// Если ошибка и не исчерпаны попытки - статус 'pending' (для retry) с execute_at через паузу backoff
+// (или через Retry-After получателя)
+// Если ошибка и исчерпаны попытки или ошибка постоянная (Permanent, например некорректный payload) - статус 'failed'
+// Если тип задания неизвестен и включен карантин (Quarantine) - статус 'pending' без траты попытки (см. quarantineTask)
+// Если исчерпан лимит запросов к хосту (Deferred) - статус 'pending' без траты попытки (см. deferTask)
+// Все записи условны: задание должно быть в 'processing' с той же попыткой (attempts = result.Attempt).
+// Если Cleaner счел выполнение зависшим и задание уже захвачено заново, каждый захват увеличил attempts,
+// и поздний результат старого выполнения не перезапишет состояние новой попытки.
+// Обращения к БД повторяются при временных ошибках (см. withRetry), чтобы задание
+// не оставалось в 'processing' до срабатывания Cleaner'а.
 func (w *Worker) handleTaskResult(ctx context.Context, result models.TaskResult) {
+	if result.Quarantine {
+		w.quarantineTask(ctx, result)
+		return
+	}
+	if result.Deferred {
+		w.deferTask(ctx, result)
+		return
+	}
+
 	if result.Success {
-		// Задание выполнено успешно
-		query := `
+		// Задание выполнено успешно. Повторяющееся задание (interval_ms или cron_expression) вместо 'completed'
+		// возвращается в 'pending' со следующим execute_at (см. nextRunQuery)
+		cronNext, err := cronNextRun(result.CronExpression, time.Now())
+		if err != nil {
+			log.Printf("[Worker %s] WARNING: task %d has invalid cron_expression %q, completing without next run: %v",
+				w.workerID, result.TaskID, result.CronExpression, err)
+		}
+		update := `
 			UPDATE scheduled_tasks
-			SET status = 'completed',
-			    completed_at = NOW(),
-			    error_message = $2
-			WHERE id = $1
+			SET status = CASE WHEN next_run.next_at IS NULL THEN 'completed' ELSE 'pending' END,
+			    completed_at = CASE WHEN next_run.next_at IS NULL THEN NOW() END,
+			    execute_at = COALESCE(next_run.next_at, scheduled_tasks.execute_at),
+			    attempts = CASE WHEN next_run.next_at IS NULL THEN scheduled_tasks.attempts ELSE 0 END,
+			    executions = scheduled_tasks.executions + 1,
+			    error_message = $2,
+			    failure_reason = NULL,
+			    result = $3,
+			    warning = NULLIF($4, ''),
+			    duration_ms = $6,
+			    response_content_type = NULLIF($7, ''),
+			    response_body_base64 = NULLIF($8, '')
+			FROM (` + nextRunQuery + `) next_run
+			WHERE id = next_run.run_id AND status = 'processing' AND attempts = $5
+			RETURNING id, status, execute_at, notify, on_success, on_failure
 		`
-		_, err := w.db.ExecContext(ctx, query, result.TaskID, result.ErrorMessage)
+		query, args := w.finishQuery(update, `SELECT status, execute_at, (SELECT id FROM chained) FROM done`, result, "completed",
+			result.TaskID, result.ErrorMessage, nullableJSON(result.Result), result.Warning, result.Attempt, result.Duration.Milliseconds(),
+			result.ContentType, result.BodyBase64, cronNext)
+		var status string
+		var nextAt time.Time
+		var chainedID sql.NullInt64
+		err = w.withRetry(ctx, result.TaskID, func() error {
+			return w.db.QueryRowContext(ctx, query, args...).Scan(&status, &nextAt, &chainedID)
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			w.logDiscardedResult(result.TaskID)
+			return
+		}
 		if err != nil {
 			log.Printf("[Worker %s] Error updating completed task %d: %v", w.workerID, result.TaskID, err)
 			return
 		}
-		log.Printf("[Worker %s] Task %d completed successfully", w.workerID, result.TaskID)
+		tasksFinished.Inc(w.metricTypes.Value(result.TaskType), "completed")
+		if status == "pending" {
+			// Уведомления отправляются, когда серия завершена, а не после каждого выполнения
+			log.Printf("[Worker %s] Recurring task %d completed, next run at %s", w.workerID, result.TaskID, nextAt.UTC().Format(time.RFC3339))
+			return
+		}
+		w.notifyFinished(result, "completed")
+		w.logChained(result.TaskID, chainedID, "on_success")
+		if result.Warning != "" {
+			log.Printf("[Worker %s] Task %d completed with warning: %s", w.workerID, result.TaskID, result.Warning)
+		} else {
+			log.Printf("[Worker %s] Task %d completed successfully", w.workerID, result.TaskID)
+		}
 	} else {
 		// Задание завершилось с ошибкой
 		// Проверяем, можно ли повторить попытку
 		var attempts, maxAttempts int
-		checkQuery := `SELECT attempts, max_attempts FROM scheduled_tasks WHERE id = $1`
-		err := w.db.QueryRowContext(ctx, checkQuery, result.TaskID).Scan(&attempts, &maxAttempts)
+		checkQuery := `SELECT attempts, max_attempts FROM scheduled_tasks WHERE id = $1 AND status = 'processing' AND attempts = $2`
+		err := w.withRetry(ctx, result.TaskID, func() error {
+			return w.db.QueryRowContext(ctx, checkQuery, result.TaskID, result.Attempt).Scan(&attempts, &maxAttempts)
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			w.logDiscardedResult(result.TaskID)
+			return
+		}
 		if err != nil {
 			log.Printf("[Worker %s] Error checking attempts for task %d: %v", w.workerID, result.TaskID, err)
 			return
 		}
 
-		if attempts >= maxAttempts {
-			// Исчерпаны попытки - помечаем как failed
-			query := `
+		if attempts >= maxAttempts || result.Permanent {
+			// Исчерпаны попытки или повтор бесполезен - помечаем как failed
+			update := `
 				UPDATE scheduled_tasks
 				SET status = 'failed',
 				    error_message = $2,
-				    completed_at = NOW()
-				WHERE id = $1
+				    completed_at = NOW(),
+				    result = $3,
+				    failure_reason = $5,
+				    duration_ms = $6,
+				    response_content_type = NULLIF($7, ''),
+				    response_body_base64 = NULLIF($8, '')
+				WHERE id = $1 AND status = 'processing' AND attempts = $4
+				RETURNING id, status, notify, on_success, on_failure
 			`
-			_, err := w.db.ExecContext(ctx, query, result.TaskID, result.ErrorMessage)
+			query, args := w.finishQuery(update, `SELECT (SELECT id FROM chained) FROM done`, result, "failed",
+				result.TaskID, result.ErrorMessage, nullableJSON(result.Result), result.Attempt, result.FailureReason, result.Duration.Milliseconds(),
+				result.ContentType, result.BodyBase64)
+			var chainedID sql.NullInt64
+			err := w.withRetry(ctx, result.TaskID, func() error {
+				return w.db.QueryRowContext(ctx, query, args...).Scan(&chainedID)
+			})
+			if errors.Is(err, sql.ErrNoRows) {
+				w.logDiscardedResult(result.TaskID)
+				return
+			}
 			if err != nil {
 				log.Printf("[Worker %s] Error updating failed task %d: %v", w.workerID, result.TaskID, err)
 				return
 			}
-			log.Printf("[Worker %s] Task %d failed (max attempts reached): %s", w.workerID, result.TaskID, result.ErrorMessage)
+			tasksFinished.Inc(w.metricTypes.Value(result.TaskType), "failed")
+			taskFailures.Inc(w.metricTypes.Value(result.TaskType), result.FailureReason)
+			w.notifyFinished(result, "failed")
+			w.logChained(result.TaskID, chainedID, "on_failure")
+			if result.Permanent && attempts < maxAttempts {
+				log.Printf("[Worker %s] Task %d failed (non-retryable, attempt %d/%d): %s", w.workerID, result.TaskID, attempts, maxAttempts, result.ErrorMessage)
+			} else {
+				log.Printf("[Worker %s] Task %d failed (max attempts reached): %s", w.workerID, result.TaskID, result.ErrorMessage)
+			}
 		} else {
-			// Еще есть попытки - возвращаем в pending для retry
+			// Еще есть попытки - возвращаем в pending для retry.
+			// Следующая попытка откладывается на время из Retry-After получателя, а без него - на паузу,
+			// которая удваивается с каждой попыткой (backoff). Без паузы (WORKER_RETRY_BASE_DELAY=0)
+			// с retryToBack задание встает в конец очереди (execute_at = NOW()) и не обгоняет
+			// задания, которые ждут дольше него; иначе сохраняет прежний execute_at.
+			delay := result.RetryAfter
+			if delay <= 0 {
+				delay = retryDelay(attempts, w.retryBaseDelay, w.retryMaxDelay)
+			}
 			query := `
 				UPDATE scheduled_tasks
 				SET status = 'pending',
-				    error_message = $2
-				WHERE id = $1
+				    error_message = $2,
+				    execute_at = CASE
+				        WHEN $3::bigint > 0 THEN NOW() + INTERVAL '1 millisecond' * $3::bigint
+				        WHEN $5::boolean THEN NOW()
+				        ELSE execute_at
+				    END,
+				    result = $4,
+				    failure_reason = $7,
+				    duration_ms = $8,
+				    response_content_type = NULLIF($9, ''),
+				    response_body_base64 = NULLIF($10, '')
+				WHERE id = $1 AND status = 'processing' AND attempts = $6
 			`
-			_, err := w.db.ExecContext(ctx, query, result.TaskID, result.ErrorMessage)
+			updated, err := w.finishTask(ctx, result.TaskID, query, result.TaskID, result.ErrorMessage, delay.Milliseconds(), nullableJSON(result.Result), w.retryToBack, result.Attempt, result.FailureReason, result.Duration.Milliseconds(),
+				result.ContentType, result.BodyBase64)
 			if err != nil {
 				log.Printf("[Worker %s] Error updating task %d for retry: %v", w.workerID, result.TaskID, err)
 				return
 			}
-			log.Printf("[Worker %s] Task %d failed (attempt %d/%d), will retry: %s", w.workerID, result.TaskID, attempts, maxAttempts, result.ErrorMessage)
+			if !updated {
+				w.logDiscardedResult(result.TaskID)
+				return
+			}
+			tasksFinished.Inc(w.metricTypes.Value(result.TaskType), "retry")
+			taskFailures.Inc(w.metricTypes.Value(result.TaskType), result.FailureReason)
+			if result.RetryAfter > 0 {
+				log.Printf("[Worker %s] Task %d failed (attempt %d/%d), will retry in %v (Retry-After): %s", w.workerID, result.TaskID, attempts, maxAttempts, result.RetryAfter, result.ErrorMessage)
+			} else if delay > 0 {
+				log.Printf("[Worker %s] Task %d failed (attempt %d/%d), will retry in %v: %s", w.workerID, result.TaskID, attempts, maxAttempts, delay, result.ErrorMessage)
+			} else {
+				log.Printf("[Worker %s] Task %d failed (attempt %d/%d), will retry: %s", w.workerID, result.TaskID, attempts, maxAttempts, result.ErrorMessage)
+			}
+		}
+	}
+}
+
+// quarantineTask возвращает задание неизвестного типа в 'pending', не расходуя попытку, и откладывает его
+// на quarantineDelay. Неизвестный тип обычно означает, что этот worker запущен со старым кодом,
+// поэтому задание дожидается worker'а с новым кодом, а не завершается ошибкой.
+func (w *Worker) quarantineTask(ctx context.Context, result models.TaskResult) {
+	query := `
+		UPDATE scheduled_tasks
+		SET status = 'pending',
+		    attempts = attempts - 1,
+		    error_message = $2,
+		    execute_at = NOW() + INTERVAL '1 millisecond' * $3::bigint
+		WHERE id = $1 AND status = 'processing' AND attempts = $4
+	`
+	updated, err := w.finishTask(ctx, result.TaskID, query, result.TaskID, result.ErrorMessage, quarantineDelay.Milliseconds(), result.Attempt)
+	if err != nil {
+		log.Printf("[Worker %s] Error quarantining task %d: %v", w.workerID, result.TaskID, err)
+		return
+	}
+	if !updated {
+		w.logDiscardedResult(result.TaskID)
+		return
+	}
+	tasksFinished.Inc(w.metricTypes.Value(result.TaskType), "quarantined")
+	log.Printf("[Worker %s] WARNING: task %d has task type %q unknown to this worker, returned to queue for %v without using an attempt; is this worker running outdated code?",
+		w.workerID, result.TaskID, result.TaskType, quarantineDelay)
+}
+
+// deferTask возвращает в 'pending' задание, которое не выполнялось: к его хосту уже идет максимум
+// одновременных запросов. Попытка не расходуется, error_message и result прошлой попытки не меняются,
+// задание откладывается на hostDeferDelay, чтобы следующий опрос не захватил его сразу же.
+func (w *Worker) deferTask(ctx context.Context, result models.TaskResult) {
+	query := `
+		UPDATE scheduled_tasks
+		SET status = 'pending',
+		    attempts = attempts - 1,
+		    execute_at = GREATEST(execute_at, NOW() + INTERVAL '1 millisecond' * $2::bigint)
+		WHERE id = $1 AND status = 'processing' AND attempts = $3
+	`
+	updated, err := w.finishTask(ctx, result.TaskID, query, result.TaskID, hostDeferDelay.Milliseconds(), result.Attempt)
+	if err != nil {
+		log.Printf("[Worker %s] Error deferring task %d: %v", w.workerID, result.TaskID, err)
+		return
+	}
+	if !updated {
+		w.logDiscardedResult(result.TaskID)
+		return
+	}
+	tasksFinished.Inc(w.metricTypes.Value(result.TaskType), "deferred")
+	log.Printf("[Worker %s] Task %d deferred for %v: %s", w.workerID, result.TaskID, hostDeferDelay, result.ErrorMessage)
+}
+
+// nextRunQuery возвращает (run_id, next_at) задания $1: время следующего выполнения повторяющегося задания
+// или NULL, если задание не повторяющееся или серия закончилась.
+// Следующее выполнение - execute_at + interval_ms (без дрейфа от длительности выполнения). Если worker'ы стояли
+// и этот момент уже прошел, пропущенные выполнения не догоняются пачкой: берется первый момент сетки после NOW().
+// С align момент привязывается к ближайшей границе минуты, часа или суток, отсчитанной от Unix epoch (то есть в UTC);
+// если эта граница не позже NOW(), берется следующая.
+// Задание с cron_expression выполняется в момент $9 - следующий момент расписания, вычисленный worker'ом
+// (см. cronNextRun); NULL - серия закончилась.
+// Серия заканчивается, когда выполнений станет max_executions или следующее выполнение окажется позже end_at.
+const nextRunQuery = `
+	SELECT id AS run_id, CASE WHEN run_at > end_at THEN NULL ELSE run_at END AS next_at
+	FROM (
+		SELECT id, end_at,
+		       CASE WHEN snapped IS NULL THEN raw_at
+		            WHEN snapped <= NOW() THEN snapped + INTERVAL '1 second' * align_seconds
+		            ELSE snapped
+		       END AS run_at
+		FROM (
+			SELECT id, end_at, raw_at, align_seconds,
+			       to_timestamp(FLOOR(EXTRACT(EPOCH FROM raw_at) / align_seconds + 0.5) * align_seconds) AS snapped
+			FROM (
+				SELECT id, end_at,
+				       CASE align WHEN 'minute' THEN 60 WHEN 'hour' THEN 3600 WHEN 'day' THEN 86400 END AS align_seconds,
+				       CASE WHEN executions + 1 >= max_executions THEN NULL
+				            WHEN cron_expression IS NOT NULL THEN $9::timestamptz
+				            WHEN interval_ms IS NULL THEN NULL
+				            ELSE execute_at + INTERVAL '1 millisecond' * interval_ms *
+				                 GREATEST(1, FLOOR(EXTRACT(EPOCH FROM NOW() - execute_at) * 1000 / interval_ms) + 1)
+				       END AS raw_at
+				FROM scheduled_tasks
+				WHERE id = $1
+			) raw
+		) aligned
+	) runs`
+
+// chainedTaskQuery - CTE chained итогового запроса (см. finishQuery): создает задание из on_success
+// задания, перешедшего в completed, или из on_failure - перешедшего в failed. Новое задание получает
+// parent_id, execute_at = NOW() + delay_seconds и вложенные on_success / on_failure, так цепочка продолжается.
+// Описание проверено и дополнено значениями по умолчанию в API; COALESCE - для записей, созданных в обход API
+const chainedTaskQuery = `
+	chained AS (
+		INSERT INTO scheduled_tasks (execute_at, task_type, queue, payload, max_attempts, timeout_seconds, notify,
+		                             parent_id, on_success, on_failure)
+		SELECT NOW() + INTERVAL '1 second' * COALESCE((spec->>'delay_seconds')::int, 0),
+		       spec->>'task_type', COALESCE(spec->>'queue', 'default'), spec->'payload',
+		       COALESCE((spec->>'max_attempts')::int, 3), NULLIF((spec->>'timeout_seconds')::int, 0), spec->'notify',
+		       parent_id, spec->'on_success', spec->'on_failure'
+		FROM (
+			SELECT id AS parent_id,
+			       CASE status WHEN 'completed' THEN on_success WHEN 'failed' THEN on_failure END AS spec
+			FROM done
+		) parent
+		WHERE jsonb_typeof(spec) = 'object'
+		RETURNING id
+	)`
+
+// finishQuery оформляет итоговый UPDATE задания (с RETURNING id, status, notify, on_success, on_failure)
+// как CTE done, к которому обращается запрос final. Тем же запросом создается следующее задание цепочки
+// (CTE chained, см. chainedTaskQuery), а с outbox - в notification_outbox добавляется по записи на каждый
+// канал notify задания, если оно перешло в completed или failed: статус, задание цепочки и уведомления
+// фиксируются атомарно. Событие уведомления передается последним параметром после args.
+func (w *Worker) finishQuery(update, final string, result models.TaskResult, status string, args ...interface{}) (string, []interface{}) {
+	withoutOutbox := `WITH done AS (` + update + `),` + chainedTaskQuery + "\n" + final
+	if w.outbox == nil || len(result.Notify) == 0 {
+		return withoutOutbox, args
+	}
+
+	event, err := json.Marshal(newNotifyEvent(result, status))
+	if err != nil {
+		log.Printf("[Worker %s] Task %d: cannot encode notification: %v", w.workerID, result.TaskID, err)
+		return withoutOutbox, args
+	}
+	args = append(args, string(event))
+	query := fmt.Sprintf(`
+		WITH done AS (%s),%s,
+		outbox AS (
+			INSERT INTO notification_outbox (task_id, channel_type, target, event)
+			SELECT done.id, ch->>'type', ch->>'target', $%d::jsonb
+			FROM done, jsonb_array_elements(CASE WHEN jsonb_typeof(done.notify) = 'array' THEN done.notify ELSE '[]' END) ch
+			WHERE done.status IN ('completed', 'failed')
+		)
+		%s`, update, chainedTaskQuery, len(args), final)
+	return query, args
+}
+
+// logChained сообщает о задании, созданном из on_success / on_failure (hook) задания taskID
+func (w *Worker) logChained(taskID int64, chainedID sql.NullInt64, hook string) {
+	if chainedID.Valid {
+		log.Printf("[Worker %s] Task %d: %s scheduled task %d", w.workerID, taskID, hook, chainedID.Int64)
+	}
+}
+
+// notifyFinished отправляет уведомления о задании, итоговый статус которого записан в БД:
+// с outbox они уже добавлены запросом finishQuery, и отправку достаточно разбудить
+func (w *Worker) notifyFinished(result models.TaskResult, status string) {
+	if w.outbox == nil {
+		w.notifier.Notify(result, status)
+		return
+	}
+	if len(result.Notify) > 0 {
+		w.outbox.notifyNow()
+	}
+}
+
+// finishTask выполняет запись результата задания (UPDATE ... WHERE id = $1 AND status = 'processing')
+// с повторами при ошибках БД. Возвращает false, если задание уже не в 'processing' -
+// например, его отменили через API, пока оно выполнялось.
+// Конфликт с переносом строки в другую секцию (isRowMovedConflict) тоже повторяется:
+// повторный UPDATE уже не найдет задание в 'processing'.
+func (w *Worker) finishTask(ctx context.Context, taskID int64, query string, args ...interface{}) (bool, error) {
+	var affected int64
+	err := w.withRetry(ctx, taskID, func() error {
+		res, err := w.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		affected, err = res.RowsAffected()
+		return err
+	})
+	return affected > 0, err
+}
+
+// rowMovedSQLState - код serialization_failure. При секционировании scheduled_tasks по status
+// (sql/partitioning) его возвращает блокировка строки, которую параллельная транзакция
+// перенесла в другую секцию, сменив статус (например, отмена задания)
+const rowMovedSQLState = "40001"
+
+// isRowMovedConflict проверяет, что ошибка - конфликт с параллельной сменой статуса строки
+// секционированной таблицы. Такая ошибка не означает проблем с БД: строку достаточно перечитать.
+func isRowMovedConflict(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == rowMovedSQLState
+}
+
+// logDiscardedResult сообщает, что результат не записан: задание больше не в 'processing'
+// (отменено через API во время выполнения) или его попытка уже не текущая (Cleaner счел выполнение
+// зависшим и задание захвачено заново). Статус, выставленный отменой или новой попыткой, не перезаписывается.
+func (w *Worker) logDiscardedResult(taskID int64) {
+	log.Printf("[Worker %s] Task %d is no longer processing by this attempt (cancelled or reclaimed while running), result discarded", w.workerID, taskID)
+}
+
+// nullableJSON возвращает значение для JSONB колонки: NULL, если результата нет
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+// withRetry выполняет обращение к БД с ограниченным числом повторов и экспоненциальной паузой.
+// Используется для записи результатов: кратковременный сбой БД не должен оставлять задание
+// в статусе 'processing'. sql.ErrNoRows не повторяется - это не временная ошибка.
+// Возвращает последнюю ошибку, если все попытки исчерпаны или контекст отменен.
+func (w *Worker) withRetry(ctx context.Context, taskID int64, op func() error) error {
+	delay := resultWriteBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || errors.Is(err, sql.ErrNoRows) || attempt >= resultWriteAttempts {
+			return err
+		}
+
+		log.Printf("[Worker %s] DB write for task %d failed (attempt %d/%d), retrying in %v: %v",
+			w.workerID, taskID, attempt, resultWriteAttempts, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
 		}
+		delay *= 2
 	}
 }
@@ -0,0 +1,746 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"at-worker/metrics"
+	"at-worker/models"
+
+	"github.com/lib/pq"
+)
+
+// TestTimeoutFor проверяет приоритет таймаутов: задание, затем тип, затем общий
+func TestTimeoutFor(t *testing.T) {
+	w := NewWorker(nil, nil, Options{
+		TaskTimeout:  5 * time.Minute,
+		TypeTimeouts: map[string]time.Duration{"email": time.Minute},
+	})
+
+	testCases := []struct {
+		name string
+		task models.ScheduledTask
+		want time.Duration
+	}{
+		{"global default", models.ScheduledTask{TaskType: "sql"}, 5 * time.Minute},
+		{"type default", models.ScheduledTask{TaskType: "email"}, time.Minute},
+		{"task override", models.ScheduledTask{TaskType: "email", Timeout: sql.NullInt32{Int32: 10, Valid: true}}, 10 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := w.timeoutFor(&tc.task); got != tc.want {
+			t.Errorf("%s: got=%v, want=%v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestClaimQuery проверяет выбор запроса захвата: по порядку или случайно внутри окна
+func TestClaimQuery(t *testing.T) {
+	testCases := []struct {
+		name       string
+		opts       Options
+		wantRandom bool
+		wantArgs   int
+	}{
+		{"no window", Options{BatchSize: 10}, false, 2},
+		{"window not larger than batch", Options{BatchSize: 10, ClaimWindow: 10}, false, 2},
+		{"window", Options{BatchSize: 10, ClaimWindow: 100}, true, 2},
+		{"window with queues", Options{BatchSize: 10, ClaimWindow: 100, Queues: []string{"a"}}, true, 3},
+		{"lookahead", Options{BatchSize: 10, Lookahead: 500 * time.Millisecond}, false, 3},
+		{"window with lookahead", Options{BatchSize: 10, ClaimWindow: 100, Lookahead: time.Second}, true, 3},
+		{"type quota", Options{BatchSize: 10, TypeQuota: 3}, false, 3},
+		{"type quota with window", Options{BatchSize: 10, ClaimWindow: 100, TypeQuota: 3}, true, 3},
+	}
+
+	for _, tc := range testCases {
+		query, args := NewWorker(nil, nil, tc.opts).claimQuery(nil, nil)
+		if got := strings.Contains(query, "random()"); got != tc.wantRandom {
+			t.Errorf("%s: random order got=%v, want=%v", tc.name, got, tc.wantRandom)
+		}
+		if len(args) != tc.wantArgs {
+			t.Errorf("%s: args got=%d, want=%d", tc.name, len(args), tc.wantArgs)
+		}
+		if tc.wantRandom && tc.opts.TypeQuota == 0 && args[len(args)-1] != tc.opts.ClaimWindow {
+			t.Errorf("%s: last arg got=%v, want window %d", tc.name, args[len(args)-1], tc.opts.ClaimWindow)
+		}
+		if got := strings.Contains(query, "PARTITION BY task_type"); got != (tc.opts.TypeQuota > 0) {
+			t.Errorf("%s: type quota got=%v, want=%v", tc.name, got, tc.opts.TypeQuota > 0)
+		}
+		if tc.opts.TypeQuota > 0 && args[len(args)-1] != tc.opts.TypeQuota {
+			t.Errorf("%s: last arg got=%v, want quota %d", tc.name, args[len(args)-1], tc.opts.TypeQuota)
+		}
+		if len(tc.opts.Queues) > 0 && !strings.Contains(query, "queue = ANY($2)") {
+			t.Errorf("%s: queue filter must use $2", tc.name)
+		}
+		if !tc.wantRandom && !strings.Contains(query, "ORDER BY "+priorityOrder+"\n") {
+			t.Errorf("%s: claim must be ordered by effective priority, got query: %s", tc.name, query)
+		}
+		if got := strings.Contains(query, "INTERVAL '1 millisecond'"); got != (tc.opts.Lookahead > 0) {
+			t.Errorf("%s: lookahead filter got=%v, want=%v", tc.name, got, tc.opts.Lookahead > 0)
+		}
+	}
+}
+
+// TestClaimQueryIndexedCandidates проверяет, что кандидаты выбираются по execute_at (по индексу) с ограничением
+// окна, а сортировка по эффективному приоритету применяется только внутри окна
+func TestClaimQueryIndexedCandidates(t *testing.T) {
+	nearest := regexp.MustCompile(`(?s)FROM scheduled_tasks\s+WHERE status = 'pending'(.*?)\) nearest`)
+	windowOrder := regexp.MustCompile(`ORDER BY execute_at\s+LIMIT \$2\s*$`)
+	testCases := []struct {
+		name       string
+		opts       Options
+		wantWindow int
+	}{
+		{"no window", Options{BatchSize: 10}, 10 * claimScanFactor},
+		{"window", Options{BatchSize: 10, ClaimWindow: 100}, 100},
+		{"type quota", Options{BatchSize: 10, TypeQuota: 3}, 10 * claimScanFactor},
+		{"batch sizes", Options{BatchSize: 10, TypeBatchSizes: map[string]int{"email": 2}}, 10 * claimScanFactor},
+	}
+
+	for _, tc := range testCases {
+		query, args := NewWorker(nil, nil, tc.opts).claimQuery(nil, nil)
+		match := nearest.FindStringSubmatch(query)
+		if match == nil {
+			t.Fatalf("%s: no window subquery in query: %s", tc.name, query)
+		}
+		if !windowOrder.MatchString(match[1]) {
+			t.Errorf("%s: candidates must be ordered by execute_at and limited by $2, got: %s", tc.name, match[1])
+		}
+		if strings.Contains(match[1], priorityOrder) {
+			t.Errorf("%s: effective priority must not be sorted before the window, got: %s", tc.name, match[1])
+		}
+		if args[1] != tc.wantWindow {
+			t.Errorf("%s: window got=%v, want=%d", tc.name, args[1], tc.wantWindow)
+		}
+	}
+}
+
+// TestClaimQueryExcludeTypes проверяет исключение типов с исчерпанным лимитом на весь парк
+func TestClaimQueryExcludeTypes(t *testing.T) {
+	w := NewWorker(nil, nil, Options{BatchSize: 10, Queues: []string{"a"}, TypeQuota: 3})
+
+	query, args := w.claimQuery([]string{"email"}, nil)
+	if !strings.Contains(query, "queue = ANY($2) AND NOT (task_type = ANY($3))") {
+		t.Errorf("Expected saturated types to be excluded with $3, got query: %s", query)
+	}
+	if len(args) != 5 || args[len(args)-1] != 3 {
+		t.Errorf("Expected 5 args ending with the quota, got %v", args)
+	}
+
+	if query, _ := w.claimQuery(nil, nil); strings.Contains(query, "task_type = ANY") {
+		t.Error("No types must be excluded without saturated limits")
+	}
+}
+
+// TestClaimQueryCatchUpThrottle проверяет, что для типов с исчерпанным догоняющим лимитом
+// исключаются только просроченные задания
+func TestClaimQueryCatchUpThrottle(t *testing.T) {
+	w := NewWorker(nil, nil, Options{BatchSize: 10, CatchUpOverdue: time.Hour, CatchUpRate: 5, CatchUpInterval: time.Minute})
+
+	query, args := w.claimQuery(nil, []string{"http_callback"})
+	if !strings.Contains(query, "NOT (task_type = ANY($2) AND execute_at < NOW() - INTERVAL '1 millisecond' * $3)") {
+		t.Errorf("Expected overdue tasks of throttled types to be excluded, got query: %s", query)
+	}
+	if len(args) != 4 || args[2] != int64(3600000) {
+		t.Errorf("Expected overdue threshold in ms as $3, got %v", args)
+	}
+}
+
+// TestClaimQueryBatchSizes проверяет размеры батча по типам и очередям: весь батч ограничен наибольшим
+// из размеров, а каждая группа - своим размером
+func TestClaimQueryBatchSizes(t *testing.T) {
+	w := NewWorker(nil, nil, Options{
+		BatchSize:       10,
+		TypeBatchSizes:  map[string]int{"email": 2, "http_callback": 100},
+		QueueBatchSizes: map[string]int{"bulk": 50},
+		TypeQuota:       30,
+	})
+
+	query, args := w.claimQuery(nil, nil)
+	if args[0] != 100 {
+		t.Errorf("Batch limit: got=%v, want=100", args[0])
+	}
+	// $2 - окно (100 * claimScanFactor), $3-$7 - размеры групп, $8 - квота по типам
+	if len(args) != 8 || args[1] != 1000 || args[6] != 10 || args[7] != 30 {
+		t.Errorf("Expected window, group sizes, default size and quota in args, got %v", args)
+	}
+	for _, want := range []string{
+		"PARTITION BY CASE WHEN task_type = ANY($3::text[]) THEN 'type:' || task_type WHEN queue = ANY($5::text[])",
+		"COALESCE(($4::int[])[array_position($3::text[], task_type)], ($6::int[])[array_position($5::text[], queue)], $7) AS group_size",
+		"WHERE group_rank <= group_size AND type_rank <= $8",
+		"LIMIT $2",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("Expected %q in query: %s", want, query)
+		}
+	}
+
+	if limit := NewWorker(nil, nil, Options{BatchSize: 10, TypeBatchSizes: map[string]int{"email": 2}}).batchSizes.limit(10); limit != 10 {
+		t.Errorf("Smaller type size must not shrink the batch, got limit %d", limit)
+	}
+}
+
+// TestBatchFill проверяет заполненность батча по группам: батч полон, если любая группа набрала свой размер,
+// даже когда весь батч меньше наибольшего размера
+func TestBatchFill(t *testing.T) {
+	sizes := newBatchSizes(10, map[string]int{"email": 2}, map[string]int{"bulk": 3})
+
+	fill := sizes.newFill()
+	fill.add("email", "default")
+	fill.add("http_callback", "bulk")
+	fill.add("http_callback", "bulk")
+	fill.add("http_callback", "default")
+	if fill.full {
+		t.Fatal("Batch must not be full while every group is below its size")
+	}
+	// Тип важнее очереди: email из очереди bulk считается в группе email
+	fill.add("email", "bulk")
+	if !fill.full {
+		t.Error("Batch must be full once the email group reached its size")
+	}
+
+	fill = sizes.newFill()
+	for i := 0; i < 3; i++ {
+		fill.add("http_callback", "bulk")
+	}
+	if !fill.full {
+		t.Error("Batch must be full once the bulk queue group reached its size")
+	}
+
+	var none *batchSizes
+	fill = none.newFill()
+	fill.add("email", "default")
+	if fill.full {
+		t.Error("Without per-group sizes fullness is decided by the batch limit only")
+	}
+}
+
+// TestCatchUpThrottle проверяет лимит просроченных заданий по типам и его сброс в новом интервале
+func TestCatchUpThrottle(t *testing.T) {
+	if newCatchUpThrottle(time.Hour, 0, time.Minute) != nil {
+		t.Fatal("Throttle must be disabled with zero rate")
+	}
+
+	c := newCatchUpThrottle(time.Hour, 2, time.Minute)
+	now := time.Now()
+
+	if c.isOverdue(now.Add(-30*time.Minute), now) {
+		t.Error("Task late by less than the threshold must not be overdue")
+	}
+	if !c.isOverdue(now.Add(-2*time.Hour), now) {
+		t.Error("Task late by hours must be overdue")
+	}
+
+	if !c.take("email", now) || !c.take("email", now) {
+		t.Fatal("First tasks within the rate must be taken")
+	}
+	if c.take("email", now) {
+		t.Error("Task over the rate must be deferred")
+	}
+	if !c.take("http_callback", now) {
+		t.Error("Rate is per task type")
+	}
+	if got := c.exhausted(now); len(got) != 1 || got[0] != "email" {
+		t.Errorf("exhausted: got %v, want [email]", got)
+	}
+
+	later := now.Add(time.Minute)
+	if got := c.exhausted(later); len(got) != 0 {
+		t.Errorf("New interval must reset the counters, got %v", got)
+	}
+	if !c.take("email", later) {
+		t.Error("Task must be taken in the new interval")
+	}
+}
+
+// TestAdaptiveInterval проверяет, что интервал опроса сокращается после полных батчей,
+// растет после пустых опросов и не выходит за границы
+func TestAdaptiveInterval(t *testing.T) {
+	if newAdaptiveInterval(5*time.Second, time.Second, 0) != nil {
+		t.Fatal("Adaptive polling must be disabled without max interval")
+	}
+
+	a := newAdaptiveInterval(5*time.Second, 500*time.Millisecond, 20*time.Second)
+	steps := []struct {
+		result pollResult
+		want   time.Duration
+	}{
+		{pollFull, 2500 * time.Millisecond},
+		{pollFull, 1250 * time.Millisecond},
+		{pollFull, 625 * time.Millisecond},
+		{pollFull, 500 * time.Millisecond},
+		{pollPartial, 500 * time.Millisecond},
+		{pollEmpty, time.Second},
+		{pollEmpty, 2 * time.Second},
+		{pollEmpty, 4 * time.Second},
+		{pollEmpty, 8 * time.Second},
+		{pollEmpty, 16 * time.Second},
+		{pollEmpty, 20 * time.Second},
+		{pollEmpty, 20 * time.Second},
+		{pollFull, 10 * time.Second},
+	}
+	for i, step := range steps {
+		if got := a.next(step.result); got != step.want {
+			t.Fatalf("Step %d: got=%v, want=%v", i, got, step.want)
+		}
+	}
+
+	if got := newAdaptiveInterval(time.Minute, time.Second, 10*time.Second).current; got != 10*time.Second {
+		t.Errorf("Initial interval must be clamped to max, got %v", got)
+	}
+}
+
+// TestBackoff проверяет общую экспоненциальную паузу: base после первой попытки, дальше удвоение до max
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+		max     time.Duration
+		want    time.Duration
+	}{
+		{1, 5 * time.Second, time.Hour, 5 * time.Second},
+		{2, 5 * time.Second, time.Hour, 10 * time.Second},
+		{4, 5 * time.Second, time.Hour, 40 * time.Second},
+		{10, 5 * time.Second, time.Hour, 2560 * time.Second},
+		{11, 5 * time.Second, time.Hour, time.Hour},
+		{1000, 5 * time.Second, time.Hour, time.Hour},
+		{3, 5 * time.Second, 0, 20 * time.Second},
+		{3, 0, time.Hour, 0},
+		{1, 2 * time.Hour, time.Hour, time.Hour},
+		{1000, time.Nanosecond, 0, 1 << 62}, // без переполнения
+		{3, 5 * time.Second, maxOutboxBackoff, 20 * time.Second},
+		{100, 5 * time.Second, maxOutboxBackoff, maxOutboxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt, tt.base, tt.max); got != tt.want {
+			t.Errorf("backoff(%d, %v, %v): got=%v, want=%v", tt.attempt, tt.base, tt.max, got, tt.want)
+		}
+	}
+}
+
+// TestRetryDelay фиксирует паузу перед повтором задания: base * 2^attempts, но не больше max
+func TestRetryDelay(t *testing.T) {
+	base, max := 5*time.Second, time.Hour
+	if got := retryDelay(1, base, max); got != 10*time.Second {
+		t.Errorf("Delay after the first attempt: got=%v, want=10s", got)
+	}
+	if got := retryDelay(2, base, max); got != 20*time.Second {
+		t.Errorf("Delay after the second attempt: got=%v, want=20s", got)
+	}
+	if got := retryDelay(20, base, max); got != max {
+		t.Errorf("Delay must be capped: got=%v, want=%v", got, max)
+	}
+	if got := retryDelay(1, 0, max); got != 0 {
+		t.Errorf("Zero base must give no delay, got=%v", got)
+	}
+}
+
+// TestCronNextRun проверяет следующий момент cron-расписания: UTC по умолчанию, CRON_TZ и задания без cron
+func TestCronNextRun(t *testing.T) {
+	now := time.Date(2025, 11, 12, 10, 22, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{"", nil},
+		{"*/5 * * * *", time.Date(2025, 11, 12, 10, 25, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 11, 12, 11, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Europe/Moscow 0 9 * * *", time.Date(2025, 11, 13, 6, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := cronNextRun(tt.expr, now)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.expr, err)
+		}
+		if next, ok := got.(time.Time); ok != (tt.want != nil) || ok && !next.Equal(tt.want.(time.Time)) {
+			t.Errorf("%q: got=%v, want=%v", tt.expr, got, tt.want)
+		}
+	}
+
+	if got, err := cronNextRun("61 * * * *", now); err == nil || got != nil {
+		t.Errorf("Invalid expression: got=%v, err=%v, want error", got, err)
+	}
+}
+
+// TestFinishQuery проверяет итоговый запрос: задание цепочки создается всегда, запись outbox и параметр
+// события добавляются только с включенным outbox и каналами notify
+func TestFinishQuery(t *testing.T) {
+	update := `UPDATE scheduled_tasks SET status = 'failed' WHERE id = $1 RETURNING id, status, notify, on_success, on_failure`
+	result := models.TaskResult{TaskID: 7, TaskType: "email", Notify: json.RawMessage(`[{"type": "webhook", "target": "https://example.com"}]`)}
+
+	w := &Worker{workerID: "test"}
+	query, args := w.finishQuery(update, `SELECT (SELECT id FROM chained) FROM done`, result, "failed", int64(7))
+	if !strings.Contains(query, "chained AS") || strings.Contains(query, "notification_outbox") || len(args) != 1 {
+		t.Errorf("Without outbox: unexpected query %s with args %v", query, args)
+	}
+
+	w.outbox = newNotifyOutbox(nil, NewNotifier(NotifierOptions{}), "test", 3, time.Second)
+	query, args = w.finishQuery(update, `SELECT (SELECT id FROM chained) FROM done`, result, "failed", int64(7))
+	if !strings.Contains(query, "chained AS") || !strings.Contains(query, "$2::jsonb") || len(args) != 2 {
+		t.Fatalf("With outbox: unexpected query %s with args %v", query, args)
+	}
+	var event notifyEvent
+	if err := json.Unmarshal([]byte(args[1].(string)), &event); err != nil || event.TaskID != 7 || event.Status != "failed" {
+		t.Errorf("Unexpected event param %v: %v", args[1], err)
+	}
+
+	result.Notify = nil
+	if query, _ = w.finishQuery(update, `SELECT 1`, result, "failed", int64(7)); strings.Contains(query, "notification_outbox") {
+		t.Errorf("Task without notify must not insert into outbox: %s", query)
+	}
+}
+
+// TestFleetRemaining проверяет остаток лимитов на весь парк по числу выполняемых заданий
+func TestFleetRemaining(t *testing.T) {
+	remaining, saturated := fleetRemaining(
+		map[string]int{"http_callback": 5, "email": 2, "sql": 1},
+		map[string]int{"http_callback": 3, "email": 2, "sql": 4, "other": 100},
+	)
+
+	if len(remaining) != 1 || remaining["http_callback"] != 2 {
+		t.Errorf("remaining: got %v, want map[http_callback:2]", remaining)
+	}
+	sort.Strings(saturated)
+	if strings.Join(saturated, ",") != "email,sql" {
+		t.Errorf("saturated: got %v, want [email sql]", saturated)
+	}
+}
+
+// TestIsRowMovedConflict проверяет распознавание конфликта с переносом строки между секциями
+func TestIsRowMovedConflict(t *testing.T) {
+	moved := &pq.Error{Code: rowMovedSQLState, Message: "tuple to be locked was already moved to another partition due to concurrent update"}
+	if !isRowMovedConflict(moved) {
+		t.Error("serialization_failure should be a row moved conflict")
+	}
+	if !isRowMovedConflict(fmt.Errorf("claim: %w", moved)) {
+		t.Error("wrapped serialization_failure should be a row moved conflict")
+	}
+	if isRowMovedConflict(&pq.Error{Code: "23505"}) || isRowMovedConflict(errors.New("connection refused")) {
+		t.Error("other errors should not be row moved conflicts")
+	}
+}
+
+// TestWaitUntilDue проверяет ожидание execute_at захваченного заранее задания и прерывание по остановке
+func TestWaitUntilDue(t *testing.T) {
+	if !waitUntilDue(context.Background(), time.Now().Add(-time.Second)) {
+		t.Errorf("Past execute_at: got=false, want=true")
+	}
+
+	start := time.Now()
+	if !waitUntilDue(context.Background(), start.Add(50*time.Millisecond)) {
+		t.Errorf("Future execute_at: got=false, want=true")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Future execute_at: returned after %v, want >= 50ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if waitUntilDue(ctx, time.Now().Add(time.Hour)) {
+		t.Errorf("Cancelled context: got=true, want=false")
+	}
+}
+
+// TestCancelClaimRace гоняет отмену заданий (тем же запросом, что и DELETE /api/v1/tasks/:id) одновременно
+// с захватом и выполнением батча и проверяет гарантии:
+//   - успешно отмененное задание остается 'cancelled': результат выполнения, начатого до отмены, не записывается
+//   - отмена не удалась только потому, что задание уже выполнено ('completed')
+//   - каждое задание выполнено не более одного раза
+//
+// Нужен PostgreSQL со схемой sql/ddl.sql: WORKER_TEST_DSN="host=localhost user=postgres dbname=at_test sslmode=disable"
+func TestCancelClaimRace(t *testing.T) {
+	dsn := os.Getenv("WORKER_TEST_DSN")
+	if dsn == "" {
+		t.Skip("WORKER_TEST_DSN is not set")
+	}
+
+	database, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer database.Close()
+
+	var mu sync.Mutex
+	executed := map[int]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			N int `json:"n"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		executed[body.N]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	queue := fmt.Sprintf("race-test-%d", time.Now().UnixNano())
+	defer database.ExecContext(ctx, `DELETE FROM scheduled_tasks WHERE queue = $1`, queue)
+
+	const taskCount = 50
+	ids := make([]int64, taskCount)
+	for i := range ids {
+		payload := fmt.Sprintf(`{"url": %q, "data": {"n": %d}}`, server.URL, i)
+		err := database.QueryRowContext(ctx, `
+			INSERT INTO scheduled_tasks (execute_at, task_type, queue, payload)
+			VALUES (NOW() - INTERVAL '1 second', 'http_callback', $1, $2)
+			RETURNING id
+		`, queue, payload).Scan(&ids[i])
+		if err != nil {
+			t.Fatalf("Failed to insert task: %v", err)
+		}
+	}
+
+	w := NewWorker(database, NewExecutor(ExecutorOptions{}), Options{BatchSize: taskCount, Queues: []string{queue}})
+	cancelled := make([]bool, taskCount)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		w.processBatch(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		for i, id := range ids {
+			res, err := database.ExecContext(ctx, `
+				UPDATE scheduled_tasks
+				SET status = 'cancelled', completed_at = NOW()
+				WHERE id = $1 AND status IN ('pending', 'processing', 'hold')
+			`, id)
+			if err != nil {
+				t.Errorf("Failed to cancel task %d: %v", id, err)
+				continue
+			}
+			affected, _ := res.RowsAffected()
+			cancelled[i] = affected == 1
+		}
+	}()
+	wg.Wait()
+
+	for i, id := range ids {
+		var status string
+		if err := database.QueryRowContext(ctx, `SELECT status FROM scheduled_tasks WHERE id = $1`, id).Scan(&status); err != nil {
+			t.Fatalf("Failed to read task %d: %v", id, err)
+		}
+		switch {
+		case cancelled[i] && status != "cancelled":
+			t.Errorf("Task %d: cancel succeeded, but status=%s", id, status)
+		case !cancelled[i] && status != "completed":
+			t.Errorf("Task %d: cancel failed, but status=%s, want=completed", id, status)
+		}
+		if executed[i] > 1 {
+			t.Errorf("Task %d: executed %d times", id, executed[i])
+		}
+	}
+}
+
+// TestRetryToBack проверяет, что с RetryToBack повторная попытка получает execute_at = NOW(),
+// а без него сохраняет прежний execute_at.
+//
+// Нужен PostgreSQL со схемой sql/ddl.sql: WORKER_TEST_DSN="host=localhost user=postgres dbname=at_test sslmode=disable"
+func TestRetryToBack(t *testing.T) {
+	dsn := os.Getenv("WORKER_TEST_DSN")
+	if dsn == "" {
+		t.Skip("WORKER_TEST_DSN is not set")
+	}
+
+	database, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	queue := fmt.Sprintf("retry-test-%d", time.Now().UnixNano())
+	defer database.ExecContext(ctx, `DELETE FROM scheduled_tasks WHERE queue = $1`, queue)
+
+	for _, retryToBack := range []bool{false, true} {
+		var id int64
+		err := database.QueryRowContext(ctx, `
+			INSERT INTO scheduled_tasks (execute_at, task_type, queue, payload, status, attempts, max_attempts, claimed_at)
+			VALUES (NOW() - INTERVAL '1 hour', 'http_callback', $1, '{}', 'processing', 1, 3, NOW())
+			RETURNING id
+		`, queue).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to insert task: %v", err)
+		}
+
+		w := NewWorker(database, NewExecutor(ExecutorOptions{}), Options{RetryToBack: retryToBack})
+		w.handleTaskResult(ctx, models.TaskResult{TaskID: id, TaskType: "http_callback", Attempt: 1, ErrorMessage: "HTTP 500"})
+
+		var status string
+		var movedToBack bool
+		err = database.QueryRowContext(ctx, `
+			SELECT status, execute_at > NOW() - INTERVAL '1 minute' FROM scheduled_tasks WHERE id = $1
+		`, id).Scan(&status, &movedToBack)
+		if err != nil {
+			t.Fatalf("Failed to read task %d: %v", id, err)
+		}
+		if status != "pending" {
+			t.Errorf("RetryToBack=%v: status=%s, want=pending", retryToBack, status)
+		}
+		if movedToBack != retryToBack {
+			t.Errorf("RetryToBack=%v: execute_at moved to now=%v", retryToBack, movedToBack)
+		}
+	}
+}
+
+// TestSupersededResultRace гоняет запись результатов двух выполнений одного задания: старого, которое
+// Cleaner счел зависшим (попытка 1), и нового после повторного захвата (попытка 3 - attempts увеличили
+// и возврат Cleaner'ом, и захват). В каком бы порядке ни пришли результаты, остается результат новой попытки.
+//
+// Нужен PostgreSQL со схемой sql/ddl.sql: WORKER_TEST_DSN="host=localhost user=postgres dbname=at_test sslmode=disable"
+func TestSupersededResultRace(t *testing.T) {
+	dsn := os.Getenv("WORKER_TEST_DSN")
+	if dsn == "" {
+		t.Skip("WORKER_TEST_DSN is not set")
+	}
+
+	database, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	queue := fmt.Sprintf("superseded-test-%d", time.Now().UnixNano())
+	defer database.ExecContext(ctx, `DELETE FROM scheduled_tasks WHERE queue = $1`, queue)
+
+	w := NewWorker(database, NewExecutor(ExecutorOptions{}), Options{})
+
+	for i := 0; i < 20; i++ {
+		var id int64
+		err := database.QueryRowContext(ctx, `
+			INSERT INTO scheduled_tasks (execute_at, task_type, queue, payload, status, attempts, max_attempts, claimed_at)
+			VALUES (NOW() - INTERVAL '1 hour', 'http_callback', $1, '{}', 'processing', 3, 5, NOW())
+			RETURNING id
+		`, queue).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to insert task: %v", err)
+		}
+
+		stale := models.TaskResult{TaskID: id, TaskType: "http_callback", Attempt: 1, Success: true, Result: json.RawMessage(`{"execution": "stale"}`)}
+		current := models.TaskResult{TaskID: id, TaskType: "http_callback", Attempt: 3, ErrorMessage: "HTTP 500", Permanent: true, Result: json.RawMessage(`{"execution": "current"}`)}
+
+		var wg sync.WaitGroup
+		for _, result := range []models.TaskResult{stale, current} {
+			wg.Add(1)
+			go func(result models.TaskResult) {
+				defer wg.Done()
+				w.handleTaskResult(ctx, result)
+			}(result)
+		}
+		wg.Wait()
+
+		var status, execution string
+		err = database.QueryRowContext(ctx, `
+			SELECT status, COALESCE(result->>'execution', '') FROM scheduled_tasks WHERE id = $1
+		`, id).Scan(&status, &execution)
+		if err != nil {
+			t.Fatalf("Failed to read task %d: %v", id, err)
+		}
+		if status != "failed" || execution != "current" {
+			t.Errorf("Task %d: status=%s result from %q execution, want failed from current", id, status, execution)
+		}
+	}
+}
+
+// TestSetQueueDepth проверяет, что статусы без заданий экспортируются как 0
+func TestSetQueueDepth(t *testing.T) {
+	setQueueDepth(map[string]int64{"pending": 7, "processing": 2})
+
+	var buf strings.Builder
+	if err := metrics.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	for _, want := range []string{
+		`at_worker_queue_depth{status="pending"} 7`,
+		`at_worker_queue_depth{status="processing"} 2`,
+		`at_worker_queue_depth{status="hold"} 0`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Metrics output has no %q", want)
+		}
+	}
+}
+
+// simulateClaimRound моделирует одновременный захват батчей несколькими worker'ами с SKIP LOCKED.
+// Worker'ы по очереди делают по одному шагу: пытаются заблокировать следующую строку своего порядка обхода.
+// Строка, уже заблокированная другим worker'ом, пропускается - это и есть потерянная на конкуренции работа.
+// Без окна все обходят очередь с головы; с окном - каждый в своем случайном порядке внутри окна.
+// Возвращает количество захваченных и пропущенных строк.
+func simulateClaimRound(rng *rand.Rand, queueLen, workers, batchSize, window int) (claimed, skipped int) {
+	orders := make([][]int, workers)
+	for i := range orders {
+		if window > batchSize {
+			n := window
+			if n > queueLen {
+				n = queueLen
+			}
+			orders[i] = rng.Perm(n)
+			continue
+		}
+		orders[i] = make([]int, queueLen)
+		for j := range orders[i] {
+			orders[i][j] = j
+		}
+	}
+
+	locked := make([]bool, queueLen)
+	taken := make([]int, workers)
+	next := make([]int, workers)
+	for active := true; active; {
+		active = false
+		for i := 0; i < workers; i++ {
+			if taken[i] >= batchSize || next[i] >= len(orders[i]) {
+				continue
+			}
+			active = true
+			row := orders[i][next[i]]
+			next[i]++
+			if locked[row] {
+				skipped++
+				continue
+			}
+			locked[row] = true
+			taken[i]++
+			claimed++
+		}
+	}
+	return claimed, skipped
+}
+
+// BenchmarkClaimContention сравнивает число пропущенных заблокированных строк на одно захваченное задание
+// (метрика skipped/claim) без окна захвата и с окном WORKER_CLAIM_WINDOW = 2 * workers * batch.
+func BenchmarkClaimContention(b *testing.B) {
+	const batchSize = 10
+	for _, workers := range []int{4, 16} {
+		for _, window := range []int{0, 2 * workers * batchSize} {
+			b.Run(fmt.Sprintf("workers=%d/window=%d", workers, window), func(b *testing.B) {
+				rng := rand.New(rand.NewSource(1))
+				totalClaimed, totalSkipped := 0, 0
+				for i := 0; i < b.N; i++ {
+					claimed, skipped := simulateClaimRound(rng, 10*workers*batchSize, workers, batchSize, window)
+					totalClaimed += claimed
+					totalSkipped += skipped
+				}
+				b.ReportMetric(float64(totalSkipped)/float64(totalClaimed), "skipped/claim")
+			})
+		}
+	}
+}
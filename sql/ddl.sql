@@ -2,14 +2,44 @@ CREATE TABLE scheduled_tasks (
     id BIGSERIAL PRIMARY KEY,
     execute_at TIMESTAMPTZ NOT NULL,
     task_type VARCHAR(50) NOT NULL,
+    queue VARCHAR(50) NOT NULL DEFAULT 'default',
     payload JSONB NOT NULL,
-    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'completed', 'failed', 'cancelled')),
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'hold', 'completed', 'failed', 'cancelled', 'skipped')),
     attempts INT DEFAULT 0,
     max_attempts INT DEFAULT 3,
     error_message TEXT,
+    result JSONB,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW(),
-    completed_at TIMESTAMPTZ
+    completed_at TIMESTAMPTZ,
+    claimed_at TIMESTAMPTZ,
+    dedup_key VARCHAR(255),
+    timeout_seconds INT,
+    lease_token VARCHAR(64),
+    locked_until TIMESTAMPTZ,
+    warning TEXT,
+    result_ttl_seconds INT,
+    scrubbed_at TIMESTAMPTZ,
+    notify JSONB,
+    skip_if_late_seconds INT,
+    progress JSONB,
+    interval_ms BIGINT,
+    max_executions INT,
+    end_at TIMESTAMPTZ,
+    executions INT NOT NULL DEFAULT 0,
+    failure_reason VARCHAR(30),
+    payload_ref VARCHAR(1024),
+    align VARCHAR(10),
+    parent_id BIGINT,
+    on_success JSONB,
+    on_failure JSONB,
+    duration_ms BIGINT,
+    priority INT NOT NULL DEFAULT 0,
+    state_transitions JSONB,
+    response_content_type VARCHAR(255),
+    response_body_base64 TEXT,
+    cancel_reason TEXT,
+    cron_expression TEXT
 );
 
 -- Индекс для быстрого поиска заданий к выполнению
@@ -17,15 +47,61 @@ CREATE INDEX idx_pending_tasks
 ON scheduled_tasks(execute_at, status) 
 WHERE status IN ('pending', 'processing');
 
+-- Индекс для опроса worker'ами, обслуживающими отдельные очереди (WORKER_QUEUES)
+CREATE INDEX idx_pending_queue
+ON scheduled_tasks(queue, execute_at)
+WHERE status = 'pending';
+
+-- Не более одного активного задания с одним dedup_key (приостановленные тоже считаются активными).
+-- Завершенные, упавшие и отмененные задания не мешают создать новое с тем же ключом
+CREATE UNIQUE INDEX idx_active_dedup_key
+ON scheduled_tasks(dedup_key)
+WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing', 'hold');
+
+-- Индекс для поиска заданий по dedup_key, включая завершенные (GET /api/v1/tasks/by-key/:key)
+CREATE INDEX idx_dedup_key
+ON scheduled_tasks(dedup_key, created_at)
+WHERE dedup_key IS NOT NULL;
+
 -- Индекс для мониторинга и статистики
 CREATE INDEX idx_status_type 
 ON scheduled_tasks(status, task_type);
 
 -- Индекс для поиска зависших заданий
 CREATE INDEX idx_processing_timeout 
-ON scheduled_tasks(updated_at) 
+ON scheduled_tasks(claimed_at) 
 WHERE status = 'processing';
 
+-- Индекс для поиска просроченных аренд (задания, захваченные внешними клиентами через claim API)
+CREATE INDEX idx_expired_leases
+ON scheduled_tasks(locked_until)
+WHERE status = 'processing' AND locked_until IS NOT NULL;
+
+-- Индекс для поиска выполненных заданий, чьи payload и результат пора удалить (result_ttl_seconds)
+CREATE INDEX idx_result_ttl
+ON scheduled_tasks(completed_at)
+WHERE status = 'completed' AND result_ttl_seconds IS NOT NULL AND scrubbed_at IS NULL;
+
+-- Outbox уведомлений о завершении заданий (worker/outbox.go): записи добавляются вместе с итоговым
+-- статусом задания и удаляются после доставки; failed_at - попытки доставки исчерпаны
+CREATE TABLE notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT NOT NULL,
+    channel_type VARCHAR(20) NOT NULL,
+    target TEXT NOT NULL,
+    event JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    failed_at TIMESTAMPTZ
+);
+
+-- Индекс для выборки уведомлений, которые пора (повторно) доставить
+CREATE INDEX idx_notification_outbox_due
+ON notification_outbox(next_attempt_at)
+WHERE failed_at IS NULL;
+
 -- Триггер для автообновления updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
@@ -38,4 +114,31 @@ $$ LANGUAGE plpgsql;
 CREATE TRIGGER trigger_update_scheduled_tasks_updated_at
 BEFORE UPDATE ON scheduled_tasks
 FOR EACH ROW
-EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+EXECUTE FUNCTION update_updated_at_column();
+
+-- История смен статуса (state_transitions): триггер дописывает переход при создании задания и при каждой
+-- смене статуса; "by" - application_name соединения (at-api, at-worker:<WORKER_ID>), хранятся последние 100
+CREATE OR REPLACE FUNCTION record_state_transition()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- При переносе строки между секциями (sql/partitioning) INSERT уже несет историю - ее не затираем
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.state_transitions IS NULL THEN
+            NEW.state_transitions = jsonb_build_array(jsonb_build_object(
+                'to', NEW.status, 'at', NOW(), 'by', current_setting('application_name')));
+        END IF;
+    ELSIF NEW.status IS DISTINCT FROM OLD.status THEN
+        NEW.state_transitions = COALESCE(OLD.state_transitions, '[]'::jsonb) || jsonb_build_array(jsonb_build_object(
+            'from', OLD.status, 'to', NEW.status, 'at', NOW(), 'by', current_setting('application_name')));
+        IF jsonb_array_length(NEW.state_transitions) > 100 THEN
+            NEW.state_transitions = NEW.state_transitions - 0;
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_record_scheduled_tasks_state_transition
+BEFORE INSERT OR UPDATE OF status ON scheduled_tasks
+FOR EACH ROW
+EXECUTE FUNCTION record_state_transition();
\ No newline at end of file
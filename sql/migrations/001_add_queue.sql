@@ -0,0 +1,8 @@
+-- Именованные очереди заданий (queue) независимо от task_type.
+-- Существующие задания попадают в очередь 'default'.
+ALTER TABLE scheduled_tasks
+    ADD COLUMN queue VARCHAR(50) NOT NULL DEFAULT 'default';
+
+CREATE INDEX idx_pending_queue
+ON scheduled_tasks(queue, execute_at)
+WHERE status = 'pending';
@@ -0,0 +1,12 @@
+-- Время захвата задания worker'ом (claimed_at) отдельно от updated_at.
+-- Cleaner определяет зависшие задания по claimed_at, а не по последнему изменению строки.
+ALTER TABLE scheduled_tasks
+    ADD COLUMN claimed_at TIMESTAMPTZ;
+
+-- Задания, уже находящиеся в работе, считаем захваченными в момент последнего изменения
+UPDATE scheduled_tasks SET claimed_at = updated_at WHERE status = 'processing';
+
+DROP INDEX IF EXISTS idx_processing_timeout;
+CREATE INDEX idx_processing_timeout
+ON scheduled_tasks(claimed_at)
+WHERE status = 'processing';
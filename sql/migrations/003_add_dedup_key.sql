@@ -0,0 +1,7 @@
+-- Бизнес-ключ дедупликации: не более одного активного (pending/processing) задания с одним ключом
+ALTER TABLE scheduled_tasks
+    ADD COLUMN dedup_key VARCHAR(255);
+
+CREATE UNIQUE INDEX idx_active_dedup_key
+ON scheduled_tasks(dedup_key)
+WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing');
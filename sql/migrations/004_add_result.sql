@@ -0,0 +1,3 @@
+-- Структурированный результат выполнения задания (код, заголовки и тело ответа HTTP callback и т.п.)
+ALTER TABLE scheduled_tasks
+    ADD COLUMN result JSONB;
@@ -0,0 +1,3 @@
+-- Таймаут выполнения отдельного задания; NULL - таймаут по умолчанию для типа задания (WORKER_TASK_TYPE_TIMEOUTS)
+ALTER TABLE scheduled_tasks
+    ADD COLUMN timeout_seconds INT;
@@ -0,0 +1,5 @@
+-- Поиск заданий по dedup_key, включая завершенные (GET /api/v1/tasks/by-key/:key).
+-- idx_active_dedup_key покрывает только активные задания
+CREATE INDEX idx_dedup_key
+ON scheduled_tasks(dedup_key, created_at)
+WHERE dedup_key IS NOT NULL;
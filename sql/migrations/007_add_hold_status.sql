@@ -0,0 +1,10 @@
+-- Статус 'hold': задание временно приостановлено и не забирается worker'ом
+ALTER TABLE scheduled_tasks DROP CONSTRAINT scheduled_tasks_status_check;
+ALTER TABLE scheduled_tasks ADD CONSTRAINT scheduled_tasks_status_check
+    CHECK (status IN ('pending', 'processing', 'hold', 'completed', 'failed', 'cancelled'));
+
+-- Приостановленное задание по-прежнему занимает свой dedup_key
+DROP INDEX IF EXISTS idx_active_dedup_key;
+CREATE UNIQUE INDEX idx_active_dedup_key
+ON scheduled_tasks(dedup_key)
+WHERE dedup_key IS NOT NULL AND status IN ('pending', 'processing', 'hold');
@@ -0,0 +1,10 @@
+-- Аренда заданий, захваченных внешними клиентами (POST /api/v1/tasks/claim).
+-- lease_token подтверждает право клиента завершить задание, locked_until - срок аренды,
+-- после которого Cleaner возвращает задание в очередь
+ALTER TABLE scheduled_tasks
+    ADD COLUMN lease_token VARCHAR(64),
+    ADD COLUMN locked_until TIMESTAMPTZ;
+
+CREATE INDEX idx_expired_leases
+ON scheduled_tasks(locked_until)
+WHERE status = 'processing' AND locked_until IS NOT NULL;
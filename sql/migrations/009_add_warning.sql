@@ -0,0 +1,3 @@
+-- Предупреждение успешно выполненного задания (частичный успех), хранится отдельно от error_message
+ALTER TABLE scheduled_tasks
+    ADD COLUMN warning TEXT;
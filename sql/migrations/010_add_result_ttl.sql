@@ -0,0 +1,8 @@
+-- Срок хранения payload и результата выполненного задания; после него Cleaner их удаляет (scrubbed_at)
+ALTER TABLE scheduled_tasks
+    ADD COLUMN result_ttl_seconds INT,
+    ADD COLUMN scrubbed_at TIMESTAMPTZ;
+
+CREATE INDEX idx_result_ttl
+ON scheduled_tasks(completed_at)
+WHERE status = 'completed' AND result_ttl_seconds IS NOT NULL AND scrubbed_at IS NULL;
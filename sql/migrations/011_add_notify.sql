@@ -0,0 +1,3 @@
+-- Каналы уведомлений о завершении задания: [{"type": "webhook", "target": "https://..."}, ...]
+ALTER TABLE scheduled_tasks
+    ADD COLUMN notify JSONB;
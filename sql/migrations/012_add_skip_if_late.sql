@@ -0,0 +1,13 @@
+-- Задание, опоздавшее больше чем на skip_if_late_seconds, не выполняется, а получает статус 'skipped'
+ALTER TABLE scheduled_tasks
+    ADD COLUMN skip_if_late_seconds INT;
+
+ALTER TABLE scheduled_tasks DROP CONSTRAINT scheduled_tasks_status_check;
+ALTER TABLE scheduled_tasks ADD CONSTRAINT scheduled_tasks_status_check
+    CHECK (status IN ('pending', 'processing', 'hold', 'completed', 'failed', 'cancelled', 'skipped'));
+
+-- Если таблица секционирована по sql/partitioning/partition_by_status.sql, секцию истории
+-- нужно пересоздать со статусом 'skipped', иначе UPDATE в 'skipped' не найдет секцию:
+--   ALTER TABLE scheduled_tasks DETACH PARTITION scheduled_tasks_done;
+--   ALTER TABLE scheduled_tasks ATTACH PARTITION scheduled_tasks_done
+--       FOR VALUES IN ('completed', 'failed', 'cancelled', 'skipped');
@@ -0,0 +1,3 @@
+-- Прогресс выполняющегося задания, который пишет worker (например {"percent": 40, "detail": "downloaded 40%"})
+ALTER TABLE scheduled_tasks
+    ADD COLUMN progress JSONB;
@@ -0,0 +1,7 @@
+-- Повторяющиеся задания: после успешного выполнения задание возвращается в очередь
+-- с execute_at + interval_ms, пока не выполнится max_executions раз или не наступит end_at
+ALTER TABLE scheduled_tasks
+    ADD COLUMN interval_ms BIGINT,
+    ADD COLUMN max_executions INT,
+    ADD COLUMN end_at TIMESTAMPTZ,
+    ADD COLUMN executions INT NOT NULL DEFAULT 0;
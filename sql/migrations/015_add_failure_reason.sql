@@ -0,0 +1,4 @@
+-- Класс причины последней неудачной попытки рядом с error_message:
+-- timeout, connection_refused, http_4xx, http_5xx, validation, unknown
+ALTER TABLE scheduled_tasks
+    ADD COLUMN failure_reason VARCHAR(30);
@@ -0,0 +1,4 @@
+-- Ссылка на payload, вынесенный во внешнее S3-совместимое хранилище (s3://bucket/key).
+-- У таких заданий в колонке payload лежит пустой объект, worker скачивает payload перед выполнением
+ALTER TABLE scheduled_tasks
+    ADD COLUMN payload_ref VARCHAR(1024);
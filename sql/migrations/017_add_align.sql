@@ -0,0 +1,4 @@
+-- Привязка выполнений повторяющегося задания к границе минуты, часа или суток UTC ('minute', 'hour', 'day').
+-- NULL - следующее выполнение ровно через interval_ms без привязки
+ALTER TABLE scheduled_tasks
+    ADD COLUMN align VARCHAR(10);
@@ -0,0 +1,20 @@
+-- Outbox уведомлений о завершении заданий. Worker добавляет записи тем же запросом, что и итоговый
+-- статус задания, и удаляет их после доставки; неудачная доставка повторяется с собственным backoff.
+-- Записи с failed_at - уведомления, не доставленные за WORKER_NOTIFY_MAX_ATTEMPTS попыток; они хранятся для разбора.
+CREATE TABLE notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    task_id BIGINT NOT NULL,
+    channel_type VARCHAR(20) NOT NULL,
+    target TEXT NOT NULL,
+    event JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    failed_at TIMESTAMPTZ
+);
+
+-- Индекс для выборки уведомлений, которые пора (повторно) доставить
+CREATE INDEX idx_notification_outbox_due
+ON notification_outbox(next_attempt_at)
+WHERE failed_at IS NULL;
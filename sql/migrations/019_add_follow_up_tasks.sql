@@ -0,0 +1,6 @@
+-- Цепочки заданий: on_success / on_failure - описание задания, которое worker создает после успешного
+-- завершения или окончательной ошибки задания; parent_id - задание, по итогу которого создано это
+ALTER TABLE scheduled_tasks
+    ADD COLUMN parent_id BIGINT,
+    ADD COLUMN on_success JSONB,
+    ADD COLUMN on_failure JSONB;
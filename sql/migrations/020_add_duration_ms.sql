@@ -0,0 +1,4 @@
+-- Длительность последнего выполнения задания в миллисекундах (для отчетов по SLA).
+-- NULL - задание еще не выполнялось
+ALTER TABLE scheduled_tasks
+    ADD COLUMN duration_ms BIGINT;
@@ -0,0 +1,5 @@
+-- Приоритет захвата задания: больше - раньше. Просроченные задания стареют (эффективный приоритет
+-- растет на 1 в минуту), поэтому поток высокоприоритетных заданий не откладывает остальные навсегда.
+-- У существующих заданий приоритет 0, порядок захвата для них не меняется (по execute_at)
+ALTER TABLE scheduled_tasks
+    ADD COLUMN priority INT NOT NULL DEFAULT 0;
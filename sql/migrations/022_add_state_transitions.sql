@@ -0,0 +1,33 @@
+-- История смен статуса задания для аудита (GET /api/v1/tasks/:id/history): массив
+-- {"from": ..., "to": ..., "at": ..., "by": ...}, который дописывает триггер при создании задания
+-- и при каждой смене статуса, кто бы ее ни сделал (API, worker, Cleaner или ручной UPDATE).
+-- "by" - application_name соединения: at-api или at-worker:<WORKER_ID>.
+-- Хранятся последние 100 переходов, чтобы история повторяющихся заданий не росла бесконечно.
+-- У заданий, созданных до миграции, история начинается с первой смены статуса после нее
+ALTER TABLE scheduled_tasks
+    ADD COLUMN state_transitions JSONB;
+
+CREATE OR REPLACE FUNCTION record_state_transition()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- При переносе строки между секциями (sql/partitioning) INSERT уже несет историю - ее не затираем
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.state_transitions IS NULL THEN
+            NEW.state_transitions = jsonb_build_array(jsonb_build_object(
+                'to', NEW.status, 'at', NOW(), 'by', current_setting('application_name')));
+        END IF;
+    ELSIF NEW.status IS DISTINCT FROM OLD.status THEN
+        NEW.state_transitions = COALESCE(OLD.state_transitions, '[]'::jsonb) || jsonb_build_array(jsonb_build_object(
+            'from', OLD.status, 'to', NEW.status, 'at', NOW(), 'by', current_setting('application_name')));
+        IF jsonb_array_length(NEW.state_transitions) > 100 THEN
+            NEW.state_transitions = NEW.state_transitions - 0;
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trigger_record_scheduled_tasks_state_transition
+BEFORE INSERT OR UPDATE OF status ON scheduled_tasks
+FOR EACH ROW
+EXECUTE FUNCTION record_state_transition();
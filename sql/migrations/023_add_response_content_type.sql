@@ -0,0 +1,6 @@
+-- Content-Type ответа http_callback и бинарное тело ответа в base64.
+-- Бинарное тело не пишется в error_message и result как текст (см. WORKER_BINARY_RESPONSE_MODE в at-worker);
+-- response_body_base64 заполняется только в режиме base64
+ALTER TABLE scheduled_tasks
+    ADD COLUMN response_content_type VARCHAR(255),
+    ADD COLUMN response_body_base64 TEXT;
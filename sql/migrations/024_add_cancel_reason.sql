@@ -0,0 +1,4 @@
+-- Причина отмены задания, указанная оператором в DELETE /api/v1/tasks/:id ({"reason": "..."}):
+-- след ручного вмешательства для аудита. NULL - задание не отменено или отменено без причины
+ALTER TABLE scheduled_tasks
+    ADD COLUMN cancel_reason TEXT;
@@ -0,0 +1,5 @@
+-- Cron-расписание повторяющегося задания ("*/5 * * * *", CRON_TZ=... для часового пояса).
+-- После успешного выполнения worker (или API для арендованного задания) вычисляет следующий момент
+-- расписания и возвращает задание в 'pending' вместо 'completed'. NULL - задание без cron
+ALTER TABLE scheduled_tasks
+    ADD COLUMN cron_expression TEXT;
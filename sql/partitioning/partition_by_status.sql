@@ -0,0 +1,141 @@
+-- Опциональное секционирование scheduled_tasks по status для больших инсталляций (PostgreSQL 13+).
+-- Это не обычная миграция: таблица пересоздается с копированием всех строк под эксклюзивной блокировкой,
+-- поэтому выполняйте скрипт в окно обслуживания при остановленных API и worker'ах.
+-- Скрипт рассчитан на схему со всеми миграциями из sql/migrations.
+--
+-- Активные задания (pending, processing, hold) лежат в отдельной небольшой секции: опрос worker'ов,
+-- Cleaner и проверка dedup_key работают с ней и не задевают историю выполненных заданий.
+-- Смена статуса на терминальный переносит строку в секцию истории (DELETE + INSERT внутри PostgreSQL).
+--
+-- Ограничения:
+--   - первичный ключ - (id, status): уникальность id обеспечивает только последовательность;
+--   - поиск по одному id без status проверяет индексы обеих секций;
+--   - блокировка строки, которую параллельная транзакция перенесла в другую секцию, завершается
+--     ошибкой 40001 - worker перечитывает такие строки при следующем опросе или повторе записи.
+BEGIN;
+
+LOCK TABLE scheduled_tasks IN ACCESS EXCLUSIVE MODE;
+
+ALTER TABLE scheduled_tasks RENAME TO scheduled_tasks_unpartitioned;
+ALTER SEQUENCE scheduled_tasks_id_seq OWNED BY NONE;
+
+CREATE TABLE scheduled_tasks (
+    id BIGINT NOT NULL DEFAULT nextval('scheduled_tasks_id_seq'),
+    execute_at TIMESTAMPTZ NOT NULL,
+    task_type VARCHAR(50) NOT NULL,
+    queue VARCHAR(50) NOT NULL DEFAULT 'default',
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'hold', 'completed', 'failed', 'cancelled', 'skipped')),
+    attempts INT DEFAULT 0,
+    max_attempts INT DEFAULT 3,
+    error_message TEXT,
+    result JSONB,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    claimed_at TIMESTAMPTZ,
+    dedup_key VARCHAR(255),
+    timeout_seconds INT,
+    lease_token VARCHAR(64),
+    locked_until TIMESTAMPTZ,
+    warning TEXT,
+    result_ttl_seconds INT,
+    scrubbed_at TIMESTAMPTZ,
+    notify JSONB,
+    skip_if_late_seconds INT,
+    progress JSONB,
+    interval_ms BIGINT,
+    max_executions INT,
+    end_at TIMESTAMPTZ,
+    executions INT NOT NULL DEFAULT 0,
+    failure_reason VARCHAR(30),
+    payload_ref VARCHAR(1024),
+    align VARCHAR(10),
+    parent_id BIGINT,
+    on_success JSONB,
+    on_failure JSONB,
+    duration_ms BIGINT,
+    priority INT NOT NULL DEFAULT 0,
+    state_transitions JSONB,
+    response_content_type VARCHAR(255),
+    response_body_base64 TEXT,
+    cancel_reason TEXT,
+    cron_expression TEXT,
+    PRIMARY KEY (id, status)
+) PARTITION BY LIST (status);
+
+-- Горячая секция: все, что worker'ы и Cleaner еще могут взять в работу
+CREATE TABLE scheduled_tasks_active
+PARTITION OF scheduled_tasks
+FOR VALUES IN ('pending', 'processing', 'hold');
+
+-- История. Для удаления старых заданий без DELETE ее можно дополнительно секционировать по created_at
+-- (PARTITION BY RANGE (created_at)) и отсоединять старые месяцы через ALTER TABLE ... DETACH PARTITION
+CREATE TABLE scheduled_tasks_done
+PARTITION OF scheduled_tasks
+FOR VALUES IN ('completed', 'failed', 'cancelled', 'skipped');
+
+INSERT INTO scheduled_tasks
+SELECT id, execute_at, task_type, queue, payload, COALESCE(status, 'pending'), attempts, max_attempts,
+       error_message, result, created_at, updated_at, completed_at, claimed_at, dedup_key, timeout_seconds,
+       lease_token, locked_until, warning, result_ttl_seconds, scrubbed_at, notify,
+       skip_if_late_seconds, progress, interval_ms, max_executions, end_at, executions,
+       failure_reason, payload_ref, align, parent_id, on_success, on_failure, duration_ms, priority,
+       state_transitions, response_content_type, response_body_base64, cancel_reason,
+       cron_expression
+FROM scheduled_tasks_unpartitioned;
+
+-- Старая таблица удаляется вместе с индексами и триггером, освобождая их имена
+DROP TABLE scheduled_tasks_unpartitioned;
+ALTER SEQUENCE scheduled_tasks_id_seq OWNED BY scheduled_tasks.id;
+
+-- Индексы из sql/ddl.sql; создаются на родительской таблице и наследуются секциями
+CREATE INDEX idx_pending_tasks
+ON scheduled_tasks(execute_at, status)
+WHERE status IN ('pending', 'processing');
+
+CREATE INDEX idx_pending_queue
+ON scheduled_tasks(queue, execute_at)
+WHERE status = 'pending';
+
+CREATE INDEX idx_dedup_key
+ON scheduled_tasks(dedup_key, created_at)
+WHERE dedup_key IS NOT NULL;
+
+CREATE INDEX idx_status_type
+ON scheduled_tasks(status, task_type);
+
+CREATE INDEX idx_processing_timeout
+ON scheduled_tasks(claimed_at)
+WHERE status = 'processing';
+
+CREATE INDEX idx_expired_leases
+ON scheduled_tasks(locked_until)
+WHERE status = 'processing' AND locked_until IS NOT NULL;
+
+CREATE INDEX idx_result_ttl
+ON scheduled_tasks(completed_at)
+WHERE status = 'completed' AND result_ttl_seconds IS NOT NULL AND scrubbed_at IS NULL;
+
+-- Уникальный индекс на секционированной таблице обязан включать status, поэтому "не более одного
+-- активного задания с dedup_key" задается на секции активных заданий. Имя сохраняется:
+-- at-api распознает нарушение именно этого индекса как дубликат
+CREATE UNIQUE INDEX idx_active_dedup_key
+ON scheduled_tasks_active(dedup_key)
+WHERE dedup_key IS NOT NULL;
+
+CREATE TRIGGER trigger_update_scheduled_tasks_updated_at
+BEFORE UPDATE ON scheduled_tasks
+FOR EACH ROW
+EXECUTE FUNCTION update_updated_at_column();
+
+-- Функция record_state_transition из миграции 022 не удаляется вместе со старой таблицей.
+-- Триггер создается после копирования строк, поэтому их история переносится как есть
+CREATE TRIGGER trigger_record_scheduled_tasks_state_transition
+BEFORE INSERT OR UPDATE OF status ON scheduled_tasks
+FOR EACH ROW
+EXECUTE FUNCTION record_state_transition();
+
+COMMIT;
+
+ANALYZE scheduled_tasks;